@@ -0,0 +1,395 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/output"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/utils"
+)
+
+// Aggregate holds the aggregated values of a device output's numeric readings
+// over a single window of time.
+type Aggregate struct {
+	// Device is the ID of the device which the readings belong to.
+	Device string
+
+	// Output is the name of the output for the aggregated readings. If a
+	// reading has no associated output, its type is used instead.
+	Output string
+
+	// Context is the context of the aggregated readings. Readings from the same
+	// output with different contexts (e.g. the channels of a multi-channel
+	// output) are aggregated separately.
+	Context map[string]string
+
+	// Window is the size of the aggregation window.
+	Window time.Duration
+
+	// Start is the start time of the window (inclusive).
+	Start time.Time
+
+	// End is the end time of the window (exclusive).
+	End time.Time
+
+	// Complete is true if the window has ended, so its values are final.
+	Complete bool
+
+	// Count is the number of readings in the window.
+	Count int
+
+	// Min is the minimum reading value in the window.
+	Min float64
+
+	// Max is the maximum reading value in the window.
+	Max float64
+
+	// Avg is the mean reading value in the window.
+	Avg float64
+
+	// Last is the most recent reading value in the window.
+	Last float64
+
+	sum float64
+}
+
+// cursorKey gets the key which orders aggregates with the same start time, for
+// paging through aggregate query results.
+func (agg *Aggregate) cursorKey() string {
+	return agg.Device + "\x00" + agg.Output + "\x00" + readingContextKey(agg.Context)
+}
+
+// observe adds a reading value to the aggregate.
+func (agg *Aggregate) observe(value float64) {
+	if agg.Count == 0 || value < agg.Min {
+		agg.Min = value
+	}
+	if agg.Count == 0 || value > agg.Max {
+		agg.Max = value
+	}
+	agg.Count++
+	agg.sum += value
+	agg.Avg = agg.sum / float64(agg.Count)
+	agg.Last = value
+}
+
+// AggregatePage is a page of results for an aggregate query.
+type AggregatePage struct {
+	// Aggregates are the matching aggregates, ordered by window start time.
+	Aggregates []*Aggregate
+
+	// Next is the query for the next page of results. It is nil if there are
+	// no more results.
+	Next *CacheQuery
+}
+
+// QueryAggregates gets the aggregated readings for the given window size which
+// match the given query. Aggregation must be enabled in the plugin config, and
+// the window size must be one of the configured aggregation windows.
+//
+// The query time bounds select the aggregation windows which overlap them. Results
+// are ordered by window start time and are paged in the same manner as
+// QueryCachedReadings.
+func (plugin *Plugin) QueryAggregates(window time.Duration, query *CacheQuery) (*AggregatePage, error) {
+	return plugin.state.QueryAggregates(window, query)
+}
+
+// aggregateKey identifies a series of aggregates.
+type aggregateKey struct {
+	device  string
+	output  string
+	context string
+	window  time.Duration
+}
+
+// aggregateSeries holds the aggregates for a single device output and window size.
+type aggregateSeries struct {
+	current *Aggregate
+	closed  []*Aggregate
+}
+
+// aggregator computes aggregates of plugin readings over the configured windows.
+type aggregator struct {
+	windows   []time.Duration
+	retention int
+
+	lock   sync.Mutex
+	series map[aggregateKey]*aggregateSeries
+}
+
+// newAggregator creates a new aggregator from the aggregation config. If
+// aggregation is not enabled, or there are no valid windows configured, nil
+// is returned.
+func newAggregator(conf *config.AggregationSettings) *aggregator {
+	if conf == nil || !conf.Enabled {
+		return nil
+	}
+
+	var windows []time.Duration
+	for _, w := range conf.Windows {
+		if w <= 0 {
+			log.WithField("window", w).Warn("[aggregation] ignoring non-positive aggregation window")
+			continue
+		}
+		windows = append(windows, w)
+	}
+	if len(windows) == 0 {
+		log.Warn("[aggregation] aggregation enabled, but no windows configured")
+		return nil
+	}
+
+	return &aggregator{
+		windows:   windows,
+		retention: conf.Retention,
+		series:    make(map[aggregateKey]*aggregateSeries),
+	}
+}
+
+// add adds the numeric readings in the given ReadContext to the aggregates for
+// each window. Non-numeric readings are ignored.
+func (agg *aggregator) add(ctx *ReadContext, now time.Time) {
+	agg.lock.Lock()
+	defer agg.lock.Unlock()
+
+	for _, reading := range ctx.Reading {
+		if reading == nil {
+			continue
+		}
+		value, err := utils.ConvertToFloat64(reading.Value)
+		if err != nil {
+			continue
+		}
+		ts := readingTime(reading, now)
+		name := aggregateOutputName(reading)
+		context := readingContextKey(reading.Context)
+
+		for _, window := range agg.windows {
+			key := aggregateKey{device: ctx.Device.id, output: name, context: context, window: window}
+			series, exists := agg.series[key]
+			if !exists {
+				series = &aggregateSeries{}
+				agg.series[key] = series
+			}
+			agg.observe(key, series, reading.Context, ts, value)
+		}
+	}
+}
+
+// observe adds a reading value taken at the given time to the series. Readings
+// which arrive after their window has been closed are dropped. The aggregator
+// lock must be held when calling this.
+func (agg *aggregator) observe(key aggregateKey, series *aggregateSeries, context map[string]string, ts time.Time, value float64) {
+	start := ts.Truncate(key.window)
+	if series.current != nil && start.Before(series.current.Start) {
+		log.WithFields(log.Fields{
+			"device":  key.device,
+			"output":  key.output,
+			"context": key.context,
+			"window":  key.window,
+		}).Debug("[aggregation] dropping reading for closed aggregation window")
+		return
+	}
+
+	if series.current == nil || start.After(series.current.Start) {
+		if series.current != nil {
+			series.closed = append(series.closed, series.current)
+			if agg.retention > 0 && len(series.closed) > agg.retention {
+				n := copy(series.closed, series.closed[len(series.closed)-agg.retention:])
+				series.closed = series.closed[:n]
+			}
+		}
+		series.current = &Aggregate{
+			Device:  key.device,
+			Output:  key.output,
+			Context: copyContext(context),
+			Window:  key.window,
+			Start:   start,
+			End:     start.Add(key.window),
+		}
+	}
+	series.current.observe(value)
+}
+
+// removeDevice removes all aggregates for the device with the given ID.
+func (agg *aggregator) removeDevice(id string) {
+	agg.lock.Lock()
+	defer agg.lock.Unlock()
+
+	for key := range agg.series {
+		if key.device == id {
+			delete(agg.series, key)
+		}
+	}
+}
+
+// hasWindow checks whether the aggregator computes aggregates for the given window.
+func (agg *aggregator) hasWindow(window time.Duration) bool {
+	for _, w := range agg.windows {
+		if w == window {
+			return true
+		}
+	}
+	return false
+}
+
+// query gets copies of the aggregates for the given window size which overlap
+// the given bounds. If devices is non-nil, only aggregates for those devices
+// are returned. Results are ordered by window start time.
+func (agg *aggregator) query(window time.Duration, start, end time.Time, devices map[string]struct{}, now time.Time) []*Aggregate {
+	var results []*Aggregate
+	include := func(a *Aggregate) {
+		if !start.IsZero() && !a.End.After(start) {
+			return
+		}
+		if !end.IsZero() && a.Start.After(end) {
+			return
+		}
+		cp := *a
+		cp.Complete = !now.Before(a.End)
+		results = append(results, &cp)
+	}
+
+	agg.lock.Lock()
+	for key, series := range agg.series {
+		if key.window != window {
+			continue
+		}
+		if devices != nil {
+			if _, ok := devices[key.device]; !ok {
+				continue
+			}
+		}
+		for _, a := range series.closed {
+			include(a)
+		}
+		if series.current != nil {
+			include(series.current)
+		}
+	}
+	agg.lock.Unlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if !a.Start.Equal(b.Start) {
+			return a.Start.Before(b.Start)
+		}
+		return a.cursorKey() < b.cursorKey()
+	})
+	return results
+}
+
+// addReadingToAggregates adds the given reading to the reading aggregates, if
+// the plugin is configured to enable aggregation.
+func (manager *stateManager) addReadingToAggregates(ctx *ReadContext) {
+	if manager.aggregator != nil {
+		manager.aggregator.add(ctx, time.Now())
+	}
+}
+
+// QueryAggregates gets the aggregates for the given window size which match
+// the given query.
+func (manager *stateManager) QueryAggregates(window time.Duration, query *CacheQuery) (*AggregatePage, error) {
+	if query == nil {
+		return nil, errors.New("cannot query aggregates with nil query")
+	}
+	if query.Limit < 0 {
+		return nil, errors.New("cache query limit must not be negative")
+	}
+	if manager.aggregator == nil {
+		return nil, errors.New("reading aggregation is not enabled")
+	}
+	if !manager.aggregator.hasWindow(window) {
+		return nil, fmt.Errorf("no aggregation configured for window: %v", window)
+	}
+
+	devices, err := manager.queryDevices(query)
+	if err != nil {
+		return nil, err
+	}
+
+	results := manager.aggregator.query(window, query.Start, query.End, devices, time.Now())
+
+	// Skip the aggregates up to the cursor, then take a page of the remaining
+	// aggregates.
+	if query.After != nil {
+		i := sort.Search(len(results), func(i int) bool {
+			return query.After.before(results[i].Start, results[i].cursorKey())
+		})
+		results = results[i:]
+	}
+	page := &AggregatePage{Aggregates: results}
+	if query.Limit > 0 && len(results) > query.Limit {
+		last := results[query.Limit-1]
+		next := *query
+		next.After = &CacheCursor{Time: last.Start, Key: last.cursorKey()}
+		page.Aggregates = results[:query.Limit]
+		page.Next = &next
+	}
+	return page, nil
+}
+
+// readingTime gets the time at which a reading was taken. If the reading does
+// not have a valid timestamp, the provided default is returned.
+func readingTime(reading *output.Reading, def time.Time) time.Time {
+	if reading.Timestamp == "" {
+		return def
+	}
+	ts, err := time.Parse(time.RFC3339, reading.Timestamp)
+	if err != nil {
+		return def
+	}
+	return ts
+}
+
+// aggregateOutputName gets the name which a reading is aggregated under.
+func aggregateOutputName(reading *output.Reading) string {
+	if o := reading.GetOutput(); o != nil && o.Name != "" {
+		return o.Name
+	}
+	return reading.Type
+}
+
+// readingContextKey gets a string which identifies a reading context, for
+// distinguishing readings from the same output. Equal contexts have equal keys.
+func readingContextKey(context map[string]string) string {
+	pairs := make([]string, 0, len(context))
+	for k, v := range context {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// copyContext copies a reading context. If the context is empty, nil is returned.
+func copyContext(context map[string]string) map[string]string {
+	if len(context) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(context))
+	for k, v := range context {
+		c[k] = v
+	}
+	return c
+}
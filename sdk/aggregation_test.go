@@ -0,0 +1,283 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/output"
+)
+
+func testAggregationConfig() *config.AggregationSettings {
+	return &config.AggregationSettings{
+		Enabled:   true,
+		Windows:   []time.Duration{time.Minute, 5 * time.Minute},
+		Retention: 2,
+	}
+}
+
+func aggregateTestReading(device, ts string, value interface{}) *ReadContext {
+	return &ReadContext{
+		Device:  &Device{id: device},
+		Reading: []*output.Reading{{Timestamp: ts, Type: "temperature", Value: value}},
+	}
+}
+
+func TestNewAggregator_nilConfig(t *testing.T) {
+	assert.Nil(t, newAggregator(nil))
+}
+
+func TestNewAggregator_disabled(t *testing.T) {
+	assert.Nil(t, newAggregator(&config.AggregationSettings{
+		Windows: []time.Duration{time.Minute},
+	}))
+}
+
+func TestNewAggregator_noValidWindows(t *testing.T) {
+	assert.Nil(t, newAggregator(&config.AggregationSettings{
+		Enabled: true,
+		Windows: []time.Duration{0, -1 * time.Minute},
+	}))
+}
+
+func TestNewAggregator(t *testing.T) {
+	agg := newAggregator(testAggregationConfig())
+	assert.NotNil(t, agg)
+	assert.True(t, agg.hasWindow(time.Minute))
+	assert.True(t, agg.hasWindow(5*time.Minute))
+	assert.False(t, agg.hasWindow(time.Hour))
+}
+
+func TestAggregator_add(t *testing.T) {
+	agg := newAggregator(testAggregationConfig())
+	now := time.Now()
+
+	agg.add(aggregateTestReading("1", "2019-03-22T09:48:00Z", 3), now)
+	agg.add(aggregateTestReading("1", "2019-03-22T09:48:10Z", 1.5), now)
+	agg.add(aggregateTestReading("1", "2019-03-22T09:48:20Z", "4.5"), now)
+
+	results := agg.query(time.Minute, time.Time{}, time.Time{}, nil, now)
+	assert.Len(t, results, 1)
+
+	a := results[0]
+	assert.Equal(t, "1", a.Device)
+	assert.Equal(t, "temperature", a.Output)
+	assert.Equal(t, time.Minute, a.Window)
+	assert.Equal(t, "2019-03-22T09:48:00Z", a.Start.Format(time.RFC3339))
+	assert.Equal(t, "2019-03-22T09:49:00Z", a.End.Format(time.RFC3339))
+	assert.True(t, a.Complete)
+	assert.Equal(t, 3, a.Count)
+	assert.Equal(t, 1.5, a.Min)
+	assert.Equal(t, 4.5, a.Max)
+	assert.Equal(t, 3.0, a.Avg)
+	assert.Equal(t, 4.5, a.Last)
+}
+
+func TestAggregator_addContext(t *testing.T) {
+	agg := newAggregator(testAggregationConfig())
+	now := time.Now()
+
+	// Readings from the same output with different contexts are aggregated
+	// separately.
+	agg.add(&ReadContext{
+		Device: &Device{id: "1"},
+		Reading: []*output.Reading{
+			{Timestamp: "2019-03-22T09:48:00Z", Type: "current", Value: 1, Context: map[string]string{"channel": "a"}},
+			{Timestamp: "2019-03-22T09:48:00Z", Type: "current", Value: 10, Context: map[string]string{"channel": "b"}},
+		},
+	}, now)
+	agg.add(&ReadContext{
+		Device: &Device{id: "1"},
+		Reading: []*output.Reading{
+			{Timestamp: "2019-03-22T09:48:10Z", Type: "current", Value: 3, Context: map[string]string{"channel": "a"}},
+			{Timestamp: "2019-03-22T09:48:10Z", Type: "current", Value: 20, Context: map[string]string{"channel": "b"}},
+		},
+	}, now)
+
+	results := agg.query(time.Minute, time.Time{}, time.Time{}, nil, now)
+	assert.Len(t, results, 2)
+
+	assert.Equal(t, map[string]string{"channel": "a"}, results[0].Context)
+	assert.Equal(t, 2, results[0].Count)
+	assert.Equal(t, 1.0, results[0].Min)
+	assert.Equal(t, 3.0, results[0].Max)
+
+	assert.Equal(t, map[string]string{"channel": "b"}, results[1].Context)
+	assert.Equal(t, 2, results[1].Count)
+	assert.Equal(t, 10.0, results[1].Min)
+	assert.Equal(t, 20.0, results[1].Max)
+}
+
+func TestAggregator_addNonNumeric(t *testing.T) {
+	agg := newAggregator(testAggregationConfig())
+	now := time.Now()
+
+	agg.add(aggregateTestReading("1", "2019-03-22T09:48:00Z", "foo"), now)
+	agg.add(aggregateTestReading("1", "2019-03-22T09:48:00Z", map[string]string{}), now)
+
+	assert.Empty(t, agg.query(time.Minute, time.Time{}, time.Time{}, nil, now))
+}
+
+func TestAggregator_windows(t *testing.T) {
+	agg := newAggregator(testAggregationConfig())
+	now := time.Now()
+
+	agg.add(aggregateTestReading("1", "2019-03-22T09:48:00Z", 1), now)
+	agg.add(aggregateTestReading("1", "2019-03-22T09:49:00Z", 2), now)
+	agg.add(aggregateTestReading("1", "2019-03-22T09:50:00Z", 3), now)
+
+	oneMinute := agg.query(time.Minute, time.Time{}, time.Time{}, nil, now)
+	assert.Len(t, oneMinute, 3)
+	assert.Equal(t, 1.0, oneMinute[0].Last)
+	assert.Equal(t, 2.0, oneMinute[1].Last)
+	assert.Equal(t, 3.0, oneMinute[2].Last)
+
+	fiveMinute := agg.query(5*time.Minute, time.Time{}, time.Time{}, nil, now)
+	assert.Len(t, fiveMinute, 2)
+	assert.Equal(t, 2, fiveMinute[0].Count)
+	assert.Equal(t, 1, fiveMinute[1].Count)
+}
+
+func TestAggregator_retention(t *testing.T) {
+	agg := newAggregator(testAggregationConfig())
+	now := time.Now()
+
+	for _, ts := range []string{
+		"2019-03-22T09:45:00Z",
+		"2019-03-22T09:46:00Z",
+		"2019-03-22T09:47:00Z",
+		"2019-03-22T09:48:00Z",
+	} {
+		agg.add(aggregateTestReading("1", ts, 1), now)
+	}
+
+	// Two closed windows are retained, plus the current window.
+	results := agg.query(time.Minute, time.Time{}, time.Time{}, nil, now)
+	assert.Len(t, results, 3)
+	assert.Equal(t, "2019-03-22T09:46:00Z", results[0].Start.Format(time.RFC3339))
+}
+
+func TestAggregator_lateReading(t *testing.T) {
+	agg := newAggregator(testAggregationConfig())
+	now := time.Now()
+
+	agg.add(aggregateTestReading("1", "2019-03-22T09:48:00Z", 1), now)
+	agg.add(aggregateTestReading("1", "2019-03-22T09:47:00Z", 2), now)
+
+	results := agg.query(time.Minute, time.Time{}, time.Time{}, nil, now)
+	assert.Len(t, results, 1)
+	assert.Equal(t, 1.0, results[0].Last)
+}
+
+func TestAggregator_query(t *testing.T) {
+	agg := newAggregator(testAggregationConfig())
+	now := time.Now()
+
+	agg.add(aggregateTestReading("2", "2019-03-22T09:48:00Z", 1), now)
+	agg.add(aggregateTestReading("1", "2019-03-22T09:48:00Z", 1), now)
+	agg.add(aggregateTestReading("1", "2019-03-22T09:50:00Z", 1), now)
+
+	// Ordered by start time, then device.
+	results := agg.query(time.Minute, time.Time{}, time.Time{}, nil, now)
+	assert.Len(t, results, 3)
+	assert.Equal(t, "1", results[0].Device)
+	assert.Equal(t, "2", results[1].Device)
+	assert.Equal(t, "1", results[2].Device)
+
+	// Filtered by device.
+	results = agg.query(time.Minute, time.Time{}, time.Time{}, map[string]struct{}{"2": {}}, now)
+	assert.Len(t, results, 1)
+	assert.Equal(t, "2", results[0].Device)
+
+	// Filtered by bounds.
+	start, _ := time.Parse(time.RFC3339, "2019-03-22T09:48:30Z")
+	end, _ := time.Parse(time.RFC3339, "2019-03-22T09:49:30Z")
+	results = agg.query(time.Minute, start, end, nil, now)
+	assert.Len(t, results, 2)
+}
+
+func TestAggregator_queryIncomplete(t *testing.T) {
+	agg := newAggregator(testAggregationConfig())
+	now := time.Now()
+
+	agg.add(aggregateTestReading("1", now.Format(time.RFC3339), 1), now)
+
+	results := agg.query(time.Minute, time.Time{}, time.Time{}, nil, now)
+	assert.Len(t, results, 1)
+	assert.False(t, results[0].Complete)
+}
+
+func TestStateManager_QueryAggregates_nilQuery(t *testing.T) {
+	sm := stateManager{aggregator: newAggregator(testAggregationConfig())}
+
+	page, err := sm.QueryAggregates(time.Minute, nil)
+	assert.Error(t, err)
+	assert.Nil(t, page)
+}
+
+func TestStateManager_QueryAggregates_disabled(t *testing.T) {
+	sm := stateManager{}
+
+	page, err := sm.QueryAggregates(time.Minute, &CacheQuery{})
+	assert.Error(t, err)
+	assert.Nil(t, page)
+}
+
+func TestStateManager_QueryAggregates_unknownWindow(t *testing.T) {
+	sm := stateManager{aggregator: newAggregator(testAggregationConfig())}
+
+	page, err := sm.QueryAggregates(time.Hour, &CacheQuery{})
+	assert.Error(t, err)
+	assert.Nil(t, page)
+}
+
+func TestStateManager_QueryAggregates(t *testing.T) {
+	sm := stateManager{
+		deviceManager: &deviceManager{
+			devices: map[string]*Device{
+				"1": {id: "1"},
+				"2": {id: "2"},
+			},
+		},
+		aggregator: newAggregator(testAggregationConfig()),
+	}
+	sm.addReadingToAggregates(aggregateTestReading("1", "2019-03-22T09:48:00Z", 1))
+	sm.addReadingToAggregates(aggregateTestReading("1", "2019-03-22T09:49:00Z", 2))
+	sm.addReadingToAggregates(aggregateTestReading("2", "2019-03-22T09:49:00Z", 3))
+
+	page, err := sm.QueryAggregates(time.Minute, &CacheQuery{Devices: []string{"1"}, Limit: 1})
+	assert.NoError(t, err)
+	assert.Len(t, page.Aggregates, 1)
+	assert.Equal(t, 1.0, page.Aggregates[0].Last)
+	assert.NotNil(t, page.Next)
+
+	page, err = sm.QueryAggregates(time.Minute, page.Next)
+	assert.NoError(t, err)
+	assert.Len(t, page.Aggregates, 1)
+	assert.Equal(t, 2.0, page.Aggregates[0].Last)
+	assert.Nil(t, page.Next)
+}
+
+func TestStateManager_addReadingToAggregates_disabled(t *testing.T) {
+	sm := stateManager{}
+	assert.NotPanics(t, func() {
+		sm.addReadingToAggregates(aggregateTestReading("1", "2019-03-22T09:48:00Z", 1))
+	})
+}
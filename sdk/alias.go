@@ -18,6 +18,7 @@ package sdk
 
 import (
 	"errors"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -26,10 +27,11 @@ import (
 // aliases registered by the device.
 type AliasCache struct {
 	// cache is a simple map which serves as the lookup table for device
-	// aliases. Since device aliases will not change for the duration of
-	// the plugin run (as they are statically configured), this does
-	// not need any form of invalidation.
+	// aliases. Aliases are removed from the cache when their device is
+	// removed from the plugin (e.g. on device config reload).
 	cache map[string]*Device
+
+	lock sync.RWMutex
 }
 
 // NewAliasCache creates a new AliasCache instance.
@@ -41,6 +43,9 @@ func NewAliasCache() *AliasCache {
 
 // Add adds a device alias mapping to the cache.
 func (cache *AliasCache) Add(alias string, device *Device) error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
 	// If the alias already exists, return an error. It is up to the
 	// configurer to ensure all aliased devices have unique aliases for
 	// the plugin.
@@ -59,5 +64,16 @@ func (cache *AliasCache) Add(alias string, device *Device) error {
 // Get gets the device associated with the specified alias. If the given
 // alias is not associated with a device, this returns nil.
 func (cache *AliasCache) Get(alias string) *Device {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+
 	return cache.cache[alias]
 }
+
+// Remove removes an alias mapping from the cache.
+func (cache *AliasCache) Remove(alias string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	delete(cache.cache, alias)
+}
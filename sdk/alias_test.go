@@ -76,3 +76,20 @@ func TestAliasCache_Get_noMatch(t *testing.T) {
 	device := c.Get("alias-unknown")
 	assert.Nil(t, device)
 }
+
+func TestAliasCache_Remove(t *testing.T) {
+	c := AliasCache{
+		cache: map[string]*Device{
+			"alias-1": {id: "123"},
+			"alias-2": {id: "456"},
+		},
+	}
+
+	c.Remove("alias-1")
+	assert.Nil(t, c.Get("alias-1"))
+	assert.NotNil(t, c.Get("alias-2"))
+
+	// Removing an unknown alias is a no-op.
+	c.Remove("alias-unknown")
+	assert.Len(t, c.cache, 1)
+}
@@ -0,0 +1,123 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
+)
+
+// Circuit breaker states.
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// circuitBreaker tracks read failures for a single device and determines
+// whether the device should be read.
+//
+// A breaker starts out closed, where all reads are allowed. Once the number
+// of consecutive failures reaches the configured threshold, the breaker opens
+// and reads are skipped until the backoff period elapses. At that point, the
+// breaker is half-open and a single trial read is allowed. If the trial read
+// succeeds, the breaker closes; if it fails, the breaker opens again with the
+// backoff period increased by the configured multiplier, up to the max backoff.
+type circuitBreaker struct {
+	config *config.CircuitBreakerSettings
+
+	lock     sync.Mutex
+	state    string
+	failures int
+	backoff  time.Duration
+	openedAt time.Time
+}
+
+// newCircuitBreaker creates a new closed circuit breaker.
+func newCircuitBreaker(conf *config.CircuitBreakerSettings) *circuitBreaker {
+	return &circuitBreaker{
+		config: conf,
+		state:  breakerClosed,
+	}
+}
+
+// allow checks whether a read should be attempted. If the breaker is open and its
+// backoff period has elapsed, it transitions to half-open and allows a trial read.
+func (breaker *circuitBreaker) allow(now time.Time) bool {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	switch breaker.state {
+	case breakerOpen:
+		if now.Sub(breaker.openedAt) < breaker.backoff {
+			return false
+		}
+		breaker.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		// A trial read is already in progress.
+		return false
+	default:
+		return true
+	}
+}
+
+// success records a successful read, closing the breaker.
+func (breaker *circuitBreaker) success() {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	breaker.state = breakerClosed
+	breaker.failures = 0
+	breaker.backoff = 0
+}
+
+// failure records a failed read. It returns true if the failure caused the
+// breaker to open.
+func (breaker *circuitBreaker) failure(now time.Time) bool {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	breaker.failures++
+
+	switch breaker.state {
+	case breakerHalfOpen:
+		// The trial read failed, so back off for longer.
+		breaker.backoff = nextBackoff(breaker.backoff, breaker.config.MaxBackoff, breaker.config.Multiplier)
+	case breakerClosed:
+		if breaker.failures < breaker.config.FailureThreshold {
+			return false
+		}
+		breaker.backoff = breaker.config.InitialBackoff
+	default:
+		return false
+	}
+
+	breaker.state = breakerOpen
+	breaker.openedAt = now
+	return true
+}
+
+// getState gets the current state of the breaker.
+func (breaker *circuitBreaker) getState() string {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	return breaker.state
+}
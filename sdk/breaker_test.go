@@ -0,0 +1,93 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
+)
+
+func testBreakerConfig() *config.CircuitBreakerSettings {
+	return &config.CircuitBreakerSettings{
+		Enabled:          true,
+		FailureThreshold: 2,
+		InitialBackoff:   1 * time.Second,
+		MaxBackoff:       3 * time.Second,
+		Multiplier:       2,
+	}
+}
+
+func TestNewCircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker(testBreakerConfig())
+	assert.Equal(t, breakerClosed, b.getState())
+	assert.True(t, b.allow(time.Now()))
+}
+
+func TestCircuitBreaker_opensAtThreshold(t *testing.T) {
+	now := time.Now()
+	b := newCircuitBreaker(testBreakerConfig())
+
+	assert.False(t, b.failure(now))
+	assert.Equal(t, breakerClosed, b.getState())
+	assert.True(t, b.allow(now))
+
+	assert.True(t, b.failure(now))
+	assert.Equal(t, breakerOpen, b.getState())
+	assert.False(t, b.allow(now))
+	assert.False(t, b.allow(now.Add(500*time.Millisecond)))
+}
+
+func TestCircuitBreaker_halfOpen(t *testing.T) {
+	now := time.Now()
+	b := newCircuitBreaker(testBreakerConfig())
+	b.failure(now)
+	b.failure(now)
+
+	// Once the backoff elapses, a single trial read is allowed.
+	assert.True(t, b.allow(now.Add(1*time.Second)))
+	assert.Equal(t, breakerHalfOpen, b.getState())
+	assert.False(t, b.allow(now.Add(1*time.Second)))
+
+	// The trial read succeeds, closing the breaker.
+	b.success()
+	assert.Equal(t, breakerClosed, b.getState())
+	assert.True(t, b.allow(now.Add(1*time.Second)))
+}
+
+func TestCircuitBreaker_backoff(t *testing.T) {
+	now := time.Now()
+	b := newCircuitBreaker(testBreakerConfig())
+	b.failure(now)
+	b.failure(now)
+	assert.Equal(t, 1*time.Second, b.backoff)
+
+	// Failed trial read; the backoff doubles.
+	now = now.Add(1 * time.Second)
+	assert.True(t, b.allow(now))
+	assert.True(t, b.failure(now))
+	assert.Equal(t, 2*time.Second, b.backoff)
+	assert.False(t, b.allow(now.Add(1*time.Second)))
+
+	// Failed trial read; the backoff is capped by the max backoff.
+	now = now.Add(2 * time.Second)
+	assert.True(t, b.allow(now))
+	assert.True(t, b.failure(now))
+	assert.Equal(t, 3*time.Second, b.backoff)
+}
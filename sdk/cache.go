@@ -0,0 +1,69 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
+)
+
+// Readings cache backends.
+const (
+	cacheBackendMemory = "memory"
+	cacheBackendDisk   = "disk"
+)
+
+// ReadingsCache is a store for the readings collected by the plugin. It holds
+// a window of historical readings which are made available via the ReadCache
+// gRPC API.
+//
+// The SDK provides an in-memory and an on-disk implementation, selected via the
+// "backend" field of the plugin's cache settings. Plugins may provide their own
+// implementation via the CustomReadingsCache plugin option.
+type ReadingsCache interface {
+	// Open prepares the cache for use. It is called once on plugin startup,
+	// prior to any readings being cached.
+	Open() error
+
+	// Put adds the readings in the given ReadContext to the cache.
+	Put(ctx *ReadContext) error
+
+	// Query passes all cached ReadContexts which were added within the given
+	// start and end bounds to the provided channel, in the order they were added.
+	// A zero-valued bound means that side of the window is unbounded. Query should
+	// not close the channel.
+	Query(start, end time.Time, readings chan<- *ReadContext) error
+
+	// Close closes the cache. It is called on plugin shutdown.
+	Close() error
+}
+
+// newReadingsCache creates a new ReadingsCache for the backend specified in the
+// cache configuration. The lookup function is used by backends which need to
+// resolve a device from its ID when loading cached readings.
+func newReadingsCache(conf *config.CacheSettings, lookup func(string) *Device) (ReadingsCache, error) {
+	switch conf.Backend {
+	case "", cacheBackendMemory:
+		return newMemoryCache(conf), nil
+	case cacheBackendDisk:
+		return newDiskCache(conf, lookup), nil
+	default:
+		return nil, fmt.Errorf("unsupported readings cache backend: %s", conf.Backend)
+	}
+}
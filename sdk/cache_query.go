@@ -0,0 +1,280 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/vapor-ware/synse-sdk/v2/sdk/utils"
+	synse "github.com/vapor-ware/synse-server-grpc/go"
+)
+
+// CacheQuery specifies which cached readings to get from the plugin's readings
+// cache. If the plugin does not have a readings cache enabled, the query is run
+// against the plugin's current readings state.
+type CacheQuery struct {
+	// Start is the lower time bound for cached readings. If zero, there is
+	// no lower bound.
+	Start time.Time
+
+	// End is the upper time bound for cached readings. If zero, there is
+	// no upper bound.
+	End time.Time
+
+	// Devices are the IDs of the devices to get cached readings for.
+	Devices []string
+
+	// Selectors are device selectors for the devices to get cached readings
+	// for. The devices matched by the selectors are combined with any devices
+	// specified by ID. If neither Devices nor Selectors are set, cached readings
+	// for all devices are returned.
+	Selectors []*synse.V3DeviceSelector
+
+	// Limit is the maximum number of reading sets to return. If 0, there is
+	// no limit.
+	Limit int
+
+	// After is the position of the last result of the previous page. Only
+	// results after it are returned. It is set on the Next query of a page,
+	// and should otherwise be nil.
+	After *CacheCursor
+}
+
+// CacheCursor is a position in the results of a CacheQuery. Results are ordered
+// by time, then by key.
+type CacheCursor struct {
+	// Time is the time of the result. For cached readings, this is the time the
+	// readings were added to the cache.
+	Time time.Time
+
+	// Key orders results with the same time.
+	Key string
+}
+
+// before checks whether the cursor is ordered before the given position.
+func (cursor *CacheCursor) before(ts time.Time, key string) bool {
+	if !cursor.Time.Equal(ts) {
+		return cursor.Time.Before(ts)
+	}
+	return cursor.Key < key
+}
+
+// CachePage is a page of results for a CacheQuery.
+type CachePage struct {
+	// Readings are the matching reading sets, ordered by the time they were
+	// cached, oldest first.
+	Readings []*ReadContext
+
+	// Next is the query for the next page of results. It is nil if there are
+	// no more results.
+	Next *CacheQuery
+}
+
+// QueryCachedReadings gets the cached readings which match the given query.
+//
+// Results are ordered by the time they were cached and can be paged through by
+// setting a limit on the query and following the Next query of each returned
+// page until it is nil. Pages resume after the last result of the previous page,
+// so results are not skipped if older readings expire from the cache between
+// pages.
+func (plugin *Plugin) QueryCachedReadings(query *CacheQuery) (*CachePage, error) {
+	return plugin.state.QueryCachedReadings(query)
+}
+
+// QueryCachedReadings gets the cached readings which match the given query, in
+// the order they were cached. If the plugin does not have a readings cache
+// enabled, the current readings state is used instead, ordered by reading time.
+func (manager *stateManager) QueryCachedReadings(query *CacheQuery) (*CachePage, error) {
+	if query == nil {
+		return nil, errors.New("cannot query cached readings with nil query")
+	}
+	if query.Limit < 0 {
+		return nil, errors.New("cache query limit must not be negative")
+	}
+
+	devices, err := manager.queryDevices(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the cached readings at or after the cursor need to be read from the
+	// cache. Caches compare bounds at the timestamp resolution, so the bound is
+	// truncated to it.
+	start := query.Start
+	if query.After != nil {
+		if after := query.After.Time.Truncate(utils.TimestampResolution()); after.After(start) {
+			start = after
+		}
+	}
+
+	// Collect the page of matching readings. The readings cache passes results
+	// through a channel, so it is read from concurrently.
+	results := newCachePageCollector(query.Limit)
+	readings := make(chan *ReadContext, 128)
+	go func() {
+		defer close(readings)
+		if manager.cacheEnabled() {
+			manager.dumpCachedReadings(start, query.End, readings)
+		} else {
+			manager.dumpCurrentReadings(readings)
+		}
+	}()
+	for r := range readings {
+		if r == nil || r.Device == nil {
+			continue
+		}
+		if devices != nil {
+			if _, ok := devices[r.Device.id]; !ok {
+				continue
+			}
+		}
+		ts, key := cachedReadingPosition(r)
+		if query.After != nil && !query.After.before(ts, key) {
+			continue
+		}
+		results.add(r, ts, key)
+	}
+
+	page := &CachePage{}
+	for _, r := range results.page() {
+		page.Readings = append(page.Readings, r.ctx)
+	}
+	if cursor := results.next(); cursor != nil {
+		next := *query
+		next.After = cursor
+		page.Next = &next
+	}
+	return page, nil
+}
+
+// cachedReadingPosition gets the position of a reading set in the results of a
+// cache query. Reading sets are ordered by the time they were added to the cache,
+// then by device and sequence number. If the time the readings were cached is not
+// known (e.g. for the current readings state or a custom readings cache), the time
+// the readings were taken is used.
+func cachedReadingPosition(ctx *ReadContext) (time.Time, string) {
+	ts := ctx.cached
+	if ts.IsZero() {
+		ts = readContextTime(ctx)
+	}
+	return ts, fmt.Sprintf("%s\x00%020d", ctx.Device.id, ctx.sequence)
+}
+
+// cacheResult is a reading set in the results of a cache query.
+type cacheResult struct {
+	ctx  *ReadContext
+	time time.Time
+	key  string
+}
+
+// cachePageCollector collects the first page of results for a cache query. It
+// only retains the results which may be in the page, plus one result to know
+// whether there is a next page.
+type cachePageCollector struct {
+	limit   int
+	results []cacheResult
+}
+
+// newCachePageCollector creates a collector for pages of the given size. If the
+// limit is 0, all results are collected.
+func newCachePageCollector(limit int) *cachePageCollector {
+	return &cachePageCollector{limit: limit}
+}
+
+// add adds a result to the collector, in order. Results at the same position
+// are kept in the order they were added.
+func (c *cachePageCollector) add(ctx *ReadContext, ts time.Time, key string) {
+	less := func(i int) bool {
+		r := c.results[i]
+		if !ts.Equal(r.time) {
+			return ts.Before(r.time)
+		}
+		return key < r.key
+	}
+
+	// Cached readings generally arrive in order, so check against the last
+	// result first.
+	n := len(c.results)
+	if c.limit > 0 && n > c.limit && !less(n-1) {
+		return
+	}
+	i := sort.Search(n, less)
+	c.results = append(c.results, cacheResult{})
+	copy(c.results[i+1:], c.results[i:])
+	c.results[i] = cacheResult{ctx: ctx, time: ts, key: key}
+	if c.limit > 0 && len(c.results) > c.limit+1 {
+		c.results = c.results[:c.limit+1]
+	}
+}
+
+// page gets the collected results in the page.
+func (c *cachePageCollector) page() []cacheResult {
+	if c.limit > 0 && len(c.results) > c.limit {
+		return c.results[:c.limit]
+	}
+	return c.results
+}
+
+// next gets the cursor for the next page of results, or nil if there are no
+// more results.
+func (c *cachePageCollector) next() *CacheCursor {
+	if c.limit == 0 || len(c.results) <= c.limit {
+		return nil
+	}
+	last := c.results[c.limit-1]
+	return &CacheCursor{Time: last.time, Key: last.key}
+}
+
+// queryDevices gets the set of device IDs which a cache query is limited to. If
+// the query does not specify any devices, nil is returned.
+func (manager *stateManager) queryDevices(query *CacheQuery) (map[string]struct{}, error) {
+	if len(query.Devices) == 0 && len(query.Selectors) == 0 {
+		return nil, nil
+	}
+
+	devices := make(map[string]struct{})
+	for _, id := range query.Devices {
+		devices[id] = struct{}{}
+	}
+	for _, selector := range query.Selectors {
+		devs, err := manager.deviceManager.GetDevices(selector)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range devs {
+			devices[d.id] = struct{}{}
+		}
+	}
+	return devices, nil
+}
+
+// readContextTime gets the time at which the readings in a ReadContext were taken.
+// If the time can not be determined, a zero time is returned.
+func readContextTime(ctx *ReadContext) time.Time {
+	for _, r := range ctx.Reading {
+		if r == nil {
+			continue
+		}
+		if ts := readingTime(r, time.Time{}); !ts.IsZero() {
+			return ts
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,269 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/output"
+	synse "github.com/vapor-ware/synse-server-grpc/go"
+)
+
+// newQueryTestStateManager creates a state manager with a readings cache holding
+// a reading for devices "1", "2" and "3". The readings are cached out of the order
+// of their reading timestamps.
+func newQueryTestStateManager() *stateManager {
+	devices := map[string]*Device{
+		"1": {id: "1"},
+		"2": {id: "2"},
+		"3": {id: "3"},
+	}
+	sm := newTestStateManager(&deviceManager{devices: devices})
+	sm.config.Cache = &config.CacheSettings{Enabled: true}
+	sm.readingsCache = newMemoryCache(&config.CacheSettings{TTL: time.Minute})
+
+	start := time.Now().Add(-time.Second)
+	for i, r := range []struct {
+		device string
+		ts     string
+	}{
+		{"2", "2019-03-22T09:48:02Z"},
+		{"1", "2019-03-22T09:48:00Z"},
+		{"3", "2019-03-22T09:48:03Z"},
+		{"1", "2019-03-22T09:48:01Z"},
+	} {
+		sm.readingsCache.(*memoryCache).add(&ReadContext{
+			Device:  devices[r.device],
+			Reading: []*output.Reading{{Timestamp: r.ts, Value: 1}},
+		}, start.Add(time.Duration(i)*time.Millisecond))
+	}
+	return sm
+}
+
+// queryTestTimestamps gets the reading timestamps of the reading sets.
+func queryTestTimestamps(readings []*ReadContext) []string {
+	var timestamps []string
+	for _, r := range readings {
+		timestamps = append(timestamps, r.Reading[0].Timestamp)
+	}
+	return timestamps
+}
+
+func TestStateManager_QueryCachedReadings_nilQuery(t *testing.T) {
+	sm := newQueryTestStateManager()
+
+	page, err := sm.QueryCachedReadings(nil)
+	assert.Error(t, err)
+	assert.Nil(t, page)
+}
+
+func TestStateManager_QueryCachedReadings_negativeLimit(t *testing.T) {
+	sm := newQueryTestStateManager()
+
+	page, err := sm.QueryCachedReadings(&CacheQuery{Limit: -1})
+	assert.Error(t, err)
+	assert.Nil(t, page)
+}
+
+func TestStateManager_QueryCachedReadings_ordered(t *testing.T) {
+	sm := newQueryTestStateManager()
+
+	// Readings are ordered by the time they were cached, which is the time the
+	// query bounds are compared against, not by their reading timestamps.
+	page, err := sm.QueryCachedReadings(&CacheQuery{})
+	assert.NoError(t, err)
+	assert.Nil(t, page.Next)
+	assert.Equal(t, []string{
+		"2019-03-22T09:48:02Z",
+		"2019-03-22T09:48:00Z",
+		"2019-03-22T09:48:03Z",
+		"2019-03-22T09:48:01Z",
+	}, queryTestTimestamps(page.Readings))
+}
+
+func TestStateManager_QueryCachedReadings_sameCacheTime(t *testing.T) {
+	sm := newQueryTestStateManager()
+	now := time.Now()
+	for _, r := range []*ReadContext{
+		{Device: &Device{id: "5"}, Reading: []*output.Reading{{Timestamp: "c", Value: 1}}, sequence: 2},
+		{Device: &Device{id: "4"}, Reading: []*output.Reading{{Timestamp: "b", Value: 1}}, sequence: 3},
+		{Device: &Device{id: "4"}, Reading: []*output.Reading{{Timestamp: "a", Value: 1}}, sequence: 1},
+	} {
+		sm.readingsCache.(*memoryCache).add(r, now)
+	}
+
+	// Readings cached at the same time are ordered by device, then sequence.
+	page, err := sm.QueryCachedReadings(&CacheQuery{Devices: []string{"4", "5"}, Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, queryTestTimestamps(page.Readings))
+	assert.NotNil(t, page.Next)
+
+	page, err = sm.QueryCachedReadings(page.Next)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c"}, queryTestTimestamps(page.Readings))
+	assert.Nil(t, page.Next)
+}
+
+func TestStateManager_QueryCachedReadings_devices(t *testing.T) {
+	sm := newQueryTestStateManager()
+
+	page, err := sm.QueryCachedReadings(&CacheQuery{Devices: []string{"1"}})
+	assert.NoError(t, err)
+	assert.Len(t, page.Readings, 2)
+	for _, r := range page.Readings {
+		assert.Equal(t, "1", r.Device.id)
+	}
+}
+
+func TestStateManager_QueryCachedReadings_selectors(t *testing.T) {
+	sm := newQueryTestStateManager()
+
+	page, err := sm.QueryCachedReadings(&CacheQuery{
+		Devices:   []string{"2"},
+		Selectors: []*synse.V3DeviceSelector{{Id: "3"}},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, page.Readings, 2)
+	assert.Equal(t, "2", page.Readings[0].Device.id)
+	assert.Equal(t, "3", page.Readings[1].Device.id)
+}
+
+func TestStateManager_QueryCachedReadings_selectorError(t *testing.T) {
+	sm := newQueryTestStateManager()
+	sm.deviceManager.aliasCache = NewAliasCache()
+
+	page, err := sm.QueryCachedReadings(&CacheQuery{
+		Selectors: []*synse.V3DeviceSelector{{Id: "does-not-exist"}},
+	})
+	assert.Error(t, err)
+	assert.Nil(t, page)
+}
+
+func TestStateManager_QueryCachedReadings_pagination(t *testing.T) {
+	sm := newQueryTestStateManager()
+
+	var timestamps []string
+	pages := 0
+	for q := (&CacheQuery{Limit: 3}); q != nil; {
+		page, err := sm.QueryCachedReadings(q)
+		assert.NoError(t, err)
+		for _, r := range page.Readings {
+			timestamps = append(timestamps, r.Reading[0].Timestamp)
+		}
+		q = page.Next
+		pages++
+	}
+
+	assert.Equal(t, 2, pages)
+	assert.Equal(t, []string{
+		"2019-03-22T09:48:02Z",
+		"2019-03-22T09:48:00Z",
+		"2019-03-22T09:48:03Z",
+		"2019-03-22T09:48:01Z",
+	}, timestamps)
+}
+
+func TestStateManager_QueryCachedReadings_paginationEviction(t *testing.T) {
+	sm := newQueryTestStateManager()
+	cache := sm.readingsCache.(*memoryCache)
+
+	page, err := sm.QueryCachedReadings(&CacheQuery{Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2019-03-22T09:48:02Z", "2019-03-22T09:48:00Z"}, queryTestTimestamps(page.Readings))
+
+	// Evicting readings from earlier pages does not cause later readings to be
+	// skipped.
+	cache.lock.Lock()
+	cache.evictOldest(evictionExpired)
+	cache.evictOldest(evictionExpired)
+	cache.lock.Unlock()
+
+	page, err = sm.QueryCachedReadings(page.Next)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2019-03-22T09:48:03Z", "2019-03-22T09:48:01Z"}, queryTestTimestamps(page.Readings))
+	assert.Nil(t, page.Next)
+}
+
+func TestStateManager_QueryCachedReadings_afterEnd(t *testing.T) {
+	sm := newQueryTestStateManager()
+
+	page, err := sm.QueryCachedReadings(&CacheQuery{After: &CacheCursor{Time: time.Now().Add(time.Hour)}})
+	assert.NoError(t, err)
+	assert.Empty(t, page.Readings)
+	assert.Nil(t, page.Next)
+}
+
+func TestCachePageCollector(t *testing.T) {
+	c := newCachePageCollector(2)
+	base := time.Now()
+	for _, i := range []int{3, 1, 4, 0, 2} {
+		c.add(&ReadContext{sequence: uint64(i)}, base.Add(time.Duration(i)*time.Second), "")
+	}
+
+	var seqs []uint64
+	for _, r := range c.page() {
+		seqs = append(seqs, r.ctx.sequence)
+	}
+	assert.Equal(t, []uint64{0, 1}, seqs)
+	assert.Equal(t, &CacheCursor{Time: base.Add(time.Second)}, c.next())
+	assert.Len(t, c.results, 3)
+
+	all := newCachePageCollector(0)
+	all.add(&ReadContext{}, base, "")
+	assert.Len(t, all.page(), 1)
+	assert.Nil(t, all.next())
+}
+
+func TestStateManager_QueryCachedReadings_cacheDisabled(t *testing.T) {
+	sm := &stateManager{
+		deviceManager: &deviceManager{
+			devices: map[string]*Device{
+				"1": {id: "1"},
+				"2": {id: "2"},
+			},
+		},
+		config: &config.PluginSettings{
+			Cache: &config.CacheSettings{
+				Enabled: false,
+			},
+		},
+		readingsLock: &sync.RWMutex{},
+		readings: map[string][]*output.Reading{
+			"1": {{Timestamp: "2019-03-22T09:48:01Z", Value: 1}},
+			"2": {{Timestamp: "2019-03-22T09:48:00Z", Value: 2}},
+		},
+	}
+
+	page, err := sm.QueryCachedReadings(&CacheQuery{})
+	assert.NoError(t, err)
+	assert.Len(t, page.Readings, 2)
+	assert.Equal(t, "2", page.Readings[0].Device.id)
+	assert.Equal(t, "1", page.Readings[1].Device.id)
+}
+
+func TestPlugin_QueryCachedReadings(t *testing.T) {
+	p := Plugin{state: newQueryTestStateManager()}
+
+	page, err := p.QueryCachedReadings(&CacheQuery{Devices: []string{"3"}})
+	assert.NoError(t, err)
+	assert.Len(t, page.Readings, 1)
+	assert.Equal(t, "3", page.Readings[0].Device.id)
+}
@@ -0,0 +1,49 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
+)
+
+func TestNewReadingsCache_memory(t *testing.T) {
+	c, err := newReadingsCache(&config.CacheSettings{Backend: "memory", TTL: time.Minute}, nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &memoryCache{}, c)
+}
+
+func TestNewReadingsCache_default(t *testing.T) {
+	c, err := newReadingsCache(&config.CacheSettings{TTL: time.Minute}, nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &memoryCache{}, c)
+}
+
+func TestNewReadingsCache_disk(t *testing.T) {
+	c, err := newReadingsCache(&config.CacheSettings{Backend: "disk", TTL: time.Minute}, nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &diskCache{}, c)
+}
+
+func TestNewReadingsCache_unsupported(t *testing.T) {
+	c, err := newReadingsCache(&config.CacheSettings{Backend: "foo"}, nil)
+	assert.Error(t, err)
+	assert.Nil(t, c)
+}
@@ -0,0 +1,150 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
+)
+
+// concurrencyGroups limits the number of concurrent device operations (reads and
+// writes) for groups of devices, such as devices which share a physical bus.
+//
+// Each group has a semaphore, created when the group is first used, which holds
+// up to the group's max concurrency. Devices which are not in a group are not
+// limited.
+type concurrencyGroups struct {
+	conf *config.ConcurrencySettings
+
+	lock       sync.Mutex
+	semaphores map[string]chan struct{}
+}
+
+// newConcurrencyGroups creates the concurrency groups for the given settings.
+func newConcurrencyGroups(conf *config.ConcurrencySettings) *concurrencyGroups {
+	return &concurrencyGroups{
+		conf:       conf,
+		semaphores: make(map[string]chan struct{}),
+	}
+}
+
+// groupFor gets the concurrency group for a device. If the device's handler has a
+// ConcurrencyGroup function, it determines the group; otherwise, the group is the
+// value of the configured group key in the device's data. If the device is not in
+// a group, an empty string is returned.
+func (groups *concurrencyGroups) groupFor(device *Device) string {
+	if device == nil {
+		return ""
+	}
+	if device.handler != nil && device.handler.ConcurrencyGroup != nil {
+		return device.handler.ConcurrencyGroup(device)
+	}
+	if groups.conf == nil || groups.conf.GroupKey == "" {
+		return ""
+	}
+	if value, ok := device.Data[groups.conf.GroupKey]; ok && value != nil {
+		return fmt.Sprint(value)
+	}
+	return ""
+}
+
+// limit gets the max concurrency for a group. Limits less than 1 are treated as 1.
+func (groups *concurrencyGroups) limit(group string) int {
+	var limit int
+	if groups.conf != nil {
+		limit = groups.conf.MaxConcurrency
+		if l, ok := groups.conf.Groups[group]; ok {
+			limit = l
+		}
+	}
+	if limit < 1 {
+		limit = 1
+	}
+	return limit
+}
+
+// semaphore gets the semaphore for a group, creating it if needed.
+func (groups *concurrencyGroups) semaphore(group string) chan struct{} {
+	groups.lock.Lock()
+	defer groups.lock.Unlock()
+
+	sem, ok := groups.semaphores[group]
+	if !ok {
+		limit := groups.limit(group)
+		log.WithFields(log.Fields{
+			"group": group,
+			"limit": limit,
+		}).Debug("[scheduler] creating device concurrency group")
+		sem = make(chan struct{}, limit)
+		groups.semaphores[group] = sem
+	}
+	return sem
+}
+
+// acquire acquires a slot in the concurrency group of each of the given devices,
+// blocking until one is available in every group. The returned function releases
+// the acquired slots. If the context is done before the slots are acquired, any
+// slots which were acquired are released and the context's error is returned.
+//
+// Acquiring from a nil concurrencyGroups, or for devices which are not in a group,
+// does not block.
+func (groups *concurrencyGroups) acquire(ctx context.Context, devices ...*Device) (func(), error) {
+	if groups == nil {
+		return func() {}, nil
+	}
+
+	// Get the distinct groups for the devices. The groups are acquired in sorted
+	// order so that operations spanning multiple groups (e.g. bulk reads) can not
+	// deadlock with one another.
+	var names []string
+	seen := make(map[string]struct{})
+	for _, device := range devices {
+		group := groups.groupFor(device)
+		if group == "" {
+			continue
+		}
+		if _, ok := seen[group]; ok {
+			continue
+		}
+		seen[group] = struct{}{}
+		names = append(names, group)
+	}
+	sort.Strings(names)
+
+	var acquired []chan struct{}
+	release := func() {
+		for _, sem := range acquired {
+			<-sem
+		}
+	}
+	for _, group := range names {
+		sem := groups.semaphore(group)
+		select {
+		case sem <- struct{}{}:
+			acquired = append(acquired, sem)
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
@@ -0,0 +1,212 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
+	synse "github.com/vapor-ware/synse-server-grpc/go"
+)
+
+func TestConcurrencyGroups_groupFor(t *testing.T) {
+	groups := newConcurrencyGroups(&config.ConcurrencySettings{GroupKey: "bus"})
+
+	assert.Equal(t, "", groups.groupFor(nil))
+	assert.Equal(t, "", groups.groupFor(&Device{}))
+	assert.Equal(t, "i2c-1", groups.groupFor(&Device{Data: map[string]interface{}{"bus": "i2c-1"}}))
+	assert.Equal(t, "3", groups.groupFor(&Device{Data: map[string]interface{}{"bus": 3}}))
+}
+
+func TestConcurrencyGroups_groupFor_handler(t *testing.T) {
+	groups := newConcurrencyGroups(&config.ConcurrencySettings{GroupKey: "bus"})
+
+	// The handler's ConcurrencyGroup takes precedence over the group key.
+	device := &Device{
+		Data: map[string]interface{}{"bus": "i2c-1"},
+		handler: &DeviceHandler{
+			ConcurrencyGroup: func(d *Device) string {
+				return "modbus"
+			},
+		},
+	}
+	assert.Equal(t, "modbus", groups.groupFor(device))
+}
+
+func TestConcurrencyGroups_groupFor_noKey(t *testing.T) {
+	groups := newConcurrencyGroups(nil)
+	assert.Equal(t, "", groups.groupFor(&Device{Data: map[string]interface{}{"bus": "i2c-1"}}))
+}
+
+func TestConcurrencyGroups_limit(t *testing.T) {
+	groups := newConcurrencyGroups(&config.ConcurrencySettings{
+		MaxConcurrency: 2,
+		Groups: map[string]int{
+			"fast": 4,
+			"bad":  0,
+		},
+	})
+
+	assert.Equal(t, 2, groups.limit("other"))
+	assert.Equal(t, 4, groups.limit("fast"))
+	assert.Equal(t, 1, groups.limit("bad"))
+	assert.Equal(t, 1, newConcurrencyGroups(nil).limit("other"))
+}
+
+func TestConcurrencyGroups_acquire_nil(t *testing.T) {
+	var groups *concurrencyGroups
+
+	release, err := groups.acquire(context.Background(), &Device{})
+	assert.NoError(t, err)
+	assert.NotPanics(t, release)
+}
+
+func TestConcurrencyGroups_acquire_noGroup(t *testing.T) {
+	groups := newConcurrencyGroups(&config.ConcurrencySettings{GroupKey: "bus", MaxConcurrency: 1})
+	device := &Device{}
+
+	// Devices not in a group are never limited.
+	release1, err := groups.acquire(context.Background(), device)
+	assert.NoError(t, err)
+	release2, err := groups.acquire(context.Background(), device)
+	assert.NoError(t, err)
+	release1()
+	release2()
+	assert.Empty(t, groups.semaphores)
+}
+
+func TestConcurrencyGroups_acquire_limit(t *testing.T) {
+	groups := newConcurrencyGroups(&config.ConcurrencySettings{GroupKey: "bus", MaxConcurrency: 1})
+	d1 := &Device{id: "1", Data: map[string]interface{}{"bus": "a"}}
+	d2 := &Device{id: "2", Data: map[string]interface{}{"bus": "a"}}
+	d3 := &Device{id: "3", Data: map[string]interface{}{"bus": "b"}}
+
+	release, err := groups.acquire(context.Background(), d1)
+	assert.NoError(t, err)
+
+	// A device in a different group is not blocked.
+	releaseOther, err := groups.acquire(context.Background(), d3)
+	assert.NoError(t, err)
+	releaseOther()
+
+	// A device in the same group blocks until the slot is released.
+	acquired := make(chan struct{})
+	go func() {
+		r, err := groups.acquire(context.Background(), d2)
+		assert.NoError(t, err)
+		close(acquired)
+		r()
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquired concurrency group slot while group is at its limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("did not acquire concurrency group slot after it was released")
+	}
+}
+
+func TestConcurrencyGroups_acquire_multipleGroups(t *testing.T) {
+	groups := newConcurrencyGroups(&config.ConcurrencySettings{GroupKey: "bus", MaxConcurrency: 1})
+	devices := []*Device{
+		{id: "1", Data: map[string]interface{}{"bus": "b"}},
+		{id: "2", Data: map[string]interface{}{"bus": "a"}},
+		{id: "3", Data: map[string]interface{}{"bus": "b"}},
+	}
+
+	// Devices sharing a group only take a single slot in it.
+	release, err := groups.acquire(context.Background(), devices...)
+	assert.NoError(t, err)
+	assert.Len(t, groups.semaphores, 2)
+	assert.Len(t, groups.semaphores["a"], 1)
+	assert.Len(t, groups.semaphores["b"], 1)
+
+	release()
+	assert.Len(t, groups.semaphores["a"], 0)
+	assert.Len(t, groups.semaphores["b"], 0)
+}
+
+func TestConcurrencyGroups_acquire_cancelled(t *testing.T) {
+	groups := newConcurrencyGroups(&config.ConcurrencySettings{GroupKey: "bus", MaxConcurrency: 1})
+	held := &Device{id: "1", Data: map[string]interface{}{"bus": "b"}}
+
+	release, err := groups.acquire(context.Background(), held)
+	assert.NoError(t, err)
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Slots acquired before the context is done are released.
+	_, err = groups.acquire(ctx, &Device{id: "2", Data: map[string]interface{}{"bus": "a"}}, held)
+	assert.Equal(t, context.Canceled, err)
+	assert.Len(t, groups.semaphores["a"], 0)
+}
+
+func TestScheduler_write_concurrencyGroup(t *testing.T) {
+	called := false
+	handler := &DeviceHandler{
+		Name: "test",
+		Write: func(device *Device, data *WriteData) error {
+			called = true
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := scheduler{
+		config: &config.PluginSettings{
+			Mode:  "parallel",
+			Write: &config.WriteSettings{},
+		},
+		concurrency: newConcurrencyGroups(&config.ConcurrencySettings{GroupKey: "bus", MaxConcurrency: 1}),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	device := &Device{
+		id:           "123",
+		handler:      handler,
+		Data:         map[string]interface{}{"bus": "a"},
+		WriteTimeout: time.Second,
+	}
+
+	release, err := s.concurrency.acquire(context.Background(), device)
+	assert.NoError(t, err)
+	defer release()
+
+	// Shutting down interrupts writes waiting on the device's concurrency group.
+	cancel()
+	txn := newTransaction(time.Second, "")
+	s.write(&WriteContext{
+		transaction: txn,
+		device:      device,
+		data:        &synse.V3WriteData{Action: "test"},
+	})
+
+	assert.False(t, called)
+	assert.Equal(t, synse.WriteStatus_ERROR, txn.status)
+	assert.Equal(t, "write interrupted: plugin shutting down", txn.message)
+}
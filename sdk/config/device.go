@@ -73,6 +73,18 @@ type DeviceProto struct {
 	// back to the default value of 30s.
 	WriteTimeout time.Duration `yaml:"writeTimeout,omitempty"`
 
+	// ReadInterval defines a custom read interval for all instances of the
+	// device prototype. Devices with a custom interval are read on their own
+	// schedule, independent of other devices. If left unspecified, the interval
+	// of the device's handler is used, falling back to the plugin's global
+	// read interval.
+	ReadInterval time.Duration `yaml:"readInterval,omitempty"`
+
+	// MaxReadingAge defines the maximum age of the current reading for all
+	// instances of the device prototype, after which the reading is considered
+	// stale. If left unspecified, the plugin's global max age is used.
+	MaxReadingAge time.Duration `yaml:"maxReadingAge,omitempty"`
+
 	// Context defines any context information which should be associated
 	// with a device instance's reading(s). If specified here, all prototype
 	// instances will inherit the context, unless inheritance is disabled.
@@ -170,6 +182,18 @@ type DeviceInstance struct {
 	// unspecified, it will fall back to the default value of 30s.
 	WriteTimeout time.Duration `yaml:"writeTimeout,omitempty"`
 
+	// ReadInterval defines a custom read interval for the device instance. If
+	// left unspecified, it will fall back to the prototype's read interval, the
+	// interval of the device's handler, or the plugin's global read interval, in
+	// that order.
+	ReadInterval time.Duration `yaml:"readInterval,omitempty"`
+
+	// MaxReadingAge defines the maximum age of the device instance's current
+	// reading, after which the reading is considered stale. If left unspecified,
+	// it will fall back to the prototype's max reading age, or the plugin's
+	// global max age.
+	MaxReadingAge time.Duration `yaml:"maxReadingAge,omitempty"`
+
 	// DisableInheritance determines whether the device instance should inherit
 	// from its device prototype.
 	DisableInheritance bool `default:"false" yaml:"disableInheritance,omitempty"`
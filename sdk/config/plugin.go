@@ -108,6 +108,16 @@ func (conf *IDSettings) Log() {
 type MetricsSettings struct {
 	// Enabled sets whether the application should report metrics or not.
 	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Address is the address which the metrics endpoint is served on.
+	Address string `default:":2112" yaml:"address,omitempty"`
+
+	// Path is the HTTP path of the metrics endpoint.
+	Path string `default:"/metrics" yaml:"path,omitempty"`
+
+	// Readings sets whether numeric device readings are exported as metrics,
+	// in addition to the SDK's internal metrics.
+	Readings bool `default:"true" yaml:"readings,omitempty"`
 }
 
 // Log logs out the config at INFO level.
@@ -116,7 +126,10 @@ func (conf *MetricsSettings) Log() {
 		log.Info("  Metrics: nil")
 	} else {
 		log.Infof("  Metrics:")
-		log.Infof("    Enabled: %v", conf.Enabled)
+		log.Infof("    Enabled:  %v", conf.Enabled)
+		log.Infof("    Address:  %s", conf.Address)
+		log.Infof("    Path:     %s", conf.Path)
+		log.Infof("    Readings: %v", conf.Readings)
 	}
 }
 
@@ -126,6 +139,12 @@ type PluginSettings struct {
 	// be "serial" or "parallel".
 	Mode string `default:"parallel" yaml:"mode,omitempty"`
 
+	// TimestampPrecision is the precision of the timestamps generated by the
+	// plugin, including reading timestamps. This can be one of "second",
+	// "millisecond", "microsecond", or "nanosecond". Sub-second precision is
+	// needed to distinguish and order multiple readings per second.
+	TimestampPrecision string `default:"second" yaml:"timestampPrecision,omitempty"`
+
 	// Listen contains the settings to configure listener behavior.
 	Listen *ListenSettings `default:"{}" yaml:"listen,omitempty"`
 
@@ -145,6 +164,28 @@ type PluginSettings struct {
 	// Cache contains the settings to configure local data caching
 	// by the plugin.
 	Cache *CacheSettings `default:"{}" yaml:"cache,omitempty"`
+
+	// Aggregation contains the settings to configure aggregation of
+	// plugin readings over windows of time.
+	Aggregation *AggregationSettings `default:"{}" yaml:"aggregation,omitempty"`
+
+	// Reload contains the settings to configure reloading of device
+	// configuration while the plugin is running.
+	Reload *ReloadSettings `default:"{}" yaml:"reload,omitempty"`
+
+	// Concurrency contains the settings to configure limits on concurrent
+	// device operations for groups of devices, such as devices which share
+	// a bus.
+	Concurrency *ConcurrencySettings `default:"{}" yaml:"concurrency,omitempty"`
+
+	// Stream contains the settings to configure the streams which send
+	// readings to ReadStream clients.
+	Stream *StreamSettings `default:"{}" yaml:"stream,omitempty"`
+
+	// ShutdownTimeout is the maximum amount of time the plugin will wait
+	// for queued writes and open streams to complete when it is terminated.
+	// Once the timeout is exceeded, any remaining work is abandoned.
+	ShutdownTimeout time.Duration `default:"25s" yaml:"shutdownTimeout,omitempty"`
 }
 
 // Log logs out the config at INFO level.
@@ -153,13 +194,19 @@ func (conf *PluginSettings) Log() {
 		log.Infof("  Settings: nil")
 	} else {
 		log.Infof("  Settings:")
-		log.Infof("    Mode: %s", conf.Mode)
+		log.Infof("    Mode:               %s", conf.Mode)
+		log.Infof("    TimestampPrecision: %s", conf.TimestampPrecision)
+		log.Infof("    ShutdownTimeout:    %v", conf.ShutdownTimeout)
 		conf.Listen.Log()
 		conf.Read.Log()
 		conf.Write.Log()
 		conf.Transaction.Log()
 		conf.Limiter.Log()
 		conf.Cache.Log()
+		conf.Aggregation.Log()
+		conf.Reload.Log()
+		conf.Concurrency.Log()
+		conf.Stream.Log()
 	}
 }
 
@@ -168,6 +215,30 @@ type ListenSettings struct {
 	// Disable can be used to globally disable listening for the plugin.
 	// By default, plugin listening is enabled.
 	Disable bool `default:"false" yaml:"disable,omitempty"`
+
+	// InitialBackoff is the amount of time to wait before restarting a listener
+	// after it first fails.
+	InitialBackoff time.Duration `default:"1s" yaml:"initialBackoff,omitempty"`
+
+	// MaxBackoff is the maximum amount of time to wait before restarting a
+	// failed listener. If a listener runs for longer than this before failing,
+	// its backoff is reset to the InitialBackoff and its restart count is reset.
+	// If 0, the backoff is uncapped and is never reset.
+	MaxBackoff time.Duration `default:"1m" yaml:"maxBackoff,omitempty"`
+
+	// Multiplier is the factor by which the backoff is increased each time a
+	// listener fails in succession.
+	Multiplier float64 `default:"2" yaml:"multiplier,omitempty"`
+
+	// Jitter is the fraction (between 0 and 1) of the backoff by which the wait
+	// time is randomly varied. This keeps listeners which fail together from
+	// restarting in lockstep.
+	Jitter float64 `default:"0.1" yaml:"jitter,omitempty"`
+
+	// MaxRestarts is the maximum number of times in succession a listener is
+	// restarted after failing, after which it is given up on. By default, there
+	// is no limit.
+	MaxRestarts int `default:"0" yaml:"maxRestarts,omitempty"`
 }
 
 // Log logs out the config at INFO level.
@@ -176,7 +247,12 @@ func (conf *ListenSettings) Log() {
 		log.Infof("    Listen: nil")
 	} else {
 		log.Infof("    Listen:")
-		log.Infof("      Disable: %v", conf.Disable)
+		log.Infof("      Disable:        %v", conf.Disable)
+		log.Infof("      InitialBackoff: %v", conf.InitialBackoff)
+		log.Infof("      MaxBackoff:     %v", conf.MaxBackoff)
+		log.Infof("      Multiplier:     %v", conf.Multiplier)
+		log.Infof("      Jitter:         %v", conf.Jitter)
+		log.Infof("      MaxRestarts:    %d", conf.MaxRestarts)
 	}
 }
 
@@ -213,6 +289,22 @@ type ReadSettings struct {
 	// Generally this does not need to be set, but can be used to tune
 	// performance for read-intensive plugins.
 	QueueSize int `default:"128" yaml:"queueSize,omitempty"`
+
+	// MaxAge defines the maximum age of a device's current reading. If a device
+	// has not been successfully read within this window, its reading is considered
+	// stale and is handled according to the StaleAction. Devices may override this
+	// via their own configuration. By default, no max age is set, so readings do
+	// not go stale.
+	MaxAge time.Duration `default:"0s" yaml:"maxAge,omitempty"`
+
+	// StaleAction defines what happens to a reading once it is older than the
+	// configured MaxAge. This can either be "flag", where the reading is returned
+	// with a "stale" field set in its context, or "drop", where the reading is
+	// no longer returned.
+	StaleAction string `default:"flag" yaml:"staleAction,omitempty"`
+
+	// Breaker contains the settings for the per-device read circuit breaker.
+	Breaker *CircuitBreakerSettings `default:"{}" yaml:"breaker,omitempty"`
 }
 
 // Log logs out the config at INFO level.
@@ -221,10 +313,54 @@ func (conf *ReadSettings) Log() {
 		log.Infof("    Read: nil")
 	} else {
 		log.Infof("    Read:")
-		log.Infof("      Disable:   %v", conf.Disable)
-		log.Infof("      QueueSize: %d", conf.QueueSize)
-		log.Infof("      Interval:  %v", conf.Interval)
-		log.Infof("      Delay:     %v", conf.Delay)
+		log.Infof("      Disable:     %v", conf.Disable)
+		log.Infof("      QueueSize:   %d", conf.QueueSize)
+		log.Infof("      Interval:    %v", conf.Interval)
+		log.Infof("      Delay:       %v", conf.Delay)
+		log.Infof("      MaxAge:      %v", conf.MaxAge)
+		log.Infof("      StaleAction: %s", conf.StaleAction)
+		conf.Breaker.Log()
+	}
+}
+
+// CircuitBreakerSettings are the settings for the per-device read circuit breaker.
+//
+// When enabled, a device which fails to be read FailureThreshold times in a row
+// will not be read again until a backoff period elapses. After the backoff, a
+// single trial read is made. If it fails, the backoff period is increased by the
+// Multiplier, up to MaxBackoff. Once a read succeeds, the device is read normally.
+type CircuitBreakerSettings struct {
+	// Enabled sets whether the circuit breaker is enabled for device reads.
+	// By default, it is disabled.
+	Enabled bool `default:"false" yaml:"enabled,omitempty"`
+
+	// FailureThreshold is the number of consecutive read failures for a device
+	// after which reads for that device are suspended.
+	FailureThreshold int `default:"5" yaml:"failureThreshold,omitempty"`
+
+	// InitialBackoff is the amount of time reads for a device are suspended
+	// for once the failure threshold is first reached.
+	InitialBackoff time.Duration `default:"5s" yaml:"initialBackoff,omitempty"`
+
+	// MaxBackoff is the maximum amount of time reads for a device are suspended.
+	MaxBackoff time.Duration `default:"5m" yaml:"maxBackoff,omitempty"`
+
+	// Multiplier is the factor by which the backoff period is increased each
+	// time a trial read fails.
+	Multiplier float64 `default:"2" yaml:"multiplier,omitempty"`
+}
+
+// Log logs out the config at INFO level.
+func (conf *CircuitBreakerSettings) Log() {
+	if conf == nil {
+		log.Infof("      Breaker: nil")
+	} else {
+		log.Infof("      Breaker:")
+		log.Infof("        Enabled:          %v", conf.Enabled)
+		log.Infof("        FailureThreshold: %d", conf.FailureThreshold)
+		log.Infof("        InitialBackoff:   %v", conf.InitialBackoff)
+		log.Infof("        MaxBackoff:       %v", conf.MaxBackoff)
+		log.Infof("        Multiplier:       %v", conf.Multiplier)
 	}
 }
 
@@ -267,6 +403,26 @@ type WriteSettings struct {
 	// Generally, this does not need to be set, but can be used to tune
 	// performance particularly for slow writing serial plugins.
 	BatchSize int `default:"128" yaml:"batchSize,omitempty"`
+
+	// StarvationLimit is the number of times queued writes of a lower priority
+	// may be passed over in favor of higher priority writes before one of them
+	// is processed, so lower priority writes are not starved by a steady stream
+	// of higher priority writes. A limit of 0 disables starvation protection,
+	// so higher priority writes are always processed first.
+	StarvationLimit int `default:"16" yaml:"starvationLimit,omitempty"`
+
+	// SerializeBy determines which writes are serialized when the plugin runs
+	// in parallel mode. Writes which share a serialization key are executed one
+	// at a time, in the order they are taken from the write queue, while writes
+	// with different keys run in parallel. This can be:
+	//   - "device": serialize writes to the same device (default).
+	//   - "handler": serialize writes to devices using the same device handler.
+	//   - "data:<field>": serialize writes to devices which have the same value
+	//     for the given field in their device data, e.g. "data:bus" for devices
+	//     which share a bus. Devices without the field are serialized per device.
+	//   - "none": do not serialize writes.
+	// In serial mode, all writes are serialized regardless of this setting.
+	SerializeBy string `default:"device" yaml:"serializeBy,omitempty"`
 }
 
 // Log logs out the config at INFO level.
@@ -275,11 +431,13 @@ func (conf *WriteSettings) Log() {
 		log.Infof("    Write: nil")
 	} else {
 		log.Infof("    Write:")
-		log.Infof("      Disable:   %v", conf.Disable)
-		log.Infof("      QueueSize: %d", conf.QueueSize)
-		log.Infof("      BatchSize: %d", conf.BatchSize)
-		log.Infof("      Interval:  %v", conf.Interval)
-		log.Infof("      Delay:     %v", conf.Delay)
+		log.Infof("      Disable:         %v", conf.Disable)
+		log.Infof("      QueueSize:       %d", conf.QueueSize)
+		log.Infof("      BatchSize:       %d", conf.BatchSize)
+		log.Infof("      Interval:        %v", conf.Interval)
+		log.Infof("      Delay:           %v", conf.Delay)
+		log.Infof("      StarvationLimit: %d", conf.StarvationLimit)
+		log.Infof("      SerializeBy:     %s", conf.SerializeBy)
 	}
 }
 
@@ -287,6 +445,15 @@ func (conf *WriteSettings) Log() {
 type TransactionSettings struct {
 	// TTL is the time-to-live for a transaction in the transaction cache.
 	TTL time.Duration `default:"5m" yaml:"ttl,omitempty"`
+
+	// Journal determines whether a plugin records the state of its write
+	// transactions to an on-disk journal, so transaction status is retained
+	// across plugin restarts. It is disabled by default.
+	Journal bool `default:"false" yaml:"journal,omitempty"`
+
+	// JournalPath is the path to the transaction journal file. This is only
+	// used if the journal is enabled.
+	JournalPath string `default:"/var/lib/synse/plugin/transactions.log" yaml:"journalPath,omitempty"`
 }
 
 // Log logs out the config at INFO level.
@@ -295,7 +462,9 @@ func (conf *TransactionSettings) Log() {
 		log.Infof("    Transaction: nil")
 	} else {
 		log.Infof("    Transaction:")
-		log.Infof("      TTL: %v", conf.TTL)
+		log.Infof("      TTL:         %v", conf.TTL)
+		log.Infof("      Journal:     %v", conf.Journal)
+		log.Infof("      JournalPath: %s", conf.JournalPath)
 	}
 }
 
@@ -324,14 +493,42 @@ func (conf *LimiterSettings) Log() {
 
 // CacheSettings are the settings for an in-memory windowed cache of plugin readings.
 type CacheSettings struct {
-	// Enabled determines whether a plugin will use a local in-memory cache
-	// to store a small window of readings. It is disabled by default.
+	// Enabled determines whether a plugin will use a local cache to store
+	// a small window of readings. It is disabled by default.
 	Enabled bool `default:"false" yaml:"enabled,omitempty"`
 
 	// TTL is the time-to-live for a reading in the readings cache. This will
 	// only be used if the cache is enabled. Once a reading exceeds this TTL,
 	// it is removed from the cache.
 	TTL time.Duration `default:"3m" yaml:"ttl,omitempty"`
+
+	// Backend is the storage backend for the readings cache. This can either
+	// be "memory", where readings are cached in memory and lost on restart,
+	// or "disk", where readings are persisted to disk so they are retained
+	// across plugin restarts.
+	Backend string `default:"memory" yaml:"backend,omitempty"`
+
+	// Directory is the directory that cached readings are stored in. This
+	// is only used by the "disk" backend.
+	Directory string `default:"/var/lib/synse/plugin/cache" yaml:"directory,omitempty"`
+
+	// SegmentDuration is the window of time covered by each on-disk cache
+	// segment file. Expired readings are removed a segment at a time, so
+	// this also determines how often the on-disk cache is compacted. This
+	// is only used by the "disk" backend.
+	SegmentDuration time.Duration `default:"1m" yaml:"segmentDuration,omitempty"`
+
+	// MaxEntries is the maximum number of cached reading sets held for each
+	// device. Once a device's buffer is full, its oldest cached readings are
+	// evicted. A value of 0 means there is no per-device limit. This is only
+	// used by the "memory" backend.
+	MaxEntries int `default:"300" yaml:"maxEntries,omitempty"`
+
+	// MaxBytes is the approximate maximum size, in bytes, of all readings held
+	// in the cache. Once exceeded, the oldest cached readings across all devices
+	// are evicted. A value of 0 means there is no size limit. This is only used
+	// by the "memory" backend.
+	MaxBytes int64 `default:"67108864" yaml:"maxBytes,omitempty"`
 }
 
 // Log logs out the config at INFO level.
@@ -340,8 +537,142 @@ func (conf *CacheSettings) Log() {
 		log.Infof("    Cache: nil")
 	} else {
 		log.Infof("    Cache:")
-		log.Infof("      Enabled: %v", conf.Enabled)
-		log.Infof("      TTL:     %v", conf.TTL)
+		log.Infof("      Enabled:         %v", conf.Enabled)
+		log.Infof("      TTL:             %v", conf.TTL)
+		log.Infof("      Backend:         %s", conf.Backend)
+		log.Infof("      Directory:       %s", conf.Directory)
+		log.Infof("      SegmentDuration: %v", conf.SegmentDuration)
+		log.Infof("      MaxEntries:      %d", conf.MaxEntries)
+		log.Infof("      MaxBytes:        %d", conf.MaxBytes)
+	}
+}
+
+// AggregationSettings are the settings for aggregating plugin readings over
+// windows of time.
+type AggregationSettings struct {
+	// Enabled determines whether a plugin will aggregate its numeric readings.
+	// It is disabled by default.
+	Enabled bool `default:"false" yaml:"enabled,omitempty"`
+
+	// Windows are the sizes of the windows to aggregate readings over, e.g.
+	// 1m and 5m. Readings are aggregated separately for each window size.
+	Windows []time.Duration `yaml:"windows,omitempty"`
+
+	// Retention is the number of completed windows of each size which are
+	// retained for each device output. Once exceeded, the oldest windows
+	// are discarded.
+	Retention int `default:"60" yaml:"retention,omitempty"`
+}
+
+// Log logs out the config at INFO level.
+func (conf *AggregationSettings) Log() {
+	if conf == nil {
+		log.Infof("    Aggregation: nil")
+	} else {
+		log.Infof("    Aggregation:")
+		log.Infof("      Enabled:   %v", conf.Enabled)
+		log.Infof("      Windows:   %v", conf.Windows)
+		log.Infof("      Retention: %d", conf.Retention)
+	}
+}
+
+// ReloadSettings are the settings for reloading device configuration while
+// the plugin is running.
+type ReloadSettings struct {
+	// Enabled determines whether device configuration is reloaded when it
+	// changes on disk or when the plugin receives a SIGHUP. It is disabled
+	// by default.
+	Enabled bool `default:"false" yaml:"enabled,omitempty"`
+
+	// Interval is how often the device configuration files are checked for
+	// changes. If 0, the files are not watched and config is only reloaded
+	// on SIGHUP.
+	Interval time.Duration `default:"10s" yaml:"interval,omitempty"`
+}
+
+// Log logs out the config at INFO level.
+func (conf *ReloadSettings) Log() {
+	if conf == nil {
+		log.Infof("    Reload: nil")
+	} else {
+		log.Infof("    Reload:")
+		log.Infof("      Enabled:  %v", conf.Enabled)
+		log.Infof("      Interval: %v", conf.Interval)
+	}
+}
+
+// ConcurrencySettings are the settings for limiting concurrent device operations
+// for groups of devices.
+//
+// A device's concurrency group is typically the physical bus it is on, e.g. a
+// serial port or I2C bus. Reads and writes for devices in the same group are
+// limited to the group's max concurrency, while devices in different groups are
+// read and written in parallel. Devices which are not in a group are not limited.
+type ConcurrencySettings struct {
+	// GroupKey is the device data key whose value is used as the concurrency
+	// group for a device, e.g. "bus". If not set, devices are only put in a
+	// concurrency group by their device handler.
+	GroupKey string `default:"" yaml:"groupKey,omitempty"`
+
+	// MaxConcurrency is the maximum number of concurrent device operations for
+	// each concurrency group, unless overridden for the group. By default, the
+	// operations for a group are executed one at a time.
+	MaxConcurrency int `default:"1" yaml:"maxConcurrency,omitempty"`
+
+	// Groups sets the maximum number of concurrent device operations for
+	// specific concurrency groups, keyed by group name.
+	Groups map[string]int `yaml:"groups,omitempty"`
+}
+
+// Log logs out the config at INFO level.
+func (conf *ConcurrencySettings) Log() {
+	if conf == nil {
+		log.Infof("    Concurrency: nil")
+	} else {
+		log.Infof("    Concurrency:")
+		log.Infof("      GroupKey:       %s", conf.GroupKey)
+		log.Infof("      MaxConcurrency: %d", conf.MaxConcurrency)
+		log.Infof("      Groups:         %v", conf.Groups)
+	}
+}
+
+// StreamSettings are the settings for streaming readings to ReadStream clients.
+type StreamSettings struct {
+	// BufferSize is the number of readings which can be buffered for each
+	// stream while waiting to be sent to the client.
+	BufferSize int `default:"128" yaml:"bufferSize,omitempty"`
+
+	// OverflowPolicy determines what happens when a reading is dispatched to
+	// a stream whose buffer is full because its client is not keeping up. It
+	// can be one of:
+	//   "drop-oldest": drop the oldest buffered reading to make room
+	//   "drop-newest": drop the new reading
+	//   "disconnect":  close the stream, ending the client's request
+	OverflowPolicy string `default:"drop-oldest" yaml:"overflowPolicy,omitempty"`
+
+	// ReplayBufferSize is the number of the most recent reading sets retained
+	// by the plugin so that clients which reconnect a stream can resume from
+	// the last reading they received. If 0, readings are not retained, and
+	// resumed streams can only fall back to the readings cache.
+	ReplayBufferSize int `default:"1024" yaml:"replayBufferSize,omitempty"`
+
+	// ReplayCacheWindow is how far back the readings cache is searched when a
+	// resumed stream missed readings which are no longer in the replay buffer.
+	// Cached readings older than this are not sent. If 0, resumed streams do
+	// not fall back to the readings cache.
+	ReplayCacheWindow time.Duration `default:"5m" yaml:"replayCacheWindow,omitempty"`
+}
+
+// Log logs out the config at INFO level.
+func (conf *StreamSettings) Log() {
+	if conf == nil {
+		log.Infof("    Stream: nil")
+	} else {
+		log.Infof("    Stream:")
+		log.Infof("      BufferSize:        %d", conf.BufferSize)
+		log.Infof("      OverflowPolicy:    %s", conf.OverflowPolicy)
+		log.Infof("      ReplayBufferSize:  %d", conf.ReplayBufferSize)
+		log.Infof("      ReplayCacheWindow: %s", conf.ReplayCacheWindow)
 	}
 }
 
@@ -408,6 +739,11 @@ type DynamicRegistrationSettings struct {
 	// the plugin/protocol/device-specific data which will be used to register
 	// devices at runtime, e.g. a server address and port.
 	Config []map[string]interface{} `default:"[]" yaml:"config,omitempty"`
+
+	// RediscoveryInterval is the interval at which the dynamic device registrars
+	// are re-run while the plugin is running, so devices which appear or disappear
+	// are added or removed. If 0, devices are only registered on startup.
+	RediscoveryInterval time.Duration `default:"0s" yaml:"rediscoveryInterval,omitempty"`
 }
 
 // Log logs out the config at INFO level.
@@ -424,6 +760,7 @@ func (conf *DynamicRegistrationSettings) Log() (err error) {
 
 		log.Infof("  DynamicRegistration:")
 		log.Infof("    Config: %v", redacted)
+		log.Infof("    RediscoveryInterval: %v", conf.RediscoveryInterval)
 	}
 	return
 }
@@ -93,6 +93,16 @@ func TestReadSettings_Log(t *testing.T) {
 	c.Log()
 }
 
+func TestCircuitBreakerSettings_Log_nil(t *testing.T) {
+	var c *CircuitBreakerSettings
+	c.Log()
+}
+
+func TestCircuitBreakerSettings_Log(t *testing.T) {
+	c := CircuitBreakerSettings{}
+	c.Log()
+}
+
 func TestWriteSettings_Log_nil(t *testing.T) {
 	var c *WriteSettings
 	c.Log()
@@ -133,6 +143,46 @@ func TestCacheSettings_Log(t *testing.T) {
 	c.Log()
 }
 
+func TestAggregationSettings_Log_nil(t *testing.T) {
+	var c *AggregationSettings
+	c.Log()
+}
+
+func TestAggregationSettings_Log(t *testing.T) {
+	c := AggregationSettings{}
+	c.Log()
+}
+
+func TestConcurrencySettings_Log_nil(t *testing.T) {
+	var c *ConcurrencySettings
+	c.Log()
+}
+
+func TestConcurrencySettings_Log(t *testing.T) {
+	c := ConcurrencySettings{}
+	c.Log()
+}
+
+func TestStreamSettings_Log_nil(t *testing.T) {
+	var c *StreamSettings
+	c.Log()
+}
+
+func TestStreamSettings_Log(t *testing.T) {
+	c := StreamSettings{}
+	c.Log()
+}
+
+func TestReloadSettings_Log_nil(t *testing.T) {
+	var c *ReloadSettings
+	c.Log()
+}
+
+func TestReloadSettings_Log(t *testing.T) {
+	c := ReloadSettings{}
+	c.Log()
+}
+
 func TestNetworkSettings_Log_nil(t *testing.T) {
 	var c *NetworkSettings
 	c.Log()
@@ -172,6 +222,7 @@ func TestDynamicRegistrationSettings_Log(t *testing.T) {
 
 	assert.Contains(t, out.String(), "msg=\"  DynamicRegistration:\"\n")
 	assert.Contains(t, out.String(), "msg=\"    Config: []\"\n")
+	assert.Contains(t, out.String(), "msg=\"    RediscoveryInterval: 0s\"\n")
 }
 
 func TestDynamicRegistrationSettings_Log_withPass(t *testing.T) {
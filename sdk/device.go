@@ -18,8 +18,11 @@ package sdk
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
+	"reflect"
+	"sync/atomic"
 	"text/template"
 	"time"
 
@@ -88,6 +91,16 @@ type Device struct {
 	// will remain valid for this device.
 	WriteTimeout time.Duration
 
+	// ReadInterval defines the interval at which the device is read. If this
+	// is not set, the device is read at the interval defined by its handler,
+	// or the plugin's global read interval if the handler does not define one.
+	ReadInterval time.Duration
+
+	// MaxReadingAge defines the maximum age of the device's current reading,
+	// after which it is considered stale. If this is not set, the plugin's
+	// global max reading age is used.
+	MaxReadingAge time.Duration
+
 	// Output is the name of the Output that this device instance will use. This
 	// is not needed for all devices/plugins, as many DeviceHandlers will already
 	// know which output to use. This field is used in cases of generalized plugins,
@@ -106,6 +119,41 @@ type Device struct {
 	// populated via the SDK on device loading and parsing and uses the Handler
 	// field to match the name of the handler to the actual instance.
 	handler *DeviceHandler
+
+	// source is the configuration which the device was created from, if it was
+	// created from config. This is used to detect changes to the device's config
+	// when device configuration is reloaded.
+	source *deviceSource
+
+	// removed is set (to 1) once the device has been removed from the plugin.
+	// It is accessed atomically, as device reads may be in flight on removal.
+	removed int32
+}
+
+// deviceSource holds the prototype and instance configuration which a device
+// was created from.
+type deviceSource struct {
+	proto    config.DeviceProto
+	instance config.DeviceInstance
+}
+
+// newDeviceSource creates a deviceSource from a device's prototype and instance
+// configuration. The prototype's instances are not retained.
+func newDeviceSource(proto *config.DeviceProto, instance *config.DeviceInstance) *deviceSource {
+	source := &deviceSource{
+		proto:    *proto,
+		instance: *instance,
+	}
+	source.proto.Instances = nil
+	return source
+}
+
+// equal checks whether two device sources hold the same configuration.
+func (source *deviceSource) equal(other *deviceSource) bool {
+	if source == nil || other == nil {
+		return source == other
+	}
+	return reflect.DeepEqual(source, other)
 }
 
 // NewDeviceFromConfig creates a new instance of a Device from its device prototype
@@ -141,6 +189,8 @@ func NewDeviceFromConfig(
 		handler      string
 		deviceType   string
 		writeTimeout time.Duration
+		readInterval time.Duration
+		maxAge       time.Duration
 	)
 
 	// If inheritance is enabled, use the prototype defined value as the base. For
@@ -157,6 +207,8 @@ func NewDeviceFromConfig(
 		handler = proto.Handler
 		deviceType = proto.Type
 		writeTimeout = proto.WriteTimeout
+		readInterval = proto.ReadInterval
+		maxAge = proto.MaxReadingAge
 
 		for _, v := range proto.Transforms {
 			t, err := NewTransformer(v)
@@ -271,23 +323,37 @@ func NewDeviceFromConfig(
 		writeTimeout = defaultWriteTimeout
 	}
 
+	// Override read interval, if set. Unlike the write timeout, a zero value is
+	// meaningful here, as it defers to the handler/global read interval.
+	if instance.ReadInterval != 0 {
+		readInterval = instance.ReadInterval
+	}
+
+	// Override max reading age, if set.
+	if instance.MaxReadingAge != 0 {
+		maxAge = instance.MaxReadingAge
+	}
+
 	// Render any templates which may exist in the context.
 	if err := parseContext(context); err != nil {
 		return nil, err
 	}
 
 	d := &Device{
-		Type:         deviceType,
-		Tags:         deviceTags,
-		Data:         data,
-		Context:      context,
-		Handler:      handler,
-		Info:         instance.Info,
-		SortIndex:    instance.SortIndex,
-		Transforms:   transforms,
-		WriteTimeout: writeTimeout,
-		Output:       instance.Output,
-		handler:      handlerFn,
+		Type:          deviceType,
+		Tags:          deviceTags,
+		Data:          data,
+		Context:       context,
+		Handler:       handler,
+		Info:          instance.Info,
+		SortIndex:     instance.SortIndex,
+		Transforms:    transforms,
+		WriteTimeout:  writeTimeout,
+		ReadInterval:  readInterval,
+		MaxReadingAge: maxAge,
+		Output:        instance.Output,
+		handler:       handlerFn,
+		source:        newDeviceSource(proto, instance),
 	}
 
 	if err := d.setAlias(instance.Alias); err != nil {
@@ -399,17 +465,37 @@ func (device *Device) GetID() string {
 	return device.id
 }
 
+// IsRemoved checks whether the device has been removed from the plugin. Readings
+// for a removed device are discarded.
+func (device *Device) IsRemoved() bool {
+	return atomic.LoadInt32(&device.removed) == 1
+}
+
+// markRemoved marks the device as removed from the plugin.
+func (device *Device) markRemoved() {
+	atomic.StoreInt32(&device.removed, 1)
+}
+
 // Read performs the read action for the device, as set by its DeviceHandler.
 //
 // If reading is not supported on the device, an UnsupportedCommandError is
 // returned.
 func (device *Device) Read() (*ReadContext, error) {
+	return device.ReadCtx(context.Background())
+}
+
+// ReadCtx performs the read action for the device with the given context. If the
+// device's handler defines a ReadCtx function, the context is passed along to it.
+//
+// If reading is not supported on the device, an UnsupportedCommandError is
+// returned.
+func (device *Device) ReadCtx(ctx context.Context) (*ReadContext, error) {
 	if !device.IsReadable() {
 		log.WithField("id", device.id).Debug("[device] device is not readable")
 		return nil, &errors.UnsupportedCommandError{}
 	}
 
-	readings, err := device.handler.Read(device)
+	readings, err := device.handler.read(ctx, device)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
@@ -425,6 +511,15 @@ func (device *Device) Read() (*ReadContext, error) {
 // If writing is not supported on the device, an UnsupportedCommandError is
 // returned.
 func (device *Device) Write(data *WriteData) error {
+	return device.WriteCtx(context.Background(), data)
+}
+
+// WriteCtx performs the write action for the device with the given context. If the
+// device's handler defines a WriteCtx function, the context is passed along to it.
+//
+// If writing is not supported on the device, an UnsupportedCommandError is
+// returned.
+func (device *Device) WriteCtx(ctx context.Context, data *WriteData) error {
 	if !device.IsWritable() {
 		log.WithField("id", device.id).Debug("[device] device is not writable")
 		return &errors.UnsupportedCommandError{}
@@ -444,7 +539,7 @@ func (device *Device) Write(data *WriteData) error {
 		}
 	}
 
-	err := device.handler.Write(device, data)
+	err := device.handler.write(ctx, device, data)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
@@ -16,7 +16,13 @@
 
 package sdk
 
-import "github.com/vapor-ware/synse-sdk/v2/sdk/output"
+import (
+	"context"
+	"time"
+
+	"github.com/vapor-ware/synse-sdk/v2/sdk/output"
+	synse "github.com/vapor-ware/synse-server-grpc/go"
+)
 
 // DeviceHandler specifies the read and write handlers for a Device
 // based on its type and model.
@@ -31,16 +37,32 @@ type DeviceHandler struct {
 	// the devices do not support writing, this can be left unspecified.
 	Write func(*Device, *WriteData) error
 
+	// WriteCtx is a context-aware variant of Write. The context passed to the function
+	// carries a deadline derived from the device's write timeout and is cancelled when
+	// the plugin shuts down. If both Write and WriteCtx are defined, WriteCtx is used.
+	WriteCtx func(context.Context, *Device, *WriteData) error
+
 	// Read is a function that handles Read requests for the handler's devices. If the
 	// devices do not support reading, this can be left unspecified.
 	Read func(*Device) ([]*output.Reading, error)
 
+	// ReadCtx is a context-aware variant of Read. The context passed to the function
+	// carries a deadline derived from the plugin's read interval and is cancelled when
+	// the plugin shuts down. If both Read and ReadCtx are defined, ReadCtx is used.
+	ReadCtx func(context.Context, *Device) ([]*output.Reading, error)
+
 	// BulkRead is a function that handles bulk read operations for the handler's devices.
 	// A bulk read is where all devices of a given kind are read at once, instead of individually.
 	// If a device does not support bulk read, this can be left as nil. Additionally,
 	// a device can only be bulk read if there is no Read handler set.
 	BulkRead func([]*Device) ([]*ReadContext, error)
 
+	// BulkReadCtx is a context-aware variant of BulkRead. It receives the same context
+	// as ReadCtx. If both BulkRead and BulkReadCtx are defined, BulkReadCtx is used.
+	// As with BulkRead, a device can only be bulk read if there is no Read or ReadCtx
+	// handler set.
+	BulkReadCtx func(context.Context, []*Device) ([]*ReadContext, error)
+
 	// Listen is a function that will listen for push-based data from the device.
 	// This function is called one per device using the handler, even if there are
 	// other handler functions (e.g. read, write) defined. The listener function
@@ -51,10 +73,49 @@ type DeviceHandler struct {
 	// of the SDK.
 	Listen func(*Device, chan *ReadContext) error
 
+	// ListenCtx is a context-aware variant of Listen. The context passed to the
+	// function is cancelled when the plugin shuts down, at which point the listener
+	// should clean up and return. If both Listen and ListenCtx are defined, ListenCtx
+	// is used.
+	ListenCtx func(context.Context, *Device, chan *ReadContext) error
+
+	// ReadInterval defines the interval at which devices using this handler are
+	// read. This applies to both individual and bulk reads. If not set, the
+	// plugin's global read interval is used. Devices may further override this
+	// via their own read interval configuration (individual reads only).
+	ReadInterval time.Duration
+
 	// Actions specifies a list of the supported write actions for the handler.
 	// This is optional and is just used as metadata surfaced by the SDK to the
 	// client via the gRPC API.
 	Actions []string
+
+	// ActionPriorities sets the priority of writes for the handler's write actions,
+	// keyed by action. Queued writes with a higher priority are processed first.
+	// Writes for actions which are not listed have normal priority.
+	ActionPriorities map[string]WritePriority
+
+	// WritePriority is an optional function which determines the priority of a
+	// write request, e.g. based on its data. If defined, it is used instead of
+	// ActionPriorities.
+	WritePriority func(*Device, *WriteData) WritePriority
+
+	// CoalesceWrites enables write coalescing for the handler's devices. When
+	// enabled, a queued write which has not yet started is superseded by a later
+	// write to the same device with the same action, so only the latest write is
+	// executed. The transactions for superseded writes are marked done, with a
+	// message noting they were skipped. This is useful for devices which receive
+	// rapid successive writes where only the latest value matters, such as fan
+	// speed or dimming controls. It is disabled by default.
+	CoalesceWrites bool
+
+	// ConcurrencyGroup is an optional function which gets the concurrency group
+	// for a device using the handler, e.g. the bus the device is on. Reads and
+	// writes for devices in the same group are limited to the group's configured
+	// max concurrency. If defined, it is used instead of the group key from the
+	// plugin's concurrency settings. An empty group means the device is not in a
+	// concurrency group.
+	ConcurrencyGroup func(*Device) string
 }
 
 // CanRead returns true if the handler has a read function defined; false otherwise.
@@ -62,7 +123,7 @@ func (handler *DeviceHandler) CanRead() bool {
 	if handler == nil {
 		return false
 	}
-	return handler.Read != nil
+	return handler.Read != nil || handler.ReadCtx != nil
 }
 
 // CanBulkRead returns true if the handler has a bulk read function defined and no
@@ -72,7 +133,7 @@ func (handler *DeviceHandler) CanBulkRead() bool {
 		return false
 	}
 	// Can only bulk read if no read handler is defined.
-	return handler.Read == nil && handler.BulkRead != nil
+	return !handler.CanRead() && (handler.BulkRead != nil || handler.BulkReadCtx != nil)
 }
 
 // CanWrite returns true if the handler has a write function defined; false otherwise.
@@ -80,7 +141,21 @@ func (handler *DeviceHandler) CanWrite() bool {
 	if handler == nil {
 		return false
 	}
-	return handler.Write != nil
+	return handler.Write != nil || handler.WriteCtx != nil
+}
+
+// writePriority gets the priority of a write request for a device using the handler.
+func (handler *DeviceHandler) writePriority(device *Device, data *synse.V3WriteData) WritePriority {
+	if handler == nil || data == nil {
+		return WritePriorityNormal
+	}
+	if handler.WritePriority != nil {
+		return handler.WritePriority(device, decodeWriteData(data))
+	}
+	if p, ok := handler.ActionPriorities[data.Action]; ok {
+		return p
+	}
+	return WritePriorityNormal
 }
 
 // CanListen returns true if the handler has a listen function defined; false otherwise.
@@ -88,7 +163,34 @@ func (handler *DeviceHandler) CanListen() bool {
 	if handler == nil {
 		return false
 	}
-	return handler.Listen != nil
+	return handler.Listen != nil || handler.ListenCtx != nil
+}
+
+// read executes the handler's read function for the given device, preferring
+// the context-aware variant when it is defined.
+func (handler *DeviceHandler) read(ctx context.Context, device *Device) ([]*output.Reading, error) {
+	if handler.ReadCtx != nil {
+		return handler.ReadCtx(ctx, device)
+	}
+	return handler.Read(device)
+}
+
+// bulkRead executes the handler's bulk read function for the given devices,
+// preferring the context-aware variant when it is defined.
+func (handler *DeviceHandler) bulkRead(ctx context.Context, devices []*Device) ([]*ReadContext, error) {
+	if handler.BulkReadCtx != nil {
+		return handler.BulkReadCtx(ctx, devices)
+	}
+	return handler.BulkRead(devices)
+}
+
+// write executes the handler's write function for the given device, preferring
+// the context-aware variant when it is defined.
+func (handler *DeviceHandler) write(ctx context.Context, device *Device, data *WriteData) error {
+	if handler.WriteCtx != nil {
+		return handler.WriteCtx(ctx, device, data)
+	}
+	return handler.Write(device, data)
 }
 
 // GetCapabilitiesMode gets the capabilities mode string representation for a device
@@ -17,6 +17,7 @@
 package sdk
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -37,6 +38,15 @@ func TestDeviceHandler_CanRead_true(t *testing.T) {
 	assert.True(t, handler.CanRead())
 }
 
+func TestDeviceHandler_CanRead_ctx(t *testing.T) {
+	handler := DeviceHandler{
+		ReadCtx: func(ctx context.Context, device *Device) (readings []*output.Reading, e error) {
+			return nil, nil
+		},
+	}
+	assert.True(t, handler.CanRead())
+}
+
 func TestDeviceHandler_CanRead_false(t *testing.T) {
 	handler := DeviceHandler{}
 	assert.False(t, handler.CanRead())
@@ -56,6 +66,15 @@ func TestDeviceHandler_CanWrite_true(t *testing.T) {
 	assert.True(t, handler.CanWrite())
 }
 
+func TestDeviceHandler_CanWrite_ctx(t *testing.T) {
+	handler := DeviceHandler{
+		WriteCtx: func(ctx context.Context, device *Device, data *WriteData) error {
+			return nil
+		},
+	}
+	assert.True(t, handler.CanWrite())
+}
+
 func TestDeviceHandler_CanWrite_false(t *testing.T) {
 	handler := DeviceHandler{}
 	assert.False(t, handler.CanWrite())
@@ -75,6 +94,15 @@ func TestDeviceHandler_CanListen_true(t *testing.T) {
 	assert.True(t, handler.CanListen())
 }
 
+func TestDeviceHandler_CanListen_ctx(t *testing.T) {
+	handler := DeviceHandler{
+		ListenCtx: func(ctx context.Context, device *Device, contexts chan *ReadContext) error {
+			return nil
+		},
+	}
+	assert.True(t, handler.CanListen())
+}
+
 func TestDeviceHandler_CanListen_false(t *testing.T) {
 	handler := DeviceHandler{}
 	assert.False(t, handler.CanListen())
@@ -94,6 +122,27 @@ func TestDeviceHandler_CanBulkRead_true(t *testing.T) {
 	assert.True(t, handler.CanBulkRead())
 }
 
+func TestDeviceHandler_CanBulkRead_ctx(t *testing.T) {
+	handler := DeviceHandler{
+		BulkReadCtx: func(ctx context.Context, devices []*Device) (contexts []*ReadContext, e error) {
+			return nil, nil
+		},
+	}
+	assert.True(t, handler.CanBulkRead())
+}
+
+func TestDeviceHandler_CanBulkRead_falseReadCtx(t *testing.T) {
+	handler := DeviceHandler{
+		ReadCtx: func(ctx context.Context, device *Device) (readings []*output.Reading, e error) {
+			return nil, nil
+		},
+		BulkRead: func(devices []*Device) (contexts []*ReadContext, e error) {
+			return nil, nil
+		},
+	}
+	assert.False(t, handler.CanBulkRead())
+}
+
 func TestDeviceHandler_CanBulkRead_false(t *testing.T) {
 	handler := DeviceHandler{}
 	assert.False(t, handler.CanBulkRead())
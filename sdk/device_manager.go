@@ -19,6 +19,7 @@ package sdk
 import (
 	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/gobwas/glob"
 
@@ -70,6 +71,7 @@ type DeviceAction struct {
 // deviceManager loads and manages a Plugin's devices.
 type deviceManager struct {
 	config         *config.Devices
+	fileConfig     *config.Devices
 	id             *pluginID
 	pluginHandlers *PluginHandlers
 	policies       *policy.Policies
@@ -80,6 +82,23 @@ type deviceManager struct {
 	devices        map[string]*Device
 	handlers       map[string]*DeviceHandler
 
+	// configured holds the IDs of the devices which were created from device
+	// configuration (as opposed to being registered dynamically). Only these
+	// devices are updated or removed when device configuration is reloaded.
+	configured map[string]struct{}
+
+	// discovered holds the IDs of the devices which were created by the dynamic
+	// device registrar. Only these devices are added or removed when dynamic
+	// devices are rediscovered.
+	discovered map[string]struct{}
+
+	// lock guards the devices, configured, and discovered maps, as devices may
+	// be added and removed while the plugin is running.
+	lock sync.RWMutex
+
+	// events is the bus which device lifecycle events are published to.
+	events *eventBus
+
 	plugin *Plugin
 }
 
@@ -99,6 +118,9 @@ func newDeviceManager(plugin *Plugin) *deviceManager {
 		aliasCache:     NewAliasCache(),
 		devices:        make(map[string]*Device),
 		handlers:       make(map[string]*DeviceHandler),
+		configured:     make(map[string]struct{}),
+		discovered:     make(map[string]struct{}),
+		events:         plugin.events,
 		plugin:         plugin,
 	}
 }
@@ -135,11 +157,26 @@ func (manager *deviceManager) init() error {
 // loadDynamicConfig loads device configurations using the dynamic device config
 // registrar plugin handler.
 func (manager *deviceManager) loadDynamicConfig() error {
+	return manager.loadDynamicConfigInto(manager.config, false)
+}
+
+// loadDynamicConfigInto loads device configurations using the dynamic device config
+// registrar plugin handler, adding them to the given device config.
+//
+// If strict is set, any registrar failure is returned as an error, regardless of
+// the dynamic device config policy. This is used when device config is loaded
+// while the plugin is running, where skipping a failed registration would cause
+// its devices to be removed.
+func (manager *deviceManager) loadDynamicConfigInto(conf *config.Devices, strict bool) error {
 	if manager.dynamicConfig != nil {
 		log.Debug("[device manager] loading dynamic config...")
 		for _, cfg := range manager.dynamicConfig.Config {
 			devices, err := manager.pluginHandlers.DynamicConfigRegistrar(cfg)
 			if err != nil {
+				if strict {
+					log.WithError(err).Error("[device manager] failed loading dynamic device config")
+					return err
+				}
 				switch manager.policies.DynamicDeviceConfig {
 				case policy.Optional:
 					log.WithError(err).Info("[device manager] failed loading dynamic device config; skipping since its optional")
@@ -154,7 +191,7 @@ func (manager *deviceManager) loadDynamicConfig() error {
 					return err
 				}
 			}
-			manager.config.Devices = append(manager.config.Devices, devices...)
+			conf.Devices = append(conf.Devices, devices...)
 		}
 	}
 	return nil
@@ -183,7 +220,7 @@ func (manager *deviceManager) createDynamicDevices() error {
 			}
 
 			for _, device := range devices {
-				if err := manager.AddDevice(device); err != nil {
+				if err := manager.addDiscoveredDevice(device); err != nil {
 					log.WithError(err).Error("[device manager] failed to add device to manager")
 					return err
 				}
@@ -206,7 +243,9 @@ func (manager *deviceManager) Start(plugin *Plugin) error {
 // GetDevice gets a device from the manager by ID. If the device does not
 // exists, nil is returned.
 func (manager *deviceManager) GetDevice(id string) *Device {
+	manager.lock.RLock()
 	device, exists := manager.devices[id]
+	manager.lock.RUnlock()
 	if !exists {
 		log.WithFields(log.Fields{
 			"id": id,
@@ -272,6 +311,9 @@ func (manager *deviceManager) GetDevicesByTagNamespace(namespace ...string) []*D
 
 // GetAllDevices gets all devices that are registered with the deviceManager.
 func (manager *deviceManager) GetAllDevices() []*Device {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+
 	devices := make([]*Device, 0, len(manager.devices))
 	for _, device := range manager.devices {
 		devices = append(devices, device)
@@ -362,6 +404,9 @@ func (manager *deviceManager) AddDevice(device *Device) error {
 		manager.plugin.GenerateDeviceID(device)
 	}
 
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
 	// Check if the Device ID collides with an existing device.
 	if _, exists := manager.devices[device.id]; exists {
 		// Log at least device.id and device.info here so we can see the duplicate.
@@ -397,6 +442,7 @@ func (manager *deviceManager) AddDevice(device *Device) error {
 		"info": device.Info,
 	}).Info("[device manager] added new device")
 
+	manager.events.publish(Event{Type: EventDeviceAdded, Device: device})
 	return nil
 }
 
@@ -420,6 +466,9 @@ func (manager *deviceManager) AddHandlers(handlers ...*DeviceHandler) error {
 // GetDevicesForHandler gets all of the Devices which are configured to use the
 // DeviceHandler with the given name.
 func (manager *deviceManager) GetDevicesForHandler(handler string) []*Device {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+
 	var devices []*Device
 	for _, device := range manager.devices {
 		if device.Handler == handler {
@@ -495,7 +544,7 @@ func (manager *deviceManager) FilterDevices(filter map[string][]string) ([]*Devi
 		checks = append(checks, check)
 	}
 
-	for _, device := range manager.devices {
+	for _, device := range manager.GetAllDevices() {
 		for _, check := range checks {
 			if check(device) {
 				filteredSet = append(filteredSet, device)
@@ -525,7 +574,7 @@ func (manager *deviceManager) createDevices() error {
 				continue
 			}
 			// Add it to the manager.
-			if err := manager.AddDevice(device); err != nil {
+			if err := manager.addConfiguredDevice(device); err != nil {
 				log.WithField("error", err).Error("[device manager] failed to add device to manager")
 				failedLoad = true
 			}
@@ -537,13 +586,31 @@ func (manager *deviceManager) createDevices() error {
 		return fmt.Errorf("failed to load devices from config")
 	}
 
-	log.WithField("devices", len(manager.devices)).Info("[device manager] created devices")
+	log.WithField("devices", len(manager.GetAllDevices())).Info("[device manager] created devices")
 	return nil
 }
 
 // loadConfig is a helper function used to load device configurations into the
 // deviceManager.
 func (manager *deviceManager) loadConfig() error {
+	if err := manager.loadConfigInto(manager.config); err != nil {
+		return err
+	}
+	if manager.config == nil {
+		return nil
+	}
+
+	// Retain the device config loaded from file, so device config can be reloaded
+	// with the dynamic device config registrar without re-reading the config files.
+	manager.fileConfig = &config.Devices{
+		Version: manager.config.Version,
+		Devices: append([]*config.DeviceProto(nil), manager.config.Devices...),
+	}
+	return nil
+}
+
+// loadConfigInto loads device configurations from file into the given device config.
+func (manager *deviceManager) loadConfigInto(conf *config.Devices) error {
 	// Setup the config loader for the device manager.
 	loader := config.NewYamlLoader("device")
 	loader.EnvOverride = DeviceEnvOverride
@@ -557,17 +624,32 @@ func (manager *deviceManager) loadConfig() error {
 		return err
 	}
 
-	return loader.Scan(manager.config)
+	return loader.Scan(conf)
 }
 
 // execDeviceStartupActions runs all the device startup actions registered with
 // the manager. This should be done before any reads/write occur (e.g. before
 // the scheduler is started).
 func (manager *deviceManager) execDeviceSetupActions(plugin *Plugin) error {
+	return manager.execDeviceSetupActionsFor(plugin, nil)
+}
+
+// execDeviceSetupActionsFor runs the device setup actions registered with the
+// manager for the given devices. If no devices are given, the actions are run
+// for all devices which match their filters.
+func (manager *deviceManager) execDeviceSetupActionsFor(plugin *Plugin, only []*Device) error {
 	if len(manager.setupActions) == 0 {
 		return nil
 	}
 
+	var include map[string]struct{}
+	if len(only) > 0 {
+		include = make(map[string]struct{}, len(only))
+		for _, d := range only {
+			include[d.id] = struct{}{}
+		}
+	}
+
 	var multiErr = sdkError.NewMultiError("Device Setup Actions")
 
 	log.WithFields(log.Fields{
@@ -591,6 +673,11 @@ func (manager *deviceManager) execDeviceSetupActions(plugin *Plugin) error {
 		}).Debug("[device manager] applied filter to devices")
 
 		for _, device := range devices {
+			if include != nil {
+				if _, ok := include[device.id]; !ok {
+					continue
+				}
+			}
 			if err := action.Action(plugin, device); err != nil {
 				log.WithFields(log.Fields{
 					"action": action.Name,
@@ -603,3 +690,237 @@ func (manager *deviceManager) execDeviceSetupActions(plugin *Plugin) error {
 	}
 	return multiErr.Err()
 }
+
+// addConfiguredDevice adds a device which was created from device configuration
+// to the manager, tracking it so it can be updated or removed on config reload.
+func (manager *deviceManager) addConfiguredDevice(device *Device) error {
+	if err := manager.AddDevice(device); err != nil {
+		return err
+	}
+
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	if manager.configured == nil {
+		manager.configured = make(map[string]struct{})
+	}
+	manager.configured[device.id] = struct{}{}
+	return nil
+}
+
+// addDiscoveredDevice adds a device which was created by the dynamic device registrar
+// to the manager, tracking it so it can be removed on rediscovery.
+func (manager *deviceManager) addDiscoveredDevice(device *Device) error {
+	if err := manager.AddDevice(device); err != nil {
+		return err
+	}
+
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	if manager.discovered == nil {
+		manager.discovered = make(map[string]struct{})
+	}
+	manager.discovered[device.id] = struct{}{}
+	return nil
+}
+
+// RemoveDevice removes the device with the given ID from the manager, along with
+// its alias and tag cache entries. The removed device is marked as removed, so
+// any readings from reads which are in flight are discarded.
+func (manager *deviceManager) RemoveDevice(id string) (*Device, error) {
+	manager.lock.Lock()
+	device, exists := manager.devices[id]
+	if !exists {
+		manager.lock.Unlock()
+		return nil, sdkError.NotFoundErr("device %s does not exist", id)
+	}
+	delete(manager.devices, id)
+	delete(manager.configured, id)
+	delete(manager.discovered, id)
+	manager.lock.Unlock()
+
+	if device.Alias != "" && manager.aliasCache.Get(device.Alias) == device {
+		manager.aliasCache.Remove(device.Alias)
+	}
+	manager.tagCache.Remove(device)
+	device.markRemoved()
+
+	log.WithFields(log.Fields{
+		"id":   device.id,
+		"type": device.Type,
+		"info": device.Info,
+	}).Info("[device manager] removed device")
+
+	manager.events.publish(Event{Type: EventDeviceRemoved, Device: device})
+	return device, nil
+}
+
+// deviceChanges describes how the devices defined by device configuration differ
+// from the devices currently registered from configuration.
+type deviceChanges struct {
+	// added are the devices which are newly defined in the config.
+	added []*Device
+
+	// updated are the new instances of devices whose config has changed. The
+	// current instance of the device has the same ID.
+	updated []*Device
+
+	// removed are the current devices which are no longer defined in the config.
+	removed []*Device
+}
+
+// empty checks whether there are no changes.
+func (changes *deviceChanges) empty() bool {
+	return len(changes.added) == 0 && len(changes.updated) == 0 && len(changes.removed) == 0
+}
+
+// loadDeviceChanges loads the device configuration, creates devices from it, and
+// compares them with the currently configured devices by ID. The loaded config
+// is returned alongside the changes. If any device can not be created from the
+// loaded config, or the dynamic device config registrar fails, an error is returned
+// and no changes should be applied.
+//
+// If reloadFiles is set, the device config files are re-read. Otherwise, the
+// device config which was loaded from file on startup is used, so only the devices
+// from the dynamic device config registrar may change.
+func (manager *deviceManager) loadDeviceChanges(reloadFiles bool) (*deviceChanges, *config.Devices, error) {
+	conf := new(config.Devices)
+	if reloadFiles {
+		if err := manager.loadConfigInto(conf); err != nil {
+			return nil, nil, err
+		}
+	} else if manager.fileConfig != nil {
+		conf.Version = manager.fileConfig.Version
+		conf.Devices = append(conf.Devices, manager.fileConfig.Devices...)
+	}
+	if err := manager.loadDynamicConfigInto(conf, true); err != nil {
+		return nil, nil, err
+	}
+
+	loaded := map[string]*Device{}
+	var ordered []*Device
+	for _, proto := range conf.Devices {
+		for _, instance := range proto.Instances {
+			device, err := NewDeviceFromConfig(proto, instance, manager.handlers)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := manager.pluginHandlers.DeviceDataValidator(device.Data); err != nil {
+				return nil, nil, err
+			}
+			manager.plugin.GenerateDeviceID(device)
+			if _, exists := loaded[device.id]; exists {
+				log.WithFields(log.Fields{
+					"id":   device.id,
+					"type": device.Type,
+					"info": device.Info,
+				}).Error("[device manager] duplicate device id in reloaded config")
+				return nil, nil, ErrDeviceIDExists
+			}
+			loaded[device.id] = device
+			ordered = append(ordered, device)
+		}
+	}
+
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+
+	changes := &deviceChanges{}
+	for _, device := range ordered {
+		current, exists := manager.devices[device.id]
+		if !exists {
+			changes.added = append(changes.added, device)
+			continue
+		}
+		if _, ok := manager.configured[device.id]; !ok {
+			// The ID is already used by a dynamically registered device.
+			log.WithField("id", device.id).Error("[device manager] reloaded config device conflicts with existing device")
+			return nil, nil, ErrDeviceIDExists
+		}
+		if !current.source.equal(device.source) {
+			changes.updated = append(changes.updated, device)
+		}
+	}
+	for id := range manager.configured {
+		if _, exists := loaded[id]; !exists {
+			if device := manager.devices[id]; device != nil {
+				changes.removed = append(changes.removed, device)
+			}
+		}
+	}
+	return changes, conf, nil
+}
+
+// loadDiscoveredChanges runs the dynamic device registrar for each dynamic registration
+// config and compares the devices it returns with the currently discovered devices
+// by ID. Devices which are newly returned are added, and devices which are no longer
+// returned are removed. Devices which are still returned are kept as they are.
+//
+// If the registrar fails for any config, an error is returned and no changes should
+// be applied, since the devices for that config can not be determined.
+func (manager *deviceManager) loadDiscoveredChanges() (*deviceChanges, error) {
+	changes := &deviceChanges{}
+	if manager.dynamicConfig == nil {
+		return changes, nil
+	}
+
+	found := map[string]*Device{}
+	var ordered []*Device
+	for _, cfg := range manager.dynamicConfig.Config {
+		devices, err := manager.pluginHandlers.DynamicRegistrar(cfg)
+		if err != nil {
+			log.WithError(err).Error("[device manager] failed rediscovering dynamic devices")
+			return nil, err
+		}
+		for _, device := range devices {
+			if device == nil {
+				continue
+			}
+			if device.id == "" {
+				manager.plugin.GenerateDeviceID(device)
+			}
+			if _, exists := found[device.id]; exists {
+				log.WithFields(log.Fields{
+					"id":   device.id,
+					"type": device.Type,
+					"info": device.Info,
+				}).Error("[device manager] duplicate device id in rediscovered devices")
+				return nil, ErrDeviceIDExists
+			}
+			found[device.id] = device
+			ordered = append(ordered, device)
+		}
+	}
+
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+
+	for _, device := range ordered {
+		if _, exists := manager.devices[device.id]; !exists {
+			changes.added = append(changes.added, device)
+			continue
+		}
+		if _, ok := manager.discovered[device.id]; !ok {
+			// The ID is already used by a device which was not dynamically registered.
+			log.WithField("id", device.id).Error("[device manager] rediscovered device conflicts with existing device")
+			return nil, ErrDeviceIDExists
+		}
+	}
+	for id := range manager.discovered {
+		if _, exists := found[id]; !exists {
+			if device := manager.devices[id]; device != nil {
+				changes.removed = append(changes.removed, device)
+			}
+		}
+	}
+	return changes, nil
+}
+
+// setConfig sets the device configuration held by the manager.
+func (manager *deviceManager) setConfig(conf *config.Devices) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	manager.config = conf
+}
@@ -19,6 +19,8 @@ package sdk
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
 	"testing"
 
 	"github.com/google/uuid"
@@ -1529,3 +1531,144 @@ func TestDeviceManager_execDeviceSetupActions_ok7(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 3, counter)
 }
+
+func TestDeviceManager_RemoveDevice_notFound(t *testing.T) {
+	m := deviceManager{
+		devices: map[string]*Device{},
+	}
+
+	device, err := m.RemoveDevice("123")
+	assert.Error(t, err)
+	assert.Nil(t, device)
+}
+
+func TestDeviceManager_RemoveDevice(t *testing.T) {
+	tag := &Tag{Namespace: "foo", Annotation: "bar", Label: "baz"}
+	d := &Device{id: "123", Alias: "alias-1", Tags: []*Tag{tag}}
+	m := deviceManager{
+		devices:    map[string]*Device{"123": d},
+		configured: map[string]struct{}{"123": {}},
+		tagCache:   NewTagCache(),
+		aliasCache: NewAliasCache(),
+	}
+	m.tagCache.Add(tag, d)
+	assert.NoError(t, m.aliasCache.Add("alias-1", d))
+
+	device, err := m.RemoveDevice("123")
+	assert.NoError(t, err)
+	assert.Equal(t, d, device)
+	assert.True(t, device.IsRemoved())
+	assert.Empty(t, m.devices)
+	assert.Empty(t, m.configured)
+	assert.Nil(t, m.aliasCache.Get("alias-1"))
+	assert.Empty(t, m.tagCache.GetDevicesFromTags(tag))
+}
+
+func TestDeviceManager_RemoveDevice_aliasReassigned(t *testing.T) {
+	d1 := &Device{id: "123", Alias: "alias-1"}
+	d2 := &Device{id: "456", Alias: "alias-1"}
+	m := deviceManager{
+		devices:    map[string]*Device{"123": d1, "456": d2},
+		tagCache:   NewTagCache(),
+		aliasCache: NewAliasCache(),
+	}
+	assert.NoError(t, m.aliasCache.Add("alias-1", d2))
+
+	// The alias belongs to another device, so it is kept.
+	_, err := m.RemoveDevice("123")
+	assert.NoError(t, err)
+	assert.Equal(t, d2, m.aliasCache.Get("alias-1"))
+}
+
+func TestDeviceManager_addConfiguredDevice(t *testing.T) {
+	m := deviceManager{
+		tagCache:       NewTagCache(),
+		aliasCache:     NewAliasCache(),
+		pluginHandlers: NewDefaultPluginHandlers(),
+		devices:        map[string]*Device{},
+		handlers: map[string]*DeviceHandler{
+			"foo": {Name: "foo"},
+		},
+	}
+
+	err := m.addConfiguredDevice(&Device{id: "123", Type: "foo", Handler: "foo", handler: m.handlers["foo"]})
+	assert.NoError(t, err)
+	assert.Contains(t, m.devices, "123")
+	assert.Contains(t, m.configured, "123")
+}
+
+func TestDeviceManager_loadDeviceChanges(t *testing.T) {
+	dir, closer := test.TempDir(t)
+	defer closer()
+	test.SetEnv(t, DeviceEnvOverride, dir)
+	defer test.RemoveEnv(t, DeviceEnvOverride)
+
+	writeReloadTestConfig(t, dir, map[int]string{1: "a", 2: "b"})
+	p := newTestPlugin()
+	assert.NoError(t, p.device.loadConfig())
+	assert.NoError(t, p.device.createDevices())
+	assert.Len(t, p.device.devices, 2)
+
+	// No changes.
+	changes, conf, err := p.device.loadDeviceChanges(true)
+	assert.NoError(t, err)
+	assert.NotNil(t, conf)
+	assert.True(t, changes.empty())
+
+	// Device 1 is updated, 2 is removed, and 3 is added.
+	writeReloadTestConfig(t, dir, map[int]string{1: "changed", 3: "c"})
+	changes, _, err = p.device.loadDeviceChanges(true)
+	assert.NoError(t, err)
+	assert.Len(t, changes.updated, 1)
+	assert.Equal(t, "changed", changes.updated[0].Info)
+	assert.Len(t, changes.removed, 1)
+	assert.Equal(t, "b", changes.removed[0].Info)
+	assert.Len(t, changes.added, 1)
+	assert.Equal(t, "c", changes.added[0].Info)
+}
+
+func TestDeviceManager_loadDeviceChanges_invalidConfig(t *testing.T) {
+	dir, closer := test.TempDir(t)
+	defer closer()
+	test.SetEnv(t, DeviceEnvOverride, dir)
+	defer test.RemoveEnv(t, DeviceEnvOverride)
+
+	writeReloadTestConfig(t, dir, map[int]string{1: "a"})
+	p := newTestPlugin()
+	assert.NoError(t, p.device.loadConfig())
+	assert.NoError(t, p.device.createDevices())
+
+	err := ioutil.WriteFile(filepath.Join(dir, "devices.yml"), []byte(`version: 3
+devices:
+  - type: temperature
+    handler: unknown
+    instances:
+      - info: a
+`), 0600)
+	assert.NoError(t, err)
+
+	changes, conf, err := p.device.loadDeviceChanges(true)
+	assert.Error(t, err)
+	assert.Nil(t, changes)
+	assert.Nil(t, conf)
+}
+
+func TestDeviceManager_loadDeviceChanges_conflict(t *testing.T) {
+	dir, closer := test.TempDir(t)
+	defer closer()
+	test.SetEnv(t, DeviceEnvOverride, dir)
+	defer test.RemoveEnv(t, DeviceEnvOverride)
+
+	writeReloadTestConfig(t, dir, map[int]string{1: "a"})
+	p := newTestPlugin()
+	assert.NoError(t, p.device.loadConfig())
+	assert.NoError(t, p.device.createDevices())
+
+	// The configured device is now registered as a non-configured device,
+	// so the reloaded config conflicts with it.
+	p.device.configured = map[string]struct{}{}
+
+	changes, _, err := p.device.loadDeviceChanges(true)
+	assert.Equal(t, ErrDeviceIDExists, err)
+	assert.Nil(t, changes)
+}
@@ -17,6 +17,7 @@
 package sdk
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"testing"
@@ -65,9 +66,11 @@ func TestNewDeviceFromConfig(t *testing.T) {
 		Context: map[string]string{
 			"foo": "bar",
 		},
-		Tags:         []string{"default/foo"},
-		Handler:      "testhandler",
-		WriteTimeout: 3 * time.Second,
+		Tags:          []string{"default/foo"},
+		Handler:       "testhandler",
+		WriteTimeout:  3 * time.Second,
+		ReadInterval:  1 * time.Second,
+		MaxReadingAge: 1 * time.Minute,
 	}
 	instance := &config.DeviceInstance{
 		Type: "type2",
@@ -90,6 +93,7 @@ func TestNewDeviceFromConfig(t *testing.T) {
 			{Apply: "FtoC"},
 		},
 		WriteTimeout:       5 * time.Second,
+		ReadInterval:       2 * time.Second,
 		DisableInheritance: false,
 	}
 
@@ -107,6 +111,8 @@ func TestNewDeviceFromConfig(t *testing.T) {
 	assert.Equal(t, "scale [2]", device.Transforms[0].Name())
 	assert.Equal(t, "apply [FtoC]", device.Transforms[1].Name())
 	assert.Equal(t, 5*time.Second, device.WriteTimeout)
+	assert.Equal(t, 2*time.Second, device.ReadInterval)
+	assert.Equal(t, 1*time.Minute, device.MaxReadingAge)
 	assert.Equal(t, "temperature", device.Output)
 }
 
@@ -288,6 +294,7 @@ func TestNewDeviceFromConfig5b(t *testing.T) {
 		Tags:         []string{"default/foo"},
 		Handler:      "testhandler",
 		WriteTimeout: 3 * time.Second,
+		ReadInterval: 1 * time.Second,
 	}
 	instance := &config.DeviceInstance{
 		Type: "type2",
@@ -314,6 +321,7 @@ func TestNewDeviceFromConfig5b(t *testing.T) {
 	assert.Equal(t, int32(1), device.SortIndex)
 	assert.Equal(t, "foo", device.Alias)
 	assert.Equal(t, 3*time.Second, device.WriteTimeout) // takes the proto value
+	assert.Equal(t, 1*time.Second, device.ReadInterval) // takes the proto value
 	assert.Equal(t, "", device.Output)
 	assert.Equal(t, 0, len(device.Transforms))
 }
@@ -966,6 +974,26 @@ func TestDevice_Read_err(t *testing.T) {
 	assert.Nil(t, ctx)
 }
 
+func TestDevice_ReadCtx_prefersCtx(t *testing.T) {
+	type key struct{}
+	device := Device{
+		handler: &DeviceHandler{
+			Read: func(device *Device) (readings []*output.Reading, e error) {
+				return nil, fmt.Errorf("test error: Read should not be called")
+			},
+			ReadCtx: func(ctx context.Context, device *Device) (readings []*output.Reading, e error) {
+				return []*output.Reading{{Value: ctx.Value(key{})}}, nil
+			},
+		},
+	}
+
+	ctx, err := device.ReadCtx(context.WithValue(context.Background(), key{}, 1))
+	assert.NoError(t, err)
+	assert.NotNil(t, ctx)
+	assert.Len(t, ctx.Reading, 1)
+	assert.Equal(t, 1, ctx.Reading[0].Value)
+}
+
 func TestDevice_Write_nilDevice(t *testing.T) {
 	device := new(Device)
 	err := device.Write(&WriteData{})
@@ -1039,6 +1067,25 @@ func TestDevice_Write_err(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestDevice_WriteCtx_prefersCtx(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	device := Device{
+		handler: &DeviceHandler{
+			Write: func(device *Device, data *WriteData) error {
+				return fmt.Errorf("test error: Write should not be called")
+			},
+			WriteCtx: func(ctx context.Context, device *Device, data *WriteData) error {
+				return ctx.Err()
+			},
+		},
+	}
+
+	err := device.WriteCtx(ctx, &WriteData{})
+	assert.Equal(t, context.Canceled, err)
+}
+
 func TestDevice_IsReadable_trueReadHandler(t *testing.T) {
 	device := Device{
 		handler: &DeviceHandler{
@@ -1296,3 +1343,45 @@ func TestDevice_parseContextError(t *testing.T) {
 	err := parseContext(ctx)
 	assert.Error(t, err)
 }
+
+func TestDevice_IsRemoved(t *testing.T) {
+	device := Device{}
+	assert.False(t, device.IsRemoved())
+
+	device.markRemoved()
+	assert.True(t, device.IsRemoved())
+}
+
+func TestDeviceSource_equal(t *testing.T) {
+	proto := &config.DeviceProto{
+		Type:    "temperature",
+		Handler: "temp",
+		Data:    map[string]interface{}{"bus": 1},
+		Instances: []*config.DeviceInstance{
+			{Info: "a", Data: map[string]interface{}{"id": 1}},
+			{Info: "b", Data: map[string]interface{}{"id": 2}},
+		},
+	}
+	source := newDeviceSource(proto, proto.Instances[0])
+
+	// Changes to other instances of the prototype do not affect the source.
+	other := *proto
+	other.Instances = []*config.DeviceInstance{proto.Instances[0]}
+	assert.True(t, source.equal(newDeviceSource(&other, proto.Instances[0])))
+
+	// Changes to the instance config do.
+	changed := *proto.Instances[0]
+	changed.Info = "changed"
+	assert.False(t, source.equal(newDeviceSource(proto, &changed)))
+
+	// Changes to the prototype config do.
+	other.Data = map[string]interface{}{"bus": 2}
+	assert.False(t, source.equal(newDeviceSource(&other, proto.Instances[0])))
+}
+
+func TestDeviceSource_equal_nil(t *testing.T) {
+	var source *deviceSource
+	assert.True(t, source.equal(nil))
+	assert.False(t, source.equal(&deviceSource{}))
+	assert.False(t, (&deviceSource{}).equal(nil))
+}
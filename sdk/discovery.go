@@ -0,0 +1,138 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/errors"
+)
+
+// rediscoverDevices re-runs the dynamic device registrars and reconciles the devices
+// they return with the plugin's current devices.
+//
+// Devices from the dynamic device config registrar are part of the plugin's device
+// config, so they are reconciled in the same manner as a device config reload. The
+// device config files are only re-read if device config reload is enabled; otherwise,
+// the file config loaded on startup is kept as it is. Devices from the dynamic
+// device registrar are matched by ID: new devices are added and devices which are
+// no longer returned are removed.
+//
+// If a registrar fails, the devices it is responsible for are left as they are.
+func (plugin *Plugin) rediscoverDevices() error {
+	plugin.deviceLock.Lock()
+	defer plugin.deviceLock.Unlock()
+
+	// Devices are not changed once the plugin has started shutting down.
+	if plugin.shutdownStarted.isSet() {
+		log.Info("[plugin] not rediscovering dynamic devices, plugin is shutting down")
+		return nil
+	}
+
+	log.Info("[plugin] rediscovering dynamic devices")
+
+	var multiErr = errors.NewMultiError("Dynamic Device Rediscovery")
+	var applied []*deviceChanges
+
+	changes, conf, err := plugin.device.loadDeviceChanges(plugin.reloadEnabled())
+	if err != nil {
+		log.WithField("error", err).Error("[plugin] failed to reload dynamic device config, keeping current devices")
+		multiErr.Add(err)
+	} else {
+		plugin.device.setConfig(conf)
+		if err := plugin.applyDeviceChanges(changes, plugin.device.addConfiguredDevice); err != nil {
+			multiErr.Add(err)
+		}
+		applied = append(applied, changes)
+	}
+
+	changes, err = plugin.device.loadDiscoveredChanges()
+	if err != nil {
+		log.WithField("error", err).Error("[plugin] failed to rediscover dynamic devices, keeping current devices")
+		multiErr.Add(err)
+	} else {
+		if err := plugin.applyDeviceChanges(changes, plugin.device.addDiscoveredDevice); err != nil {
+			multiErr.Add(err)
+		}
+		applied = append(applied, changes)
+	}
+
+	var added, updated, removed int
+	for _, c := range applied {
+		added += len(c.added)
+		updated += len(c.updated)
+		removed += len(c.removed)
+		for _, device := range c.added {
+			log.WithFields(log.Fields{
+				"id":   device.id,
+				"type": device.Type,
+				"info": device.Info,
+			}).Info("[plugin] rediscovery: device added")
+		}
+		for _, device := range c.updated {
+			log.WithFields(log.Fields{
+				"id":   device.id,
+				"type": device.Type,
+				"info": device.Info,
+			}).Info("[plugin] rediscovery: device updated")
+		}
+		for _, device := range c.removed {
+			log.WithFields(log.Fields{
+				"id":   device.id,
+				"type": device.Type,
+				"info": device.Info,
+			}).Info("[plugin] rediscovery: device removed")
+		}
+	}
+	rediscoveryChangesMetric.WithLabelValues(deviceChangeAdded).Add(float64(added))
+	rediscoveryChangesMetric.WithLabelValues(deviceChangeUpdated).Add(float64(updated))
+	rediscoveryChangesMetric.WithLabelValues(deviceChangeRemoved).Add(float64(removed))
+
+	err = multiErr.Err()
+	if err != nil {
+		rediscoveryRunsMetric.WithLabelValues("error").Inc()
+	} else {
+		rediscoveryRunsMetric.WithLabelValues("success").Inc()
+	}
+
+	log.WithFields(log.Fields{
+		"added":   added,
+		"updated": updated,
+		"removed": removed,
+	}).Info("[plugin] completed dynamic device rediscovery")
+	return err
+}
+
+// runDeviceRediscovery periodically rediscovers dynamic devices at the given
+// interval. It runs until the stop channel is closed.
+func (plugin *Plugin) runDeviceRediscovery(interval time.Duration, stop <-chan struct{}) {
+	log.WithField("interval", interval).Info("[plugin] starting dynamic device rediscovery")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			log.Info("[plugin] stopping dynamic device rediscovery")
+			return
+		case <-ticker.C:
+			_ = plugin.rediscoverDevices()
+		}
+	}
+}
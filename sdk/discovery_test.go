@@ -0,0 +1,222 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vapor-ware/synse-sdk/v2/internal/test"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
+)
+
+// setTestRegistrar sets up dynamic device registration for the plugin with a
+// registrar which returns a "temp" device for each of the IDs in the given slice
+// at the time it is called.
+func setTestRegistrar(p *Plugin, ids *[]string, fail *bool) {
+	p.device.dynamicConfig = &config.DynamicRegistrationSettings{
+		Config: []map[string]interface{}{{"address": "localhost"}},
+	}
+	p.pluginHandlers.DynamicRegistrar = func(_ map[string]interface{}) ([]*Device, error) {
+		if *fail {
+			return nil, fmt.Errorf("registrar error")
+		}
+		var devices []*Device
+		for _, id := range *ids {
+			devices = append(devices, &Device{
+				id:      id,
+				Info:    id,
+				Type:    "temperature",
+				Handler: "temp",
+			})
+		}
+		return devices, nil
+	}
+}
+
+func TestDeviceManager_loadDiscoveredChanges_noDynamicConfig(t *testing.T) {
+	m := deviceManager{}
+
+	changes, err := m.loadDiscoveredChanges()
+	assert.NoError(t, err)
+	assert.True(t, changes.empty())
+}
+
+func TestDeviceManager_loadDiscoveredChanges(t *testing.T) {
+	ids := []string{"1", "2"}
+	fail := false
+	p := newTestPlugin()
+	setTestRegistrar(p, &ids, &fail)
+	assert.NoError(t, p.device.createDynamicDevices())
+	assert.Len(t, p.device.discovered, 2)
+
+	changes, err := p.device.loadDiscoveredChanges()
+	assert.NoError(t, err)
+	assert.True(t, changes.empty())
+
+	ids = []string{"2", "3"}
+	changes, err = p.device.loadDiscoveredChanges()
+	assert.NoError(t, err)
+	assert.Empty(t, changes.updated)
+	assert.Len(t, changes.added, 1)
+	assert.Equal(t, "3", changes.added[0].id)
+	assert.Len(t, changes.removed, 1)
+	assert.Equal(t, "1", changes.removed[0].id)
+}
+
+func TestDeviceManager_loadDiscoveredChanges_registrarError(t *testing.T) {
+	ids := []string{"1"}
+	fail := false
+	p := newTestPlugin()
+	setTestRegistrar(p, &ids, &fail)
+	assert.NoError(t, p.device.createDynamicDevices())
+
+	fail = true
+	changes, err := p.device.loadDiscoveredChanges()
+	assert.Error(t, err)
+	assert.Nil(t, changes)
+}
+
+func TestDeviceManager_loadDiscoveredChanges_duplicate(t *testing.T) {
+	ids := []string{"1", "1"}
+	fail := false
+	p := newTestPlugin()
+	setTestRegistrar(p, &ids, &fail)
+
+	changes, err := p.device.loadDiscoveredChanges()
+	assert.Equal(t, ErrDeviceIDExists, err)
+	assert.Nil(t, changes)
+}
+
+func TestDeviceManager_loadDiscoveredChanges_conflict(t *testing.T) {
+	ids := []string{"1"}
+	fail := false
+	p := newTestPlugin()
+	setTestRegistrar(p, &ids, &fail)
+	assert.NoError(t, p.device.AddDevice(&Device{id: "1", Type: "temperature", Handler: "temp"}))
+
+	changes, err := p.device.loadDiscoveredChanges()
+	assert.Equal(t, ErrDeviceIDExists, err)
+	assert.Nil(t, changes)
+}
+
+func TestPlugin_rediscoverDevices(t *testing.T) {
+	dir, closer := test.TempDir(t)
+	defer closer()
+	test.SetEnv(t, DeviceEnvOverride, dir)
+	defer test.RemoveEnv(t, DeviceEnvOverride)
+	writeReloadTestConfig(t, dir, map[int]string{1: "configured"})
+
+	ids := []string{"psu-1", "psu-2"}
+	fail := false
+	p := newTestPlugin()
+	setTestRegistrar(p, &ids, &fail)
+	assert.NoError(t, p.device.init())
+	assert.Equal(t, []string{"configured", "psu-1", "psu-2"}, reloadTestDeviceInfo(p))
+	removed := p.device.GetDevice("psu-1")
+
+	// A device is swapped out.
+	ids = []string{"psu-2", "psu-3"}
+	err := p.rediscoverDevices()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"configured", "psu-2", "psu-3"}, reloadTestDeviceInfo(p))
+	assert.True(t, removed.IsRemoved())
+
+	// If the registrar fails, the current devices are kept.
+	fail = true
+	err = p.rediscoverDevices()
+	assert.Error(t, err)
+	assert.Equal(t, []string{"configured", "psu-2", "psu-3"}, reloadTestDeviceInfo(p))
+}
+
+func TestPlugin_rediscoverDevices_shuttingDown(t *testing.T) {
+	ids := []string{"psu-1"}
+	fail := false
+	p := newTestPlugin()
+	setTestRegistrar(p, &ids, &fail)
+	assert.NoError(t, p.device.createDynamicDevices())
+	assert.Equal(t, []string{"psu-1"}, reloadTestDeviceInfo(p))
+
+	// Device changes are not applied once shutdown has started.
+	p.shutdownStarted.set(true)
+	ids = []string{"psu-1", "psu-2"}
+	assert.NoError(t, p.rediscoverDevices())
+	assert.Equal(t, []string{"psu-1"}, reloadTestDeviceInfo(p))
+}
+
+func TestPlugin_rediscoverDevices_fileConfig(t *testing.T) {
+	dir, closer := test.TempDir(t)
+	defer closer()
+	test.SetEnv(t, DeviceEnvOverride, dir)
+	defer test.RemoveEnv(t, DeviceEnvOverride)
+	writeReloadTestConfig(t, dir, map[int]string{1: "configured"})
+
+	ids := []string{"psu-1"}
+	fail := false
+	p := newTestPlugin()
+	setTestRegistrar(p, &ids, &fail)
+	assert.NoError(t, p.device.init())
+
+	// Device config reload is not enabled, so changes to the config files are
+	// not picked up by rediscovery.
+	writeReloadTestConfig(t, dir, map[int]string{1: "changed", 2: "added"})
+	err := p.rediscoverDevices()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"configured", "psu-1"}, reloadTestDeviceInfo(p))
+
+	// With device config reload enabled, they are.
+	p.config.Settings.Reload = &config.ReloadSettings{Enabled: true}
+	err = p.rediscoverDevices()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"added", "changed", "psu-1"}, reloadTestDeviceInfo(p))
+}
+
+func TestPlugin_runDeviceRediscovery(t *testing.T) {
+	dir, closer := test.TempDir(t)
+	defer closer()
+	test.SetEnv(t, DeviceEnvOverride, dir)
+	defer test.RemoveEnv(t, DeviceEnvOverride)
+	writeReloadTestConfig(t, dir, map[int]string{1: "configured"})
+
+	ids := []string{"psu-1"}
+	fail := false
+	p := newTestPlugin()
+	setTestRegistrar(p, &ids, &fail)
+	assert.NoError(t, p.device.init())
+
+	ids = []string{"psu-2"}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		p.runDeviceRediscovery(10*time.Millisecond, stop)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return p.GetDevice("psu-2") != nil && p.GetDevice("psu-1") == nil
+	}, time.Second, 10*time.Millisecond)
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("rediscovery was not stopped")
+	}
+}
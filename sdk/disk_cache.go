@@ -0,0 +1,407 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/output"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/utils"
+)
+
+const segmentFileExt = ".seg"
+
+// ErrCacheClosed is returned when attempting to add to a readings cache which
+// is not open.
+var ErrCacheClosed = errors.New("readings cache is not open")
+
+// diskCacheRecord is the on-disk representation of a cached ReadContext.
+type diskCacheRecord struct {
+	// Timestamp is the time at which the readings were added to the cache.
+	Timestamp time.Time
+
+	// Device is the ID of the device which the readings belong to.
+	Device string
+
+	// Readings are the cached readings.
+	Readings []*output.Reading
+
+	// Outputs are the names of the outputs of the cached readings, in the same
+	// order as the readings. A reading's output is not exported, so it is not
+	// encoded with the reading and must be restored from its name.
+	Outputs []string
+}
+
+// diskCache is a ReadingsCache which persists readings to disk, so the cached
+// readings are retained across plugin restarts.
+//
+// Readings are appended to segment files, each of which holds the readings
+// added within a fixed window of time (the segment duration). Segment files
+// are named by the start time of their window. Once all the readings in a
+// segment are older than the cache TTL, the segment is removed.
+//
+// Each record in a segment is encoded as a 4-byte big-endian length followed
+// by the gob-encoded record. A partially written record (e.g. from a crash
+// mid-write) at the end of a segment is ignored when reading.
+type diskCache struct {
+	dir             string
+	ttl             time.Duration
+	segmentDuration time.Duration
+
+	// lookup resolves a device from its ID when loading cached readings.
+	lookup func(string) *Device
+
+	lock         sync.Mutex
+	isOpen       bool
+	current      *os.File
+	currentStart time.Time
+	stop         chan struct{}
+}
+
+// newDiskCache creates a new on-disk readings cache. The cache must be opened
+// prior to use.
+func newDiskCache(conf *config.CacheSettings, lookup func(string) *Device) *diskCache {
+	segmentDuration := conf.SegmentDuration
+	if segmentDuration <= 0 {
+		segmentDuration = time.Minute
+	}
+	return &diskCache{
+		dir:             conf.Directory,
+		ttl:             conf.TTL,
+		segmentDuration: segmentDuration,
+		lookup:          lookup,
+	}
+}
+
+// Open creates the cache directory, if it does not already exist, removes any
+// expired segments, and starts periodic compaction.
+func (c *diskCache) Open() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.isOpen {
+		return nil
+	}
+
+	log.WithField("dir", c.dir).Info("[cache] opening on-disk readings cache")
+	if err := os.MkdirAll(c.dir, os.ModePerm); err != nil {
+		return err
+	}
+	if err := c.compact(time.Now()); err != nil {
+		return err
+	}
+
+	c.stop = make(chan struct{})
+	c.isOpen = true
+	go c.runCompaction(c.stop)
+	return nil
+}
+
+// Put appends the readings in the given ReadContext to the current segment.
+func (c *diskCache) Put(ctx *ReadContext) error {
+	if ctx == nil || ctx.Device == nil {
+		return fmt.Errorf("cannot cache readings without a device")
+	}
+
+	outputs := make([]string, len(ctx.Reading))
+	for i, reading := range ctx.Reading {
+		if o := reading.GetOutput(); o != nil {
+			outputs[i] = o.Name
+		}
+	}
+
+	frame, err := encodeDiskCacheRecord(&diskCacheRecord{
+		Timestamp: time.Now().UTC(),
+		Device:    ctx.Device.id,
+		Readings:  ctx.Reading,
+		Outputs:   outputs,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.isOpen {
+		return ErrCacheClosed
+	}
+	segment, err := c.segmentFor(time.Now())
+	if err != nil {
+		return err
+	}
+	_, err = segment.Write(frame)
+	return err
+}
+
+// Query passes all cached ReadContexts within the given bounds to the channel.
+// Readings for devices which can no longer be resolved are skipped.
+func (c *diskCache) Query(start, end time.Time, readings chan<- *ReadContext) error {
+	segments, err := c.segments()
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-c.ttl)
+
+	for _, segStart := range segments {
+		segEnd := segStart.Add(c.segmentDuration)
+
+		// Skip segments which fall completely outside of the bounds.
+		if !segEnd.After(cutoff) {
+			continue
+		}
+		if !start.IsZero() && segEnd.Before(start) {
+			continue
+		}
+		if !end.IsZero() && segStart.After(end) {
+			continue
+		}
+
+		err := c.readSegment(segStart, func(record *diskCacheRecord) {
+			// Bounds are compared at the resolution of the timestamp precision, as
+			// the query bounds are RFC3339 timestamps with that precision.
+			ts := record.Timestamp.Truncate(utils.TimestampResolution())
+			if record.Timestamp.Before(cutoff) {
+				return
+			}
+			if !start.IsZero() && ts.Before(start) {
+				return
+			}
+			if !end.IsZero() && ts.After(end) {
+				return
+			}
+
+			var device *Device
+			if c.lookup != nil {
+				device = c.lookup(record.Device)
+			}
+			if device == nil {
+				log.WithField("device", record.Device).Debug("[cache] cached device no longer exists, skipping")
+				return
+			}
+			for i, name := range record.Outputs {
+				if name != "" && i < len(record.Readings) {
+					record.Readings[i].WithOutput(output.Get(name))
+				}
+			}
+			ctx := NewReadContext(device, record.Readings)
+			ctx.cached = record.Timestamp
+			readings <- ctx
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops compaction and closes the current segment file.
+func (c *diskCache) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.isOpen {
+		return nil
+	}
+	log.Info("[cache] closing on-disk readings cache")
+
+	c.isOpen = false
+	close(c.stop)
+
+	var err error
+	if c.current != nil {
+		err = c.current.Close()
+		c.current = nil
+	}
+	return err
+}
+
+// segmentFor gets the segment file which readings added at the given time should
+// be written to, rotating the current segment if needed. The cache lock must be
+// held when calling this.
+func (c *diskCache) segmentFor(now time.Time) (*os.File, error) {
+	start := now.Truncate(c.segmentDuration)
+	if c.current != nil && start.Equal(c.currentStart) {
+		return c.current, nil
+	}
+
+	if c.current != nil {
+		if err := c.current.Close(); err != nil {
+			log.WithField("error", err).Warn("[cache] failed to close readings cache segment")
+		}
+		c.current = nil
+	}
+
+	f, err := os.OpenFile(c.segmentPath(start), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	c.current = f
+	c.currentStart = start
+	return f, nil
+}
+
+// segmentPath gets the path to the segment file for the segment with the given start time.
+func (c *diskCache) segmentPath(start time.Time) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%020d%s", start.UnixNano(), segmentFileExt))
+}
+
+// segments gets the start times of all segments in the cache directory, in order.
+func (c *diskCache) segments() ([]time.Time, error) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []time.Time
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentFileExt) {
+			continue
+		}
+		ns, err := strconv.ParseInt(strings.TrimSuffix(name, segmentFileExt), 10, 64)
+		if err != nil {
+			log.WithField("file", name).Warn("[cache] ignoring unrecognized file in cache directory")
+			continue
+		}
+		segments = append(segments, time.Unix(0, ns))
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].Before(segments[j])
+	})
+	return segments, nil
+}
+
+// readSegment decodes each record in the segment with the given start time,
+// passing it to the provided function.
+func (c *diskCache) readSegment(start time.Time, fn func(*diskCacheRecord)) error {
+	f, err := os.Open(c.segmentPath(start))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// The segment may have been compacted since it was listed.
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for {
+		record, err := decodeDiskCacheRecord(reader)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"segment": f.Name(),
+				"error":   err,
+			}).Error("[cache] failed to decode cached record, skipping rest of segment")
+			return nil
+		}
+		fn(record)
+	}
+}
+
+// compact removes all segments whose readings are older than the cache TTL.
+func (c *diskCache) compact(now time.Time) error {
+	segments, err := c.segments()
+	if err != nil {
+		return err
+	}
+
+	cutoff := now.Add(-c.ttl)
+	for _, start := range segments {
+		if start.Add(c.segmentDuration).After(cutoff) {
+			// Segments are ordered, so all remaining segments are newer.
+			break
+		}
+		if c.current != nil && start.Equal(c.currentStart) {
+			continue
+		}
+		log.WithField("segment", start).Debug("[cache] removing expired readings cache segment")
+		if err := os.Remove(c.segmentPath(start)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// runCompaction periodically compacts the cache until the stop channel is closed.
+func (c *diskCache) runCompaction(stop chan struct{}) {
+	ticker := time.NewTicker(c.segmentDuration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			c.lock.Lock()
+			err := c.compact(now)
+			c.lock.Unlock()
+			if err != nil {
+				log.WithField("error", err).Error("[cache] failed to compact readings cache")
+			}
+		}
+	}
+}
+
+// encodeDiskCacheRecord encodes a record into a length-prefixed frame.
+func encodeDiskCacheRecord(record *diskCacheRecord) ([]byte, error) {
+	var body bytes.Buffer
+	if err := gob.NewEncoder(&body).Encode(record); err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, 4+body.Len())
+	binary.BigEndian.PutUint32(frame, uint32(body.Len()))
+	copy(frame[4:], body.Bytes())
+	return frame, nil
+}
+
+// decodeDiskCacheRecord decodes the next length-prefixed record from the reader.
+func decodeDiskCacheRecord(reader io.Reader) (*diskCacheRecord, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(reader, size[:]); err != nil {
+		return nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+
+	var record diskCacheRecord
+	if err := gob.NewDecoder(bytes.NewReader(body)).Decode(&record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
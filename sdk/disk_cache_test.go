@@ -0,0 +1,204 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/output"
+)
+
+func newTestDiskCache(t *testing.T, ttl time.Duration) (*diskCache, map[string]*Device) {
+	devices := map[string]*Device{
+		"123": {id: "123"},
+		"456": {id: "456"},
+	}
+	c := newDiskCache(
+		&config.CacheSettings{
+			Directory:       t.TempDir(),
+			TTL:             ttl,
+			SegmentDuration: time.Minute,
+		},
+		func(id string) *Device { return devices[id] },
+	)
+	return c, devices
+}
+
+func drainReadings(readings chan *ReadContext) []*ReadContext {
+	close(readings)
+	var ctxs []*ReadContext
+	for r := range readings {
+		ctxs = append(ctxs, r)
+	}
+	return ctxs
+}
+
+func TestDiskCache_putClosed(t *testing.T) {
+	c, devices := newTestDiskCache(t, time.Minute)
+
+	err := c.Put(NewReadContext(devices["123"], []*output.Reading{{Value: 1}}))
+	assert.Equal(t, ErrCacheClosed, err)
+}
+
+func TestDiskCache_PutQuery(t *testing.T) {
+	c, devices := newTestDiskCache(t, time.Minute)
+	assert.NoError(t, c.Open())
+	defer c.Close()
+
+	assert.NoError(t, c.Put(NewReadContext(devices["123"], []*output.Reading{
+		{Timestamp: "2020-01-01T00:00:00Z", Type: "temperature", Value: int64(1), Unit: &output.Unit{Name: "celsius", Symbol: "C"}},
+	})))
+	assert.NoError(t, c.Put(NewReadContext(devices["456"], []*output.Reading{
+		{Type: "state", Value: "on", Context: map[string]string{"foo": "bar"}},
+		{Type: "nil", Value: nil},
+	})))
+
+	readings := make(chan *ReadContext, 5)
+	assert.NoError(t, c.Query(time.Time{}, time.Time{}, readings))
+	ctxs := drainReadings(readings)
+	assert.Len(t, ctxs, 2)
+
+	assert.Equal(t, devices["123"], ctxs[0].Device)
+	assert.Len(t, ctxs[0].Reading, 1)
+	assert.Equal(t, "2020-01-01T00:00:00Z", ctxs[0].Reading[0].Timestamp)
+	assert.Equal(t, int64(1), ctxs[0].Reading[0].Value)
+	assert.Equal(t, &output.Unit{Name: "celsius", Symbol: "C"}, ctxs[0].Reading[0].Unit)
+
+	assert.Equal(t, devices["456"], ctxs[1].Device)
+	assert.Len(t, ctxs[1].Reading, 2)
+	assert.Equal(t, "on", ctxs[1].Reading[0].Value)
+	assert.Equal(t, map[string]string{"foo": "bar"}, ctxs[1].Reading[0].Context)
+	assert.Nil(t, ctxs[1].Reading[1].Value)
+}
+
+func TestDiskCache_Query_bounds(t *testing.T) {
+	c, devices := newTestDiskCache(t, time.Minute)
+	assert.NoError(t, c.Open())
+	defer c.Close()
+
+	assert.NoError(t, c.Put(NewReadContext(devices["123"], []*output.Reading{{Value: 1}})))
+
+	// End bound before the reading was cached.
+	readings := make(chan *ReadContext, 5)
+	assert.NoError(t, c.Query(time.Time{}, time.Now().Add(-time.Hour), readings))
+	assert.Empty(t, drainReadings(readings))
+
+	// Start bound after the reading was cached.
+	readings = make(chan *ReadContext, 5)
+	assert.NoError(t, c.Query(time.Now().Add(time.Hour), time.Time{}, readings))
+	assert.Empty(t, drainReadings(readings))
+
+	// Bounds contain the reading.
+	readings = make(chan *ReadContext, 5)
+	assert.NoError(t, c.Query(time.Now().Add(-time.Hour), time.Now().Add(time.Hour), readings))
+	assert.Len(t, drainReadings(readings), 1)
+}
+
+func TestDiskCache_Query_unknownDevice(t *testing.T) {
+	c, _ := newTestDiskCache(t, time.Minute)
+	assert.NoError(t, c.Open())
+	defer c.Close()
+
+	assert.NoError(t, c.Put(NewReadContext(&Device{id: "789"}, []*output.Reading{{Value: 1}})))
+
+	readings := make(chan *ReadContext, 5)
+	assert.NoError(t, c.Query(time.Time{}, time.Time{}, readings))
+	assert.Empty(t, drainReadings(readings))
+}
+
+func TestDiskCache_persistsAcrossReopen(t *testing.T) {
+	c, devices := newTestDiskCache(t, time.Minute)
+	assert.NoError(t, c.Open())
+	assert.NoError(t, c.Put(NewReadContext(devices["123"], []*output.Reading{{Value: 1}})))
+	assert.NoError(t, c.Close())
+
+	// Create a new cache using the same directory, e.g. after a plugin restart.
+	reopened := newDiskCache(
+		&config.CacheSettings{Directory: c.dir, TTL: time.Minute, SegmentDuration: time.Minute},
+		c.lookup,
+	)
+	assert.NoError(t, reopened.Open())
+	defer reopened.Close()
+
+	readings := make(chan *ReadContext, 5)
+	assert.NoError(t, reopened.Query(time.Time{}, time.Time{}, readings))
+	ctxs := drainReadings(readings)
+	assert.Len(t, ctxs, 1)
+	assert.Equal(t, 1, ctxs[0].Reading[0].Value)
+}
+
+func TestDiskCache_Query_output(t *testing.T) {
+	c, devices := newTestDiskCache(t, time.Minute)
+	assert.NoError(t, c.Open())
+	defer c.Close()
+
+	reading, err := output.Count.MakeReading(3)
+	assert.NoError(t, err)
+	assert.NoError(t, c.Put(NewReadContext(devices["123"], []*output.Reading{reading, {Value: 1}})))
+
+	// The reading outputs are restored by name, since they are not encoded with
+	// the readings.
+	readings := make(chan *ReadContext, 5)
+	assert.NoError(t, c.Query(time.Time{}, time.Time{}, readings))
+	ctxs := drainReadings(readings)
+	assert.Len(t, ctxs, 1)
+	assert.Equal(t, &output.Count, ctxs[0].Reading[0].GetOutput())
+	assert.Nil(t, ctxs[0].Reading[1].GetOutput())
+}
+
+func TestDiskCache_partialRecord(t *testing.T) {
+	c, devices := newTestDiskCache(t, time.Minute)
+	assert.NoError(t, c.Open())
+	defer c.Close()
+
+	assert.NoError(t, c.Put(NewReadContext(devices["123"], []*output.Reading{{Value: 1}})))
+
+	// Simulate a partially written record at the end of the segment.
+	_, err := c.current.Write([]byte{0, 0, 1, 0, 1, 2})
+	assert.NoError(t, err)
+
+	readings := make(chan *ReadContext, 5)
+	assert.NoError(t, c.Query(time.Time{}, time.Time{}, readings))
+	assert.Len(t, drainReadings(readings), 1)
+}
+
+func TestDiskCache_compact(t *testing.T) {
+	c, _ := newTestDiskCache(t, time.Minute)
+	assert.NoError(t, os.MkdirAll(c.dir, os.ModePerm))
+
+	now := time.Now().Truncate(time.Minute)
+	expired := now.Add(-10 * time.Minute)
+	current := now.Add(-1 * time.Minute)
+	for _, start := range []time.Time{expired, current} {
+		assert.NoError(t, os.WriteFile(c.segmentPath(start), []byte{}, 0644))
+	}
+	// Unrecognized files in the directory are left alone.
+	assert.NoError(t, os.WriteFile(filepath.Join(c.dir, "foo.txt"), []byte{}, 0644))
+
+	assert.NoError(t, c.compact(now))
+
+	segments, err := c.segments()
+	assert.NoError(t, err)
+	assert.Len(t, segments, 1)
+	assert.True(t, segments[0].Equal(current))
+	assert.FileExists(t, filepath.Join(c.dir, "foo.txt"))
+}
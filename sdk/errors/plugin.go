@@ -40,3 +40,13 @@ func InvalidArgumentErr(format string, a ...interface{}) error {
 func NotFoundErr(format string, a ...interface{}) error {
 	return status.Errorf(codes.NotFound, format, a...)
 }
+
+// FailedPreconditionErr creates a gRPC FailedPrecondition error with the given description.
+func FailedPreconditionErr(format string, a ...interface{}) error {
+	return status.Errorf(codes.FailedPrecondition, format, a...)
+}
+
+// ResourceExhaustedErr creates a gRPC ResourceExhausted error with the given description.
+func ResourceExhaustedErr(format string, a ...interface{}) error {
+	return status.Errorf(codes.ResourceExhausted, format, a...)
+}
@@ -41,6 +41,24 @@ func TestNotFoundErr(t *testing.T) {
 	assert.True(t, strings.Contains(err.Error(), errString))
 }
 
+// TestFailedPreconditionErr tests constructing a new FailedPrecondition error.
+func TestFailedPreconditionErr(t *testing.T) {
+	errString := "test error"
+	err := FailedPreconditionErr(errString)
+
+	assert.True(t, strings.Contains(err.Error(), "FailedPrecondition"))
+	assert.True(t, strings.Contains(err.Error(), errString))
+}
+
+// TestResourceExhaustedErr tests constructing a new ResourceExhausted error.
+func TestResourceExhaustedErr(t *testing.T) {
+	errString := "test error"
+	err := ResourceExhaustedErr(errString)
+
+	assert.True(t, strings.Contains(err.Error(), "ResourceExhausted"))
+	assert.True(t, strings.Contains(err.Error(), errString))
+}
+
 // TestUnsupportedCommandErrorErr tests constructing and stringify-ing
 // an UnsupportedCommandError error.
 func TestUnsupportedCommandErrorErr(t *testing.T) {
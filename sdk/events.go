@@ -0,0 +1,216 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultEventBuffer is the size of an event subscription's buffer if no size
+// is specified when subscribing.
+const defaultEventBuffer = 64
+
+// EventType identifies the kind of a plugin Event.
+type EventType string
+
+// Plugin event types.
+const (
+	// EventDeviceAdded is published when a device is added to the plugin.
+	EventDeviceAdded EventType = "device_added"
+
+	// EventDeviceRemoved is published when a device is removed from the plugin.
+	EventDeviceRemoved EventType = "device_removed"
+
+	// EventReadFailed is published when a device read fails.
+	EventReadFailed EventType = "read_failed"
+
+	// EventListenerRestarting is published when a device listener fails and
+	// will be restarted.
+	EventListenerRestarting EventType = "listener_restarting"
+
+	// EventListenerFailed is published when a device listener fails and will
+	// not be restarted, as it has exceeded its max restarts.
+	EventListenerFailed EventType = "listener_failed"
+
+	// EventTransactionStatus is published when the status of a write transaction
+	// changes.
+	EventTransactionStatus EventType = "transaction_status"
+)
+
+// Event describes something which happened in the plugin. Which fields are set
+// depends on the event type.
+type Event struct {
+	// Type is the type of the event.
+	Type EventType
+
+	// Time is the time at which the event occurred.
+	Time time.Time
+
+	// Device is the device which the event relates to, if any. For removed
+	// devices, this is the device as it was when it was removed.
+	Device *Device
+
+	// Transaction is the ID of the write transaction which the event relates
+	// to, for transaction events.
+	Transaction string
+
+	// Status is the new status of the write transaction, for transaction events.
+	Status string
+
+	// Message is the message associated with the write transaction, if any,
+	// for transaction events.
+	Message string
+
+	// Restarts is the number of times the listener has been restarted, for
+	// listener events.
+	Restarts int
+
+	// Err is the error which caused the event, for failure events.
+	Err error
+}
+
+// EventSubscription is a subscription to plugin events, created with
+// Plugin.SubscribeEvents.
+//
+// Events are delivered to the subscription's buffered channel without blocking
+// the plugin. If the buffer is full when an event is published, the event is
+// dropped for the subscription; the number of dropped events is tracked.
+type EventSubscription struct {
+	bus     *eventBus
+	events  chan Event
+	types   map[EventType]struct{}
+	dropped uint64
+	once    sync.Once
+}
+
+// Events gets the channel on which the subscription's events are delivered. The
+// channel is closed when the subscription is closed.
+func (sub *EventSubscription) Events() <-chan Event {
+	return sub.events
+}
+
+// Dropped gets the number of events which were dropped for the subscription
+// because its buffer was full.
+func (sub *EventSubscription) Dropped() uint64 {
+	return atomic.LoadUint64(&sub.dropped)
+}
+
+// Close ends the subscription. No more events are delivered and the events
+// channel is closed. Calling Close more than once has no additional effect.
+func (sub *EventSubscription) Close() {
+	sub.once.Do(func() {
+		sub.bus.unsubscribe(sub)
+		close(sub.events)
+	})
+}
+
+// accepts checks whether the subscription receives events of the given type.
+func (sub *EventSubscription) accepts(t EventType) bool {
+	if len(sub.types) == 0 {
+		return true
+	}
+	_, ok := sub.types[t]
+	return ok
+}
+
+// SubscribeEvents subscribes to plugin events. If any event types are given, only
+// events of those types are delivered; otherwise, all events are delivered.
+//
+// The buffer size sets how many events can be held for the subscription before
+// further events are dropped. If it is not positive, a default size is used. The
+// subscription should be closed once it is no longer needed.
+func (plugin *Plugin) SubscribeEvents(buffer int, types ...EventType) *EventSubscription {
+	return plugin.events.subscribe(buffer, types...)
+}
+
+// eventBus fans out plugin events to subscribers.
+type eventBus struct {
+	lock sync.RWMutex
+	subs map[*EventSubscription]struct{}
+}
+
+// newEventBus creates a new event bus.
+func newEventBus() *eventBus {
+	return &eventBus{
+		subs: make(map[*EventSubscription]struct{}),
+	}
+}
+
+// subscribe adds a new subscription to the bus.
+func (bus *eventBus) subscribe(buffer int, types ...EventType) *EventSubscription {
+	if buffer <= 0 {
+		buffer = defaultEventBuffer
+	}
+	sub := &EventSubscription{
+		bus:    bus,
+		events: make(chan Event, buffer),
+		types:  make(map[EventType]struct{}, len(types)),
+	}
+	for _, t := range types {
+		sub.types[t] = struct{}{}
+	}
+
+	bus.lock.Lock()
+	bus.subs[sub] = struct{}{}
+	bus.lock.Unlock()
+
+	log.WithFields(log.Fields{
+		"buffer": buffer,
+		"types":  types,
+	}).Debug("[events] added event subscription")
+	return sub
+}
+
+// unsubscribe removes a subscription from the bus.
+func (bus *eventBus) unsubscribe(sub *EventSubscription) {
+	bus.lock.Lock()
+	delete(bus.subs, sub)
+	bus.lock.Unlock()
+
+	log.Debug("[events] removed event subscription")
+}
+
+// publish delivers an event to all subscriptions which accept it. Delivery does
+// not block: if a subscription's buffer is full, the event is dropped for that
+// subscription. Publishing to a nil bus does nothing.
+func (bus *eventBus) publish(event Event) {
+	if bus == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	bus.lock.RLock()
+	defer bus.lock.RUnlock()
+
+	for sub := range bus.subs {
+		if !sub.accepts(event.Type) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+			eventsDroppedMetric.WithLabelValues(string(event.Type)).Inc()
+		}
+	}
+}
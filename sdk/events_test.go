@@ -0,0 +1,256 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
+)
+
+// nextEvent gets the next event from the subscription, failing the test if
+// there is none.
+func nextEvent(t *testing.T, sub *EventSubscription) Event {
+	select {
+	case e := <-sub.Events():
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+		return Event{}
+	}
+}
+
+func TestEventBus_publish(t *testing.T) {
+	bus := newEventBus()
+	sub := bus.subscribe(0)
+	defer sub.Close()
+
+	device := &Device{id: "123"}
+	bus.publish(Event{Type: EventDeviceAdded, Device: device})
+
+	e := nextEvent(t, sub)
+	assert.Equal(t, EventDeviceAdded, e.Type)
+	assert.Equal(t, device, e.Device)
+	assert.False(t, e.Time.IsZero())
+	assert.Equal(t, defaultEventBuffer, cap(sub.events))
+}
+
+func TestEventBus_publish_nilBus(t *testing.T) {
+	var bus *eventBus
+	assert.NotPanics(t, func() {
+		bus.publish(Event{Type: EventDeviceAdded})
+	})
+}
+
+func TestEventBus_publish_filtered(t *testing.T) {
+	bus := newEventBus()
+	removed := bus.subscribe(4, EventDeviceRemoved)
+	defer removed.Close()
+	all := bus.subscribe(4)
+	defer all.Close()
+
+	bus.publish(Event{Type: EventDeviceAdded})
+	bus.publish(Event{Type: EventDeviceRemoved})
+
+	assert.Equal(t, EventDeviceRemoved, nextEvent(t, removed).Type)
+	assert.Empty(t, removed.events)
+
+	assert.Equal(t, EventDeviceAdded, nextEvent(t, all).Type)
+	assert.Equal(t, EventDeviceRemoved, nextEvent(t, all).Type)
+}
+
+func TestEventBus_publish_fullBuffer(t *testing.T) {
+	bus := newEventBus()
+	slow := bus.subscribe(1)
+	defer slow.Close()
+	fast := bus.subscribe(4)
+	defer fast.Close()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			bus.publish(Event{Type: EventReadFailed})
+		}
+		close(done)
+	}()
+
+	// Publishing does not block on the full subscriber.
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked on full subscription buffer")
+	}
+
+	assert.Equal(t, uint64(2), slow.Dropped())
+	assert.Len(t, slow.events, 1)
+	assert.Equal(t, uint64(0), fast.Dropped())
+	assert.Len(t, fast.events, 3)
+}
+
+func TestEventSubscription_Close(t *testing.T) {
+	bus := newEventBus()
+	sub := bus.subscribe(4)
+
+	sub.Close()
+	assert.Empty(t, bus.subs)
+
+	_, open := <-sub.Events()
+	assert.False(t, open)
+
+	// Events are no longer delivered, and closing again is a no-op.
+	assert.NotPanics(t, func() {
+		bus.publish(Event{Type: EventDeviceAdded})
+		sub.Close()
+	})
+}
+
+func TestPlugin_SubscribeEvents(t *testing.T) {
+	p := Plugin{events: newEventBus()}
+
+	sub := p.SubscribeEvents(4, EventDeviceAdded)
+	defer sub.Close()
+
+	p.events.publish(Event{Type: EventDeviceAdded})
+	assert.Equal(t, EventDeviceAdded, nextEvent(t, sub).Type)
+}
+
+func TestDeviceManager_events(t *testing.T) {
+	bus := newEventBus()
+	sub := bus.subscribe(4)
+	defer sub.Close()
+
+	m := deviceManager{
+		tagCache:       NewTagCache(),
+		aliasCache:     NewAliasCache(),
+		pluginHandlers: NewDefaultPluginHandlers(),
+		devices:        map[string]*Device{},
+		handlers: map[string]*DeviceHandler{
+			"foo": {Name: "foo"},
+		},
+		events: bus,
+	}
+	device := &Device{id: "123", Type: "foo", Handler: "foo"}
+
+	assert.NoError(t, m.AddDevice(device))
+	e := nextEvent(t, sub)
+	assert.Equal(t, EventDeviceAdded, e.Type)
+	assert.Equal(t, device, e.Device)
+
+	_, err := m.RemoveDevice("123")
+	assert.NoError(t, err)
+	e = nextEvent(t, sub)
+	assert.Equal(t, EventDeviceRemoved, e.Type)
+	assert.Equal(t, device, e.Device)
+}
+
+func TestScheduler_readFailed_event(t *testing.T) {
+	bus := newEventBus()
+	sub := bus.subscribe(4)
+	defer sub.Close()
+
+	s := scheduler{
+		config:       &config.PluginSettings{Read: &config.ReadSettings{}},
+		stateManager: &stateManager{},
+		events:       bus,
+	}
+	device := &Device{id: "123"}
+
+	s.readFailed(device, fmt.Errorf("read error"))
+	e := nextEvent(t, sub)
+	assert.Equal(t, EventReadFailed, e.Type)
+	assert.Equal(t, device, e.Device)
+	assert.EqualError(t, e.Err, "read error")
+}
+
+func TestScheduler_listen_events(t *testing.T) {
+	bus := newEventBus()
+	sub := bus.subscribe(8, EventListenerRestarting, EventListenerFailed)
+	defer sub.Close()
+
+	handler := &DeviceHandler{
+		Name: "test",
+		Listen: func(device *Device, contexts chan *ReadContext) error {
+			return fmt.Errorf("listen error")
+		},
+	}
+	s := scheduler{
+		config: &config.PluginSettings{
+			Listen: &config.ListenSettings{MaxRestarts: 1},
+		},
+		stateManager: &stateManager{},
+		stop:         make(chan struct{}),
+		events:       bus,
+	}
+
+	s.listen(NewListenerCtx(handler, &Device{id: "123"}))
+
+	e := nextEvent(t, sub)
+	assert.Equal(t, EventListenerRestarting, e.Type)
+	assert.Equal(t, 1, e.Restarts)
+	assert.EqualError(t, e.Err, "listen error")
+
+	e = nextEvent(t, sub)
+	assert.Equal(t, EventListenerFailed, e.Type)
+	assert.Equal(t, 1, e.Restarts)
+}
+
+func TestTransaction_events(t *testing.T) {
+	bus := newEventBus()
+	sub := bus.subscribe(8)
+	defer sub.Close()
+
+	sm := stateManager{
+		transactions: cache.New(1*time.Minute, 2*time.Minute),
+		events:       bus,
+	}
+	txn, err := sm.newTransaction(time.Second, "abc")
+	assert.NoError(t, err)
+
+	device := &Device{id: "123"}
+	txn.device = device
+	txn.setStatusPending()
+	txn.setStatusWriting()
+	txn.message = "write failed"
+	txn.setStatusError()
+
+	for _, status := range []string{"pending", "writing", "error"} {
+		e := nextEvent(t, sub)
+		assert.Equal(t, EventTransactionStatus, e.Type)
+		assert.Equal(t, "abc", e.Transaction)
+		assert.Equal(t, device, e.Device)
+		assert.Equal(t, status, e.Status)
+	}
+	assert.Empty(t, sub.events)
+}
+
+func TestTransaction_events_cancelled(t *testing.T) {
+	bus := newEventBus()
+	sub := bus.subscribe(4)
+	defer sub.Close()
+
+	txn := newTransaction(time.Second, "abc")
+	txn.events = bus
+	txn.setStatusCancelled()
+
+	e := nextEvent(t, sub)
+	assert.Equal(t, EventTransactionStatus, e.Type)
+	assert.Equal(t, "cancelled", e.Status)
+}
@@ -0,0 +1,334 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/output"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/utils"
+)
+
+// Approximate fixed overheads, in bytes, used when estimating the size of
+// cached readings.
+const (
+	cacheEntryOverhead   = 96
+	cacheReadingOverhead = 128
+	cacheValueSize       = 16
+)
+
+// memoryCacheEntry is a single set of readings held in the in-memory cache.
+type memoryCacheEntry struct {
+	device    string
+	timestamp time.Time
+	expires   time.Time
+	size      int64
+	ctx       *ReadContext
+
+	// elem is the entry's element in the cache's insertion-ordered list.
+	elem *list.Element
+}
+
+// ringBuffer is a FIFO buffer of cache entries for a single device. If it has
+// a capacity, pushing to a full buffer evicts its oldest entry; otherwise, the
+// buffer grows as needed.
+type ringBuffer struct {
+	capacity int
+	entries  []*memoryCacheEntry
+	head     int
+	count    int
+}
+
+// newRingBuffer creates a new ring buffer with the given capacity. A capacity
+// of 0 means the buffer is unbounded.
+func newRingBuffer(capacity int) *ringBuffer {
+	return &ringBuffer{
+		capacity: capacity,
+	}
+}
+
+// push adds an entry to the buffer. If the buffer was full, the oldest entry is
+// evicted to make room for it and returned.
+func (ring *ringBuffer) push(entry *memoryCacheEntry) *memoryCacheEntry {
+	var evicted *memoryCacheEntry
+	if ring.capacity > 0 && ring.count == ring.capacity {
+		evicted = ring.pop()
+	}
+	if ring.count == len(ring.entries) {
+		ring.grow()
+	}
+	ring.entries[(ring.head+ring.count)%len(ring.entries)] = entry
+	ring.count++
+	return evicted
+}
+
+// pop removes and returns the oldest entry in the buffer.
+func (ring *ringBuffer) pop() *memoryCacheEntry {
+	if ring.count == 0 {
+		return nil
+	}
+	entry := ring.entries[ring.head]
+	ring.entries[ring.head] = nil
+	ring.head = (ring.head + 1) % len(ring.entries)
+	ring.count--
+	return entry
+}
+
+// grow increases the size of the buffer's backing slice, up to its capacity.
+func (ring *ringBuffer) grow() {
+	size := len(ring.entries) * 2
+	if size == 0 {
+		size = 8
+	}
+	if ring.capacity > 0 && size > ring.capacity {
+		size = ring.capacity
+	}
+
+	entries := make([]*memoryCacheEntry, size)
+	for i := 0; i < ring.count; i++ {
+		entries[i] = ring.entries[(ring.head+i)%len(ring.entries)]
+	}
+	ring.entries = entries
+	ring.head = 0
+}
+
+// memoryCache is an in-memory ReadingsCache. Readings are held in a ring buffer
+// per device, bounded by the configured max entries. The cache as a whole is
+// bounded by the configured max bytes; once exceeded, the oldest readings across
+// all devices are evicted. Readings expire after the configured TTL.
+type memoryCache struct {
+	ttl        time.Duration
+	maxEntries int
+	maxBytes   int64
+
+	lock    sync.Mutex
+	devices map[string]*ringBuffer
+	order   *list.List
+	bytes   int64
+}
+
+// newMemoryCache creates a new in-memory readings cache.
+func newMemoryCache(conf *config.CacheSettings) *memoryCache {
+	return &memoryCache{
+		ttl:        conf.TTL,
+		maxEntries: conf.MaxEntries,
+		maxBytes:   conf.MaxBytes,
+		devices:    make(map[string]*ringBuffer),
+		order:      list.New(),
+	}
+}
+
+// Open is a no-op for the in-memory cache.
+func (c *memoryCache) Open() error {
+	return nil
+}
+
+// Put adds the readings in the given ReadContext to the cache.
+func (c *memoryCache) Put(ctx *ReadContext) error {
+	if ctx == nil || ctx.Device == nil {
+		return fmt.Errorf("cannot cache readings without a device")
+	}
+	c.add(ctx, time.Now())
+	return nil
+}
+
+// add adds the readings in the given ReadContext to the cache with the given
+// timestamp, evicting older readings as needed to stay within the cache bounds.
+func (c *memoryCache) add(ctx *ReadContext, timestamp time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	c.evictExpired(now)
+
+	ctx.cached = timestamp
+	entry := &memoryCacheEntry{
+		device:    ctx.Device.id,
+		timestamp: timestamp,
+		size:      estimateCacheSize(ctx),
+		ctx:       ctx,
+	}
+	if c.ttl > 0 {
+		entry.expires = now.Add(c.ttl)
+	}
+
+	ring, exists := c.devices[entry.device]
+	if !exists {
+		ring = newRingBuffer(c.maxEntries)
+		c.devices[entry.device] = ring
+	}
+	if evicted := ring.push(entry); evicted != nil {
+		c.order.Remove(evicted.elem)
+		c.bytes -= evicted.size
+		cacheEvictionsMetric.WithLabelValues(evictionMaxEntries).Inc()
+	}
+	entry.elem = c.order.PushBack(entry)
+	c.bytes += entry.size
+
+	// Always retain the newest entry, even if it exceeds the max bytes on its own.
+	for c.maxBytes > 0 && c.bytes > c.maxBytes && c.order.Len() > 1 {
+		c.evictOldest(evictionMaxBytes)
+	}
+	c.updateMetrics()
+}
+
+// Query passes all cached ReadContexts within the given bounds to the channel,
+// in the order in which they were added.
+func (c *memoryCache) Query(start, end time.Time, readings chan<- *ReadContext) error {
+	now := time.Now()
+
+	// Collect the matching readings before passing them to the channel, so the
+	// cache is not locked while waiting on the consumer.
+	var matches []*ReadContext
+	c.lock.Lock()
+	for elem := c.order.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*memoryCacheEntry)
+		if entry.expired(now) {
+			continue
+		}
+
+		// Bounds are compared at the resolution of the timestamp precision, as
+		// the query bounds are RFC3339 timestamps with that precision.
+		ts := entry.timestamp.Truncate(utils.TimestampResolution())
+		if !start.IsZero() && ts.Before(start) {
+			continue
+		}
+		if !end.IsZero() && ts.After(end) {
+			continue
+		}
+		matches = append(matches, entry.ctx)
+	}
+	c.lock.Unlock()
+
+	for _, ctx := range matches {
+		readings <- ctx
+	}
+	return nil
+}
+
+// Close flushes all items from the in-memory cache.
+func (c *memoryCache) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.devices = make(map[string]*ringBuffer)
+	c.order.Init()
+	c.bytes = 0
+	c.updateMetrics()
+	return nil
+}
+
+// entries gets the number of reading sets held in the cache.
+func (c *memoryCache) entries() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.order.Len()
+}
+
+// size gets the approximate size, in bytes, of the readings held in the cache.
+func (c *memoryCache) size() int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.bytes
+}
+
+// evictExpired removes all entries which have exceeded the cache TTL. Since all
+// entries share the same TTL, they expire in the order they were added. The cache
+// lock must be held when calling this.
+func (c *memoryCache) evictExpired(now time.Time) {
+	for c.order.Len() > 0 {
+		if !c.order.Front().Value.(*memoryCacheEntry).expired(now) {
+			return
+		}
+		c.evictOldest(evictionExpired)
+	}
+}
+
+// evictOldest removes the oldest entry in the cache. Since entries are added in
+// order, this is also the oldest entry in its device's ring buffer. The cache
+// lock must be held when calling this.
+func (c *memoryCache) evictOldest(reason string) {
+	elem := c.order.Front()
+	if elem == nil {
+		return
+	}
+	entry := c.order.Remove(elem).(*memoryCacheEntry)
+	c.bytes -= entry.size
+
+	if ring, exists := c.devices[entry.device]; exists {
+		ring.pop()
+		if ring.count == 0 {
+			delete(c.devices, entry.device)
+		}
+	}
+	cacheEvictionsMetric.WithLabelValues(reason).Inc()
+}
+
+// updateMetrics updates the cache size metrics. The cache lock must be held
+// when calling this.
+func (c *memoryCache) updateMetrics() {
+	cacheEntriesMetric.Set(float64(c.order.Len()))
+	cacheBytesMetric.Set(float64(c.bytes))
+	cacheDevicesMetric.Set(float64(len(c.devices)))
+}
+
+// expired checks whether the entry has exceeded its TTL.
+func (entry *memoryCacheEntry) expired(now time.Time) bool {
+	return !entry.expires.IsZero() && !now.Before(entry.expires)
+}
+
+// estimateCacheSize estimates the number of bytes held by a cached ReadContext.
+// This is not exact; it is used to bound the overall size of the cache.
+func estimateCacheSize(ctx *ReadContext) int64 {
+	size := int64(cacheEntryOverhead)
+	if ctx.Device != nil {
+		size += int64(len(ctx.Device.id))
+	}
+	for _, reading := range ctx.Reading {
+		size += estimateReadingSize(reading)
+	}
+	return size
+}
+
+// estimateReadingSize estimates the number of bytes held by a reading.
+func estimateReadingSize(reading *output.Reading) int64 {
+	if reading == nil {
+		return 0
+	}
+	size := int64(cacheReadingOverhead + len(reading.Timestamp) + len(reading.Type))
+	if reading.Unit != nil {
+		size += int64(len(reading.Unit.Name) + len(reading.Unit.Symbol))
+	}
+	for k, v := range reading.Context {
+		size += int64(len(k) + len(v))
+	}
+	switch v := reading.Value.(type) {
+	case string:
+		size += int64(len(v))
+	case []byte:
+		size += int64(len(v))
+	default:
+		size += cacheValueSize
+	}
+	return size
+}
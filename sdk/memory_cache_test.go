@@ -0,0 +1,191 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/output"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/utils"
+)
+
+func testReadContext(device string, value interface{}) *ReadContext {
+	return &ReadContext{
+		Device:  &Device{id: device},
+		Reading: []*output.Reading{{Value: value}},
+	}
+}
+
+func TestRingBuffer_unbounded(t *testing.T) {
+	ring := newRingBuffer(0)
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, ring.push(&memoryCacheEntry{size: int64(i)}))
+	}
+	assert.Equal(t, 20, ring.count)
+
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, int64(i), ring.pop().size)
+	}
+	assert.Nil(t, ring.pop())
+}
+
+func TestRingBuffer_bounded(t *testing.T) {
+	ring := newRingBuffer(3)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, ring.push(&memoryCacheEntry{size: int64(i)}))
+	}
+
+	evicted := ring.push(&memoryCacheEntry{size: 3})
+	assert.NotNil(t, evicted)
+	assert.Equal(t, int64(0), evicted.size)
+	assert.Equal(t, 3, ring.count)
+	assert.Len(t, ring.entries, 3)
+
+	assert.Equal(t, int64(1), ring.pop().size)
+	assert.Equal(t, int64(2), ring.pop().size)
+	assert.Equal(t, int64(3), ring.pop().size)
+	assert.Nil(t, ring.pop())
+}
+
+func TestMemoryCache_PutQuery(t *testing.T) {
+	c := newMemoryCache(&config.CacheSettings{TTL: time.Minute})
+	assert.NoError(t, c.Open())
+
+	err := c.Put(testReadContext("123", 1))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, c.entries())
+	assert.True(t, c.size() > 0)
+
+	readings := make(chan *ReadContext, 5)
+	err = c.Query(time.Time{}, time.Time{}, readings)
+	assert.NoError(t, err)
+	assert.Len(t, readings, 1)
+
+	rctx := <-readings
+	assert.Equal(t, "123", rctx.Device.id)
+
+	assert.NoError(t, c.Close())
+	assert.Equal(t, 0, c.entries())
+	assert.Equal(t, int64(0), c.size())
+}
+
+func TestMemoryCache_PutNoDevice(t *testing.T) {
+	c := newMemoryCache(&config.CacheSettings{TTL: time.Minute})
+
+	err := c.Put(&ReadContext{})
+	assert.Error(t, err)
+	assert.Equal(t, 0, c.entries())
+}
+
+func TestMemoryCache_maxEntries(t *testing.T) {
+	c := newMemoryCache(&config.CacheSettings{TTL: time.Minute, MaxEntries: 2})
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, c.Put(testReadContext("1", i)))
+	}
+	assert.NoError(t, c.Put(testReadContext("2", 10)))
+	assert.Equal(t, 3, c.entries())
+
+	readings := make(chan *ReadContext, 5)
+	assert.NoError(t, c.Query(time.Time{}, time.Time{}, readings))
+	assert.Len(t, readings, 3)
+
+	// Only the newest readings for device 1 are retained, in order.
+	assert.Equal(t, 3, (<-readings).Reading[0].Value)
+	assert.Equal(t, 4, (<-readings).Reading[0].Value)
+	assert.Equal(t, 10, (<-readings).Reading[0].Value)
+}
+
+func TestMemoryCache_maxBytes(t *testing.T) {
+	entrySize := estimateCacheSize(testReadContext("1", 1))
+	c := newMemoryCache(&config.CacheSettings{TTL: time.Minute, MaxBytes: entrySize * 2})
+
+	assert.NoError(t, c.Put(testReadContext("1", 1)))
+	assert.NoError(t, c.Put(testReadContext("2", 2)))
+	assert.Equal(t, 2, c.entries())
+
+	// Adding a third entry evicts the oldest entry across all devices.
+	assert.NoError(t, c.Put(testReadContext("3", 3)))
+	assert.Equal(t, 2, c.entries())
+	assert.Equal(t, entrySize*2, c.size())
+	assert.NotContains(t, c.devices, "1")
+
+	readings := make(chan *ReadContext, 5)
+	assert.NoError(t, c.Query(time.Time{}, time.Time{}, readings))
+	assert.Len(t, readings, 2)
+	assert.Equal(t, "2", (<-readings).Device.id)
+	assert.Equal(t, "3", (<-readings).Device.id)
+}
+
+func TestMemoryCache_maxBytesKeepsNewest(t *testing.T) {
+	c := newMemoryCache(&config.CacheSettings{TTL: time.Minute, MaxBytes: 1})
+
+	assert.NoError(t, c.Put(testReadContext("1", 1)))
+	assert.NoError(t, c.Put(testReadContext("1", 2)))
+	assert.Equal(t, 1, c.entries())
+}
+
+func TestMemoryCache_expired(t *testing.T) {
+	c := newMemoryCache(&config.CacheSettings{TTL: time.Millisecond})
+
+	assert.NoError(t, c.Put(testReadContext("1", 1)))
+	time.Sleep(2 * time.Millisecond)
+
+	readings := make(chan *ReadContext, 5)
+	assert.NoError(t, c.Query(time.Time{}, time.Time{}, readings))
+	assert.Empty(t, readings)
+
+	// Expired entries are evicted when new readings are added.
+	assert.NoError(t, c.Put(testReadContext("2", 2)))
+	assert.Equal(t, 1, c.entries())
+	assert.NotContains(t, c.devices, "1")
+}
+
+func TestEstimateReadingSize(t *testing.T) {
+	assert.Equal(t, int64(0), estimateReadingSize(nil))
+
+	small := estimateReadingSize(&output.Reading{Value: 1})
+	large := estimateReadingSize(&output.Reading{
+		Type:    "temperature",
+		Unit:    &output.Unit{Name: "celsius", Symbol: "C"},
+		Value:   "a string value",
+		Context: map[string]string{"source": "test"},
+	})
+	assert.True(t, large > small)
+}
+
+func TestMemoryCache_Query_subSecond(t *testing.T) {
+	assert.NoError(t, utils.SetTimestampPrecision(utils.PrecisionNanosecond))
+	defer func() {
+		assert.NoError(t, utils.SetTimestampPrecision(utils.PrecisionSecond))
+	}()
+
+	c := newMemoryCache(&config.CacheSettings{TTL: time.Minute})
+	base := time.Now().Truncate(time.Second)
+	c.add(testReadContext("1", 1), base)
+	c.add(testReadContext("1", 2), base.Add(300*time.Millisecond))
+	c.add(testReadContext("1", 3), base.Add(700*time.Millisecond))
+
+	// Bounds within the same second select readings at sub-second resolution.
+	readings := make(chan *ReadContext, 5)
+	assert.NoError(t, c.Query(base.Add(200*time.Millisecond), base.Add(500*time.Millisecond), readings))
+	assert.Len(t, readings, 1)
+	assert.Equal(t, 2, (<-readings).Reading[0].Value)
+}
@@ -17,27 +17,166 @@
 package sdk
 
 import (
+	"fmt"
 	"net/http"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 )
 
-// exposeMetrics exposes Prometheus application metrics via HTTP. It starts
-// an HTTP server on the default metrics port (2112) and exposes the /metrics
-// endpoint.
+// Readings cache eviction reasons, used as the "reason" label for the
+// cache evictions metric.
+const (
+	evictionExpired    = "expired"
+	evictionMaxEntries = "max_entries"
+	evictionMaxBytes   = "max_bytes"
+)
+
+// Application metrics for the in-memory readings cache.
+var (
+	cacheEntriesMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "synse_sdk_readings_cache_entries",
+		Help: "The number of reading sets held in the in-memory readings cache.",
+	})
+
+	cacheBytesMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "synse_sdk_readings_cache_bytes",
+		Help: "The approximate size, in bytes, of the in-memory readings cache.",
+	})
+
+	cacheDevicesMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "synse_sdk_readings_cache_devices",
+		Help: "The number of devices with readings in the in-memory readings cache.",
+	})
+
+	cacheEvictionsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "synse_sdk_readings_cache_evictions_total",
+		Help: "The number of reading sets evicted from the in-memory readings cache.",
+	}, []string{"reason"})
+)
+
+// Device change types, used as the "change" label for the device rediscovery
+// changes metric.
+const (
+	deviceChangeAdded   = "added"
+	deviceChangeUpdated = "updated"
+	deviceChangeRemoved = "removed"
+)
+
+// Application metrics for dynamic device rediscovery.
+var (
+	rediscoveryRunsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "synse_sdk_device_rediscovery_runs_total",
+		Help: "The number of dynamic device rediscovery runs, by result.",
+	}, []string{"result"})
+
+	rediscoveryChangesMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "synse_sdk_device_rediscovery_changes_total",
+		Help: "The number of device changes applied by dynamic device rediscovery.",
+	}, []string{"change"})
+)
+
+// Application metrics for plugin events.
+var (
+	eventsDroppedMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "synse_sdk_events_dropped_total",
+		Help: "The number of plugin events dropped because a subscriber's buffer was full.",
+	}, []string{"type"})
+)
+
+// Application metrics for ReadStream subscribers.
+var (
+	streamReadingsDroppedMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "synse_sdk_stream_readings_dropped_total",
+		Help: "The number of readings dropped because a read stream's buffer was full, by overflow policy.",
+	}, []string{"policy"})
+
+	streamDisconnectsMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "synse_sdk_stream_disconnects_total",
+		Help: "The number of read streams closed because their buffer overflowed.",
+	})
+)
+
+// Write transaction outcomes, used as the "status" label for the write
+// transactions metric.
+const (
+	transactionDone      = "done"
+	transactionError     = "error"
+	transactionCancelled = "cancelled"
+)
+
+// Application metrics for device reads and writes.
+var (
+	readDurationMetric = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "synse_sdk_device_read_duration_seconds",
+		Help: "The time taken to read devices, by device handler.",
+	}, []string{"handler"})
+
+	readErrorsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "synse_sdk_device_read_errors_total",
+		Help: "The number of failed device reads, by device handler.",
+	}, []string{"handler"})
+
+	transactionsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "synse_sdk_write_transactions_total",
+		Help: "The number of completed write transactions, by outcome.",
+	}, []string{"status"})
+)
+
+// newMetricsServer creates the HTTP server which exposes Prometheus application
+// metrics on the configured metrics address and path (:2112 and /metrics, by
+// default).
+//
+// In addition to the application metrics, the plugin's device readings, write
+// queue depths, and stream count are exported via a metricsCollector. The
+// collector is registered with a registry for the plugin, rather than the
+// default registry, so it can not conflict with collectors registered for other
+// plugins in the same process.
+func newMetricsServer(plugin *Plugin) (*http.Server, error) {
+	conf := plugin.config.Metrics
+	addr, path := conf.Address, conf.Path
+	if addr == "" {
+		addr = ":2112"
+	}
+	if path == "" {
+		path = "/metrics"
+	}
+
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(newMetricsCollector(plugin)); err != nil {
+		return nil, fmt.Errorf("failed to register plugin metrics collector: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(
+			prometheus.Gatherers{prometheus.DefaultGatherer, registry},
+			promhttp.HandlerOpts{},
+		),
+	))
+
+	return &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}, nil
+}
+
+// exposeMetrics exposes Prometheus application metrics via HTTP, serving them
+// with the given metrics server until it is shut down.
 //
 // The caller of this function is responsible for ensuring that the plugin
 // has metrics enabled via the plugin configuration.
 //
 // This function blocks on ListenAndServe, so the caller should run this
 // as a goroutine.
-func exposeMetrics() {
-	log.Info("[metrics] exposing prometheus metrics on :2112/metrics")
+func exposeMetrics(server *http.Server) {
+	log.Infof("[metrics] exposing prometheus metrics on %s", server.Addr)
 
-	http.Handle("/metrics", promhttp.Handler())
-	err := http.ListenAndServe(":2112", nil)
-	if err != nil {
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatalf("[metrics] failed to serve metrics endpoint: %v", err)
 	}
 }
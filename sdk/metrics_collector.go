@@ -0,0 +1,195 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/output"
+)
+
+// Metric descriptions for the metrics which are computed from the plugin's
+// state when the metrics endpoint is scraped.
+var (
+	deviceReadingDesc = prometheus.NewDesc(
+		"synse_device_reading",
+		"The current value of a numeric device reading.",
+		[]string{"device", "device_type", "alias", "tags", "type", "output", "unit"},
+		nil,
+	)
+
+	writeQueueDepthDesc = prometheus.NewDesc(
+		"synse_sdk_write_queue_depth",
+		"The number of writes queued for processing, by write priority.",
+		[]string{"priority"},
+		nil,
+	)
+
+	streamsDesc = prometheus.NewDesc(
+		"synse_sdk_read_streams",
+		"The number of connected read streams.",
+		nil,
+		nil,
+	)
+)
+
+// metricsCollector is a Prometheus collector which exports metrics computed from
+// the current plugin state: the plugin's numeric device readings, the depth of
+// its write queues, and the number of connected read streams.
+//
+// Metrics for components which are nil are not collected.
+type metricsCollector struct {
+	state     *stateManager
+	devices   *deviceManager
+	scheduler *scheduler
+
+	// readings sets whether device readings are collected.
+	readings bool
+
+	// duplicates holds the label values, joined, of the reading series which
+	// have been logged as duplicates, so each is only logged once.
+	duplicates    map[string]struct{}
+	duplicateLock sync.Mutex
+}
+
+// newMetricsCollector creates a new collector for the plugin's metrics.
+func newMetricsCollector(plugin *Plugin) *metricsCollector {
+	return &metricsCollector{
+		state:     plugin.state,
+		devices:   plugin.device,
+		scheduler: plugin.scheduler,
+		readings:  plugin.config.Metrics.Readings,
+	}
+}
+
+// Describe sends the descriptions of the collected metrics to the channel.
+func (c *metricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- deviceReadingDesc
+	ch <- writeQueueDepthDesc
+	ch <- streamsDesc
+}
+
+// Collect sends the current value of the collected metrics to the channel.
+func (c *metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.readings && c.state != nil {
+		c.collectReadings(ch)
+	}
+	if c.scheduler != nil {
+		for _, q := range c.scheduler.priorityQueues() {
+			ch <- prometheus.MustNewConstMetric(
+				writeQueueDepthDesc, prometheus.GaugeValue, float64(len(q.queue)), q.priority.String(),
+			)
+		}
+	}
+	if c.state != nil {
+		ch <- prometheus.MustNewConstMetric(streamsDesc, prometheus.GaugeValue, float64(c.state.streamCount()))
+	}
+}
+
+// collectReadings sends a gauge for each of the plugin's current numeric device
+// readings to the channel. Readings with non-numeric values are not exported.
+func (c *metricsCollector) collectReadings(ch chan<- prometheus.Metric) {
+	for id, readings := range c.state.GetReadings() {
+		var device *Device
+		if c.devices != nil {
+			device = c.devices.GetDevice(id)
+		}
+
+		// The reading context is not used as a label, since it may hold arbitrary
+		// values, so readings which differ only by their context map to the same
+		// series. A registry fails the whole scrape if the same series is collected
+		// twice, so only the first of any duplicate readings is sent.
+		seen := make(map[string]struct{}, len(readings))
+		for _, reading := range readings {
+			value, ok := toFloat64(reading.Value)
+			if !ok {
+				continue
+			}
+			labels := readingLabels(id, device, reading)
+			key := strings.Join(labels, "\x00")
+			if _, ok := seen[key]; ok {
+				c.logDuplicate(key, id, reading)
+				continue
+			}
+			seen[key] = struct{}{}
+			ch <- prometheus.MustNewConstMetric(deviceReadingDesc, prometheus.GaugeValue, value, labels...)
+		}
+	}
+}
+
+// logDuplicate logs that a device reading was not exported because another of the
+// device's readings has the same labels. Each duplicate series is only logged once.
+func (c *metricsCollector) logDuplicate(key, id string, reading *output.Reading) {
+	c.duplicateLock.Lock()
+	defer c.duplicateLock.Unlock()
+
+	if _, ok := c.duplicates[key]; ok {
+		return
+	}
+	if c.duplicates == nil {
+		c.duplicates = make(map[string]struct{})
+	}
+	c.duplicates[key] = struct{}{}
+	log.WithFields(log.Fields{
+		"device":  id,
+		"type":    reading.Type,
+		"context": reading.Context,
+	}).Warn("[metrics] not exporting device reading with duplicate labels; only the first reading is exported")
+}
+
+// readingLabels gets the label values for a device reading metric, in the order
+// of the deviceReadingDesc labels. The device may be nil, e.g. if it was removed
+// after the reading was collected, in which case the device labels are empty.
+func readingLabels(id string, device *Device, reading *output.Reading) []string {
+	var deviceType, alias string
+	var tags []string
+	if device != nil {
+		deviceType = device.Type
+		alias = device.Alias
+		for _, tag := range device.Tags {
+			tags = append(tags, tag.String())
+		}
+		sort.Strings(tags)
+	}
+
+	var outputName string
+	if o := reading.GetOutput(); o != nil {
+		outputName = o.Name
+	}
+
+	var unit string
+	if reading.Unit != nil {
+		unit = reading.Unit.Symbol
+		if unit == "" {
+			unit = reading.Unit.Name
+		}
+	}
+
+	return []string{
+		id,
+		deviceType,
+		alias,
+		strings.Join(tags, ","),
+		reading.Type,
+		outputName,
+		unit,
+	}
+}
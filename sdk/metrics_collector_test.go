@@ -0,0 +1,195 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/output"
+)
+
+// newTestMetricsCollector creates a metrics collector for a plugin with a single
+// device which has the given readings.
+func newTestMetricsCollector(readings ...*output.Reading) *metricsCollector {
+	tag, _ := NewTag("vapor/rack:r1")
+	devices := &deviceManager{
+		devices: map[string]*Device{
+			"123": {id: "123", Type: "temperature", Alias: "inlet", Tags: []*Tag{tag}},
+		},
+	}
+	return &metricsCollector{
+		state: &stateManager{
+			deviceManager: devices,
+			readings:      map[string][]*output.Reading{"123": readings},
+			readingsLock:  &sync.RWMutex{},
+			streams:       map[uuid.UUID]*ReadStream{uuid.New(): nil},
+			streamLock:    &sync.Mutex{},
+		},
+		devices: devices,
+		scheduler: newScheduler(&Plugin{
+			config: &config.Plugin{
+				Settings: &config.PluginSettings{
+					Write: &config.WriteSettings{QueueSize: 10},
+				},
+			},
+			device: devices,
+			state:  &stateManager{},
+		}),
+		readings: true,
+	}
+}
+
+func TestMetricsCollector_readings(t *testing.T) {
+	c := newTestMetricsCollector(
+		&output.Reading{Type: "temperature", Value: 20.5, Unit: &output.Unit{Name: "celsius", Symbol: "C"}},
+		&output.Reading{Type: "humidity", Value: 40, Unit: &output.Unit{Name: "percent"}},
+		&output.Reading{Type: "state", Value: "ok"},
+		&output.Reading{Type: "state", Value: "1"},
+	)
+
+	expected := `
+# HELP synse_device_reading The current value of a numeric device reading.
+# TYPE synse_device_reading gauge
+synse_device_reading{alias="inlet",device="123",device_type="temperature",output="",tags="vapor/rack:r1",type="humidity",unit="percent"} 40
+synse_device_reading{alias="inlet",device="123",device_type="temperature",output="",tags="vapor/rack:r1",type="temperature",unit="C"} 20.5
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected), "synse_device_reading")
+	assert.NoError(t, err)
+}
+
+func TestMetricsCollector_readingsDuplicate(t *testing.T) {
+	c := newTestMetricsCollector(
+		&output.Reading{Type: "temperature", Value: 20, Context: map[string]string{"zone": "a"}},
+		&output.Reading{Type: "temperature", Value: 21, Context: map[string]string{"zone": "b"}},
+	)
+
+	// The context is not a label, so the readings are the same series. Duplicate
+	// series would fail the scrape, so only the first is collected.
+	expected := `
+# HELP synse_device_reading The current value of a numeric device reading.
+# TYPE synse_device_reading gauge
+synse_device_reading{alias="inlet",device="123",device_type="temperature",output="",tags="vapor/rack:r1",type="temperature",unit=""} 20
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected), "synse_device_reading")
+	assert.NoError(t, err)
+
+	// The duplicate is recorded so it is only logged once.
+	assert.Len(t, c.duplicates, 1)
+	assert.Equal(t, 1, testutil.CollectAndCount(c, "synse_device_reading"))
+	assert.Len(t, c.duplicates, 1)
+}
+
+func TestMetricsCollector_readingsDisabled(t *testing.T) {
+	c := newTestMetricsCollector(&output.Reading{Type: "temperature", Value: 20})
+	c.readings = false
+
+	assert.Equal(t, 0, testutil.CollectAndCount(c, "synse_device_reading"))
+}
+
+func TestMetricsCollector_internal(t *testing.T) {
+	c := newTestMetricsCollector()
+
+	expected := `
+# HELP synse_sdk_read_streams The number of connected read streams.
+# TYPE synse_sdk_read_streams gauge
+synse_sdk_read_streams 1
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected), "synse_sdk_read_streams")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, testutil.CollectAndCount(c, "synse_sdk_write_queue_depth"))
+}
+
+func TestMetricsCollector_nil(t *testing.T) {
+	c := &metricsCollector{readings: true}
+
+	assert.Equal(t, 0, testutil.CollectAndCount(c))
+}
+
+func TestNewMetricsServer(t *testing.T) {
+	newPlugin := func() *Plugin {
+		return &Plugin{
+			config: &config.Plugin{
+				Metrics: &config.MetricsSettings{Enabled: true, Path: "/test-metrics"},
+			},
+			state: &stateManager{
+				streams:    map[uuid.UUID]*ReadStream{uuid.New(): nil},
+				streamLock: &sync.Mutex{},
+			},
+		}
+	}
+
+	server, err := newMetricsServer(newPlugin())
+	assert.NoError(t, err)
+	assert.Equal(t, ":2112", server.Addr)
+
+	// The plugin metrics and the application metrics are both served.
+	rec := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test-metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "synse_sdk_read_streams 1")
+	assert.Contains(t, rec.Body.String(), "synse_sdk_readings_cache_entries")
+
+	// Each plugin registers its collector separately, so they do not conflict.
+	_, err = newMetricsServer(newPlugin())
+	assert.NoError(t, err)
+}
+
+func TestExposeMetrics_shutdown(t *testing.T) {
+	server := &http.Server{Addr: "127.0.0.1:0"}
+	done := make(chan struct{})
+	go func() {
+		exposeMetrics(server)
+		close(done)
+	}()
+
+	assert.NoError(t, server.Shutdown(context.Background()))
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("metrics endpoint still serving after shutdown")
+	}
+}
+
+func TestReadingLabels_noDevice(t *testing.T) {
+	labels := readingLabels("123", nil, &output.Reading{
+		Type:    "temperature",
+		Unit:    &output.Unit{Name: "celsius"},
+		Context: map[string]string{"b": "2", "a": "1"},
+	})
+	assert.Equal(t, []string{"123", "", "", "", "temperature", "", "celsius"}, labels)
+}
+
+func TestTransaction_statusMetrics(t *testing.T) {
+	done := testutil.ToFloat64(transactionsMetric.WithLabelValues(transactionDone))
+	cancelled := testutil.ToFloat64(transactionsMetric.WithLabelValues(transactionCancelled))
+
+	newTransaction(0, "").setStatusDone()
+	newTransaction(0, "").setStatusCancelled()
+
+	assert.Equal(t, done+1, testutil.ToFloat64(transactionsMetric.WithLabelValues(transactionDone)))
+	assert.Equal(t, cancelled+1, testutil.ToFloat64(transactionsMetric.WithLabelValues(transactionCancelled)))
+}
@@ -17,6 +17,8 @@
 package sdk
 
 import (
+	"time"
+
 	"github.com/vapor-ware/synse-sdk/v2/sdk/output"
 	synse "github.com/vapor-ware/synse-server-grpc/go"
 )
@@ -33,6 +35,14 @@ import (
 type ReadContext struct {
 	Device  *Device
 	Reading []*output.Reading
+
+	// sequence is the sequence number assigned to the readings by the state
+	// manager when they are processed. It is 0 if no sequence was assigned.
+	sequence uint64
+
+	// cached is the time at which the readings were added to the readings
+	// cache. It is set by the SDK's readings caches, and is zero otherwise.
+	cached time.Time
 }
 
 // NewReadContext creates a new instance of a ReadContext from the given
@@ -49,6 +59,7 @@ type WriteContext struct {
 	transaction *transaction
 	device      *Device
 	data        *synse.V3WriteData
+	priority    WritePriority
 }
 
 // WriteData is an SDK alias for the Synse gRPC WriteData. This is done to
@@ -64,6 +64,16 @@ func CustomDeviceDataValidator(validator DeviceDataValidator) PluginOption {
 	}
 }
 
+// CustomReadingsCache lets you set a custom backend for the plugin's readings cache.
+// The custom cache is only used if caching is enabled in the plugin configuration,
+// in which case it takes precedence over the configured cache backend.
+func CustomReadingsCache(readingsCache ReadingsCache) PluginOption {
+	log.Debug("[options] using custom readings cache")
+	return func(plugin *Plugin) {
+		plugin.readingsCache = readingsCache
+	}
+}
+
 // PluginConfigRequired is a PluginOption which designates that a Plugin should require
 // a plugin config and will fail if it does not detect one. By default, a Plugin considers
 // them optional and will use a set of default configurations if no config is found.
@@ -18,6 +18,7 @@ package sdk
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
@@ -41,6 +42,18 @@ func TestCustomDeviceIdentifier(t *testing.T) {
 	assert.NotNil(t, plugin.pluginHandlers.DeviceIdentifier)
 }
 
+// TestCustomReadingsCache tests creating a PluginOption for a custom
+// readings cache.
+func TestCustomReadingsCache(t *testing.T) {
+	c := newMemoryCache(&config.CacheSettings{TTL: time.Minute})
+	opt := CustomReadingsCache(c)
+	plugin := Plugin{}
+	assert.Nil(t, plugin.readingsCache)
+
+	opt(&plugin)
+	assert.Equal(t, c, plugin.readingsCache)
+}
+
 // TestCustomDynamicDeviceRegistration tests creating a PluginOption for
 // a custom device registration function.
 func TestCustomDynamicDeviceRegistration(t *testing.T) {
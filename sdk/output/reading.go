@@ -18,6 +18,7 @@ package output
 
 import (
 	"fmt"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/vapor-ware/synse-sdk/v2/sdk/utils"
@@ -28,6 +29,8 @@ import (
 // for a reading is represented using the RFC3339 layout.
 type Reading struct {
 	// Timestamp is the RFC3339-formatted time at which the reading was taken.
+	// Readings made from an Output are timestamped when they are made; handlers
+	// which know when a value was acquired should set it with WithTimestamp.
 	Timestamp string
 
 	// Type is the type of the reading, as defined by the Reading's output.
@@ -54,6 +57,26 @@ func (reading *Reading) GetOutput() *Output {
 	return reading.output
 }
 
+// WithOutput sets the output associated with the reading. This is useful for
+// restoring the output of a reading which was stored without it, e.g. a reading
+// loaded from an on-disk cache, since the output is not serialized with it.
+func (reading *Reading) WithOutput(output *Output) *Reading {
+	reading.output = output
+	return reading
+}
+
+// WithTimestamp sets the time at which the reading was taken, formatted with the
+// configured timestamp precision. This is useful for handlers which acquire values
+// before making readings from them, e.g. devices which return a batch of samples
+// in a single read, so each reading has the time its value was acquired rather
+// than the time the reading was made, e.g.
+//
+//    SomeOutput.MakeReading(3).WithTimestamp(sampleTime)
+func (reading *Reading) WithTimestamp(t time.Time) *Reading {
+	reading.Timestamp = utils.FormatTime(t)
+	return reading
+}
+
 // WithContext adds a context to the reading. This is useful when creating a
 // reading from an output and you wish to in-line the setting of the reading
 // context, e.g.
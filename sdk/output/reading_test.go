@@ -18,8 +18,10 @@ package output
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/utils"
 )
 
 func TestReading_GetOutput(t *testing.T) {
@@ -33,6 +35,14 @@ func TestReading_GetOutput(t *testing.T) {
 	assert.Equal(t, o, r.GetOutput())
 }
 
+func TestReading_WithOutput(t *testing.T) {
+	o := &Output{Name: "test"}
+	r := &Reading{}
+
+	assert.Equal(t, r, r.WithOutput(o))
+	assert.Equal(t, o, r.GetOutput())
+}
+
 func TestReading_GetOutput_noOutput(t *testing.T) {
 	r := Reading{}
 	assert.Nil(t, r.GetOutput())
@@ -231,3 +241,22 @@ func TestReading_Encode_error(t *testing.T) {
 		})
 	}
 }
+
+func TestReading_WithTimestamp(t *testing.T) {
+	r := Reading{Timestamp: "2019-03-22T09:48:00Z"}
+	ts := time.Date(2019, 3, 22, 4, 30, 15, 0, time.FixedZone("EST", -5*60*60))
+
+	assert.Equal(t, &r, r.WithTimestamp(ts))
+	assert.Equal(t, "2019-03-22T09:30:15Z", r.Timestamp)
+}
+
+func TestReading_WithTimestamp_precision(t *testing.T) {
+	assert.NoError(t, utils.SetTimestampPrecision(utils.PrecisionNanosecond))
+	defer func() {
+		assert.NoError(t, utils.SetTimestampPrecision(utils.PrecisionSecond))
+	}()
+
+	r := Reading{}
+	r.WithTimestamp(time.Date(2019, 3, 22, 9, 48, 0, 5000, time.UTC))
+	assert.Equal(t, "2019-03-22T09:48:00.000005000Z", r.Timestamp)
+}
@@ -17,6 +17,7 @@
 package sdk
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
@@ -24,6 +25,7 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 
 	log "github.com/sirupsen/logrus"
@@ -32,6 +34,7 @@ import (
 	"github.com/vapor-ware/synse-sdk/v2/sdk/health"
 	"github.com/vapor-ware/synse-sdk/v2/sdk/output"
 	"github.com/vapor-ware/synse-sdk/v2/sdk/policy"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/utils"
 )
 
 const (
@@ -53,6 +56,10 @@ var (
 	defaultPluginConfig = "/etc/synse/plugin/config"
 )
 
+// ErrPluginShuttingDown is returned when attempting to change the plugin's devices
+// once the plugin has started shutting down.
+var ErrPluginShuttingDown = errors.FailedPreconditionErr("plugin is shutting down")
+
 func init() {
 	flag.BoolVar(&flagDebug, "debug", false, "enable debug logging")
 	flag.BoolVar(&flagVersion, "version", false, "print the plugin version information")
@@ -82,6 +89,7 @@ type Plugin struct {
 
 	// Options and handlers
 	pluginHandlers *PluginHandlers
+	readingsCache  ReadingsCache
 
 	// Plugin components
 	scheduler *scheduler
@@ -89,6 +97,23 @@ type Plugin struct {
 	device    *deviceManager
 	server    *server
 	health    *health.Manager
+	events    *eventBus
+
+	// metricsServer serves the plugin's application metrics, if metrics are
+	// enabled. It is set when the plugin is run.
+	metricsServer *http.Server
+
+	// deviceLock serializes changes to the plugin's devices made while it is
+	// running, e.g. device removal and device config reload.
+	deviceLock sync.Mutex
+
+	// Shutdown state. shutdownStarting is closed when shutdown begins and
+	// shutdownDone is closed once it has completed.
+	shutdownOnce     sync.Once
+	shutdownStarted  stateFlag
+	shutdownStarting chan struct{}
+	shutdownDone     chan struct{}
+	shutdownErr      error
 }
 
 // NewPlugin creates a new instance of a Plugin. This should be the only
@@ -125,12 +150,15 @@ func NewPlugin(options ...PluginOption) (*Plugin, error) {
 	// Create the plugin. We create the instance first so a reference to it
 	// is available for subsequent setup actions.
 	p := Plugin{
-		version:        version,
-		info:           &metadata,
-		config:         new(config.Plugin),
-		quit:           make(chan os.Signal),
-		policies:       policy.NewDefaultPolicies(),
-		pluginHandlers: NewDefaultPluginHandlers(),
+		version:          version,
+		info:             &metadata,
+		config:           new(config.Plugin),
+		quit:             make(chan os.Signal),
+		shutdownStarting: make(chan struct{}),
+		shutdownDone:     make(chan struct{}),
+		policies:         policy.NewDefaultPolicies(),
+		pluginHandlers:   NewDefaultPluginHandlers(),
+		events:           newEventBus(),
 	}
 
 	// Set custom options for the plugin.
@@ -164,6 +192,12 @@ func NewPlugin(options ...PluginOption) (*Plugin, error) {
 	}
 	p.id = id
 
+	// Set the precision of the timestamps generated by the plugin.
+	if err := utils.SetTimestampPrecision(p.config.Settings.TimestampPrecision); err != nil {
+		log.WithField("error", err).Error("[plugin] invalid timestamp precision")
+		return nil, err
+	}
+
 	// Initialize the plugin components. The order in which components are initialized
 	// is important, since a dependency chain exists between some components. In particular:
 	// * the state manager requires the device manager.
@@ -171,7 +205,15 @@ func NewPlugin(options ...PluginOption) (*Plugin, error) {
 	// * the server requires the device manager, state manager, scheduler, and health manager
 	p.health = health.NewManager(p.config.Health)
 	p.device = newDeviceManager(&p)
-	p.state = newStateManager(p.config.Settings, p.device)
+	p.state, err = newStateManager(p.config.Settings, p.device)
+	if err != nil {
+		log.WithField("error", err).Error("[plugin] failed to initialize state manager")
+		return nil, err
+	}
+	p.state.events = p.events
+	if p.readingsCache != nil && p.config.Settings.Cache.Enabled {
+		p.state.readingsCache = p.readingsCache
+	}
 	p.scheduler = newScheduler(&p)
 	p.server = newServer(&p)
 
@@ -222,6 +264,33 @@ func (plugin *Plugin) Run() error {
 	return plugin.run()
 }
 
+// Shutdown gracefully terminates the plugin.
+//
+// The plugin stops accepting new writes and waits for any queued writes to
+// complete. Open reading streams are closed, listeners are cancelled via the
+// context passed to context-aware handlers, and the gRPC server is stopped once
+// its pending requests have completed. Post-run actions are executed last.
+//
+// The provided context bounds how long the plugin will wait for pending work to
+// complete. If it expires, any remaining queued writes are failed and the gRPC
+// server is stopped immediately.
+//
+// Shutdown is called automatically when the plugin receives a SIGTERM or SIGINT.
+// Calling it more than once has no additional effect.
+func (plugin *Plugin) Shutdown(ctx context.Context) error {
+	plugin.shutdownOnce.Do(func() {
+		plugin.shutdownStarted.set(true)
+		if plugin.shutdownStarting != nil {
+			close(plugin.shutdownStarting)
+		}
+		plugin.shutdownErr = plugin.shutdown(ctx)
+		if plugin.shutdownDone != nil {
+			close(plugin.shutdownDone)
+		}
+	})
+	return plugin.shutdownErr
+}
+
 // RegisterHealthChecks registers custom health checks with the plugin.
 func (plugin *Plugin) RegisterHealthChecks(checks ...health.Check) error {
 	for _, check := range checks {
@@ -286,6 +355,64 @@ func (plugin *Plugin) AddDevice(device *Device) error {
 	return plugin.device.AddDevice(device)
 }
 
+// RemoveDevice removes the device with the given ID from the plugin.
+//
+// The device is removed from the device manager and its tag and alias lookups,
+// its current readings and read status are dropped, its listener is cancelled,
+// and any of its writes which are still queued are rejected. Reads and writes
+// which are in flight when the device is removed are allowed to complete, but
+// their results are discarded.
+//
+// If no device with the given ID exists, or the plugin is shutting down, an error
+// is returned.
+func (plugin *Plugin) RemoveDevice(id string) error {
+	plugin.deviceLock.Lock()
+	defer plugin.deviceLock.Unlock()
+
+	if plugin.shutdownStarted.isSet() {
+		return ErrPluginShuttingDown
+	}
+
+	device := plugin.device.GetDevice(id)
+	if device == nil {
+		return errors.NotFoundErr("device %s does not exist", id)
+	}
+	plugin.removeDevice(device)
+	return nil
+}
+
+// removeDevice removes a device from the plugin's components. The caller should
+// hold the plugin's device lock.
+func (plugin *Plugin) removeDevice(device *Device) {
+	if _, err := plugin.device.RemoveDevice(device.id); err != nil {
+		log.WithFields(log.Fields{
+			"device": device.id,
+			"error":  err,
+		}).Debug("[plugin] device already removed from device manager")
+	}
+	plugin.scheduler.removeDevice(device)
+	plugin.state.removeDevice(device.id)
+}
+
+// CancelTransaction cancels the write for the transaction with the given ID. If the
+// write is still queued, it is removed from the write queue and never runs. If the
+// write is in progress, the context passed to the handler's WriteCtx function is
+// cancelled. Handlers which only define Write can not be interrupted, so once their
+// write has started it is not cancelled and ErrWriteInProgress is returned; the
+// transaction reports the actual result of the write.
+//
+// A cancelled transaction is terminal. Since the Synse gRPC API has no cancelled
+// write status, it is reported with an ERROR status and a cancellation message.
+//
+// Cancellation is only available to plugin code for now. The Synse gRPC API has no
+// cancel RPC, so the plugin's gRPC server can not expose it until one is added to
+// synse-server-grpc.
+//
+// An error is returned if the transaction does not exist or has already completed.
+func (plugin *Plugin) CancelTransaction(id string) error {
+	return plugin.scheduler.cancelTransaction(id)
+}
+
 // GetDevice gets a device from the plugin's device manager.
 func (plugin *Plugin) GetDevice(id string) *Device {
 	return plugin.device.GetDevice(id)
@@ -333,7 +460,13 @@ func (plugin *Plugin) run() error {
 	// the plugin. This is a blocking function so it must be called in a goroutine.
 	if plugin.config.Metrics.Enabled {
 		log.Debug("[plugin] application metrics enabled")
-		go exposeMetrics()
+		server, err := newMetricsServer(plugin)
+		if err != nil {
+			log.WithField("error", err).Error("[plugin] failed to set up metrics endpoint")
+			return err
+		}
+		plugin.metricsServer = server
+		go exposeMetrics(server)
 	}
 
 	// Start the plugin components. Order matters here.
@@ -345,16 +478,72 @@ func (plugin *Plugin) run() error {
 	plugin.state.Start()
 	plugin.scheduler.Start()
 
+	// Watch for device config changes, if config reload is enabled.
+	if plugin.reloadEnabled() {
+		go plugin.watchDeviceConfig(plugin.shutdownStarting)
+	}
+
+	// Periodically rediscover dynamic devices, if configured.
+	if dynamic := plugin.config.DynamicRegistration; dynamic != nil && dynamic.RediscoveryInterval > 0 {
+		go plugin.runDeviceRediscovery(dynamic.RediscoveryInterval, plugin.shutdownStarting)
+	}
+
 	// Run the gRPC server. This will block while running until the
 	// plugin is terminated.
-	return plugin.server.start()
+	if err := plugin.server.start(); err != nil {
+		return err
+	}
+
+	// If the server was stopped as part of plugin shutdown, wait for shutdown to
+	// complete before returning. Otherwise, the server stopped on its own, so there
+	// is nothing to wait for.
+	if !plugin.shutdownStarted.isSet() {
+		log.Warn("[plugin] gRPC server stopped without plugin shutdown")
+		return nil
+	}
+	<-plugin.shutdownDone
+	return plugin.shutdownErr
+}
+
+// shutdown stops all of the plugin components. Order matters here: writes are
+// drained before streams are closed and the gRPC server is stopped, so pending
+// write requests can resolve.
+func (plugin *Plugin) shutdown(ctx context.Context) error {
+	log.Info("[plugin] shutting down")
+
+	// Wait for any in-progress device changes to complete, and prevent further
+	// changes to devices while shutting down.
+	plugin.deviceLock.Lock()
+	defer plugin.deviceLock.Unlock()
+
+	var multiErr = errors.NewMultiError("Plugin Shutdown")
+
+	if err := plugin.scheduler.Shutdown(ctx); err != nil {
+		log.WithField("error", err).Error("[plugin] failed to gracefully stop scheduler")
+		multiErr.Add(err)
+	}
+	plugin.state.Stop()
+	plugin.server.gracefulStop(ctx)
+
+	if plugin.metricsServer != nil {
+		if err := plugin.metricsServer.Shutdown(ctx); err != nil {
+			log.WithField("error", err).Error("[plugin] failed to gracefully stop metrics endpoint")
+			multiErr.Add(err)
+		}
+	}
+
+	if err := plugin.execPostRun(); err != nil {
+		log.WithField("error", err).Error("[plugin] failed post-run action execution")
+		multiErr.Add(err)
+	}
+	return multiErr.Err()
 }
 
 // onQuitSignal is a function that runs as a goroutine during plugin Run. It
-// listens for a quit signal and will terminate the plugin when such a signal
-// is received.
+// listens for a quit signal and will gracefully shut down the plugin when such
+// a signal is received.
 //
-// Post-run actions are executed here as part of plugin termination.
+// Post-run actions are executed as part of plugin shutdown.
 func (plugin *Plugin) onQuitSignal() {
 	// Register system calls for graceful stopping.
 	signal.Notify(plugin.quit, syscall.SIGTERM)
@@ -366,20 +555,24 @@ func (plugin *Plugin) onQuitSignal() {
 	// is received.
 	sig := <-plugin.quit
 
-	// If we get here, a signal was received, so we can run termination actions.
+	// If we get here, a signal was received, so we can shut down the plugin.
 	log.WithFields(log.Fields{
-		"signal": sig.String(),
+		"signal":  sig.String(),
+		"timeout": plugin.config.Settings.ShutdownTimeout,
 	}).Info("[plugin] terminating plugin")
 
-	if err := plugin.execPostRun(); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), plugin.config.Settings.ShutdownTimeout)
+	defer cancel()
+
+	// Once shutdown completes, the gRPC server will have stopped and Run
+	// will return.
+	if err := plugin.Shutdown(ctx); err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
-		}).Error("[plugin] failed post-run action execution")
-		os.Exit(1)
+		}).Error("[plugin] plugin did not shut down cleanly")
+		return
 	}
-
 	log.Info("[done]")
-	os.Exit(0)
 }
 
 // execPreRun executes the pre-run actions for the plugin.
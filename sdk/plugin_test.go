@@ -17,20 +17,45 @@
 package sdk
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/patrickmn/go-cache"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/vapor-ware/synse-sdk/v2/internal/test"
 	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
 	"github.com/vapor-ware/synse-sdk/v2/sdk/health"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/output"
 	"github.com/vapor-ware/synse-sdk/v2/sdk/policy"
+	synse "github.com/vapor-ware/synse-server-grpc/go"
 )
 
+// newTestPlugin creates a plugin with the components needed to load, change and
+// remove devices. Devices are created with the "temp" handler.
+func newTestPlugin() *Plugin {
+	p := &Plugin{
+		id:             &pluginID{uuid: uuid.NewSHA1(uuid.NameSpaceDNS, []byte("test"))},
+		config:         &config.Plugin{Settings: &config.PluginSettings{}},
+		policies:       policy.NewDefaultPolicies(),
+		pluginHandlers: NewDefaultPluginHandlers(),
+	}
+	p.device = newDeviceManager(p)
+	p.device.handlers["temp"] = &DeviceHandler{Name: "temp"}
+	p.state = newTestStateManager(p.device)
+	p.scheduler = &scheduler{
+		deviceManager: p.device,
+		stateManager:  p.state,
+	}
+	return p
+}
+
 func TestNewPlugin(t *testing.T) {
 	// check that logging gets set to debug
 	flagDebug = true
@@ -116,6 +141,20 @@ func TestNewPlugin_noConfig(t *testing.T) {
 	assert.Nil(t, p)
 }
 
+func TestNewPlugin_badCacheBackend(t *testing.T) {
+	origPath := currentDirConfig
+	metadata = PluginMetadata{Name: "test"}
+	defer func() {
+		currentDirConfig = origPath
+		metadata = PluginMetadata{}
+	}()
+	currentDirConfig = "./testdata/plugin_badcache"
+
+	p, err := NewPlugin()
+	assert.EqualError(t, err, "unsupported readings cache backend: dsik")
+	assert.Nil(t, p)
+}
+
 func TestPlugin_RegisterHealthChecks_noneRegistered(t *testing.T) {
 	p := Plugin{
 		health: health.NewManager(&config.HealthSettings{}),
@@ -495,6 +534,105 @@ func TestPlugin_execPostRun_actionWithError(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestPlugin_Shutdown(t *testing.T) {
+	var postRunCalls int
+	p := Plugin{
+		scheduler: &scheduler{
+			writeChan: make(chan *WriteContext, 1),
+			stop:      make(chan struct{}),
+		},
+		state: &stateManager{
+			streams:    map[uuid.UUID]*ReadStream{},
+			streamLock: &sync.Mutex{},
+			stop:       make(chan struct{}),
+		},
+		server:           &server{},
+		shutdownStarting: make(chan struct{}),
+		shutdownDone:     make(chan struct{}),
+		postRun: []*PluginAction{
+			{
+				Name: "test action",
+				Action: func(p *Plugin) error {
+					postRunCalls++
+					return nil
+				},
+			},
+		},
+	}
+
+	err := p.Shutdown(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, postRunCalls)
+	assert.True(t, p.shutdownStarted.isSet())
+
+	_, isOpen := <-p.shutdownStarting
+	assert.False(t, isOpen)
+	_, isOpen = <-p.shutdownDone
+	assert.False(t, isOpen)
+
+	// Shutting down again should not re-run shutdown.
+	err = p.Shutdown(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, postRunCalls)
+}
+
+func TestPlugin_Shutdown_postRunError(t *testing.T) {
+	p := Plugin{
+		scheduler: &scheduler{
+			writeChan: make(chan *WriteContext, 1),
+			stop:      make(chan struct{}),
+		},
+		state: &stateManager{
+			streams:    map[uuid.UUID]*ReadStream{},
+			streamLock: &sync.Mutex{},
+			stop:       make(chan struct{}),
+		},
+		server: &server{},
+		postRun: []*PluginAction{
+			{
+				Name: "test error action",
+				Action: func(p *Plugin) error {
+					return fmt.Errorf("test error")
+				},
+			},
+		},
+	}
+
+	err := p.Shutdown(context.Background())
+	assert.Error(t, err)
+}
+
+func TestPlugin_Shutdown_metricsServer(t *testing.T) {
+	p := Plugin{
+		scheduler: &scheduler{
+			writeChan: make(chan *WriteContext, 1),
+			stop:      make(chan struct{}),
+		},
+		state: &stateManager{
+			streams:    map[uuid.UUID]*ReadStream{},
+			streamLock: &sync.Mutex{},
+			stop:       make(chan struct{}),
+		},
+		server:        &server{},
+		metricsServer: &http.Server{Addr: "127.0.0.1:0"},
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- p.metricsServer.ListenAndServe()
+	}()
+
+	err := p.Shutdown(context.Background())
+	assert.NoError(t, err)
+
+	select {
+	case err := <-served:
+		assert.Equal(t, http.ErrServerClosed, err)
+	case <-time.After(time.Second):
+		t.Fatal("metrics endpoint still serving after plugin shutdown")
+	}
+}
+
 func TestPlugin_loadConfig_noCfgOptional(t *testing.T) {
 	origPath := currentDirConfig
 	d, closer := test.TempDir(t)
@@ -787,6 +925,81 @@ func TestPlugin_GetDevice(t *testing.T) {
 	assert.Equal(t, "123", device.id)
 }
 
+func TestPlugin_RemoveDevice_notFound(t *testing.T) {
+	p := newTestPlugin()
+
+	err := p.RemoveDevice("123")
+	assert.Error(t, err)
+}
+
+func TestPlugin_RemoveDevice_shuttingDown(t *testing.T) {
+	p := newTestPlugin()
+	device := &Device{id: "123", Type: "temperature", Handler: "temp"}
+	assert.NoError(t, p.device.AddDevice(device))
+	p.shutdownStarted.set(true)
+
+	err := p.RemoveDevice("123")
+	assert.Equal(t, ErrPluginShuttingDown, err)
+	assert.False(t, device.IsRemoved())
+	assert.NotNil(t, p.GetDevice("123"))
+}
+
+func TestPlugin_RemoveDevice(t *testing.T) {
+	handler := &DeviceHandler{
+		Name: "temp",
+		Write: func(device *Device, data *WriteData) error {
+			return nil
+		},
+		ListenCtx: func(ctx context.Context, device *Device, contexts chan *ReadContext) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+	p := newTestPlugin()
+	p.device.handlers["temp"] = handler
+	p.scheduler.writeChan = make(chan *WriteContext, 2)
+
+	device := &Device{
+		id:      "123",
+		Type:    "temperature",
+		Handler: "temp",
+		Alias:   "psu-1",
+		handler: handler,
+	}
+	assert.NoError(t, p.device.AddDevice(device))
+	p.state.readings["123"] = []*output.Reading{{Value: 1}}
+	p.state.readStatuses["123"] = &deviceReadStatus{}
+	p.scheduler.startListener(handler, device)
+
+	txn := newTransaction(time.Second, "")
+	txn.setStatusPending()
+	assert.NoError(t, p.scheduler.enqueue(&WriteContext{transaction: txn, device: device}))
+
+	err := p.RemoveDevice("123")
+	assert.NoError(t, err)
+	assert.True(t, device.IsRemoved())
+
+	// The device is no longer found by ID or alias.
+	assert.Nil(t, p.GetDevice("123"))
+	devices, err := p.device.GetDevices(&synse.V3DeviceSelector{Id: "psu-1"})
+	assert.Error(t, err)
+	assert.Empty(t, devices)
+
+	// Its state, listener, and queued writes are cleaned up.
+	assert.Empty(t, p.state.readings)
+	assert.Empty(t, p.state.readStatuses)
+	assert.Empty(t, p.scheduler.listeners)
+	assert.Empty(t, p.scheduler.writeChan)
+	assert.Equal(t, synse.WriteStatus_ERROR, txn.status)
+
+	// New writes to the removed device are rejected.
+	_, err = p.scheduler.Write(device, []*synse.V3WriteData{{Action: "test"}})
+	assert.Equal(t, ErrDeviceRemoved, err)
+
+	// It can not be removed again.
+	assert.Error(t, p.RemoveDevice("123"))
+}
+
 func TestPlugin_GenerateDeviceID(t *testing.T) {
 	p := Plugin{
 		pluginHandlers: NewDefaultPluginHandlers(),
@@ -804,3 +1017,26 @@ func TestPlugin_GenerateDeviceID(t *testing.T) {
 	devID := p.GenerateDeviceID(&d)
 	assert.Equal(t, "e534b6b2-006e-5f61-93c0-b00ae7535155", devID)
 }
+
+func TestPlugin_CancelTransaction(t *testing.T) {
+	sm := &stateManager{
+		transactions: cache.New(1*time.Minute, 2*time.Minute),
+	}
+	p := Plugin{
+		scheduler: &scheduler{
+			writeChan:    make(chan *WriteContext, 1),
+			stateManager: sm,
+		},
+	}
+
+	txn, err := sm.newTransaction(1*time.Minute, "abc")
+	assert.NoError(t, err)
+	assert.NoError(t, p.scheduler.enqueue(&WriteContext{transaction: txn, device: &Device{id: "123"}}))
+
+	assert.NoError(t, p.CancelTransaction("abc"))
+	assert.True(t, txn.cancelled)
+	assert.Empty(t, p.scheduler.writeChan)
+
+	// The transaction can not be cancelled again once complete.
+	assert.Equal(t, ErrTransactionComplete, p.CancelTransaction("abc"))
+}
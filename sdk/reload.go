@@ -0,0 +1,250 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/errors"
+)
+
+// reloadEnabled checks whether the plugin is configured to reload device config
+// when it changes.
+func (plugin *Plugin) reloadEnabled() bool {
+	settings := plugin.config.Settings
+	return settings != nil && settings.Reload != nil && settings.Reload.Enabled
+}
+
+// reloadDevices reloads the device configuration and applies any changes to the
+// plugin's devices. Devices are matched by ID: devices which are no longer in the
+// config are removed, devices new to the config are added, and devices whose
+// config has changed are replaced.
+//
+// Only devices which were created from device config are affected; dynamically
+// registered devices are left as they are. If the reloaded config is invalid, no
+// changes are made.
+func (plugin *Plugin) reloadDevices() error {
+	plugin.deviceLock.Lock()
+	defer plugin.deviceLock.Unlock()
+
+	// Devices are not changed once the plugin has started shutting down.
+	if plugin.shutdownStarted.isSet() {
+		log.Info("[plugin] not reloading device config, plugin is shutting down")
+		return nil
+	}
+
+	log.Info("[plugin] reloading device config")
+
+	changes, conf, err := plugin.device.loadDeviceChanges(true)
+	if err != nil {
+		log.WithField("error", err).Error("[plugin] failed to reload device config, keeping current devices")
+		return err
+	}
+	plugin.device.setConfig(conf)
+
+	if changes.empty() {
+		log.Info("[plugin] no device changes found on config reload")
+		return nil
+	}
+
+	err = plugin.applyDeviceChanges(changes, plugin.device.addConfiguredDevice)
+
+	log.WithFields(log.Fields{
+		"added":   len(changes.added),
+		"updated": len(changes.updated),
+		"removed": len(changes.removed),
+	}).Info("[plugin] applied device config changes")
+	return err
+}
+
+// applyDeviceChanges applies a set of device changes to the plugin. Removed devices
+// are removed from the plugin, updated devices replace the current device with the
+// same ID, and added devices are added using the provided function. Device setup
+// actions are run for, and the scheduler is updated with, any new device instances.
+//
+// The caller should hold the plugin's device lock.
+func (plugin *Plugin) applyDeviceChanges(changes *deviceChanges, add func(*Device) error) error {
+	var multiErr = errors.NewMultiError("Apply Device Changes")
+
+	for _, device := range changes.removed {
+		plugin.removeDevice(device)
+	}
+
+	var added []*Device
+	for _, device := range changes.updated {
+		if current := plugin.device.GetDevice(device.id); current != nil {
+			plugin.removeDevice(current)
+		}
+		if err := add(device); err != nil {
+			log.WithFields(log.Fields{
+				"device": device.id,
+				"error":  err,
+			}).Error("[plugin] failed to add updated device")
+			multiErr.Add(err)
+			continue
+		}
+		added = append(added, device)
+	}
+	for _, device := range changes.added {
+		if err := add(device); err != nil {
+			log.WithFields(log.Fields{
+				"device": device.id,
+				"error":  err,
+			}).Error("[plugin] failed to add new device")
+			multiErr.Add(err)
+			continue
+		}
+		added = append(added, device)
+	}
+
+	if len(added) > 0 {
+		if err := plugin.device.execDeviceSetupActionsFor(plugin, added); err != nil {
+			multiErr.Add(err)
+		}
+		plugin.scheduler.addDevices(added...)
+	}
+	return multiErr.Err()
+}
+
+// watchDeviceConfig reloads the device config whenever the plugin receives a
+// SIGHUP or, if a watch interval is configured, the device config files change.
+// It runs until the stop channel is closed.
+func (plugin *Plugin) watchDeviceConfig(stop <-chan struct{}) {
+	settings := plugin.config.Settings.Reload
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if settings.Interval > 0 {
+		ticker := time.NewTicker(settings.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	log.WithField("interval", settings.Interval).Info("[plugin] will reload device config on: [SIGHUP, file change]")
+
+	last, err := deviceConfigFingerprint()
+	if err != nil {
+		log.WithField("error", err).Warn("[plugin] unable to check device config files for changes")
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-hup:
+			log.Info("[plugin] received SIGHUP")
+			_ = plugin.reloadDevices()
+			if fp, err := deviceConfigFingerprint(); err == nil {
+				last = fp
+			}
+		case <-tick:
+			fp, err := deviceConfigFingerprint()
+			if err != nil {
+				log.WithField("error", err).Debug("[plugin] unable to check device config files for changes")
+				continue
+			}
+			if fp == last {
+				continue
+			}
+			last = fp
+			log.Info("[plugin] device config files changed")
+			_ = plugin.reloadDevices()
+		}
+	}
+}
+
+// deviceConfigFingerprint gets a summary of the device config files which would
+// be loaded by the device manager, used to detect when they change. The summary
+// includes the path, size, and modification time of each file.
+//
+// This follows the same search as the device config loader: if the device config
+// override environment variable is set, it is used; otherwise, the first search
+// path which contains config files is used.
+func deviceConfigFingerprint() (string, error) {
+	var files []os.FileInfo
+	var dir string
+
+	if override := os.Getenv(DeviceEnvOverride); override != "" {
+		info, err := os.Stat(override)
+		if err != nil {
+			return "", err
+		}
+		if info.IsDir() {
+			dir = override
+			files, err = deviceConfigFiles(override)
+			if err != nil {
+				return "", err
+			}
+		} else {
+			dir = filepath.Dir(override)
+			files = []os.FileInfo{info}
+		}
+	} else {
+		for _, path := range []string{localDeviceConfig, defaultDeviceConfig} {
+			found, err := deviceConfigFiles(path)
+			if err != nil || len(found) == 0 {
+				continue
+			}
+			dir, files = path, found
+			break
+		}
+	}
+
+	parts := make([]string, 0, len(files))
+	for _, f := range files {
+		parts = append(parts, fmt.Sprintf(
+			"%s:%d:%d", filepath.Join(dir, f.Name()), f.Size(), f.ModTime().UnixNano(),
+		))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ";"), nil
+}
+
+// deviceConfigFiles gets the YAML files in the given directory.
+func deviceConfigFiles(dir string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []os.FileInfo
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		switch filepath.Ext(entry.Name()) {
+		case ".yml", ".yaml":
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, info)
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,231 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vapor-ware/synse-sdk/v2/internal/test"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/output"
+)
+
+// writeReloadTestConfig writes a device config file to the given directory with a
+// device instance for each of the given IDs, using the given info.
+func writeReloadTestConfig(t *testing.T, dir string, instances map[int]string) {
+	var ids []int
+	for id := range instances {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var b strings.Builder
+	b.WriteString("version: 3\ndevices:\n  - type: temperature\n    handler: temp\n    instances:\n")
+	for _, id := range ids {
+		fmt.Fprintf(&b, "      - info: %s\n        data:\n          id: %d\n", instances[id], id)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "devices.yml"), []byte(b.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// reloadTestDeviceInfo gets the info of all devices registered with the plugin.
+func reloadTestDeviceInfo(p *Plugin) []string {
+	var info []string
+	for _, d := range p.device.GetAllDevices() {
+		info = append(info, d.Info)
+	}
+	sort.Strings(info)
+	return info
+}
+
+func TestPlugin_reloadDevices(t *testing.T) {
+	dir, closer := test.TempDir(t)
+	defer closer()
+	test.SetEnv(t, DeviceEnvOverride, dir)
+	defer test.RemoveEnv(t, DeviceEnvOverride)
+
+	writeReloadTestConfig(t, dir, map[int]string{1: "a", 2: "b"})
+	p := newTestPlugin()
+	assert.NoError(t, p.device.loadConfig())
+	assert.NoError(t, p.device.createDevices())
+	assert.Equal(t, []string{"a", "b"}, reloadTestDeviceInfo(p))
+
+	var original = map[string]*Device{}
+	for _, d := range p.device.GetAllDevices() {
+		original[d.Info] = d
+		p.state.readings[d.id] = []*output.Reading{{Value: 1}}
+	}
+
+	writeReloadTestConfig(t, dir, map[int]string{1: "changed", 3: "c"})
+	err := p.reloadDevices()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c", "changed"}, reloadTestDeviceInfo(p))
+
+	// The updated device keeps its ID, but is a new instance.
+	updated := p.device.GetDevice(original["a"].id)
+	assert.NotNil(t, updated)
+	assert.Equal(t, "changed", updated.Info)
+	assert.True(t, original["a"].IsRemoved())
+	assert.False(t, updated.IsRemoved())
+
+	// The removed device is gone, along with its state.
+	assert.Nil(t, p.device.GetDevice(original["b"].id))
+	assert.True(t, original["b"].IsRemoved())
+	assert.NotContains(t, p.state.readings, original["b"].id)
+
+	// The device manager holds the reloaded config.
+	assert.Len(t, p.device.config.Devices[0].Instances, 2)
+}
+
+func TestPlugin_reloadDevices_shuttingDown(t *testing.T) {
+	dir, closer := test.TempDir(t)
+	defer closer()
+	test.SetEnv(t, DeviceEnvOverride, dir)
+	defer test.RemoveEnv(t, DeviceEnvOverride)
+
+	writeReloadTestConfig(t, dir, map[int]string{1: "a"})
+	p := newTestPlugin()
+	assert.NoError(t, p.device.loadConfig())
+	assert.NoError(t, p.device.createDevices())
+
+	// Device changes are not applied once shutdown has started.
+	p.shutdownStarted.set(true)
+	writeReloadTestConfig(t, dir, map[int]string{1: "a", 2: "b"})
+	assert.NoError(t, p.reloadDevices())
+	assert.Equal(t, []string{"a"}, reloadTestDeviceInfo(p))
+}
+
+func TestPlugin_reloadDevices_noChanges(t *testing.T) {
+	dir, closer := test.TempDir(t)
+	defer closer()
+	test.SetEnv(t, DeviceEnvOverride, dir)
+	defer test.RemoveEnv(t, DeviceEnvOverride)
+
+	writeReloadTestConfig(t, dir, map[int]string{1: "a"})
+	p := newTestPlugin()
+	assert.NoError(t, p.device.loadConfig())
+	assert.NoError(t, p.device.createDevices())
+	device := p.device.GetAllDevices()[0]
+
+	err := p.reloadDevices()
+	assert.NoError(t, err)
+	assert.Equal(t, device, p.device.GetDevice(device.id))
+	assert.False(t, device.IsRemoved())
+}
+
+func TestPlugin_reloadDevices_invalidConfig(t *testing.T) {
+	dir, closer := test.TempDir(t)
+	defer closer()
+	test.SetEnv(t, DeviceEnvOverride, dir)
+	defer test.RemoveEnv(t, DeviceEnvOverride)
+
+	writeReloadTestConfig(t, dir, map[int]string{1: "a"})
+	p := newTestPlugin()
+	assert.NoError(t, p.device.loadConfig())
+	assert.NoError(t, p.device.createDevices())
+
+	err := ioutil.WriteFile(filepath.Join(dir, "devices.yml"), []byte("version: [\n"), 0600)
+	assert.NoError(t, err)
+
+	// The current devices are kept.
+	err = p.reloadDevices()
+	assert.Error(t, err)
+	assert.Equal(t, []string{"a"}, reloadTestDeviceInfo(p))
+}
+
+func TestPlugin_reloadDevices_keepsDynamicDevices(t *testing.T) {
+	dir, closer := test.TempDir(t)
+	defer closer()
+	test.SetEnv(t, DeviceEnvOverride, dir)
+	defer test.RemoveEnv(t, DeviceEnvOverride)
+
+	writeReloadTestConfig(t, dir, map[int]string{1: "a"})
+	p := newTestPlugin()
+	assert.NoError(t, p.device.loadConfig())
+	assert.NoError(t, p.device.createDevices())
+
+	dynamic := &Device{
+		id:      "dynamic",
+		Info:    "dynamic",
+		Type:    "temperature",
+		Handler: "temp",
+		handler: p.device.handlers["temp"],
+	}
+	assert.NoError(t, p.device.AddDevice(dynamic))
+
+	writeReloadTestConfig(t, dir, map[int]string{2: "b"})
+	err := p.reloadDevices()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "dynamic"}, reloadTestDeviceInfo(p))
+}
+
+func TestDeviceConfigFingerprint(t *testing.T) {
+	dir, closer := test.TempDir(t)
+	defer closer()
+	test.SetEnv(t, DeviceEnvOverride, dir)
+	defer test.RemoveEnv(t, DeviceEnvOverride)
+
+	empty, err := deviceConfigFingerprint()
+	assert.NoError(t, err)
+	assert.Empty(t, empty)
+
+	writeReloadTestConfig(t, dir, map[int]string{1: "a"})
+	first, err := deviceConfigFingerprint()
+	assert.NoError(t, err)
+	assert.NotEqual(t, empty, first)
+
+	// Non-config files are ignored.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("foo"), 0600))
+	fp, err := deviceConfigFingerprint()
+	assert.NoError(t, err)
+	assert.Equal(t, first, fp)
+
+	// Changes to config files change the fingerprint.
+	time.Sleep(10 * time.Millisecond)
+	writeReloadTestConfig(t, dir, map[int]string{1: "a", 2: "b"})
+	second, err := deviceConfigFingerprint()
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, second)
+}
+
+func TestDeviceConfigFingerprint_overrideFile(t *testing.T) {
+	dir, closer := test.TempDir(t)
+	defer closer()
+	writeReloadTestConfig(t, dir, map[int]string{1: "a"})
+	test.SetEnv(t, DeviceEnvOverride, filepath.Join(dir, "devices.yml"))
+	defer test.RemoveEnv(t, DeviceEnvOverride)
+
+	fp, err := deviceConfigFingerprint()
+	assert.NoError(t, err)
+	assert.Contains(t, fp, "devices.yml")
+}
+
+func TestDeviceConfigFingerprint_overrideNotExist(t *testing.T) {
+	test.SetEnv(t, DeviceEnvOverride, "/tmp/synse/does/not/exist")
+	defer test.RemoveEnv(t, DeviceEnvOverride)
+
+	_, err := deviceConfigFingerprint()
+	assert.Error(t, err)
+}
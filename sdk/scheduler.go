@@ -20,7 +20,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -42,6 +46,9 @@ var (
 	ErrDeviceWriteTimeout = errors.New("device write timed out")
 	ErrNilDevice          = errors.New("cannot perform action on nil device")
 	ErrNilData            = errors.New("cannot write nil data to device")
+	ErrSchedulerShutdown  = errors.New("scheduler is shutting down, not accepting writes")
+	ErrDeviceRemoved      = errors.New("device has been removed from the plugin")
+	ErrWriteCancelled     = errors.New("write cancelled")
 )
 
 // ListenerCtx is the context needed for a listener function to be called
@@ -54,8 +61,66 @@ type ListenerCtx struct {
 	// device is the Device that is being listened to via the listener.
 	device *Device
 
-	// restarts is the number of times the listener has been restarted.
+	// restarts is the number of times in succession the listener has been
+	// restarted. It is reset once the listener runs long enough before failing
+	// to be considered healthy.
 	restarts int
+
+	// lastErr is the error returned by the most recent failed listener run.
+	lastErr error
+
+	// state is the current state of the listener: running, restarting (waiting
+	// to be restarted after a failure), stopped, or failed (given up on).
+	state string
+
+	// ctx is the context passed to context-aware listeners. It is cancelled
+	// when the listener's device is removed. If nil, the scheduler's context
+	// is used.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lock sync.Mutex
+}
+
+// Listener states.
+const (
+	listenerRunning    = "running"
+	listenerRestarting = "restarting"
+	listenerStopped    = "stopped"
+	listenerFailed     = "failed"
+)
+
+// setState sets the state of the listener.
+func (ctx *ListenerCtx) setState(state string) {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+
+	ctx.state = state
+}
+
+// failed records a failed listener run, returning the updated restart count. If
+// the run was healthy, i.e. the listener had been running for a while before it
+// failed, the failure is not in succession with earlier failures, so the restart
+// count starts again from the beginning.
+func (ctx *ListenerCtx) failed(err error, healthy bool) int {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+
+	if healthy {
+		ctx.restarts = 0
+	}
+	ctx.restarts++
+	ctx.lastErr = err
+	ctx.state = listenerRestarting
+	return ctx.restarts
+}
+
+// status gets a summary of the listener's state, restart count, and last error.
+func (ctx *ListenerCtx) status() (state string, restarts int, lastErr error) {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+
+	return ctx.state, ctx.restarts, ctx.lastErr
 }
 
 // NewListenerCtx creates a new ListenerCtx for the given handler and device.
@@ -84,19 +149,89 @@ type scheduler struct {
 	// limiter is a rate limiter for making requests.
 	limiter *rate.Limiter
 
+	// concurrency limits concurrent reads and writes for groups of devices,
+	// such as devices which share a bus. If nil, operations are not limited.
+	concurrency *concurrencyGroups
+
 	// writeChan is the channel that is used to queue write actions for
-	// devices.
+	// devices. It holds normal priority writes.
 	writeChan chan *WriteContext
 
+	// writeQueues holds the queues for writes with priorities other than
+	// normal priority. writeSkips counts the number of times each priority's
+	// queued writes have been passed over for higher priority writes. It is
+	// only used by the write loop.
+	writeQueues map[WritePriority]chan *WriteContext
+	writeSkips  map[WritePriority]int
+
+	// coalesced holds the transaction of the latest queued write for each device
+	// and action, for handlers which coalesce writes. It is keyed by coalesceKey.
+	coalesced    map[string]*transaction
+	coalesceLock sync.Mutex
+
 	// stop is a channel used to signal that the scheduler should stop.
 	// This is generally used for graceful shutdown.
 	stop chan struct{}
 
+	// ctx is the context passed to context-aware device handler functions.
+	// It is cancelled when the scheduler is stopped so in-flight handler
+	// calls (e.g. listeners) know to terminate.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// writesDone is closed when the write loop terminates. It is used on
+	// shutdown to wait for in-flight writes to complete.
+	writesDone chan struct{}
+
+	// queueLock guards against queueing writes once the scheduler has started
+	// shutting down. Once draining is set, new writes are rejected.
+	queueLock sync.RWMutex
+	draining  bool
+
+	// listeners holds the contexts for all listeners started by the scheduler.
+	listeners    []*ListenerCtx
+	listenerLock sync.Mutex
+
+	// breakers holds the read circuit breakers for devices, keyed by device ID.
+	// Breakers are only used if enabled in the read configuration.
+	breakers    map[string]*circuitBreaker
+	breakerLock sync.Mutex
+
+	// readLoops holds the intervals of the running read loops. Read loops may
+	// be started after reads are scheduled if devices with a new read interval
+	// are added.
+	readLoops    map[time.Duration]struct{}
+	readLoopLock sync.Mutex
+	readLoopWG   sync.WaitGroup
+
+	// events is the bus which read failure and listener events are published to.
+	events *eventBus
+
 	// Flag to check what state the scheduler is in. This is generally
 	// used for debug/testing.
-	isReading   bool
-	isWriting   bool
-	isListening bool
+	isReading   stateFlag
+	isWriting   stateFlag
+	isListening stateFlag
+}
+
+// stateFlag is a boolean flag which can be set and checked from multiple
+// goroutines. Its zero value is unset.
+type stateFlag struct {
+	value int32
+}
+
+// set sets the value of the flag.
+func (flag *stateFlag) set(value bool) {
+	var v int32
+	if value {
+		v = 1
+	}
+	atomic.StoreInt32(&flag.value, v)
+}
+
+// isSet checks whether the flag is set.
+func (flag *stateFlag) isSet() bool {
+	return atomic.LoadInt32(&flag.value) == 1
 }
 
 // newScheduler creates a new instance of the plugin's scheduler component.
@@ -121,14 +256,22 @@ func newScheduler(plugin *Plugin) *scheduler {
 		}
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &scheduler{
 		deviceManager: plugin.device,
 		stateManager:  plugin.state,
 		config:        conf,
 		limiter:       limiter,
+		concurrency:   newConcurrencyGroups(conf.Concurrency),
 		serialLock:    &sync.Mutex{},
 		writeChan:     make(chan *WriteContext, conf.Write.QueueSize),
+		writeQueues:   newWriteQueues(conf.Write.QueueSize),
 		stop:          make(chan struct{}),
+		ctx:           ctx,
+		cancel:        cancel,
+		writesDone:    make(chan struct{}),
+		events:        plugin.events,
 	}
 }
 
@@ -156,20 +299,174 @@ func (scheduler *scheduler) registerActions(plugin *Plugin) {
 // the plugin.
 func (scheduler *scheduler) healthChecks(plugin *Plugin) error {
 	wqh := health.NewPeriodicHealthCheck("write queue health", 30*time.Second, func() error {
-		// Determine the percent usage of the write queue.
-		pctUsage := (float64(len(scheduler.writeChan)) / float64(cap(scheduler.writeChan))) * 100
-
-		// If the write queue is at 95% usage, we consider it unhealthy; the write
-		// queue should be configured to be larger.
-		if pctUsage > 95 {
-			return fmt.Errorf("write queue usage >95%%, consider increasing size in configuration")
+		for _, q := range scheduler.priorityQueues() {
+			// Determine the percent usage of the write queue.
+			pctUsage := (float64(len(q.queue)) / float64(cap(q.queue))) * 100
+
+			// If any priority's write queue is at 95% usage, we consider it unhealthy;
+			// the write queue should be configured to be larger.
+			if pctUsage > 95 {
+				return fmt.Errorf(
+					"%s priority write queue usage >95%%, consider increasing size in configuration (queue depths: %s)",
+					q.priority, scheduler.writeQueueDepths(),
+				)
+			}
 		}
 		return nil
 	})
 	plugin.health.RegisterDefault(wqh)
+
+	if scheduler.config != nil && scheduler.config.Listen != nil && !scheduler.config.Listen.Disable {
+		scheduler.registerListenerHealthCheck(plugin)
+	}
+
+	if scheduler.breakersEnabled() {
+		scheduler.registerBreakerHealthCheck(plugin)
+	}
+	return nil
+}
+
+// registerListenerHealthCheck registers a health check which reports the restart
+// count and last error of any listener that is not running.
+func (scheduler *scheduler) registerListenerHealthCheck(plugin *Plugin) {
+	lh := health.NewPeriodicHealthCheck("device listeners", 30*time.Second, scheduler.checkListeners)
+	plugin.health.RegisterDefault(lh)
+}
+
+// checkListeners checks the health of the scheduler's listeners. If any listener has
+// failed and is waiting to be restarted, or has been given up on, we consider it
+// unhealthy.
+func (scheduler *scheduler) checkListeners() error {
+	var unhealthy []string
+	for _, ctx := range scheduler.getListeners() {
+		state, restarts, lastErr := ctx.status()
+		if state == listenerRestarting || state == listenerFailed {
+			unhealthy = append(unhealthy, fmt.Sprintf(
+				"%s (handler: %s, state: %s, restarts: %d, last error: %v)",
+				ctx.device.id, ctx.handler.Name, state, restarts, lastErr,
+			))
+		}
+	}
+	if len(unhealthy) > 0 {
+		sort.Strings(unhealthy)
+		return fmt.Errorf("device listeners not running: %s", strings.Join(unhealthy, "; "))
+	}
 	return nil
 }
 
+// registerBreakerHealthCheck registers a health check which reports any devices
+// whose read circuit breaker is not closed.
+func (scheduler *scheduler) registerBreakerHealthCheck(plugin *Plugin) {
+	rbh := health.NewPeriodicHealthCheck("device read circuit breakers", 30*time.Second, func() error {
+		// If any device's breaker is not closed, the device is not being read
+		// normally, so we consider it unhealthy.
+		var unhealthy []string
+		for id, state := range scheduler.breakerStates() {
+			if state != breakerClosed {
+				unhealthy = append(unhealthy, fmt.Sprintf("%s (%s)", id, state))
+			}
+		}
+		if len(unhealthy) > 0 {
+			sort.Strings(unhealthy)
+			return fmt.Errorf("device read circuit breakers not closed: %s", strings.Join(unhealthy, ", "))
+		}
+		return nil
+	})
+	plugin.health.RegisterDefault(rbh)
+}
+
+// getListeners gets a copy of the contexts of all listeners started by the scheduler.
+func (scheduler *scheduler) getListeners() []*ListenerCtx {
+	scheduler.listenerLock.Lock()
+	defer scheduler.listenerLock.Unlock()
+
+	listeners := make([]*ListenerCtx, len(scheduler.listeners))
+	copy(listeners, scheduler.listeners)
+	return listeners
+}
+
+// breakersEnabled checks whether device read circuit breakers are enabled.
+func (scheduler *scheduler) breakersEnabled() bool {
+	return scheduler.config != nil &&
+		scheduler.config.Read != nil &&
+		scheduler.config.Read.Breaker != nil &&
+		scheduler.config.Read.Breaker.Enabled
+}
+
+// getBreaker gets the read circuit breaker for the given device, creating it if
+// it does not yet exist. If circuit breakers are not enabled, nil is returned.
+func (scheduler *scheduler) getBreaker(device *Device) *circuitBreaker {
+	if !scheduler.breakersEnabled() {
+		return nil
+	}
+
+	scheduler.breakerLock.Lock()
+	defer scheduler.breakerLock.Unlock()
+
+	if scheduler.breakers == nil {
+		scheduler.breakers = make(map[string]*circuitBreaker)
+	}
+	breaker, exists := scheduler.breakers[device.id]
+	if !exists {
+		breaker = newCircuitBreaker(scheduler.config.Read.Breaker)
+		scheduler.breakers[device.id] = breaker
+	}
+	return breaker
+}
+
+// breakerStates gets the current state of the read circuit breaker for each
+// device which has one.
+func (scheduler *scheduler) breakerStates() map[string]string {
+	scheduler.breakerLock.Lock()
+	defer scheduler.breakerLock.Unlock()
+
+	states := make(map[string]string, len(scheduler.breakers))
+	for id, breaker := range scheduler.breakers {
+		states[id] = breaker.getState()
+	}
+	return states
+}
+
+// allowRead checks whether the given device's circuit breaker allows it to be read.
+func (scheduler *scheduler) allowRead(device *Device) bool {
+	breaker := scheduler.getBreaker(device)
+	return breaker == nil || breaker.allow(time.Now())
+}
+
+// readSucceeded records a successful read of the given device.
+func (scheduler *scheduler) readSucceeded(device *Device) {
+	if device.IsRemoved() {
+		return
+	}
+	if breaker := scheduler.getBreaker(device); breaker != nil {
+		if breaker.getState() != breakerClosed {
+			log.WithField("device", device.id).Info("[scheduler] device read recovered, closing circuit breaker")
+		}
+		breaker.success()
+	}
+}
+
+// readFailed records a failed read of the given device.
+func (scheduler *scheduler) readFailed(device *Device, err error) {
+	if device.IsRemoved() {
+		return
+	}
+	scheduler.stateManager.recordReadFailure(device.id, err)
+	if device.handler != nil {
+		readErrorsMetric.WithLabelValues(device.handler.Name).Inc()
+	}
+	scheduler.events.publish(Event{Type: EventReadFailed, Device: device, Err: err})
+
+	if breaker := scheduler.getBreaker(device); breaker != nil {
+		if breaker.failure(time.Now()) {
+			log.WithFields(log.Fields{
+				"device": device.id,
+				"error":  err,
+			}).Warn("[scheduler] opening circuit breaker for failing device, suspending reads")
+		}
+	}
+}
+
 // Start starts the scheduler.
 func (scheduler *scheduler) Start() {
 	log.Info("[scheduler] starting")
@@ -180,10 +477,331 @@ func (scheduler *scheduler) Start() {
 }
 
 // Stop the scheduler.
+//
+// This signals the read, write, and listen loops to terminate and cancels the
+// context passed to context-aware handlers. It is safe to call more than once.
 func (scheduler *scheduler) Stop() error {
 	log.Info("[scheduler] stopping")
 
-	close(scheduler.stop)
+	select {
+	case <-scheduler.stop:
+		// already stopped
+	default:
+		close(scheduler.stop)
+	}
+	if scheduler.cancel != nil {
+		scheduler.cancel()
+	}
+	return nil
+}
+
+// Shutdown gracefully stops the scheduler.
+//
+// New writes are rejected once shutdown begins. Any writes which are already
+// queued are given until the context expires to complete. If the context expires
+// before the write queue is drained, the remaining queued writes are not executed
+// and their transactions are set to an error state. Once the writes are resolved,
+// the scheduler is stopped, which cancels any running listeners.
+func (scheduler *scheduler) Shutdown(ctx context.Context) error {
+	log.Info("[scheduler] shutting down")
+
+	// Stop accepting new writes.
+	scheduler.queueLock.Lock()
+	scheduler.draining = true
+	scheduler.queueLock.Unlock()
+
+	var err error
+
+	// Wait for the write loop to work through the queued writes. If writing is not
+	// running, there is nothing which will process the queue, so don't wait.
+	if scheduler.isWriting.isSet() {
+		ticker := time.NewTicker(50 * time.Millisecond)
+	drain:
+		for scheduler.queuedWrites() > 0 {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+				break drain
+			case <-ticker.C:
+			}
+		}
+		ticker.Stop()
+	}
+
+	// Signal the read/write/listen loops to stop. The write loop will finish its
+	// current batch before terminating, so wait for it to exit.
+	select {
+	case <-scheduler.stop:
+	default:
+		close(scheduler.stop)
+	}
+	if scheduler.isWriting.isSet() && scheduler.writesDone != nil {
+		select {
+		case <-scheduler.writesDone:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	// Cancel the handler context. Any writes still in-flight at this point will
+	// be failed and listeners will be signaled to terminate.
+	if stopErr := scheduler.Stop(); stopErr != nil {
+		return stopErr
+	}
+
+	// Fail any writes which remain in the queue; they will not be executed.
+	for _, w := range scheduler.removeQueuedWrites(func(*WriteContext) bool { return true }) {
+		log.WithFields(log.Fields{
+			"transaction": w.transaction.id,
+			"device":      w.device.GetID(),
+		}).Warn("[scheduler] failing queued write on shutdown")
+		w.transaction.message = "write not executed: plugin shutting down"
+		w.transaction.setStatusError()
+	}
+	if err != nil {
+		log.WithField("error", err).Warn("[scheduler] shutdown did not complete gracefully")
+	}
+	return err
+}
+
+// runContext gets the context used for context-aware handler functions. If the
+// scheduler was not created with a context, a background context is used.
+func (scheduler *scheduler) runContext() context.Context {
+	if scheduler.ctx == nil {
+		return context.Background()
+	}
+	return scheduler.ctx
+}
+
+// readContext returns a context to pass to device read handlers. The context is
+// cancelled on shutdown and, if a read interval is given, expires after that
+// interval so a stalled read does not hold up subsequent read cycles.
+func (scheduler *scheduler) readContext(interval time.Duration) (context.Context, context.CancelFunc) {
+	if interval > 0 {
+		return context.WithTimeout(scheduler.runContext(), interval)
+	}
+	return context.WithCancel(scheduler.runContext())
+}
+
+// enqueueRetryInterval is the interval at which a write waiting for room in a
+// full write queue retries queueing.
+const enqueueRetryInterval = 10 * time.Millisecond
+
+// enqueue adds a write to the scheduler's write queue. If the scheduler is shutting
+// down, or the device has been removed, the write is not queued and its transaction
+// is set to an error state.
+//
+// If the write queue is full, enqueue blocks until there is room for the write. The
+// queue lock is not held while waiting, so a full queue does not hold up shutdown
+// or the cancellation of queued writes.
+func (scheduler *scheduler) enqueue(w *WriteContext) error {
+	for first := true; ; first = false {
+		done, err := scheduler.tryEnqueue(w, first)
+		if done {
+			return err
+		}
+
+		select {
+		case <-scheduler.stop:
+			return scheduler.rejectWrite(w, ErrSchedulerShutdown)
+		case <-time.After(enqueueRetryInterval):
+		}
+	}
+}
+
+// tryEnqueue adds a write to the scheduler's write queue without blocking. It returns
+// false if the write queue is full, in which case the write should be retried. Once
+// the write has been queued, rejected, or superseded, true is returned.
+//
+// On the first attempt, the write is registered for coalescing. A write which is
+// waiting for room in the queue may be superseded by a later write for the same
+// device and action, in which case its transaction is complete and it is not queued.
+func (scheduler *scheduler) tryEnqueue(w *WriteContext, first bool) (bool, error) {
+	scheduler.queueLock.RLock()
+	defer scheduler.queueLock.RUnlock()
+
+	if w.transaction.isSuperseded() {
+		return true, nil
+	}
+	if scheduler.draining {
+		return true, scheduler.rejectWrite(w, ErrSchedulerShutdown)
+	}
+	if w.device.IsRemoved() {
+		return true, scheduler.rejectWrite(w, ErrDeviceRemoved)
+	}
+
+	// The write is registered for coalescing before it is queued, since the write
+	// loop may take it from the queue as soon as it is sent.
+	if first {
+		scheduler.coalesce(w)
+	}
+	select {
+	case scheduler.writeQueue(w.priority) <- w:
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// rejectWrite sets the transaction of a write which could not be queued to an error
+// state and returns the error. The write is claimed first; if it was superseded by a
+// later write, its transaction is already complete, so nil is returned.
+func (scheduler *scheduler) rejectWrite(w *WriteContext, err error) error {
+	if !w.transaction.claim() {
+		return nil
+	}
+	scheduler.clearCoalesced(w)
+	w.transaction.message = err.Error()
+	w.transaction.setStatusError()
+	return err
+}
+
+// coalesceKey gets the key used to identify writes which may be coalesced: writes
+// for the same device and action. If the write's handler does not coalesce writes,
+// an empty string is returned.
+func coalesceKey(w *WriteContext) string {
+	if w.device == nil || w.data == nil || w.device.handler == nil || !w.device.handler.CoalesceWrites {
+		return ""
+	}
+	return w.device.id + "/" + w.data.Action
+}
+
+// coalesce supersedes any write for the same device and action which has not yet
+// been claimed with the given, newly submitted, write, if the device's handler
+// coalesces writes. The superseded write is not executed; its transaction is marked
+// done with a message noting that it was skipped.
+func (scheduler *scheduler) coalesce(w *WriteContext) {
+	key := coalesceKey(w)
+	if key == "" {
+		return
+	}
+
+	scheduler.coalesceLock.Lock()
+	if scheduler.coalesced == nil {
+		scheduler.coalesced = make(map[string]*transaction)
+	}
+	prev := scheduler.coalesced[key]
+	scheduler.coalesced[key] = w.transaction
+	scheduler.coalesceLock.Unlock()
+
+	// If the previous write has already been claimed, it is being executed (or
+	// was failed), so it can not be superseded.
+	if prev == nil || prev == w.transaction || !prev.supersede() {
+		return
+	}
+	log.WithFields(log.Fields{
+		"transaction": prev.id,
+		"superseded":  w.transaction.id,
+		"device":      w.device.id,
+		"action":      w.data.Action,
+	}).Debug("[scheduler] coalesced queued write")
+	prev.message = fmt.Sprintf("write skipped: superseded by transaction %s", w.transaction.id)
+	prev.setStatusDone()
+}
+
+// clearCoalesced removes the write from the latest coalesced writes once it is no
+// longer queued.
+func (scheduler *scheduler) clearCoalesced(w *WriteContext) {
+	key := coalesceKey(w)
+	if key == "" {
+		return
+	}
+
+	scheduler.coalesceLock.Lock()
+	defer scheduler.coalesceLock.Unlock()
+	if scheduler.coalesced[key] == w.transaction {
+		delete(scheduler.coalesced, key)
+	}
+}
+
+// rejectQueuedWrites removes any queued writes for the device with the given ID
+// from the write queue and sets their transactions to an error state. Queued
+// writes for other devices are kept, in order.
+func (scheduler *scheduler) rejectQueuedWrites(id string) {
+	removed := scheduler.removeQueuedWrites(func(w *WriteContext) bool {
+		return w.device.id == id
+	})
+	for _, w := range removed {
+		log.WithFields(log.Fields{
+			"transaction": w.transaction.id,
+			"device":      id,
+		}).Warn("[scheduler] rejecting queued write for removed device")
+		w.transaction.message = ErrDeviceRemoved.Error()
+		w.transaction.setStatusError()
+	}
+}
+
+// removeQueuedWrites removes the queued writes which match the given function from
+// the write queues and returns them, highest priority first. All other queued writes
+// are kept, in order. Matching writes which were superseded are dropped from the
+// queue but not returned, since their transactions are already complete.
+func (scheduler *scheduler) removeQueuedWrites(match func(*WriteContext) bool) []*WriteContext {
+	// Hold the queue lock so no writes are queued while the queue is being
+	// filtered. The write loop may still take writes from the queue concurrently.
+	scheduler.queueLock.Lock()
+	defer scheduler.queueLock.Unlock()
+
+	var removed []*WriteContext
+	for _, q := range scheduler.priorityQueues() {
+		var keep []*WriteContext
+		for n := len(q.queue); n > 0; n-- {
+			w := takeWrite(q.queue)
+			if w == nil {
+				break
+			}
+			if match(w) {
+				if w.transaction.claim() {
+					scheduler.clearCoalesced(w)
+					removed = append(removed, w)
+				}
+			} else {
+				keep = append(keep, w)
+			}
+		}
+
+		// Re-queue the kept writes. Since no writes could have been queued in the
+		// meantime, there is room for them.
+		for _, w := range keep {
+			q.queue <- w
+		}
+	}
+	return removed
+}
+
+// cancelTransaction cancels the write for the transaction with the given ID. If
+// the write is still queued, it is removed from the queue and will not run. If
+// the write is in progress, its context is cancelled and the transaction is set to
+// the cancelled state once the handler abandons the write. Only handlers which
+// define WriteCtx can be interrupted; for handlers which only define Write, an
+// in-progress write is left to complete and ErrWriteInProgress is returned.
+//
+// An error is returned if the transaction does not exist or has already completed.
+func (scheduler *scheduler) cancelTransaction(id string) error {
+	t := scheduler.stateManager.getTransaction(id)
+	if t == nil {
+		return ErrTransactionNotFound
+	}
+	if t.isDone() {
+		return ErrTransactionComplete
+	}
+
+	removed := scheduler.removeQueuedWrites(func(w *WriteContext) bool {
+		return w.transaction == t
+	})
+	if len(removed) > 0 {
+		log.WithField("transaction", id).Info("[scheduler] cancelled queued write")
+		t.message = ErrWriteCancelled.Error()
+		t.setStatusCancelled()
+		return nil
+	}
+
+	// The write is no longer queued, so it has been taken by the write loop. The
+	// write finalizes the transaction once it sees the cancellation.
+	if err := t.requestCancel(); err != nil {
+		log.WithField("transaction", id).Info("[scheduler] unable to cancel in-progress write")
+		return err
+	}
+	log.WithField("transaction", id).Info("[scheduler] cancelling in-progress write")
 	return nil
 }
 
@@ -198,19 +816,27 @@ func (scheduler *scheduler) Write(device *Device, data []*synse.V3WriteData) ([]
 	if !device.IsWritable() {
 		return nil, ErrDeviceNotWritable
 	}
+	if device.IsRemoved() {
+		return nil, ErrDeviceRemoved
+	}
 
 	var response []*synse.V3WriteTransaction
+	var txns []*transaction
 	for _, writeData := range data {
 		t, err := scheduler.stateManager.newTransaction(device.WriteTimeout, writeData.Transaction)
 		if err != nil {
+			scheduler.cancelWrites(txns)
 			return nil, err
 		}
 		t.context = writeData
+		t.device = device
 		t.setStatusPending()
 
+		priority := device.handler.writePriority(device, writeData)
 		log.WithFields(log.Fields{
 			"device":      device.id,
 			"transaction": t.id,
+			"priority":    priority,
 		}).Debug("[scheduler] queuing device write")
 
 		// Map the transaction ID to the write context for the response.
@@ -222,11 +848,16 @@ func (scheduler *scheduler) Write(device *Device, data []*synse.V3WriteData) ([]
 		})
 
 		// Queue up the write.
-		scheduler.writeChan <- &WriteContext{
+		if err := scheduler.enqueue(&WriteContext{
 			transaction: t,
 			device:      device,
 			data:        writeData,
+			priority:    priority,
+		}); err != nil {
+			scheduler.cancelWrites(txns)
+			return nil, err
 		}
+		txns = append(txns, t)
 	}
 	return response, nil
 }
@@ -241,6 +872,9 @@ func (scheduler *scheduler) WriteAndWait(device *Device, data []*synse.V3WriteDa
 	if !device.IsWritable() {
 		return nil, ErrDeviceNotWritable
 	}
+	if device.IsRemoved() {
+		return nil, ErrDeviceRemoved
+	}
 
 	var response []*synse.V3TransactionStatus
 	var txns []*transaction
@@ -249,24 +883,31 @@ func (scheduler *scheduler) WriteAndWait(device *Device, data []*synse.V3WriteDa
 	for _, writeData := range data {
 		t, err := scheduler.stateManager.newTransaction(device.WriteTimeout, writeData.Transaction)
 		if err != nil {
+			scheduler.cancelWrites(txns)
 			return nil, err
 		}
 		t.context = writeData
+		t.device = device
 		t.setStatusPending()
 
+		priority := device.handler.writePriority(device, writeData)
 		log.WithFields(log.Fields{
 			"device":      device.id,
 			"transaction": t.id,
+			"priority":    priority,
 		}).Debug("[scheduler] queuing device write")
 
-		txns = append(txns, t)
-
 		// Queue up the write.
-		scheduler.writeChan <- &WriteContext{
+		if err := scheduler.enqueue(&WriteContext{
 			transaction: t,
 			device:      device,
 			data:        writeData,
+			priority:    priority,
+		}); err != nil {
+			scheduler.cancelWrites(txns)
+			return nil, err
 		}
+		txns = append(txns, t)
 
 		waitGroup.Add(1)
 		go func(t *transaction, wg *sync.WaitGroup) {
@@ -283,6 +924,21 @@ func (scheduler *scheduler) WriteAndWait(device *Device, data []*synse.V3WriteDa
 	return response, nil
 }
 
+// cancelWrites cancels the writes for the given transactions. It is used when a
+// batch of writes fails part way through: the caller only gets the error, not the
+// transactions for the writes which were already queued, so those writes are
+// cancelled rather than left to run untracked.
+func (scheduler *scheduler) cancelWrites(txns []*transaction) {
+	for _, t := range txns {
+		if err := scheduler.cancelTransaction(t.id); err != nil {
+			log.WithFields(log.Fields{
+				"transaction": t.id,
+				"error":       err,
+			}).Debug("[scheduler] unable to cancel write from failed batch")
+		}
+	}
+}
+
 // scheduleReads schedules device reads based on the plugin configuration.
 //
 // This will do nothing if:
@@ -309,13 +965,31 @@ func (scheduler *scheduler) scheduleReads() {
 		"mode":     mode,
 	}).Info("[scheduler] starting read scheduling")
 
-	scheduler.isReading = true
+	// Devices and handlers may define their own read intervals. Each distinct
+	// interval gets its own read loop so that slow-to-read devices do not hold
+	// up devices which need to be read more frequently.
+	intervals := scheduler.readIntervals()
+	log.WithField("intervals", intervals).Debug("[scheduler] starting read loops")
+
+	scheduler.isReading.set(true)
+	for _, i := range intervals {
+		scheduler.startReadLoop(i)
+	}
+	scheduler.readLoopWG.Wait()
+
+	scheduler.isReading.set(false)
+	log.Info("[scheduler] stop channel closed, terminating scheduleReads")
+}
+
+// readLoop continuously reads all devices and bulk read handlers whose read interval
+// matches the given interval, waiting for the interval between each read cycle. It
+// returns once the scheduler is stopped.
+func (scheduler *scheduler) readLoop(interval time.Duration) {
 	for {
 		// If the stop channel is closed, stop the read loop.
 		select {
 		case <-scheduler.stop:
-			scheduler.isReading = false
-			log.Info("[scheduler] stop channel closed, terminating scheduleReads")
+			log.WithField("interval", interval).Debug("[scheduler] terminating read loop")
 			return
 		default:
 			// no stop signal
@@ -323,8 +997,13 @@ func (scheduler *scheduler) scheduleReads() {
 
 		var waitGroup sync.WaitGroup
 
-		// Run all single device reads.
-		for _, device := range scheduler.deviceManager.devices {
+		// Run all single device reads. The devices are fetched each cycle, as
+		// devices may be added or removed while the plugin is running.
+		for _, device := range scheduler.deviceManager.GetAllDevices() {
+			if device.handler.CanBulkRead() || scheduler.deviceReadInterval(device) != interval {
+				continue
+			}
+
 			// Increment the WaitGroup counter for each device.
 			waitGroup.Add(1)
 
@@ -337,6 +1016,10 @@ func (scheduler *scheduler) scheduleReads() {
 
 		// Run all batch device reads.
 		for _, handler := range scheduler.deviceManager.handlers {
+			if !handler.CanBulkRead() || scheduler.handlerReadInterval(handler) != interval {
+				continue
+			}
+
 			// Increment the WaitGroup for each bulk read action.
 			waitGroup.Add(1)
 
@@ -351,17 +1034,100 @@ func (scheduler *scheduler) scheduleReads() {
 		waitGroup.Wait()
 
 		if interval != 0 {
-			time.Sleep(interval)
+			select {
+			case <-scheduler.stop:
+			case <-time.After(interval):
+			}
 		}
 	}
 }
 
+// startReadLoop starts a read loop for the given interval, if one is not already
+// running. No read loop is started once the scheduler has been stopped.
+func (scheduler *scheduler) startReadLoop(interval time.Duration) {
+	scheduler.readLoopLock.Lock()
+	defer scheduler.readLoopLock.Unlock()
+
+	select {
+	case <-scheduler.stop:
+		return
+	default:
+	}
+
+	if scheduler.readLoops == nil {
+		scheduler.readLoops = make(map[time.Duration]struct{})
+	}
+	if _, running := scheduler.readLoops[interval]; running {
+		return
+	}
+	scheduler.readLoops[interval] = struct{}{}
+
+	scheduler.readLoopWG.Add(1)
+	go func() {
+		defer scheduler.readLoopWG.Done()
+		scheduler.readLoop(interval)
+	}()
+}
+
+// readIntervals gets the sorted set of distinct read intervals used by the devices
+// and bulk read handlers registered with the plugin. The global read interval is
+// always included.
+func (scheduler *scheduler) readIntervals() []time.Duration {
+	seen := map[time.Duration]struct{}{
+		scheduler.config.Read.Interval: {},
+	}
+	for _, device := range scheduler.deviceManager.GetAllDevices() {
+		if !device.handler.CanBulkRead() {
+			seen[scheduler.deviceReadInterval(device)] = struct{}{}
+		}
+	}
+	for _, handler := range scheduler.deviceManager.handlers {
+		if handler.CanBulkRead() {
+			seen[scheduler.handlerReadInterval(handler)] = struct{}{}
+		}
+	}
+
+	var intervals []time.Duration
+	for interval := range seen {
+		intervals = append(intervals, interval)
+	}
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i] < intervals[j]
+	})
+	return intervals
+}
+
+// deviceReadInterval gets the interval at which the given device should be read.
+// A device-specific interval takes precedence over a handler-specific interval,
+// which takes precedence over the plugin's global read interval.
+func (scheduler *scheduler) deviceReadInterval(device *Device) time.Duration {
+	if device.ReadInterval > 0 {
+		return device.ReadInterval
+	}
+	return scheduler.handlerReadInterval(device.handler)
+}
+
+// handlerReadInterval gets the interval at which devices for the given handler
+// should be read, falling back to the plugin's global read interval.
+func (scheduler *scheduler) handlerReadInterval(handler *DeviceHandler) time.Duration {
+	if handler != nil && handler.ReadInterval > 0 {
+		return handler.ReadInterval
+	}
+	return scheduler.config.Read.Interval
+}
+
 // scheduleWrites schedules device writes based on the plugin configuration.
 //
 // This will do nothing if:
 // - Writing is globally disabled for the plugin.
 // - No registered device handlers implement a write function.
 func (scheduler *scheduler) scheduleWrites() {
+	// Signal that the write loop has terminated when this returns, so anything
+	// waiting on in-flight writes (e.g. shutdown) can continue.
+	if scheduler.writesDone != nil {
+		defer close(scheduler.writesDone)
+	}
+
 	if scheduler.config.Write.Disable {
 		log.Info("[scheduler] writing will not be scheduled (writes globally disabled)")
 		return
@@ -377,18 +1143,19 @@ func (scheduler *scheduler) scheduleWrites() {
 	mode := scheduler.config.Mode
 
 	wlog := log.WithFields(log.Fields{
-		"interval": interval,
-		"delay":    delay,
-		"mode":     mode,
+		"interval":    interval,
+		"delay":       delay,
+		"mode":        mode,
+		"serializeBy": scheduler.config.Write.SerializeBy,
 	})
 
 	wlog.Info("[scheduler] starting write scheduling")
-	scheduler.isWriting = true
+	scheduler.isWriting.set(true)
 	for {
 		// If the stop channel is closed, stop the write loop.
 		select {
 		case <-scheduler.stop:
-			scheduler.isWriting = false
+			scheduler.isWriting.set(false)
 			log.Info("[scheduler] stop channel closed, terminating scheduleWrites")
 			return
 		default:
@@ -398,25 +1165,33 @@ func (scheduler *scheduler) scheduleWrites() {
 		var waitGroup sync.WaitGroup
 
 		// Check for any pending writes. If any exist, attempt to fulfill
-		// the writes and update their transaction state accordingly.
-		var totalWrites = 0
+		// the writes and update their transaction state accordingly. Writes are
+		// taken from the queues in priority order.
+		var batch []*WriteContext
 		for i := 0; i < scheduler.config.Write.BatchSize; i++ {
-			select {
-			case w := <-scheduler.writeChan:
-				// Increment the WaitGroup counter for all writes being executed
-				// in this batch.
-				waitGroup.Add(1)
-				totalWrites++
-
-				// Launch the device write.
-				go func(wg *sync.WaitGroup, writeContext *WriteContext) {
-					scheduler.write(writeContext)
-					wg.Done()
-				}(&waitGroup, w)
-
-			default:
+			w := scheduler.nextWrite()
+			if w == nil {
 				// If there is nothing to write, do nothing.
+				continue
 			}
+			batch = append(batch, w)
+		}
+		totalWrites := len(batch)
+
+		// Writes which must be serialized (e.g. writes to the same device) are
+		// grouped and executed in order, while each group runs in parallel.
+		for _, group := range scheduler.groupWrites(batch) {
+			// Increment the WaitGroup counter for all write groups being executed
+			// in this batch.
+			waitGroup.Add(1)
+
+			// Launch the device writes.
+			go func(wg *sync.WaitGroup, writeContexts []*WriteContext) {
+				for _, writeContext := range writeContexts {
+					scheduler.write(writeContext)
+				}
+				wg.Done()
+			}(&waitGroup, group)
 		}
 
 		if totalWrites > 0 {
@@ -430,7 +1205,10 @@ func (scheduler *scheduler) scheduleWrites() {
 		waitGroup.Wait()
 
 		if interval != 0 {
-			time.Sleep(interval)
+			select {
+			case <-scheduler.stop:
+			case <-time.After(interval):
+			}
 		}
 	}
 }
@@ -453,13 +1231,13 @@ func (scheduler *scheduler) scheduleListen() {
 		return
 	}
 
-	scheduler.isListening = true
+	scheduler.isListening.set(true)
 	// For each handler which has a listener function defined, get the devices for
 	// the handler and start the listener for those devices.
 	for _, handler := range scheduler.deviceManager.handlers {
 		hlog := log.WithField("handler", handler.Name)
 
-		if handler.Listen != nil {
+		if handler.CanListen() {
 			hlog.Info("[scheduler] starting listener")
 
 			// Get the devices for the handler.
@@ -471,13 +1249,74 @@ func (scheduler *scheduler) scheduleListen() {
 
 			// For each device, run the listener goroutine.
 			for _, device := range devices {
-				ctx := NewListenerCtx(handler, device)
-				go scheduler.listen(ctx)
+				scheduler.startListener(handler, device)
 			}
 		}
 	}
 }
 
+// startListener starts a listener for the given device.
+func (scheduler *scheduler) startListener(handler *DeviceHandler, device *Device) {
+	ctx := NewListenerCtx(handler, device)
+	ctx.ctx, ctx.cancel = context.WithCancel(scheduler.runContext())
+
+	scheduler.listenerLock.Lock()
+	scheduler.listeners = append(scheduler.listeners, ctx)
+	scheduler.listenerLock.Unlock()
+	go scheduler.listen(ctx)
+}
+
+// stopListeners stops the listeners for the device with the given ID and stops
+// tracking them. Listeners which do not take a context can not be interrupted,
+// but they will not be restarted.
+func (scheduler *scheduler) stopListeners(id string) {
+	scheduler.listenerLock.Lock()
+	defer scheduler.listenerLock.Unlock()
+
+	var remaining []*ListenerCtx
+	for _, ctx := range scheduler.listeners {
+		if ctx.device.id != id {
+			remaining = append(remaining, ctx)
+			continue
+		}
+		if ctx.cancel != nil {
+			ctx.cancel()
+		}
+	}
+	scheduler.listeners = remaining
+}
+
+// addDevices schedules the given devices, which were added after the scheduler
+// started. Reads for the devices are picked up by the read loops; a read loop
+// is started for any new read interval. Listeners are started for devices with
+// listener handlers.
+func (scheduler *scheduler) addDevices(devices ...*Device) {
+	for _, device := range devices {
+		if device.handler == nil {
+			continue
+		}
+		if scheduler.isReading.isSet() && device.handler.CanRead() {
+			scheduler.startReadLoop(scheduler.deviceReadInterval(device))
+		}
+		if scheduler.isListening.isSet() && device.handler.CanListen() {
+			scheduler.startListener(device.handler, device)
+		}
+	}
+}
+
+// removeDevice stops scheduling the given device. Its listeners are stopped, its
+// queued writes are rejected, and its read circuit breaker is discarded. Any reads
+// of the device which are in flight are allowed to complete, but their readings
+// are discarded.
+func (scheduler *scheduler) removeDevice(device *Device) {
+	scheduler.stopListeners(device.id)
+	scheduler.rejectQueuedWrites(device.id)
+
+	scheduler.breakerLock.Lock()
+	delete(scheduler.breakers, device.id)
+	scheduler.breakerLock.Unlock()
+}
+
 // finalizeReadings is a helper function which takes a read context and
 // applies any transformations and augmentations which are defined by its
 // Device to produce the final reading result.
@@ -540,7 +1379,7 @@ func (scheduler *scheduler) read(device *Device) {
 	// acquiring the serial lock so something isn't holding on to the lock
 	// and just waiting.
 	if scheduler.limiter != nil {
-		if err := scheduler.limiter.Wait(context.Background()); err != nil {
+		if err := scheduler.limiter.Wait(scheduler.runContext()); err != nil {
 			rlog.WithField("error", err).Error("[scheduler] error with rate limiter")
 		}
 	}
@@ -556,8 +1395,30 @@ func (scheduler *scheduler) read(device *Device) {
 			defer scheduler.serialLock.Unlock()
 		}
 
+		// Wait for the device's concurrency group, if it is in one.
+		release, err := scheduler.concurrency.acquire(scheduler.runContext(), device)
+		if err != nil {
+			rlog.WithField("error", err).Debug("[scheduler] device read interrupted waiting for concurrency group")
+			return
+		}
+		defer release()
+
+		// If the device's circuit breaker is open, skip the read. This is only checked
+		// once the device is about to be read, since allowing a read moves an open
+		// breaker to half-open, and it only closes or re-opens once the read outcome
+		// is recorded.
+		if !scheduler.allowRead(device) {
+			rlog.Debug("[scheduler] circuit breaker open, skipping device read")
+			return
+		}
+
 		// Read from the device.
-		response, err := device.Read()
+		ctx, cancel := scheduler.readContext(scheduler.deviceReadInterval(device))
+		defer cancel()
+
+		start := time.Now()
+		response, err := device.ReadCtx(ctx)
+		readDurationMetric.WithLabelValues(device.handler.Name).Observe(time.Since(start).Seconds())
 		if err != nil {
 			// Check to see if the error is that of unsupported error. If it is, we
 			// do not want to log out here (low-interval read polling would cause this
@@ -565,13 +1426,18 @@ func (scheduler *scheduler) read(device *Device) {
 			_, unsupported := err.(*sdkError.UnsupportedCommandError)
 			if !unsupported {
 				rlog.Error("[scheduler] failed device read")
+				scheduler.readFailed(device, err)
+			} else {
+				scheduler.readSucceeded(device)
 			}
 		} else {
 			err := finalizeReadings(device, response)
 			if err != nil {
 				rlog.Error("[scheduler] discarding readings")
+				scheduler.readFailed(device, err)
 			} else {
-				scheduler.stateManager.readChan <- response
+				scheduler.readSucceeded(device)
+				scheduler.stateManager.submitReadings(response)
 			}
 		}
 
@@ -599,7 +1465,7 @@ func (scheduler *scheduler) bulkRead(handler *DeviceHandler) {
 	// acquiring the serial lock so something isn't holding on to the lock
 	// and just waiting.
 	if scheduler.limiter != nil {
-		if err := scheduler.limiter.Wait(context.Background()); err != nil {
+		if err := scheduler.limiter.Wait(scheduler.runContext()); err != nil {
 			rlog.WithField("error", err).Error("[scheduler] error with rate limiter")
 		}
 	}
@@ -607,8 +1473,8 @@ func (scheduler *scheduler) bulkRead(handler *DeviceHandler) {
 	// If the handler supports bulk reading, execute bulk reads. Devices using the
 	// handler will not have been read individually yet.
 	if handler.CanBulkRead() {
-		devices := scheduler.deviceManager.GetDevicesForHandler(handler.Name)
-		if len(devices) == 0 {
+		handlerDevices := scheduler.deviceManager.GetDevicesForHandler(handler.Name)
+		if len(handlerDevices) == 0 {
 			rlog.Debug("[scheduler] handler has no devices to read")
 			return
 		}
@@ -619,17 +1485,53 @@ func (scheduler *scheduler) bulkRead(handler *DeviceHandler) {
 			defer scheduler.serialLock.Unlock()
 		}
 
-		response, err := handler.BulkRead(devices)
+		// Wait for the concurrency groups of the handler's devices.
+		release, err := scheduler.concurrency.acquire(scheduler.runContext(), handlerDevices...)
+		if err != nil {
+			rlog.WithField("error", err).Debug("[scheduler] bulk read interrupted waiting for concurrency groups")
+			return
+		}
+		defer release()
+
+		// Only bulk read the devices whose circuit breakers allow it. As with
+		// individual reads, this is only checked once the devices are about to
+		// be read, so every allowed read has its outcome recorded.
+		var devices []*Device
+		for _, device := range handlerDevices {
+			if scheduler.allowRead(device) {
+				devices = append(devices, device)
+			} else {
+				rlog.WithField("device", device.id).Debug("[scheduler] circuit breaker open, skipping device bulk read")
+			}
+		}
+		if len(devices) == 0 {
+			rlog.Debug("[scheduler] handler has no devices to read")
+			return
+		}
+
+		ctx, cancel := scheduler.readContext(scheduler.handlerReadInterval(handler))
+		defer cancel()
+
+		start := time.Now()
+		response, err := handler.bulkRead(ctx, devices)
+		readDurationMetric.WithLabelValues(handler.Name).Observe(time.Since(start).Seconds())
 		if err != nil {
 			rlog.WithField("error", err).Error("[scheduler] handler failed bulk read")
+			for _, device := range devices {
+				scheduler.readFailed(device, err)
+			}
 		} else {
+			for _, device := range devices {
+				scheduler.readSucceeded(device)
+			}
 			for _, readCtx := range response {
 				device := readCtx.Device
 				err := finalizeReadings(device, readCtx)
 				if err != nil {
 					rlog.Error("[scheduler] discarding readings")
+					scheduler.readFailed(device, err)
 				} else {
-					scheduler.stateManager.readChan <- readCtx
+					scheduler.stateManager.submitReadings(readCtx)
 				}
 			}
 		}
@@ -654,11 +1556,19 @@ func (scheduler *scheduler) write(writeCtx *WriteContext) {
 		"device":      writeCtx.device,
 	})
 
+	// Claim the write. If it was superseded by a later write while it was queued,
+	// its transaction is already complete, so there is nothing to do.
+	if !writeCtx.transaction.claim() {
+		wlog.Debug("[scheduler] skipping superseded device write")
+		return
+	}
+	scheduler.clearCoalesced(writeCtx)
+
 	// Rate limiting, if configured. We want to do this before potentially
 	// acquiring the serial lock so something isn't holding on to the lock
 	// and just waiting.
 	if scheduler.limiter != nil {
-		if err := scheduler.limiter.Wait(context.Background()); err != nil {
+		if err := scheduler.limiter.Wait(scheduler.runContext()); err != nil {
 			wlog.WithField("error", err).Error("[scheduler] error with rate limiter")
 		}
 	}
@@ -674,58 +1584,70 @@ func (scheduler *scheduler) write(writeCtx *WriteContext) {
 	// Get the device.
 	device := writeCtx.device
 	if device == nil {
-		writeCtx.transaction.setStatusError()
 		writeCtx.transaction.message = "no device found with ID: " + writeCtx.device.id
+		writeCtx.transaction.setStatusError()
 		wlog.Error("[scheduler] " + writeCtx.transaction.message)
 		return
 	}
 
 	if !device.IsWritable() {
-		writeCtx.transaction.setStatusError()
 		writeCtx.transaction.message = "device is not writable: " + writeCtx.device.id
+		writeCtx.transaction.setStatusError()
 		wlog.Error("[scheduler] " + writeCtx.transaction.message)
 		return
 	}
 
-	writeCtx.transaction.setStatusWriting()
+	// The device may have been removed after the write was taken from the queue.
+	if device.IsRemoved() {
+		writeCtx.transaction.message = ErrDeviceRemoved.Error()
+		writeCtx.transaction.setStatusError()
+		wlog.Warn("[scheduler] not writing to removed device")
+		return
+	}
+
+	// Wait for the device's concurrency group, if it is in one.
+	release, acquireErr := scheduler.concurrency.acquire(scheduler.runContext(), device)
+	if acquireErr != nil {
+		writeCtx.transaction.message = "write interrupted: plugin shutting down"
+		writeCtx.transaction.setStatusError()
+		wlog.WithField("error", acquireErr).Warn("[scheduler] device write interrupted waiting for concurrency group")
+		return
+	}
+	defer release()
 
 	// Write to the device. If the device write does not complete within
-	// the set time bounds, error out with timeout.
-	// See: https://gobyexample.com/timeouts
+	// the set time bounds, error out with timeout. The write context is also
+	// cancelled if the transaction is cancelled.
+	ctx, cancel := context.WithTimeout(scheduler.runContext(), device.WriteTimeout)
+	defer cancel()
+
+	// The transaction may have been cancelled after the write was taken from the queue.
+	// Once started, only writes by handlers which define WriteCtx can be cancelled;
+	// handlers which only define Write can not be interrupted, so their write runs to
+	// completion and its actual result is reported.
+	interrupt := cancel
+	if device.handler.WriteCtx == nil {
+		interrupt = nil
+	}
+	if !writeCtx.transaction.startWrite(interrupt) {
+		writeCtx.transaction.message = ErrWriteCancelled.Error()
+		writeCtx.transaction.setStatusCancelled()
+		wlog.Info("[scheduler] not writing cancelled transaction")
+		return
+	}
+
+	writeCtx.transaction.setStatusWriting()
+
 	writer := make(chan error, 1)
 	go func() {
 		data := decodeWriteData(writeCtx.data)
-		writer <- device.Write(data)
+		writer <- device.WriteCtx(ctx, data)
 	}()
 
-	// Wait for the write to complete, or timeout.
-	// FIXME (etd): this does technically give us a timeout, where we will stop
-	//  waiting for the write after the given timeout and just mark the transaction
-	//  dead, but thats kinda all this does.
-	//
-	//  from an upstream perspective, this is fine.. we want to be able to say
-	//  "we expect this write to complete after N time, afterwards, timeout and consider
-	//  it failed".
-	//
-	//  from the backend, it does present this to the frontend consumer, but it doesn't
-	//  actually stop the write from happening, so while we have "timed out", the write
-	//  is still going on in the background and could eventually resolve, which is
-	//  not at all what we want to do.
-	//
-	//  this could be resolved by passing in a context and cancelling, but uhh.. its
-	//  complicated and the internet has many mixed feeling-ed blog posts about it.
-	//
-	//  this relates to #365. I'll need to spend more time thinking this through.
-	//  essentially the issue lies in the fact that context cancellation relies on the
-	//  fact that you can terminate the work at some point (e.g. if iterating in an
-	//  infinite loop.., emitting from a channel, ...), then you can wait on the context
-	//  done signal, wait for the fn to finish up, and then you're done. to me, it seems
-	//  like the write will need to be waited on either way, so i'm not sure there is
-	//  a benefit to using a cancellation context unless writing is handled in a different
-	//  manner..
-	//
-	//  it seems like having a cancelation context could be useful if there is some
-	//  retry logic on the write, but thats mostly it..
+	// Wait for the write to complete, or timeout. Handlers which define WriteCtx
+	// receive the write context and are expected to abandon the write once it is
+	// done. Handlers which only define Write can not be interrupted, so the write
+	// may continue in the background after the transaction is marked as failed.
 
 	log.WithFields(log.Fields{
 		"device":  device.GetID(),
@@ -738,14 +1660,29 @@ func (scheduler *scheduler) write(writeCtx *WriteContext) {
 	select {
 	case writeErr := <-writer:
 		err = writeErr
-	case <-time.After(device.WriteTimeout):
-		err = ErrDeviceWriteTimeout
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			err = ErrDeviceWriteTimeout
+		} else {
+			err = errors.New("write interrupted: plugin shutting down")
+		}
 	}
 
+	// A write which fails after cancellation was requested, whether interrupted
+	// by the scheduler or abandoned by the handler, is considered cancelled.
+	if err != nil && err != ErrDeviceWriteTimeout && writeCtx.transaction.isCancelRequested() {
+		err = ErrWriteCancelled
+	}
+	if err == ErrWriteCancelled {
+		wlog.Info("[scheduler] device write cancelled")
+		writeCtx.transaction.message = err.Error()
+		writeCtx.transaction.setStatusCancelled()
+		return
+	}
 	if err != nil {
 		wlog.WithField("error", err).Error("[scheduler] failed to write to device")
-		writeCtx.transaction.setStatusError()
 		writeCtx.transaction.message = err.Error()
+		writeCtx.transaction.setStatusError()
 		return
 	}
 	wlog.Debug("[scheduler] successfully wrote to device")
@@ -759,6 +1696,10 @@ func (scheduler *scheduler) write(writeCtx *WriteContext) {
 }
 
 // listen listens to devices to collect readings using a device's Listen function.
+//
+// If the listener fails, it is restarted after a backoff period, which grows with
+// each successive failure according to the listen settings. If a maximum number of
+// restarts is configured, the listener is given up on once it has been exceeded.
 func (scheduler *scheduler) listen(listenerCtx *ListenerCtx) {
 	llog := log.WithFields(log.Fields{
 		"handler": listenerCtx.handler.Name,
@@ -767,31 +1708,139 @@ func (scheduler *scheduler) listen(listenerCtx *ListenerCtx) {
 
 	llog.Info("[scheduler] starting listener for device")
 
-	for {
-		// Run the listener fore the device. Pass in the state manager's read channel,
-		// as the listener is really just collecting readings.
-		err := listenerCtx.handler.Listen(
-			listenerCtx.device,
-			scheduler.stateManager.readChan,
-		)
-		if err != nil {
-			// Increment the number of restarts.
-			listenerCtx.restarts++
+	settings := scheduler.listenSettings()
+	backoff := settings.InitialBackoff
 
-			// If a listener function results in error, we want to restart it to try and
-			// keep listening. Log the error and re-try listening.
-			llog.WithFields(log.Fields{
-				"restarts": listenerCtx.restarts,
-				"error":    err,
-			}).Error("[scheduler] listener failed, will restart and try again")
-			continue
+	runCtx := listenerCtx.ctx
+	if runCtx == nil {
+		runCtx = scheduler.runContext()
+	}
+
+	for {
+		// If the scheduler is stopping or the device was removed, do not (re)start
+		// the listener.
+		select {
+		case <-scheduler.stop:
+			llog.Info("[scheduler] scheduler stopped, ending device listen")
+			listenerCtx.setState(listenerStopped)
+			return
+		case <-runCtx.Done():
+			llog.Info("[scheduler] listener stopped, ending device listen")
+			listenerCtx.setState(listenerStopped)
+			return
+		default:
+			// no stop signal
+		}
 
+		// Run the listener for the device. Pass in the state manager's read channel,
+		// as the listener is really just collecting readings. Context-aware listeners
+		// are preferred, as they can be cancelled on shutdown.
+		listenerCtx.setState(listenerRunning)
+		started := time.Now()
+
+		var err error
+		if listenerCtx.handler.ListenCtx != nil {
+			err = listenerCtx.handler.ListenCtx(
+				runCtx,
+				listenerCtx.device,
+				scheduler.stateManager.readChan,
+			)
 		} else {
+			err = listenerCtx.handler.Listen(
+				listenerCtx.device,
+				scheduler.stateManager.readChan,
+			)
+		}
+
+		if err == nil {
 			// If the listener ended without any error, we take this to mean
 			// that it terminated in a way that is considered ok, so we do not
 			// want to try and restart. Instead, just stop listening.
 			llog.Info("[scheduler] listener completed without error, ending device listen")
+			listenerCtx.setState(listenerStopped)
 			return
 		}
+
+		// If the listener had been running for a while before failing, it is not
+		// failing in succession, so start backing off and counting restarts from
+		// the beginning again. Without a max backoff, there is no point at which a
+		// run is considered long enough.
+		healthy := settings.MaxBackoff > 0 && time.Since(started) >= settings.MaxBackoff
+
+		restarts := listenerCtx.failed(err, healthy)
+		if settings.MaxRestarts > 0 && restarts > settings.MaxRestarts {
+			llog.WithFields(log.Fields{
+				"restarts": restarts - 1,
+				"error":    err,
+			}).Error("[scheduler] listener failed and exceeded max restarts, giving up")
+			listenerCtx.setState(listenerFailed)
+			scheduler.events.publish(Event{
+				Type:     EventListenerFailed,
+				Device:   listenerCtx.device,
+				Restarts: restarts - 1,
+				Err:      err,
+			})
+			return
+		}
+
+		if healthy {
+			backoff = settings.InitialBackoff
+		}
+		wait := jitter(backoff, settings.Jitter)
+
+		// If a listener function results in error, we want to restart it to try and
+		// keep listening. Log the error and re-try listening after the backoff.
+		llog.WithFields(log.Fields{
+			"restarts": restarts,
+			"error":    err,
+			"backoff":  wait,
+		}).Error("[scheduler] listener failed, will restart and try again")
+		scheduler.events.publish(Event{
+			Type:     EventListenerRestarting,
+			Device:   listenerCtx.device,
+			Restarts: restarts,
+			Err:      err,
+		})
+
+		select {
+		case <-scheduler.stop:
+		case <-runCtx.Done():
+		case <-time.After(wait):
+		}
+		backoff = nextBackoff(backoff, settings.MaxBackoff, settings.Multiplier)
+	}
+}
+
+// listenSettings gets the listen settings for the scheduler. If none are configured,
+// empty settings are returned, so failed listeners are restarted immediately.
+func (scheduler *scheduler) listenSettings() *config.ListenSettings {
+	if scheduler.config == nil || scheduler.config.Listen == nil {
+		return &config.ListenSettings{}
+	}
+	return scheduler.config.Listen
+}
+
+// nextBackoff increases the given backoff by the multiplier, capped at the max
+// backoff. A max of zero means the backoff is uncapped.
+func nextBackoff(backoff, max time.Duration, multiplier float64) time.Duration {
+	if multiplier > 1 {
+		backoff = time.Duration(float64(backoff) * multiplier)
+	}
+	if max > 0 && backoff > max {
+		backoff = max
+	}
+	return backoff
+}
+
+// jitter randomly varies the given duration by up to the given fraction of it, in
+// either direction.
+func jitter(d time.Duration, fraction float64) time.Duration {
+	if d <= 0 || fraction <= 0 {
+		return d
+	}
+	if fraction > 1 {
+		fraction = 1
 	}
+	delta := float64(d) * fraction * (2*rand.Float64() - 1) // nolint: gosec
+	return d + time.Duration(delta)
 }
@@ -17,6 +17,7 @@
 package sdk
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"testing"
@@ -61,6 +62,8 @@ func TestNewScheduler(t *testing.T) {
 	assert.NotNil(t, sched.stateManager)
 	assert.NotNil(t, sched.config)
 	assert.NotNil(t, sched.writeChan)
+	assert.Len(t, sched.writeQueues, 2)
+	assert.NotNil(t, sched.concurrency)
 	assert.NotNil(t, sched.stop)
 	assert.Nil(t, sched.limiter)
 }
@@ -117,6 +120,24 @@ func TestScheduler_healthChecks(t *testing.T) {
 	assert.Equal(t, plugin.health.Count(), 1)
 }
 
+func TestScheduler_healthChecks_breakers(t *testing.T) {
+	plugin := Plugin{
+		health: health.NewManager(&config.HealthSettings{}),
+	}
+	sched := scheduler{
+		config: &config.PluginSettings{
+			Read: &config.ReadSettings{
+				Breaker: &config.CircuitBreakerSettings{Enabled: true},
+			},
+		},
+	}
+
+	err := sched.healthChecks(&plugin)
+	assert.NoError(t, err)
+
+	assert.Equal(t, plugin.health.Count(), 2)
+}
+
 func TestScheduler_Stop(t *testing.T) {
 	s := scheduler{
 		stop: make(chan struct{}),
@@ -129,6 +150,142 @@ func TestScheduler_Stop(t *testing.T) {
 	assert.False(t, isOpen)
 }
 
+func TestScheduler_Stop_multipleCalls(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := scheduler{
+		stop:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	assert.NotPanics(t, func() {
+		assert.NoError(t, s.Stop())
+		assert.NoError(t, s.Stop())
+	})
+
+	_, isOpen := <-s.stop
+	assert.False(t, isOpen)
+	assert.Error(t, s.runContext().Err())
+}
+
+func TestScheduler_Shutdown_noWrites(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := scheduler{
+		writeChan: make(chan *WriteContext, 2),
+		stop:      make(chan struct{}),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	err := s.Shutdown(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, s.draining)
+	assert.Error(t, s.runContext().Err())
+}
+
+func TestScheduler_Shutdown_rejectsWrites(t *testing.T) {
+	handler := &DeviceHandler{
+		Write: func(device *Device, data *WriteData) error {
+			return nil
+		},
+	}
+	s := scheduler{
+		writeChan: make(chan *WriteContext, 2),
+		stop:      make(chan struct{}),
+		stateManager: &stateManager{
+			transactions: cache.New(1*time.Minute, 2*time.Minute),
+		},
+	}
+
+	err := s.Shutdown(context.Background())
+	assert.NoError(t, err)
+
+	resp, err := s.Write(&Device{handler: handler}, []*synse.V3WriteData{{Action: "test"}})
+	assert.Equal(t, ErrSchedulerShutdown, err)
+	assert.Nil(t, resp)
+	assert.Empty(t, s.writeChan)
+}
+
+func TestScheduler_Shutdown_failsQueuedWrites(t *testing.T) {
+	s := scheduler{
+		writeChan: make(chan *WriteContext, 2),
+		stop:      make(chan struct{}),
+		stateManager: &stateManager{
+			transactions: cache.New(1*time.Minute, 2*time.Minute),
+		},
+	}
+	s.isWriting.set(true)
+
+	txn, err := s.stateManager.newTransaction(10*time.Minute, "")
+	assert.NoError(t, err)
+	s.writeChan <- &WriteContext{
+		transaction: txn,
+		device:      &Device{id: "123"},
+		data:        &synse.V3WriteData{Action: "test"},
+	}
+
+	// Nothing is processing the write queue, so the shutdown context will expire
+	// before the queue is drained.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err = s.Shutdown(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Empty(t, s.writeChan)
+	assert.Equal(t, synse.WriteStatus_ERROR, txn.status)
+	assert.Equal(t, "write not executed: plugin shutting down", txn.message)
+}
+
+func TestScheduler_Shutdown_drainsWrites(t *testing.T) {
+	handler := &DeviceHandler{
+		Name: "test",
+		Write: func(device *Device, data *WriteData) error {
+			return nil
+		},
+	}
+
+	s := scheduler{
+		config: &config.PluginSettings{
+			Mode: "parallel",
+			Write: &config.WriteSettings{
+				Interval:  10 * time.Millisecond,
+				BatchSize: 10,
+			},
+		},
+		deviceManager: &deviceManager{
+			handlers: map[string]*DeviceHandler{
+				"test": handler,
+			},
+		},
+		stateManager: &stateManager{
+			transactions: cache.New(1*time.Minute, 2*time.Minute),
+		},
+		writeChan:  make(chan *WriteContext, 10),
+		stop:       make(chan struct{}),
+		writesDone: make(chan struct{}),
+	}
+
+	device := &Device{id: "123", handler: handler, WriteTimeout: 1 * time.Second}
+	txns, err := s.Write(device, []*synse.V3WriteData{{Action: "a"}, {Action: "b"}})
+	assert.NoError(t, err)
+	assert.Len(t, txns, 2)
+
+	go s.scheduleWrites()
+	// Wait for the write loop to start before shutting down.
+	for !s.isWriting.isSet() {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = s.Shutdown(ctx)
+	assert.NoError(t, err)
+	for _, txn := range txns {
+		assert.Equal(t, synse.WriteStatus_DONE, s.stateManager.getTransaction(txn.Id).status)
+	}
+}
+
 func TestScheduler_Write_nilDevice(t *testing.T) {
 	s := &scheduler{}
 
@@ -279,9 +436,9 @@ func TestScheduler_scheduleReads_readDisabled(t *testing.T) {
 		},
 	}
 
-	assert.False(t, s.isReading)
+	assert.False(t, s.isReading.isSet())
 	s.scheduleReads()
-	assert.False(t, s.isReading)
+	assert.False(t, s.isReading.isSet())
 }
 
 func TestScheduler_scheduleReads_noHandlers(t *testing.T) {
@@ -298,9 +455,9 @@ func TestScheduler_scheduleReads_noHandlers(t *testing.T) {
 		},
 	}
 
-	assert.False(t, s.isReading)
+	assert.False(t, s.isReading.isSet())
 	s.scheduleReads()
-	assert.False(t, s.isReading)
+	assert.False(t, s.isReading.isSet())
 }
 
 func TestScheduler_scheduleReads(t *testing.T) {
@@ -345,6 +502,123 @@ func TestScheduler_scheduleReads(t *testing.T) {
 	assert.Equal(t, s.deviceManager.GetDevice("123"), reading.Device)
 }
 
+// Devices with their own read interval should be read independently, so a slow device
+// does not hold up reads for a fast device.
+func TestScheduler_scheduleReads_perDeviceInterval(t *testing.T) {
+	handler := &DeviceHandler{
+		Name: "test",
+		Read: func(device *Device) (readings []*output.Reading, e error) {
+			if device.id == "slow" {
+				time.Sleep(500 * time.Millisecond)
+			}
+			return []*output.Reading{{Value: 1}}, nil
+		},
+	}
+
+	s := scheduler{
+		config: &config.PluginSettings{
+			Mode: "parallel",
+			Read: &config.ReadSettings{
+				Interval: 10 * time.Millisecond,
+			},
+		},
+		deviceManager: &deviceManager{
+			handlers: map[string]*DeviceHandler{
+				"test": handler,
+			},
+			devices: map[string]*Device{
+				"fast": {id: "fast", handler: handler},
+				"slow": {id: "slow", handler: handler, ReadInterval: 1 * time.Second},
+			},
+		},
+		stateManager: &stateManager{
+			readChan: make(chan *ReadContext, 100),
+		},
+		stop: make(chan struct{}),
+	}
+
+	go s.scheduleReads()
+	time.Sleep(300 * time.Millisecond)
+	close(s.stop)
+
+	counts := map[string]int{}
+	for len(s.stateManager.readChan) > 0 {
+		ctx := <-s.stateManager.readChan
+		counts[ctx.Device.id]++
+	}
+	assert.Equal(t, 0, counts["slow"])
+	assert.Greater(t, counts["fast"], 5)
+}
+
+func TestScheduler_readIntervals(t *testing.T) {
+	handler := &DeviceHandler{
+		Name: "single",
+		Read: func(device *Device) (readings []*output.Reading, e error) {
+			return nil, nil
+		},
+	}
+	bulkHandler := &DeviceHandler{
+		Name:         "bulk",
+		ReadInterval: 5 * time.Second,
+		BulkRead: func(devices []*Device) (contexts []*ReadContext, e error) {
+			return nil, nil
+		},
+	}
+
+	s := scheduler{
+		config: &config.PluginSettings{
+			Read: &config.ReadSettings{
+				Interval: 1 * time.Second,
+			},
+		},
+		deviceManager: &deviceManager{
+			handlers: map[string]*DeviceHandler{
+				"single": handler,
+				"bulk":   bulkHandler,
+			},
+			devices: map[string]*Device{
+				"1": {id: "1", handler: handler},
+				"2": {id: "2", handler: handler, ReadInterval: 2 * time.Second},
+				"3": {id: "3", handler: bulkHandler, ReadInterval: 3 * time.Second},
+			},
+		},
+	}
+
+	assert.Equal(t, []time.Duration{1 * time.Second, 2 * time.Second, 5 * time.Second}, s.readIntervals())
+}
+
+func TestScheduler_deviceReadInterval(t *testing.T) {
+	s := scheduler{
+		config: &config.PluginSettings{
+			Read: &config.ReadSettings{
+				Interval: 1 * time.Second,
+			},
+		},
+	}
+
+	cases := []struct {
+		device   *Device
+		expected time.Duration
+	}{
+		{
+			device:   &Device{},
+			expected: 1 * time.Second,
+		},
+		{
+			device:   &Device{handler: &DeviceHandler{ReadInterval: 2 * time.Second}},
+			expected: 2 * time.Second,
+		},
+		{
+			device:   &Device{ReadInterval: 3 * time.Second, handler: &DeviceHandler{ReadInterval: 2 * time.Second}},
+			expected: 3 * time.Second,
+		},
+	}
+
+	for i, c := range cases {
+		assert.Equal(t, c.expected, s.deviceReadInterval(c.device), i)
+	}
+}
+
 // When configured in serial mode, scheduleReads should execute all reads serially, even
 // if there is a mix of single-read and batch-read handlers.
 //
@@ -640,9 +914,9 @@ func TestScheduler_scheduleWrites_writeDisabled(t *testing.T) {
 		},
 	}
 
-	assert.False(t, s.isWriting)
+	assert.False(t, s.isWriting.isSet())
 	s.scheduleWrites()
-	assert.False(t, s.isWriting)
+	assert.False(t, s.isWriting.isSet())
 }
 
 func TestScheduler_scheduleWrites_noHandlers(t *testing.T) {
@@ -659,9 +933,9 @@ func TestScheduler_scheduleWrites_noHandlers(t *testing.T) {
 		},
 	}
 
-	assert.False(t, s.isWriting)
+	assert.False(t, s.isWriting.isSet())
 	s.scheduleWrites()
-	assert.False(t, s.isWriting)
+	assert.False(t, s.isWriting.isSet())
 }
 
 func TestScheduler_scheduleWrites(t *testing.T) {
@@ -712,6 +986,7 @@ func TestScheduler_scheduleWrites(t *testing.T) {
 		txn,
 		s.deviceManager.GetDevice("123"),
 		&synse.V3WriteData{Action: "test"},
+		WritePriorityNormal,
 	}
 	s.writeChan <- wctx
 
@@ -771,12 +1046,14 @@ func TestScheduler_scheduleWrites_serial_withDelay(t *testing.T) {
 		txn1,
 		s.deviceManager.GetDevice("123"),
 		&synse.V3WriteData{Action: "test"},
+		WritePriorityNormal,
 	}
 
 	wctx2 := &WriteContext{
 		txn2,
 		s.deviceManager.GetDevice("123"),
 		&synse.V3WriteData{Action: "test"},
+		WritePriorityNormal,
 	}
 
 	start := time.Now()
@@ -809,9 +1086,9 @@ func TestScheduler_scheduleListen_listenDisabled(t *testing.T) {
 		},
 	}
 
-	assert.False(t, s.isListening)
+	assert.False(t, s.isListening.isSet())
 	s.scheduleListen()
-	assert.False(t, s.isListening)
+	assert.False(t, s.isListening.isSet())
 }
 
 func TestScheduler_scheduleListen_noHandlers(t *testing.T) {
@@ -828,9 +1105,9 @@ func TestScheduler_scheduleListen_noHandlers(t *testing.T) {
 		},
 	}
 
-	assert.False(t, s.isListening)
+	assert.False(t, s.isListening.isSet())
 	s.scheduleListen()
-	assert.False(t, s.isListening)
+	assert.False(t, s.isListening.isSet())
 }
 
 func TestScheduler_scheduleListen(t *testing.T) {
@@ -875,86 +1152,365 @@ func TestScheduler_scheduleListen(t *testing.T) {
 	assert.Equal(t, s.deviceManager.GetDevice("123"), reading.Device)
 }
 
-func TestScheduler_applyTransformations_NoTransformers(t *testing.T) {
-	device := &Device{
-		Transforms: []Transformer{},
-	}
-	rctx := &ReadContext{
-		Reading: []*output.Reading{
-			{Value: 2},
+func TestScheduler_listen_ctxCancelled(t *testing.T) {
+	handler := &DeviceHandler{
+		Name: "test",
+		ListenCtx: func(ctx context.Context, device *Device, contexts chan *ReadContext) error {
+			<-ctx.Done()
+			return ctx.Err()
 		},
 	}
 
-	err := finalizeReadings(device, rctx)
-	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := scheduler{
+		stateManager: &stateManager{
+			readChan: make(chan *ReadContext),
+		},
+		stop:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
 
-	// Verify that the reading value did not change.
-	assert.Equal(t, 2, rctx.Reading[0].Value.(int))
+	done := make(chan struct{})
+	go func() {
+		s.listen(NewListenerCtx(handler, &Device{id: "123"}))
+		close(done)
+	}()
 
-	// Verify that no additional context was set.
-	assert.Empty(t, rctx.Reading[0].Context)
+	assert.NoError(t, s.Stop())
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Fatal("listener was not terminated on scheduler stop")
+	}
 }
 
-func TestScheduler_applyTransformations_ScaleTransformerOk(t *testing.T) {
-	device := &Device{
-		Transforms: []Transformer{
-			&ScaleTransformer{Factor: 2},
+func TestScheduler_removeDevice(t *testing.T) {
+	handler := &DeviceHandler{
+		Name: "test",
+		ListenCtx: func(ctx context.Context, device *Device, contexts chan *ReadContext) error {
+			<-ctx.Done()
+			return ctx.Err()
 		},
 	}
-	rctx := &ReadContext{
-		Reading: []*output.Reading{
-			{Value: 2},
+	d1 := &Device{id: "123", handler: handler}
+	d2 := &Device{id: "456", handler: handler}
+
+	s := scheduler{
+		stateManager: &stateManager{
+			readChan: make(chan *ReadContext),
 		},
+		breakers: map[string]*circuitBreaker{"123": {}},
+		stop:     make(chan struct{}),
 	}
+	defer close(s.stop)
 
-	err := finalizeReadings(device, rctx)
-	assert.NoError(t, err)
+	s.startListener(handler, d1)
+	s.startListener(handler, d2)
+	s.removeDevice(d1)
 
-	// Verify that the reading value changed
-	assert.Equal(t, float64(4), rctx.Reading[0].Value.(float64))
+	assert.Len(t, s.listeners, 1)
+	assert.Equal(t, d2, s.listeners[0].device)
+	assert.NotContains(t, s.breakers, "123")
 
-	// Verify that no additional context was set.
-	assert.Empty(t, rctx.Reading[0].Context)
+	// Only the removed device's listener is cancelled.
+	assert.NoError(t, s.listeners[0].ctx.Err())
+	s.stopListeners("456")
+	assert.Empty(t, s.listeners)
 }
 
-func TestScheduler_applyTransformations_ApplyTransformerOk(t *testing.T) {
-	device := &Device{
-		Transforms: []Transformer{
-			&ApplyTransformer{
-				Func: &funcs.Func{
-					Name: "test-fn-1",
-					Fn: func(value interface{}) (interface{}, error) {
-						return (value.(int)) * 2, nil
-					},
-				},
-			},
+func TestScheduler_addDevices(t *testing.T) {
+	handler := &DeviceHandler{
+		Name: "test",
+		Read: func(device *Device) ([]*output.Reading, error) {
+			return nil, nil
+		},
+		ListenCtx: func(ctx context.Context, device *Device, contexts chan *ReadContext) error {
+			<-ctx.Done()
+			return ctx.Err()
 		},
 	}
-	rctx := &ReadContext{
-		Reading: []*output.Reading{
-			{Value: 2},
+
+	s := scheduler{
+		config: &config.PluginSettings{
+			Read: &config.ReadSettings{
+				Interval: time.Hour,
+			},
+		},
+		deviceManager: &deviceManager{},
+		stateManager: &stateManager{
+			readChan: make(chan *ReadContext),
 		},
+		stop: make(chan struct{}),
 	}
+	s.isReading.set(true)
+	s.isListening.set(true)
 
-	err := finalizeReadings(device, rctx)
-	assert.NoError(t, err)
+	s.addDevices(&Device{id: "123", handler: handler, ReadInterval: time.Minute})
+	assert.Len(t, s.listeners, 1)
+	assert.Contains(t, s.readLoops, time.Minute)
 
-	// Verify that the reading value changed.
-	assert.Equal(t, 4, rctx.Reading[0].Value.(int))
+	// A read loop is only started once per interval.
+	s.addDevices(&Device{id: "456", handler: handler, ReadInterval: time.Minute})
+	assert.Len(t, s.readLoops, 1)
+	assert.Len(t, s.listeners, 2)
 
-	// Verify that no additional context was set.
-	assert.Empty(t, rctx.Reading[0].Context)
+	s.stopListeners("123")
+	s.stopListeners("456")
+	close(s.stop)
+	s.readLoopWG.Wait()
 }
 
-func TestScheduler_applyTransformations_ApplyAndScaleTransformerOK(t *testing.T) {
-	device := &Device{
-		Transforms: []Transformer{
-			// First scale
-			&ScaleTransformer{
-				Factor: 2,
+func TestScheduler_listen_backoff(t *testing.T) {
+	var runs []time.Time
+	handler := &DeviceHandler{
+		Name: "test",
+		Listen: func(device *Device, contexts chan *ReadContext) error {
+			runs = append(runs, time.Now())
+			return fmt.Errorf("test error")
+		},
+	}
+
+	s := scheduler{
+		config: &config.PluginSettings{
+			Listen: &config.ListenSettings{
+				InitialBackoff: 20 * time.Millisecond,
+				MaxBackoff:     1 * time.Second,
+				Multiplier:     2,
+				MaxRestarts:    3,
 			},
-			// Then apply
-			&ApplyTransformer{
+		},
+		stateManager: &stateManager{
+			readChan: make(chan *ReadContext),
+		},
+		stop: make(chan struct{}),
+	}
+
+	ctx := NewListenerCtx(handler, &Device{id: "123"})
+	s.listen(ctx)
+
+	// The listener is run once initially, then restarted up to the max restarts.
+	assert.Len(t, runs, 4)
+	assert.GreaterOrEqual(t, runs[1].Sub(runs[0]), 20*time.Millisecond)
+	assert.GreaterOrEqual(t, runs[2].Sub(runs[1]), 40*time.Millisecond)
+	assert.GreaterOrEqual(t, runs[3].Sub(runs[2]), 80*time.Millisecond)
+
+	state, restarts, lastErr := ctx.status()
+	assert.Equal(t, listenerFailed, state)
+	assert.Equal(t, 4, restarts)
+	assert.EqualError(t, lastErr, "test error")
+}
+
+func TestScheduler_listen_backoffUncapped(t *testing.T) {
+	var runs []time.Time
+	handler := &DeviceHandler{
+		Name: "test",
+		Listen: func(device *Device, contexts chan *ReadContext) error {
+			runs = append(runs, time.Now())
+			return fmt.Errorf("test error")
+		},
+	}
+
+	s := scheduler{
+		config: &config.PluginSettings{
+			Listen: &config.ListenSettings{
+				InitialBackoff: 20 * time.Millisecond,
+				Multiplier:     2,
+				MaxRestarts:    3,
+			},
+		},
+		stateManager: &stateManager{
+			readChan: make(chan *ReadContext),
+		},
+		stop: make(chan struct{}),
+	}
+
+	ctx := NewListenerCtx(handler, &Device{id: "123"})
+	s.listen(ctx)
+
+	// Without a max backoff, the backoff keeps growing and is never reset.
+	assert.Len(t, runs, 4)
+	assert.GreaterOrEqual(t, runs[1].Sub(runs[0]), 20*time.Millisecond)
+	assert.GreaterOrEqual(t, runs[2].Sub(runs[1]), 40*time.Millisecond)
+	assert.GreaterOrEqual(t, runs[3].Sub(runs[2]), 80*time.Millisecond)
+
+	state, restarts, _ := ctx.status()
+	assert.Equal(t, listenerFailed, state)
+	assert.Equal(t, 4, restarts)
+}
+
+func TestScheduler_listen_healthyRunResetsRestarts(t *testing.T) {
+	runs := 0
+	handler := &DeviceHandler{
+		Name: "test",
+		Listen: func(device *Device, contexts chan *ReadContext) error {
+			runs++
+			if runs > 3 {
+				return nil
+			}
+			// Run for longer than the max backoff before failing.
+			time.Sleep(30 * time.Millisecond)
+			return fmt.Errorf("test error")
+		},
+	}
+
+	s := scheduler{
+		config: &config.PluginSettings{
+			Listen: &config.ListenSettings{
+				InitialBackoff: 5 * time.Millisecond,
+				MaxBackoff:     20 * time.Millisecond,
+				Multiplier:     2,
+				MaxRestarts:    1,
+			},
+		},
+		stateManager: &stateManager{
+			readChan: make(chan *ReadContext),
+		},
+		stop: make(chan struct{}),
+	}
+
+	ctx := NewListenerCtx(handler, &Device{id: "123"})
+	s.listen(ctx)
+
+	// None of the failures were in succession, so the max restarts is never hit.
+	assert.Equal(t, 4, runs)
+
+	state, restarts, _ := ctx.status()
+	assert.Equal(t, listenerStopped, state)
+	assert.Equal(t, 1, restarts)
+}
+
+func TestListenerCtx_failed(t *testing.T) {
+	ctx := NewListenerCtx(&DeviceHandler{}, &Device{id: "123"})
+
+	assert.Equal(t, 1, ctx.failed(fmt.Errorf("test error"), false))
+	assert.Equal(t, 2, ctx.failed(fmt.Errorf("test error"), false))
+
+	// A healthy run restarts the count.
+	assert.Equal(t, 1, ctx.failed(fmt.Errorf("test error"), true))
+
+	state, restarts, lastErr := ctx.status()
+	assert.Equal(t, listenerRestarting, state)
+	assert.Equal(t, 1, restarts)
+	assert.EqualError(t, lastErr, "test error")
+}
+
+func TestScheduler_registerListenerHealthCheck(t *testing.T) {
+	plugin := Plugin{
+		health: health.NewManager(&config.HealthSettings{}),
+	}
+	handler := &DeviceHandler{Name: "test"}
+
+	running := NewListenerCtx(handler, &Device{id: "1"})
+	running.setState(listenerRunning)
+	failed := NewListenerCtx(handler, &Device{id: "2"})
+	failed.failed(fmt.Errorf("connection refused"), false)
+
+	s := scheduler{
+		listeners: []*ListenerCtx{running, failed},
+	}
+	s.registerListenerHealthCheck(&plugin)
+	assert.Equal(t, 1, plugin.health.Count())
+
+	err := s.checkListeners()
+	assert.EqualError(t, err, "device listeners not running: 2 (handler: test, state: restarting, restarts: 1, last error: connection refused)")
+}
+
+func TestNextBackoff(t *testing.T) {
+	assert.Equal(t, 2*time.Second, nextBackoff(1*time.Second, 0, 2))
+	assert.Equal(t, 3*time.Second, nextBackoff(2*time.Second, 3*time.Second, 2))
+	assert.Equal(t, 1*time.Second, nextBackoff(1*time.Second, 0, 0))
+}
+
+func TestJitter(t *testing.T) {
+	assert.Equal(t, 1*time.Second, jitter(1*time.Second, 0))
+	for i := 0; i < 10; i++ {
+		d := jitter(1*time.Second, 0.5)
+		assert.GreaterOrEqual(t, d, 500*time.Millisecond)
+		assert.LessOrEqual(t, d, 1500*time.Millisecond)
+	}
+}
+
+func TestScheduler_applyTransformations_NoTransformers(t *testing.T) {
+	device := &Device{
+		Transforms: []Transformer{},
+	}
+	rctx := &ReadContext{
+		Reading: []*output.Reading{
+			{Value: 2},
+		},
+	}
+
+	err := finalizeReadings(device, rctx)
+	assert.NoError(t, err)
+
+	// Verify that the reading value did not change.
+	assert.Equal(t, 2, rctx.Reading[0].Value.(int))
+
+	// Verify that no additional context was set.
+	assert.Empty(t, rctx.Reading[0].Context)
+}
+
+func TestScheduler_applyTransformations_ScaleTransformerOk(t *testing.T) {
+	device := &Device{
+		Transforms: []Transformer{
+			&ScaleTransformer{Factor: 2},
+		},
+	}
+	rctx := &ReadContext{
+		Reading: []*output.Reading{
+			{Value: 2},
+		},
+	}
+
+	err := finalizeReadings(device, rctx)
+	assert.NoError(t, err)
+
+	// Verify that the reading value changed
+	assert.Equal(t, float64(4), rctx.Reading[0].Value.(float64))
+
+	// Verify that no additional context was set.
+	assert.Empty(t, rctx.Reading[0].Context)
+}
+
+func TestScheduler_applyTransformations_ApplyTransformerOk(t *testing.T) {
+	device := &Device{
+		Transforms: []Transformer{
+			&ApplyTransformer{
+				Func: &funcs.Func{
+					Name: "test-fn-1",
+					Fn: func(value interface{}) (interface{}, error) {
+						return (value.(int)) * 2, nil
+					},
+				},
+			},
+		},
+	}
+	rctx := &ReadContext{
+		Reading: []*output.Reading{
+			{Value: 2},
+		},
+	}
+
+	err := finalizeReadings(device, rctx)
+	assert.NoError(t, err)
+
+	// Verify that the reading value changed.
+	assert.Equal(t, 4, rctx.Reading[0].Value.(int))
+
+	// Verify that no additional context was set.
+	assert.Empty(t, rctx.Reading[0].Context)
+}
+
+func TestScheduler_applyTransformations_ApplyAndScaleTransformerOK(t *testing.T) {
+	device := &Device{
+		Transforms: []Transformer{
+			// First scale
+			&ScaleTransformer{
+				Factor: 2,
+			},
+			// Then apply
+			&ApplyTransformer{
 				Func: &funcs.Func{
 					Name: "test-fn-1",
 					Fn: func(value interface{}) (interface{}, error) {
@@ -1305,6 +1861,658 @@ func TestScheduler_applyTransformations_oneFnOk_withScaleErr(t *testing.T) {
 	assert.Empty(t, rctx.Reading[0].Context)
 }
 
+func TestScheduler_read_ctxDeadline(t *testing.T) {
+	var hasDeadline bool
+	handler := &DeviceHandler{
+		Name: "test",
+		ReadCtx: func(ctx context.Context, device *Device) ([]*output.Reading, error) {
+			_, hasDeadline = ctx.Deadline()
+			return []*output.Reading{{Value: 1}}, nil
+		},
+	}
+
+	s := scheduler{
+		config: &config.PluginSettings{
+			Mode: "parallel",
+			Read: &config.ReadSettings{
+				Interval: 1 * time.Second,
+			},
+		},
+		stateManager: &stateManager{
+			readChan: make(chan *ReadContext, 1),
+		},
+	}
+
+	s.read(&Device{id: "123", handler: handler})
+	assert.True(t, hasDeadline)
+	assert.Len(t, s.stateManager.readChan, 1)
+}
+
+func TestScheduler_read_recordsFailure(t *testing.T) {
+	handler := &DeviceHandler{
+		Name: "test",
+		Read: func(device *Device) ([]*output.Reading, error) {
+			return nil, fmt.Errorf("test error")
+		},
+	}
+
+	s := scheduler{
+		config: &config.PluginSettings{
+			Mode: "parallel",
+			Read: &config.ReadSettings{},
+		},
+		stateManager: &stateManager{
+			readChan: make(chan *ReadContext, 1),
+		},
+	}
+
+	s.read(&Device{id: "123", handler: handler})
+	s.read(&Device{id: "123", handler: handler})
+
+	status, exists := s.stateManager.getReadStatus("123")
+	assert.True(t, exists)
+	assert.Equal(t, 2, status.consecutiveFailures)
+	assert.Empty(t, s.stateManager.readChan)
+}
+
+func TestScheduler_read_breakerOpen(t *testing.T) {
+	var reads int
+	handler := &DeviceHandler{
+		Name: "test",
+		Read: func(device *Device) ([]*output.Reading, error) {
+			reads++
+			return nil, fmt.Errorf("test error")
+		},
+	}
+
+	s := scheduler{
+		config: &config.PluginSettings{
+			Mode: "parallel",
+			Read: &config.ReadSettings{
+				Breaker: &config.CircuitBreakerSettings{
+					Enabled:          true,
+					FailureThreshold: 2,
+					InitialBackoff:   1 * time.Minute,
+				},
+			},
+		},
+		stateManager: &stateManager{
+			readChan: make(chan *ReadContext, 1),
+		},
+	}
+
+	device := &Device{id: "123", handler: handler}
+	for i := 0; i < 5; i++ {
+		s.read(device)
+	}
+
+	// Reads stop once the breaker opens.
+	assert.Equal(t, 2, reads)
+	assert.Equal(t, map[string]string{"123": breakerOpen}, s.breakerStates())
+}
+
+func TestScheduler_read_breakerConcurrencyInterrupted(t *testing.T) {
+	var reads int
+	handler := &DeviceHandler{
+		Name: "test",
+		Read: func(device *Device) ([]*output.Reading, error) {
+			reads++
+			return nil, nil
+		},
+	}
+
+	breakerConfig := &config.CircuitBreakerSettings{
+		Enabled:          true,
+		FailureThreshold: 1,
+		InitialBackoff:   1 * time.Minute,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := scheduler{
+		config: &config.PluginSettings{
+			Mode: "parallel",
+			Read: &config.ReadSettings{
+				Breaker: breakerConfig,
+			},
+		},
+		breakers: map[string]*circuitBreaker{
+			"123": {
+				config:   breakerConfig,
+				state:    breakerOpen,
+				backoff:  1 * time.Minute,
+				openedAt: time.Now().Add(-1 * time.Hour),
+			},
+		},
+		concurrency: newConcurrencyGroups(&config.ConcurrencySettings{GroupKey: "bus", MaxConcurrency: 1}),
+		stateManager: &stateManager{
+			readChan: make(chan *ReadContext, 1),
+		},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	device := &Device{id: "123", handler: handler, Data: map[string]interface{}{"bus": "a"}}
+
+	release, err := s.concurrency.acquire(context.Background(), device)
+	assert.NoError(t, err)
+
+	// A read interrupted waiting on the concurrency group does not take the
+	// breaker's trial read.
+	cancel()
+	s.read(device)
+	assert.Equal(t, 0, reads)
+	assert.Equal(t, map[string]string{"123": breakerOpen}, s.breakerStates())
+
+	// So the trial read can still happen later.
+	release()
+	s.ctx = context.Background()
+	s.read(device)
+	assert.Equal(t, 1, reads)
+	assert.Equal(t, map[string]string{"123": breakerClosed}, s.breakerStates())
+}
+
+func TestScheduler_bulkRead_ctx(t *testing.T) {
+	var called bool
+	handler := &DeviceHandler{
+		Name: "test",
+		BulkReadCtx: func(ctx context.Context, devices []*Device) ([]*ReadContext, error) {
+			called = true
+			return []*ReadContext{
+				NewReadContext(devices[0], []*output.Reading{{Value: 1}}),
+			}, nil
+		},
+	}
+	device := &Device{id: "123", Handler: "test", handler: handler}
+
+	s := scheduler{
+		config: &config.PluginSettings{
+			Mode: "parallel",
+			Read: &config.ReadSettings{},
+		},
+		deviceManager: &deviceManager{
+			devices: map[string]*Device{
+				"123": device,
+			},
+		},
+		stateManager: &stateManager{
+			readChan: make(chan *ReadContext, 1),
+		},
+	}
+
+	s.bulkRead(handler)
+	assert.True(t, called)
+	assert.Len(t, s.stateManager.readChan, 1)
+}
+
+func TestScheduler_bulkRead_breakerConcurrencyInterrupted(t *testing.T) {
+	var reads int
+	handler := &DeviceHandler{
+		Name: "test",
+		BulkRead: func(devices []*Device) ([]*ReadContext, error) {
+			reads++
+			return nil, nil
+		},
+	}
+	device := &Device{id: "123", Handler: "test", handler: handler, Data: map[string]interface{}{"bus": "a"}}
+
+	breakerConfig := &config.CircuitBreakerSettings{
+		Enabled:          true,
+		FailureThreshold: 1,
+		InitialBackoff:   1 * time.Minute,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := scheduler{
+		config: &config.PluginSettings{
+			Mode: "parallel",
+			Read: &config.ReadSettings{
+				Breaker: breakerConfig,
+			},
+		},
+		breakers: map[string]*circuitBreaker{
+			"123": {
+				config:   breakerConfig,
+				state:    breakerOpen,
+				backoff:  1 * time.Minute,
+				openedAt: time.Now().Add(-1 * time.Hour),
+			},
+		},
+		concurrency: newConcurrencyGroups(&config.ConcurrencySettings{GroupKey: "bus", MaxConcurrency: 1}),
+		deviceManager: &deviceManager{
+			devices: map[string]*Device{
+				"123": device,
+			},
+		},
+		stateManager: &stateManager{
+			readChan: make(chan *ReadContext, 1),
+		},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	release, err := s.concurrency.acquire(context.Background(), device)
+	assert.NoError(t, err)
+
+	// A bulk read interrupted waiting on the concurrency groups does not take
+	// the breakers' trial reads.
+	cancel()
+	s.bulkRead(handler)
+	assert.Equal(t, 0, reads)
+	assert.Equal(t, map[string]string{"123": breakerOpen}, s.breakerStates())
+
+	release()
+	s.ctx = context.Background()
+	s.bulkRead(handler)
+	assert.Equal(t, 1, reads)
+	assert.Equal(t, map[string]string{"123": breakerClosed}, s.breakerStates())
+}
+
+func TestScheduler_write_ctxTimeout(t *testing.T) {
+	cancelled := make(chan struct{})
+	handler := &DeviceHandler{
+		Name: "test",
+		WriteCtx: func(ctx context.Context, device *Device, data *WriteData) error {
+			<-ctx.Done()
+			close(cancelled)
+			return ctx.Err()
+		},
+	}
+
+	s := scheduler{
+		config: &config.PluginSettings{
+			Mode:  "parallel",
+			Write: &config.WriteSettings{},
+		},
+	}
+
+	txn := newTransaction(1*time.Second, "")
+	s.write(&WriteContext{
+		transaction: txn,
+		device:      &Device{id: "123", handler: handler, WriteTimeout: 10 * time.Millisecond},
+		data:        &synse.V3WriteData{Action: "test"},
+	})
+
+	assert.Equal(t, synse.WriteStatus_ERROR, txn.status)
+	assert.Equal(t, ErrDeviceWriteTimeout.Error(), txn.message)
+
+	select {
+	case <-cancelled:
+	case <-time.After(1 * time.Second):
+		t.Fatal("write handler context was not cancelled")
+	}
+}
+
+func TestScheduler_write_deviceRemoved(t *testing.T) {
+	called := false
+	handler := &DeviceHandler{
+		Name: "test",
+		Write: func(device *Device, data *WriteData) error {
+			called = true
+			return nil
+		},
+	}
+
+	s := scheduler{
+		config: &config.PluginSettings{
+			Mode:  "parallel",
+			Write: &config.WriteSettings{},
+		},
+	}
+
+	device := &Device{id: "123", handler: handler, WriteTimeout: time.Second}
+	device.markRemoved()
+
+	txn := newTransaction(1*time.Second, "")
+	s.write(&WriteContext{
+		transaction: txn,
+		device:      device,
+		data:        &synse.V3WriteData{Action: "test"},
+	})
+
+	assert.False(t, called)
+	assert.Equal(t, synse.WriteStatus_ERROR, txn.status)
+	assert.Equal(t, ErrDeviceRemoved.Error(), txn.message)
+}
+
+func TestScheduler_enqueue_deviceRemoved(t *testing.T) {
+	s := scheduler{
+		writeChan: make(chan *WriteContext, 1),
+	}
+
+	device := &Device{id: "123"}
+	device.markRemoved()
+
+	txn := newTransaction(1*time.Second, "")
+	err := s.enqueue(&WriteContext{transaction: txn, device: device})
+	assert.Equal(t, ErrDeviceRemoved, err)
+	assert.Equal(t, synse.WriteStatus_ERROR, txn.status)
+	assert.Empty(t, s.writeChan)
+}
+
+func TestScheduler_enqueue_fullQueue(t *testing.T) {
+	s := scheduler{
+		writeChan: make(chan *WriteContext, 1),
+		stop:      make(chan struct{}),
+	}
+	device := &Device{id: "123"}
+	assert.NoError(t, s.enqueue(&WriteContext{transaction: newTransaction(time.Second, ""), device: device}))
+
+	txn := newTransaction(time.Second, "")
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.enqueue(&WriteContext{transaction: txn, device: device})
+	}()
+	time.Sleep(3 * enqueueRetryInterval)
+
+	// The waiting write does not hold the queue lock, so queued writes can still
+	// be removed, and a waiting write is rejected once shutdown begins.
+	assert.Len(t, s.removeQueuedWrites(func(*WriteContext) bool { return false }), 0)
+	s.queueLock.Lock()
+	s.draining = true
+	s.queueLock.Unlock()
+
+	select {
+	case err := <-errs:
+		assert.Equal(t, ErrSchedulerShutdown, err)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for write to be rejected")
+	}
+	assert.Equal(t, synse.WriteStatus_ERROR, txn.status)
+	assert.Len(t, s.writeChan, 1)
+}
+
+func TestScheduler_enqueue_fullQueueStopped(t *testing.T) {
+	s := scheduler{
+		writeChan: make(chan *WriteContext, 1),
+		stop:      make(chan struct{}),
+	}
+	device := &Device{id: "123"}
+	assert.NoError(t, s.enqueue(&WriteContext{transaction: newTransaction(time.Second, ""), device: device}))
+	close(s.stop)
+
+	txn := newTransaction(time.Second, "")
+	err := s.enqueue(&WriteContext{transaction: txn, device: device})
+	assert.Equal(t, ErrSchedulerShutdown, err)
+	assert.Equal(t, synse.WriteStatus_ERROR, txn.status)
+}
+
+func TestScheduler_Write_partialFailure(t *testing.T) {
+	s := &scheduler{
+		stateManager: &stateManager{
+			transactions: cache.New(1*time.Minute, 2*time.Minute),
+		},
+		writeChan: make(chan *WriteContext, 4),
+	}
+	dev := &Device{
+		WriteTimeout: 1 * time.Minute,
+		id:           "test-1",
+		handler: &DeviceHandler{
+			Write: func(device *Device, data *WriteData) error {
+				return nil
+			},
+		},
+	}
+
+	// The second write reuses the transaction ID of the first, so it fails. The
+	// first write was already queued; since its transaction is not returned, it
+	// is cancelled.
+	resp, err := s.Write(dev, []*synse.V3WriteData{
+		{Action: "first", Transaction: "txn-1"},
+		{Action: "second", Transaction: "txn-1"},
+	})
+	assert.Error(t, err)
+	assert.Nil(t, resp)
+
+	txn := s.stateManager.getTransaction("txn-1")
+	assert.True(t, txn.cancelled)
+	assert.Equal(t, ErrWriteCancelled.Error(), txn.message)
+	assert.Empty(t, s.writeChan)
+}
+
+func TestScheduler_WriteAndWait_partialFailure(t *testing.T) {
+	s := &scheduler{
+		stateManager: &stateManager{
+			transactions: cache.New(1*time.Minute, 2*time.Minute),
+		},
+		writeChan: make(chan *WriteContext, 4),
+	}
+	dev := &Device{
+		WriteTimeout: 1 * time.Minute,
+		id:           "test-1",
+		handler: &DeviceHandler{
+			Write: func(device *Device, data *WriteData) error {
+				return nil
+			},
+		},
+	}
+
+	resp, err := s.WriteAndWait(dev, []*synse.V3WriteData{
+		{Action: "first", Transaction: "txn-1"},
+		{Action: "second", Transaction: "txn-1"},
+	})
+	assert.Error(t, err)
+	assert.Nil(t, resp)
+
+	txn := s.stateManager.getTransaction("txn-1")
+	assert.True(t, txn.cancelled)
+	assert.Equal(t, ErrWriteCancelled.Error(), txn.message)
+	assert.Empty(t, s.writeChan)
+}
+
+func TestScheduler_rejectQueuedWrites(t *testing.T) {
+	s := scheduler{
+		writeChan: make(chan *WriteContext, 4),
+	}
+
+	d1 := &Device{id: "123"}
+	d2 := &Device{id: "456"}
+	var txns []*transaction
+	for _, d := range []*Device{d1, d2, d1, d2} {
+		txn := newTransaction(1*time.Second, "")
+		txn.setStatusPending()
+		txns = append(txns, txn)
+		assert.NoError(t, s.enqueue(&WriteContext{transaction: txn, device: d}))
+	}
+
+	s.rejectQueuedWrites("123")
+
+	assert.Equal(t, synse.WriteStatus_ERROR, txns[0].status)
+	assert.Equal(t, ErrDeviceRemoved.Error(), txns[0].message)
+	assert.Equal(t, synse.WriteStatus_ERROR, txns[2].status)
+
+	// Writes for other devices remain queued, in order.
+	assert.Len(t, s.writeChan, 2)
+	assert.Equal(t, txns[1], (<-s.writeChan).transaction)
+	assert.Equal(t, txns[3], (<-s.writeChan).transaction)
+	assert.Equal(t, synse.WriteStatus_PENDING, txns[1].status)
+}
+
+func TestScheduler_cancelTransaction_notFound(t *testing.T) {
+	s := scheduler{
+		stateManager: &stateManager{
+			transactions: cache.New(1*time.Minute, 2*time.Minute),
+		},
+	}
+
+	err := s.cancelTransaction("abc")
+	assert.Equal(t, ErrTransactionNotFound, err)
+}
+
+func TestScheduler_cancelTransaction_complete(t *testing.T) {
+	s := scheduler{
+		stateManager: &stateManager{
+			transactions: cache.New(1*time.Minute, 2*time.Minute),
+		},
+	}
+	txn, err := s.stateManager.newTransaction(time.Second, "abc")
+	assert.NoError(t, err)
+	txn.setStatusDone()
+
+	err = s.cancelTransaction("abc")
+	assert.Equal(t, ErrTransactionComplete, err)
+	assert.Equal(t, synse.WriteStatus_DONE, txn.status)
+	assert.False(t, txn.cancelled)
+}
+
+func TestScheduler_cancelTransaction_queued(t *testing.T) {
+	s := scheduler{
+		writeChan: make(chan *WriteContext, 4),
+		stateManager: &stateManager{
+			transactions: cache.New(1*time.Minute, 2*time.Minute),
+		},
+	}
+
+	device := &Device{id: "123"}
+	var txns []*transaction
+	for _, id := range []string{"a", "b", "c"} {
+		txn, err := s.stateManager.newTransaction(time.Second, id)
+		assert.NoError(t, err)
+		txn.setStatusPending()
+		txns = append(txns, txn)
+		assert.NoError(t, s.enqueue(&WriteContext{transaction: txn, device: device}))
+	}
+
+	err := s.cancelTransaction("b")
+	assert.NoError(t, err)
+	assert.True(t, txns[1].cancelled)
+	assert.True(t, txns[1].isDone())
+	assert.Equal(t, synse.WriteStatus_ERROR, txns[1].status)
+	assert.Equal(t, ErrWriteCancelled.Error(), txns[1].message)
+
+	// Other writes remain queued, in order.
+	assert.Len(t, s.writeChan, 2)
+	assert.Equal(t, txns[0], (<-s.writeChan).transaction)
+	assert.Equal(t, txns[2], (<-s.writeChan).transaction)
+}
+
+func TestScheduler_cancelTransaction_writing(t *testing.T) {
+	started := make(chan struct{})
+	handler := &DeviceHandler{
+		Name: "test",
+		WriteCtx: func(ctx context.Context, device *Device, data *WriteData) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+
+	s := scheduler{
+		config: &config.PluginSettings{
+			Mode:  "parallel",
+			Write: &config.WriteSettings{},
+		},
+		writeChan: make(chan *WriteContext, 1),
+		stateManager: &stateManager{
+			transactions: cache.New(1*time.Minute, 2*time.Minute),
+		},
+	}
+	txn, err := s.stateManager.newTransaction(time.Minute, "abc")
+	assert.NoError(t, err)
+
+	go s.write(&WriteContext{
+		transaction: txn,
+		device:      &Device{id: "123", handler: handler, WriteTimeout: time.Minute},
+		data:        &synse.V3WriteData{Action: "test"},
+	})
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("write was not started")
+	}
+
+	err = s.cancelTransaction("abc")
+	assert.NoError(t, err)
+
+	select {
+	case <-txn.done:
+	case <-time.After(time.Second):
+		t.Fatal("write was not cancelled")
+	}
+	assert.True(t, txn.cancelled)
+	assert.Equal(t, synse.WriteStatus_ERROR, txn.status)
+	assert.Equal(t, ErrWriteCancelled.Error(), txn.message)
+}
+
+func TestScheduler_cancelTransaction_writingNotInterruptible(t *testing.T) {
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	handler := &DeviceHandler{
+		Name: "test",
+		Write: func(device *Device, data *WriteData) error {
+			close(started)
+			<-finish
+			return nil
+		},
+	}
+
+	s := scheduler{
+		config: &config.PluginSettings{
+			Mode:  "parallel",
+			Write: &config.WriteSettings{},
+		},
+		writeChan: make(chan *WriteContext, 1),
+		stateManager: &stateManager{
+			transactions: cache.New(1*time.Minute, 2*time.Minute),
+		},
+	}
+	txn, err := s.stateManager.newTransaction(time.Minute, "abc")
+	assert.NoError(t, err)
+
+	go s.write(&WriteContext{
+		transaction: txn,
+		device:      &Device{id: "123", handler: handler, WriteTimeout: time.Minute},
+		data:        &synse.V3WriteData{Action: "test"},
+	})
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("write was not started")
+	}
+
+	// The handler can not be interrupted, so the write is not cancelled and the
+	// transaction reports the result of the write.
+	err = s.cancelTransaction("abc")
+	assert.Equal(t, ErrWriteInProgress, err)
+
+	close(finish)
+	select {
+	case <-txn.done:
+	case <-time.After(time.Second):
+		t.Fatal("write did not complete")
+	}
+	assert.False(t, txn.cancelled)
+	assert.Equal(t, synse.WriteStatus_DONE, txn.status)
+}
+
+func TestScheduler_write_cancelledBeforeStart(t *testing.T) {
+	called := false
+	handler := &DeviceHandler{
+		Name: "test",
+		Write: func(device *Device, data *WriteData) error {
+			called = true
+			return nil
+		},
+	}
+
+	s := scheduler{
+		config: &config.PluginSettings{
+			Mode:  "parallel",
+			Write: &config.WriteSettings{},
+		},
+	}
+
+	txn := newTransaction(1*time.Second, "")
+	assert.NoError(t, txn.requestCancel())
+	s.write(&WriteContext{
+		transaction: txn,
+		device:      &Device{id: "123", handler: handler, WriteTimeout: time.Second},
+		data:        &synse.V3WriteData{Action: "test"},
+	})
+
+	assert.False(t, called)
+	assert.True(t, txn.cancelled)
+	assert.Equal(t, synse.WriteStatus_ERROR, txn.status)
+}
+
 func TestScheduler_finalizeReadings_withContext(t *testing.T) {
 	device := &Device{
 		Context: map[string]string{"foo": "bar"},
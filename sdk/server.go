@@ -35,6 +35,7 @@ import (
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	grpcMetadata "google.golang.org/grpc/metadata"
 )
 
 const (
@@ -57,6 +58,9 @@ var (
 	ErrSelectorRequiresID  = sdkError.InvalidArgumentErr("selector must specify device id")
 	ErrNoDeviceForSelector = sdkError.NotFoundErr("no device found for specified selector")
 	ErrTransactionNotFound = sdkError.NotFoundErr("transaction not found")
+	ErrTransactionComplete = sdkError.FailedPreconditionErr("transaction has already completed")
+	ErrWriteInProgress     = sdkError.FailedPreconditionErr("write is in progress and can not be interrupted")
+	ErrStreamOverflow      = sdkError.ResourceExhaustedErr("read stream closed: client is not keeping up with readings")
 )
 
 // server implements the Synse Plugin gRPC server. It is used by the
@@ -171,6 +175,31 @@ func (server *server) stop() {
 	}
 }
 
+// gracefulStop stops the gRPC server from accepting new connections and waits
+// for any pending RPCs to complete. If the context expires before the server
+// finishes stopping, the server is stopped immediately, terminating all open
+// connections.
+func (server *server) gracefulStop(ctx context.Context) {
+	log.Info("[server] gracefully stopping")
+	if server.grpc == nil {
+		return
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		server.grpc.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		log.Debug("[server] graceful stop completed")
+	case <-ctx.Done():
+		log.Warn("[server] graceful stop did not complete in time, forcing stop")
+		server.grpc.Stop()
+	}
+}
+
 // teardown the server post-run. This is called as a PluginAction on plugin
 // termination.
 func (server *server) teardown() error {
@@ -397,6 +426,10 @@ func (server *server) Devices(request *synse.V3DeviceSelector, stream synse.V3Pl
 		}
 		d.Outputs = outputs
 
+		// Surface the device's read status (last successful read, consecutive
+		// failures) via the device metadata.
+		d.Metadata = server.stateManager.encodeReadStatus(d.Id, d.Metadata)
+
 		if err := stream.Send(d); err != nil {
 			return err
 		}
@@ -452,6 +485,7 @@ func (server *server) Read(request *synse.V3ReadRequest, stream synse.V3Plugin_R
 
 // ReadCache gets the cached readings from the plugin. If the plugin is not configured
 // to cache its readings, this will return a dump of the entire current readings state.
+// Readings are streamed in time order, oldest first.
 //
 // It is the handler for the Synse gRPC V3Plugin service's `ReadCache` RPC method.
 func (server *server) ReadCache(request *synse.V3Bounds, stream synse.V3Plugin_ReadCacheServer) error {
@@ -485,6 +519,16 @@ func (server *server) ReadCache(request *synse.V3Bounds, stream synse.V3Plugin_R
 }
 
 // ReadStream streams readings to the caller as they are read from the plugin.
+//
+// In addition to the device selectors in the request, the readings streamed can be
+// filtered by reading type or output name, by device tag, and to only readings whose
+// value changed (optionally by at least a deadband). Since the request message only
+// holds selectors, these filters are passed as request metadata; see StreamFilter.
+//
+// Streamed readings carry their sequence number, and the epoch of the plugin run
+// which assigned it, in their context. A client which reconnects can resume the
+// stream after the last reading it received by passing "<epoch>:<sequence>" in the
+// "synse-stream-resume-from" request metadata.
 func (server *server) ReadStream(request *synse.V3StreamRequest, stream synse.V3Plugin_ReadStreamServer) error {
 	log.WithFields(log.Fields{
 		"selectors": request.Selectors,
@@ -517,12 +561,30 @@ func (server *server) ReadStream(request *synse.V3StreamRequest, stream synse.V3
 		filter = append(filter, id)
 	}
 
-	s := newReadStream(filter)
+	// Additional reading filters are passed in the request metadata.
+	md, _ := grpcMetadata.FromIncomingContext(stream.Context())
+	readingFilter, err := newStreamFilterFromMetadata(md)
+	if err != nil {
+		return err
+	}
+
+	resumeFrom, resume, err := resumePointFromMetadata(md)
+	if err != nil {
+		return err
+	}
+
+	s := newReadStream(filter, server.stateManager.streamSettings())
+	s.readingFilter = readingFilter
 	log.WithFields(log.Fields{
-		"id":     s.id,
-		"filter": s.filter,
+		"id":            s.id,
+		"filter":        s.filter,
+		"readingFilter": readingFilter,
 	}).Debug("[server] created new stream for readings")
-	server.stateManager.addStream(s)
+	if resume {
+		server.stateManager.resumeStream(s, resumeFrom)
+	} else {
+		server.stateManager.addStream(s)
+	}
 	defer func() {
 		server.stateManager.removeStream(s.id)
 		s.close()
@@ -539,11 +601,21 @@ func (server *server) ReadStream(request *synse.V3StreamRequest, stream synse.V3
 				reading.DeviceType = device.Type
 				reading.DeviceInfo = device.Info
 			}
+			if r.sequence != 0 {
+				reading.Context = sequenceContext(reading.Context, server.stateManager.epoch, r.sequence)
+			}
 			if err := stream.Send(reading); err != nil {
 				return err
 			}
 		}
 	}
+	if s.isOverflowed() {
+		log.WithFields(log.Fields{
+			"id":      s.id,
+			"dropped": s.Dropped(),
+		}).Warn("[server] read stream closed: client not keeping up with readings")
+		return ErrStreamOverflow
+	}
 	log.Info("[server] done streaming readings")
 	return nil
 }
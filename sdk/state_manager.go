@@ -18,6 +18,7 @@ package sdk
 
 import (
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
@@ -30,22 +31,79 @@ import (
 	"github.com/vapor-ware/synse-sdk/v2/sdk/utils"
 )
 
+const (
+	// staleActionFlag is the stale action which marks stale readings via their
+	// reading context.
+	staleActionFlag = "flag"
+
+	// staleActionDrop is the stale action which drops stale readings, so they
+	// are no longer returned.
+	staleActionDrop = "drop"
+)
+
+// deviceReadStatus tracks the outcome of the reads for a single device.
+type deviceReadStatus struct {
+	// lastSuccess is the time at which readings were last received for the device.
+	lastSuccess time.Time
+
+	// consecutiveFailures is the number of reads which have failed since the
+	// last successful read.
+	consecutiveFailures int
+
+	// lastError is the error from the most recent failed read.
+	lastError error
+}
+
 // stateManager manages the read and write (transaction) state for plugin devices.
 type stateManager struct {
 	config        *config.PluginSettings
 	deviceManager *deviceManager
 	readChan      chan *ReadContext
 	readings      map[string][]*output.Reading
-	readingsCache *cache.Cache
+	readingsCache ReadingsCache
 	readingsLock  *sync.RWMutex
 	transactions  *cache.Cache
 
+	// journal records write transaction state to disk so it is retained across
+	// plugin restarts. It is nil if the transaction journal is not enabled.
+	journal *transactionJournal
+
+	// aggregator computes aggregates of device readings over windows of time.
+	// It is nil if aggregation is not enabled.
+	aggregator *aggregator
+
 	streams    map[uuid.UUID]*ReadStream
 	streamLock *sync.Mutex
+
+	// sequence is the sequence number of the latest reading set processed by
+	// the state manager. It is accessed atomically.
+	sequence uint64
+
+	// epoch identifies the run of the plugin which assigned the sequence numbers.
+	// Sequence numbers start again from 0 when the plugin restarts, so a stream
+	// can only be resumed from a sequence number assigned in the same epoch.
+	epoch string
+
+	// replay holds the most recently processed reading sets, so streams can
+	// be resumed. It is nil if the replay buffer is disabled.
+	replay *replayBuffer
+
+	// readStatuses tracks the last successful read and consecutive read failures
+	// for each device, keyed by device ID.
+	readStatuses map[string]*deviceReadStatus
+	statusLock   sync.RWMutex
+
+	// events is the bus which write transaction events are published to.
+	events *eventBus
+
+	// stop is a channel used to signal that the state manager should stop
+	// processing readings. This is generally used for graceful shutdown.
+	stop chan struct{}
 }
 
-// newStateManager creates a new instance of the stateManager.
-func newStateManager(conf *config.PluginSettings, deviceManager *deviceManager) *stateManager {
+// newStateManager creates a new instance of the stateManager. An error is returned
+// if the readings cache could not be created from the cache configuration.
+func newStateManager(conf *config.PluginSettings, deviceManager *deviceManager) (*stateManager, error) {
 	if conf == nil {
 		panic("state manager requires a non-nil config")
 	}
@@ -53,10 +111,29 @@ func newStateManager(conf *config.PluginSettings, deviceManager *deviceManager)
 		panic("state manager requires a non-nil device manager")
 	}
 
-	var readingsCache *cache.Cache
+	var readingsCache ReadingsCache
 	if conf.Cache.Enabled {
-		log.WithField("ttl", conf.Cache.TTL).Debug("[state manager] readings cache enabled")
-		readingsCache = cache.New(conf.Cache.TTL, conf.Cache.TTL*2)
+		log.WithFields(log.Fields{
+			"ttl":     conf.Cache.TTL,
+			"backend": conf.Cache.Backend,
+		}).Debug("[state manager] readings cache enabled")
+
+		var err error
+		readingsCache, err = newReadingsCache(conf.Cache, deviceManager.GetDevice)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var replay *replayBuffer
+	if conf.Stream != nil {
+		replay = newReplayBuffer(conf.Stream.ReplayBufferSize)
+	}
+
+	var journal *transactionJournal
+	if conf.Transaction.Journal {
+		log.WithField("path", conf.Transaction.JournalPath).Debug("[state manager] transaction journal enabled")
+		journal = newTransactionJournal(conf.Transaction)
 	}
 
 	return &stateManager{
@@ -68,11 +145,17 @@ func newStateManager(conf *config.PluginSettings, deviceManager *deviceManager)
 			conf.Transaction.TTL,
 			conf.Transaction.TTL*2,
 		),
+		journal:       journal,
 		readingsCache: readingsCache,
 		readingsLock:  &sync.RWMutex{},
+		aggregator:    newAggregator(conf.Aggregation),
 		streams:       make(map[uuid.UUID]*ReadStream),
 		streamLock:    &sync.Mutex{},
-	}
+		replay:        replay,
+		readStatuses:  make(map[string]*deviceReadStatus),
+		epoch:         newEpoch(),
+		stop:          make(chan struct{}),
+	}, nil
 }
 
 // Start starts the StateManager.
@@ -81,6 +164,26 @@ func (manager *stateManager) Start() {
 	go manager.updateReadings()
 }
 
+// Stop stops the StateManager.
+//
+// This closes all streams which are connected to the state manager, allowing any
+// active ReadStream requests to terminate, stops processing incoming readings, and
+// closes the readings cache. It is safe to call more than once.
+func (manager *stateManager) Stop() {
+	log.Info("[state manager] stopping")
+
+	select {
+	case <-manager.stop:
+		// already stopped
+		return
+	default:
+		close(manager.stop)
+	}
+	manager.closeStreams()
+	manager.closeCache()
+	manager.closeJournal()
+}
+
 // addStream adds a new stream for the stateManager to send reading data to.
 func (manager *stateManager) addStream(stream *ReadStream) {
 	log.WithField("id", stream.id).Debug("[state manager] adding stream")
@@ -99,6 +202,27 @@ func (manager *stateManager) removeStream(id uuid.UUID) {
 	delete(manager.streams, id)
 }
 
+// streamCount gets the number of streams which the stateManager is sending data to.
+func (manager *stateManager) streamCount() int {
+	manager.streamLock.Lock()
+	defer manager.streamLock.Unlock()
+
+	return len(manager.streams)
+}
+
+// closeStreams closes and removes all streams which the stateManager is sending
+// data to.
+func (manager *stateManager) closeStreams() {
+	manager.streamLock.Lock()
+	defer manager.streamLock.Unlock()
+
+	for id, stream := range manager.streams {
+		log.WithField("id", id).Debug("[state manager] closing stream")
+		stream.close()
+		delete(manager.streams, id)
+	}
+}
+
 // registerActions registers pre-run (setup) and post-run (teardown) actions
 // for the state manager.
 func (manager *stateManager) registerActions(plugin *Plugin) {
@@ -109,6 +233,86 @@ func (manager *stateManager) registerActions(plugin *Plugin) {
 			Action: manager.healthChecks,
 		},
 	)
+
+	if manager.cacheEnabled() {
+		plugin.RegisterPreRunActions(
+			&PluginAction{
+				Name:   "Open readings cache",
+				Action: func(p *Plugin) error { return manager.openCache() },
+			},
+		)
+	}
+
+	if manager.journal != nil {
+		plugin.RegisterPreRunActions(
+			&PluginAction{
+				Name:   "Open transaction journal",
+				Action: func(p *Plugin) error { return manager.openJournal() },
+			},
+		)
+	}
+}
+
+// cacheEnabled checks whether the state manager has a readings cache enabled.
+func (manager *stateManager) cacheEnabled() bool {
+	return manager.config != nil &&
+		manager.config.Cache != nil &&
+		manager.config.Cache.Enabled &&
+		manager.readingsCache != nil
+}
+
+// openCache opens the readings cache, if caching is enabled.
+func (manager *stateManager) openCache() error {
+	if !manager.cacheEnabled() {
+		return nil
+	}
+	return manager.readingsCache.Open()
+}
+
+// closeCache closes the readings cache, if caching is enabled.
+func (manager *stateManager) closeCache() {
+	if !manager.cacheEnabled() {
+		return
+	}
+	if err := manager.readingsCache.Close(); err != nil {
+		log.WithField("error", err).Error("[state manager] failed to close readings cache")
+	}
+}
+
+// openJournal opens the transaction journal, if enabled, and restores the journaled
+// transactions to the transaction cache. Restored transactions expire from the cache
+// once the transaction TTL has elapsed since they were last updated.
+func (manager *stateManager) openJournal() error {
+	if manager.journal == nil {
+		return nil
+	}
+	records, err := manager.journal.Open()
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		expiration := cache.DefaultExpiration
+		if manager.journal.ttl > 0 {
+			expiration = manager.journal.ttl - time.Since(record.Recorded)
+			if expiration <= 0 {
+				continue
+			}
+		}
+		manager.transactions.Set(record.ID, record.transaction(), expiration)
+	}
+	log.WithField("count", len(records)).Info("[state manager] restored transactions from journal")
+	return nil
+}
+
+// closeJournal closes the transaction journal, if enabled.
+func (manager *stateManager) closeJournal() {
+	if manager.journal == nil {
+		return
+	}
+	if err := manager.journal.Close(); err != nil {
+		log.WithField("error", err).Error("[state manager] failed to close transaction journal")
+	}
 }
 
 // healthChecks defines and registers the state manager's default health checks with
@@ -129,11 +333,35 @@ func (manager *stateManager) healthChecks(plugin *Plugin) error {
 	return nil
 }
 
+// submitReadings queues a reading set for the state manager to process. If the
+// state manager is stopped before the reading set is queued, it is discarded, so
+// reads which are in-flight when the state manager stops do not block forever.
+func (manager *stateManager) submitReadings(reading *ReadContext) {
+	select {
+	case manager.readChan <- reading:
+	case <-manager.stop:
+		log.WithField("device", reading.Device.GetID()).Debug("[state manager] stopped, discarding readings")
+	}
+}
+
 func (manager *stateManager) updateReadings() {
 	// todo: figure out how to test this...
 	for {
-		// Read from the read channel for incoming readings.
-		reading := <-manager.readChan
+		// Read from the read channel for incoming readings. If the state manager
+		// was stopped, terminate.
+		var reading *ReadContext
+		select {
+		case <-manager.stop:
+			log.Info("[state manager] stop channel closed, terminating updateReadings")
+			return
+		case reading = <-manager.readChan:
+		}
+		// If the device was removed while it was being read, discard its readings.
+		if reading.Device.IsRemoved() {
+			log.WithField("device", reading.Device.id).Debug("[state manager] discarding readings for removed device")
+			continue
+		}
+
 		id := reading.Device.id
 		readings := reading.Reading
 
@@ -141,55 +369,209 @@ func (manager *stateManager) updateReadings() {
 		manager.readingsLock.Lock()
 		manager.readings[id] = readings
 		manager.readingsLock.Unlock()
+		manager.recordReadSuccess(id)
 
-		// Dispatch the reading to all connected streams.
+		// Sequence the reading and retain it so streams can be resumed, then
+		// dispatch it to all connected streams.
+		manager.nextSequence(reading)
+		manager.replay.add(reading)
 		manager.dispatchToStreams(reading)
 
 		// Update the local readings cache, if enabled.
 		manager.addReadingToCache(reading)
+
+		// Update the reading aggregates, if enabled.
+		manager.addReadingToAggregates(reading)
+	}
+}
+
+// removeDevice removes the current reading state and read status for the device
+// with the given ID, along with any reading aggregates for it.
+func (manager *stateManager) removeDevice(id string) {
+	manager.readingsLock.Lock()
+	delete(manager.readings, id)
+	manager.readingsLock.Unlock()
+
+	manager.statusLock.Lock()
+	delete(manager.readStatuses, id)
+	manager.statusLock.Unlock()
+
+	if manager.aggregator != nil {
+		manager.aggregator.removeDevice(id)
 	}
 }
 
 // dispatchToStreams dispatches the given reading to all streams currently
-// connected to the state manager.
+// connected to the state manager. Dispatch does not block; streams which are
+// not keeping up with readings apply their overflow policy.
 func (manager *stateManager) dispatchToStreams(reading *ReadContext) {
 	manager.streamLock.Lock()
 	defer manager.streamLock.Unlock()
 
 	for _, stream := range manager.streams {
-		stream.stream <- reading
+		stream.dispatch(reading)
 	}
 }
 
+// streamSettings gets the settings for new read streams. If the state manager
+// has no stream settings, nil is returned and streams use the defaults.
+func (manager *stateManager) streamSettings() *config.StreamSettings {
+	if manager.config == nil {
+		return nil
+	}
+	return manager.config.Stream
+}
+
 // addReadingToCache adds the given reading to the readingsCache, if the plugin
 // is configured to enable read caching.
 func (manager *stateManager) addReadingToCache(ctx *ReadContext) {
 	if manager.config.Cache.Enabled {
-		now := utils.GetCurrentTime()
-		item, exists := manager.readingsCache.Get(now)
-		if !exists {
-			newCtxs := []*ReadContext{ctx}
-			manager.readingsCache.Set(now, &newCtxs, cache.DefaultExpiration)
-		} else {
-			cached := item.(*[]*ReadContext)
-			*cached = append(*cached, ctx)
+		if err := manager.readingsCache.Put(ctx); err != nil {
+			log.WithField("error", err).Error("[state manager] failed to add reading to cache")
+		}
+	}
+}
+
+// recordReadSuccess records a successful read for the specified device, resetting
+// its consecutive failure count.
+func (manager *stateManager) recordReadSuccess(device string) {
+	manager.statusLock.Lock()
+	defer manager.statusLock.Unlock()
+
+	if manager.readStatuses == nil {
+		manager.readStatuses = make(map[string]*deviceReadStatus)
+	}
+	manager.readStatuses[device] = &deviceReadStatus{
+		lastSuccess: time.Now(),
+	}
+}
+
+// recordReadFailure records a failed read for the specified device.
+func (manager *stateManager) recordReadFailure(device string, err error) {
+	manager.statusLock.Lock()
+	defer manager.statusLock.Unlock()
+
+	if manager.readStatuses == nil {
+		manager.readStatuses = make(map[string]*deviceReadStatus)
+	}
+	status, exists := manager.readStatuses[device]
+	if !exists {
+		status = &deviceReadStatus{}
+		manager.readStatuses[device] = status
+	}
+	status.consecutiveFailures++
+	status.lastError = err
+}
+
+// getReadStatus gets a copy of the read status for the specified device. If no
+// reads have been recorded for the device, false is returned.
+func (manager *stateManager) getReadStatus(device string) (deviceReadStatus, bool) {
+	manager.statusLock.RLock()
+	defer manager.statusLock.RUnlock()
+
+	status, exists := manager.readStatuses[device]
+	if !exists {
+		return deviceReadStatus{}, false
+	}
+	return *status, true
+}
+
+// encodeReadStatus adds the read status for the specified device to a copy of the
+// given device metadata, so it can be surfaced via the Devices RPC.
+func (manager *stateManager) encodeReadStatus(device string, metadata map[string]string) map[string]string {
+	status, exists := manager.getReadStatus(device)
+	if !exists {
+		return metadata
+	}
+
+	md := make(map[string]string, len(metadata)+2)
+	for k, v := range metadata {
+		md[k] = v
+	}
+	if !status.lastSuccess.IsZero() {
+		md["last_successful_read"] = status.lastSuccess.UTC().Format(time.RFC3339)
+	}
+	md["consecutive_read_failures"] = strconv.Itoa(status.consecutiveFailures)
+	return md
+}
+
+// maxReadingAge gets the max age for the current readings of the specified device.
+// A zero value means that the device's readings do not go stale.
+func (manager *stateManager) maxReadingAge(device string) time.Duration {
+	if manager.deviceManager != nil {
+		if d := manager.deviceManager.GetDevice(device); d != nil && d.MaxReadingAge > 0 {
+			return d.MaxReadingAge
 		}
 	}
+	if manager.config != nil && manager.config.Read != nil {
+		return manager.config.Read.MaxAge
+	}
+	return 0
+}
+
+// staleAction gets the configured stale action. If there is no read config, stale
+// readings are flagged.
+func (manager *stateManager) staleAction() string {
+	if manager.config == nil || manager.config.Read == nil || manager.config.Read.StaleAction == "" {
+		return staleActionFlag
+	}
+	return manager.config.Read.StaleAction
+}
+
+// checkStale applies the configured stale action to the given readings for the
+// specified device if the readings are older than the device's max reading age.
+// Stale readings are either dropped (nil is returned) or copied and flagged in
+// their reading context; the stored readings are never modified.
+func (manager *stateManager) checkStale(device string, readings []*output.Reading) []*output.Reading {
+	maxAge := manager.maxReadingAge(device)
+	if maxAge <= 0 || len(readings) == 0 {
+		return readings
+	}
+
+	status, exists := manager.getReadStatus(device)
+	if !exists || status.lastSuccess.IsZero() || time.Since(status.lastSuccess) <= maxAge {
+		return readings
+	}
+
+	if manager.staleAction() == staleActionDrop {
+		log.WithFields(log.Fields{
+			"device": device,
+			"maxAge": maxAge,
+		}).Debug("[state manager] dropping stale readings")
+		return nil
+	}
+
+	flagged := make([]*output.Reading, len(readings))
+	for i, r := range readings {
+		reading := *r
+		reading.Context = nil
+		reading.WithContext(r.Context).WithContext(map[string]string{
+			"stale": "true",
+		})
+		flagged[i] = &reading
+	}
+	return flagged
 }
 
 // GetReadingsForDevice gets the current reading(s) for the specified device from
-// the StateManager.
+// the StateManager. If the readings are stale, the configured stale action is
+// applied to them.
 func (manager *stateManager) GetReadingsForDevice(device string) []*output.Reading {
 	manager.readingsLock.RLock()
-	defer manager.readingsLock.RUnlock()
+	readings := manager.readings[device]
+	manager.readingsLock.RUnlock()
 
-	return manager.readings[device]
+	return manager.checkStale(device, readings)
 }
 
 // GetOutputsForDevice gets the outputs for a device, based on the outputs associated
 // with any readings collected for the device.
 func (manager *stateManager) GetOutputsForDevice(device string) []*output.Output {
-	readings := manager.GetReadingsForDevice(device)
+	// Stale readings still describe the device's outputs, so use the stored readings
+	// directly rather than the stale-checked readings.
+	manager.readingsLock.RLock()
+	readings := manager.readings[device]
+	manager.readingsLock.RUnlock()
 
 	var outputs []*output.Output
 	for _, r := range readings {
@@ -201,10 +583,11 @@ func (manager *stateManager) GetOutputsForDevice(device string) []*output.Output
 	return outputs
 }
 
-// GetCachedReadings gets the readings in the StateManager's readingsCache. If the plugin
-// is not configured to maintain a readings cache, this will just return a dump of the
-// current reading state. Once the data has been passed through the given channel, this
-// function will close the channel prior to returning.
+// GetCachedReadings gets the readings in the StateManager's readingsCache, in the
+// order they were cached. If the plugin is not configured to maintain a readings
+// cache, this will just return a dump of the current reading state. Once the data
+// has been passed through the given channel, this function will close the channel
+// prior to returning.
 func (manager *stateManager) GetCachedReadings(start, end string, readings chan *ReadContext) {
 	// Whether we exit the function normally or by error, we want to close the channel
 	// when we complete to signal to the reader that we are done here.
@@ -230,45 +613,18 @@ func (manager *stateManager) GetCachedReadings(start, end string, readings chan
 
 	// If read caching is disabled, dump the current state; otherwise, dump the
 	// reading cache contents.
-	if manager.config.Cache.Enabled {
+	if manager.cacheEnabled() {
 		manager.dumpCachedReadings(startTime, endTime, readings)
 	} else {
 		manager.dumpCurrentReadings(readings)
 	}
-
 }
 
-// dumpCachedReadings dumps the cached reading
+// dumpCachedReadings dumps the cached readings within the given bounds out to
+// the provided channel.
 func (manager *stateManager) dumpCachedReadings(start, end time.Time, readings chan *ReadContext) {
-	for timestamp, item := range manager.readingsCache.Items() {
-		ts, err := utils.ParseRFC3339(timestamp)
-		if err != nil {
-			// If we can't parse the timestamp from the cache, an error is logged
-			// and we move on. We should always be using RFC3339 formatted timestamps
-			// as keys when things get inserted, so if we find something in there that
-			// does not conform, it means something is wrong and we should not use it
-			// (data corruption, something added incorrectly, ...)
-			log.Error("[cache] failed to parse RFC3339 timestamp from cache - ignoring")
-			continue
-		}
-
-		// If we have a start bound, check that the cached items are
-		// within that bound. If not, ignore them.
-		if !start.IsZero() && ts.Before(start) {
-			continue
-		}
-
-		// If we have an end bound, check that the cached items are
-		// within that bound. If not, ignore them.
-		if !end.IsZero() && ts.After(end) {
-			continue
-		}
-
-		// Pass the read contexts to the channel
-		ctxs := item.Object.(*[]*ReadContext)
-		for _, ctx := range *ctxs {
-			readings <- ctx
-		}
+	if err := manager.readingsCache.Query(start, end, readings); err != nil {
+		log.WithField("error", err).Error("[state manager] failed to get cached readings")
 	}
 }
 
@@ -284,11 +640,11 @@ func (manager *stateManager) dumpCurrentReadings(readings chan *ReadContext) {
 }
 
 // GetReadings gets a copy of the entire current readings state in the
-// StateManager as a map with the device id as the key.
+// StateManager as a map with the device id as the key. If any readings
+// are stale, the configured stale action is applied to them.
 func (manager *stateManager) GetReadings() map[string][]*output.Reading {
 	readings := make(map[string][]*output.Reading)
 	manager.readingsLock.RLock()
-	defer manager.readingsLock.RUnlock()
 
 	// Iterate over the StateManager's readings map to make a copy of it.
 	// We want a copy since the underlying data should only be accessed
@@ -296,6 +652,18 @@ func (manager *stateManager) GetReadings() map[string][]*output.Reading {
 	for k, v := range manager.readings {
 		readings[k] = v
 	}
+	manager.readingsLock.RUnlock()
+
+	// Apply the stale action to any stale readings. Dropped readings are
+	// removed from the returned state entirely.
+	for k, v := range readings {
+		r := manager.checkStale(k, v)
+		if r == nil && len(v) > 0 {
+			delete(readings, k)
+		} else {
+			readings[k] = r
+		}
+	}
 	return readings
 }
 
@@ -306,6 +674,8 @@ func (manager *stateManager) newTransaction(timeout time.Duration, customID stri
 	if exists {
 		return nil, fmt.Errorf("transaction with ID %s already exists", t.id)
 	}
+	t.events = manager.events
+	t.journal = manager.journal
 	manager.transactions.Set(t.id, t, cache.DefaultExpiration)
 	return t, nil
 }
@@ -17,10 +17,12 @@
 package sdk
 
 import (
+	"fmt"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/patrickmn/go-cache"
 	"github.com/stretchr/testify/assert"
 	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
@@ -28,11 +30,27 @@ import (
 	"github.com/vapor-ware/synse-sdk/v2/sdk/output"
 )
 
+// newTestStateManager creates a state manager for the given device manager with
+// its maps and locks initialized. Tests set up any other components they use.
+func newTestStateManager(devices *deviceManager) *stateManager {
+	return &stateManager{
+		config:        &config.PluginSettings{},
+		deviceManager: devices,
+		readings:      map[string][]*output.Reading{},
+		readingsLock:  &sync.RWMutex{},
+		transactions:  cache.New(time.Minute, 2*time.Minute),
+		streams:       map[uuid.UUID]*ReadStream{},
+		streamLock:    &sync.Mutex{},
+		epoch:         "test",
+		readStatuses:  map[string]*deviceReadStatus{},
+	}
+}
+
 func Test_newStateManager_nilConfig(t *testing.T) {
 	deviceManager := deviceManager{}
 
 	assert.Panics(t, func() {
-		newStateManager(nil, &deviceManager)
+		_, _ = newStateManager(nil, &deviceManager)
 	})
 }
 
@@ -55,9 +73,23 @@ func Test_newStateManager_nilDeviceManager(t *testing.T) {
 	}
 
 	assert.Panics(t, func() {
-		newStateManager(&conf, nil)
+		_, _ = newStateManager(&conf, nil)
 	})
 }
+func Test_newStateManager_badCacheBackend(t *testing.T) {
+	conf := config.PluginSettings{
+		Cache: &config.CacheSettings{
+			Enabled: true,
+			Backend: "dsik",
+			TTL:     5 * time.Minute,
+		},
+	}
+
+	sm, err := newStateManager(&conf, &deviceManager{})
+	assert.Error(t, err)
+	assert.Nil(t, sm)
+}
+
 func Test_newStateManager(t *testing.T) {
 	// Create plugin settings.
 	conf := config.PluginSettings{
@@ -97,13 +129,15 @@ func Test_newStateManager(t *testing.T) {
 		},
 	}
 
-	sm := newStateManager(&conf, &deviceManager)
+	sm, err := newStateManager(&conf, &deviceManager)
+	assert.NoError(t, err)
 
 	assert.Equal(t, &conf, sm.config)
 	assert.Equal(t, 100, cap(sm.readChan))
 	assert.Empty(t, sm.readings)
 	assert.NotNil(t, sm.transactions)
 	assert.NotNil(t, sm.readingsCache)
+	assert.NotEmpty(t, sm.epoch)
 
 	assert.Equal(t, &deviceManager, sm.deviceManager)
 }
@@ -133,6 +167,77 @@ func TestStateManager_healthChecks(t *testing.T) {
 	assert.Equal(t, plugin.health.Count(), 1)
 }
 
+func TestStateManager_dispatchToStreams_slowStream(t *testing.T) {
+	slow := newReadStream(nil, &config.StreamSettings{BufferSize: 1})
+	fast := newReadStream(nil, &config.StreamSettings{BufferSize: 8})
+
+	sm := stateManager{
+		streams: map[uuid.UUID]*ReadStream{
+			slow.id: slow,
+			fast.id: fast,
+		},
+		streamLock: &sync.Mutex{},
+	}
+
+	for i := 0; i < 4; i++ {
+		sm.dispatchToStreams(&ReadContext{Device: &Device{id: "123"}})
+	}
+
+	assert.Len(t, slow.stream, 1)
+	assert.Equal(t, uint64(3), slow.Dropped())
+	assert.Len(t, fast.stream, 4)
+	assert.Equal(t, uint64(0), fast.Dropped())
+}
+
+func TestStateManager_Stop(t *testing.T) {
+	s1 := newReadStream(nil, nil)
+	s2 := newReadStream(nil, nil)
+
+	sm := stateManager{
+		streams: map[uuid.UUID]*ReadStream{
+			s1.id: s1,
+			s2.id: s2,
+		},
+		streamLock: &sync.Mutex{},
+		stop:       make(chan struct{}),
+	}
+
+	sm.Stop()
+	assert.Empty(t, sm.streams)
+	assert.True(t, s1.closed)
+	assert.True(t, s2.closed)
+
+	_, isOpen := <-sm.stop
+	assert.False(t, isOpen)
+
+	// Stopping again should have no effect.
+	assert.NotPanics(t, sm.Stop)
+}
+
+func TestStateManager_submitReadings(t *testing.T) {
+	sm := stateManager{
+		readChan: make(chan *ReadContext, 1),
+		stop:     make(chan struct{}),
+	}
+
+	r := &ReadContext{Device: &Device{id: "123"}}
+	sm.submitReadings(r)
+	assert.Equal(t, r, <-sm.readChan)
+}
+
+func TestStateManager_submitReadings_stopped(t *testing.T) {
+	sm := stateManager{
+		readChan: make(chan *ReadContext),
+		stop:     make(chan struct{}),
+	}
+	close(sm.stop)
+
+	// Nothing is receiving readings, but submitting should not block once the
+	// state manager is stopped.
+	sm.submitReadings(&ReadContext{Device: &Device{id: "123"}})
+	assert.Empty(t, sm.readChan)
+}
+
 func TestStateManager_addReadingToCache_cacheDisabled(t *testing.T) {
 	sm := stateManager{
 		config: &config.PluginSettings{
@@ -140,10 +245,10 @@ func TestStateManager_addReadingToCache_cacheDisabled(t *testing.T) {
 				Enabled: false,
 			},
 		},
-		readingsCache: cache.New(1*time.Minute, 2*time.Minute),
+		readingsCache: newMemoryCache(&config.CacheSettings{TTL: time.Minute}),
 	}
 
-	assert.Equal(t, 0, sm.readingsCache.ItemCount())
+	assert.Equal(t, 0, sm.readingsCache.(*memoryCache).entries())
 
 	sm.addReadingToCache(&ReadContext{
 		Device: &Device{
@@ -152,10 +257,7 @@ func TestStateManager_addReadingToCache_cacheDisabled(t *testing.T) {
 		Reading: []*output.Reading{{Value: 1}},
 	})
 
-	assert.Equal(t, 0, sm.readingsCache.ItemCount())
-
-	_, exists := sm.readingsCache.Get("test-1")
-	assert.False(t, exists)
+	assert.Equal(t, 0, sm.readingsCache.(*memoryCache).entries())
 }
 
 func TestStateManager_addReadingToCache_new(t *testing.T) {
@@ -165,10 +267,10 @@ func TestStateManager_addReadingToCache_new(t *testing.T) {
 				Enabled: true,
 			},
 		},
-		readingsCache: cache.New(1*time.Minute, 2*time.Minute),
+		readingsCache: newMemoryCache(&config.CacheSettings{TTL: time.Minute}),
 	}
 
-	assert.Equal(t, 0, sm.readingsCache.ItemCount())
+	assert.Equal(t, 0, sm.readingsCache.(*memoryCache).entries())
 
 	sm.addReadingToCache(&ReadContext{
 		Device: &Device{
@@ -177,7 +279,7 @@ func TestStateManager_addReadingToCache_new(t *testing.T) {
 		Reading: []*output.Reading{{Value: 1}},
 	})
 
-	assert.Equal(t, 1, sm.readingsCache.ItemCount())
+	assert.Equal(t, 1, sm.readingsCache.(*memoryCache).entries())
 }
 
 func TestStateManager_addReadingToCache_twoReadings(t *testing.T) {
@@ -187,10 +289,10 @@ func TestStateManager_addReadingToCache_twoReadings(t *testing.T) {
 				Enabled: true,
 			},
 		},
-		readingsCache: cache.New(1*time.Minute, 2*time.Minute),
+		readingsCache: newMemoryCache(&config.CacheSettings{TTL: time.Minute}),
 	}
 
-	assert.Equal(t, 0, sm.readingsCache.ItemCount())
+	assert.Equal(t, 0, sm.readingsCache.(*memoryCache).entries())
 
 	// Add first reading
 	sm.addReadingToCache(&ReadContext{
@@ -200,7 +302,7 @@ func TestStateManager_addReadingToCache_twoReadings(t *testing.T) {
 		Reading: []*output.Reading{{Value: 1}},
 	})
 
-	assert.Equal(t, 1, sm.readingsCache.ItemCount())
+	assert.Equal(t, 1, sm.readingsCache.(*memoryCache).entries())
 
 	// Add second reading for the device
 	sm.addReadingToCache(&ReadContext{
@@ -210,7 +312,7 @@ func TestStateManager_addReadingToCache_twoReadings(t *testing.T) {
 		Reading: []*output.Reading{{Value: 1}},
 	})
 
-	assert.Equal(t, 1, sm.readingsCache.ItemCount())
+	assert.Equal(t, 2, sm.readingsCache.(*memoryCache).entries())
 }
 
 func TestStateManager_GetReadingsForDevice_noDevice(t *testing.T) {
@@ -236,6 +338,150 @@ func TestStateManager_GetReadingsForDevice_deviceExists(t *testing.T) {
 	assert.Len(t, res, 1)
 }
 
+func TestStateManager_GetReadingsForDevice_staleFlagged(t *testing.T) {
+	sm := stateManager{
+		config: &config.PluginSettings{
+			Read: &config.ReadSettings{
+				MaxAge:      1 * time.Minute,
+				StaleAction: staleActionFlag,
+			},
+		},
+		readingsLock: &sync.RWMutex{},
+		readings: map[string][]*output.Reading{
+			"foo": {{Value: 1, Context: map[string]string{"a": "b"}}},
+		},
+		readStatuses: map[string]*deviceReadStatus{
+			"foo": {lastSuccess: time.Now().Add(-2 * time.Minute)},
+		},
+	}
+
+	res := sm.GetReadingsForDevice("foo")
+	assert.Len(t, res, 1)
+	assert.Equal(t, map[string]string{"a": "b", "stale": "true"}, res[0].Context)
+
+	// The stored reading should not be modified.
+	assert.Equal(t, map[string]string{"a": "b"}, sm.readings["foo"][0].Context)
+}
+
+func TestStateManager_GetReadingsForDevice_staleNoReadConfig(t *testing.T) {
+	sm := stateManager{
+		config: &config.PluginSettings{},
+		deviceManager: &deviceManager{
+			devices: map[string]*Device{
+				"foo": {id: "foo", MaxReadingAge: 1 * time.Minute},
+			},
+		},
+		readingsLock: &sync.RWMutex{},
+		readings: map[string][]*output.Reading{
+			"foo": {{Value: 1}},
+		},
+		readStatuses: map[string]*deviceReadStatus{
+			"foo": {lastSuccess: time.Now().Add(-2 * time.Minute)},
+		},
+	}
+
+	// Without a read config, the device's stale readings are flagged.
+	res := sm.GetReadingsForDevice("foo")
+	assert.Len(t, res, 1)
+	assert.Equal(t, map[string]string{"stale": "true"}, res[0].Context)
+}
+
+func TestStateManager_GetReadingsForDevice_staleDropped(t *testing.T) {
+	sm := stateManager{
+		config: &config.PluginSettings{
+			Read: &config.ReadSettings{
+				MaxAge:      1 * time.Minute,
+				StaleAction: staleActionDrop,
+			},
+		},
+		readingsLock: &sync.RWMutex{},
+		readings: map[string][]*output.Reading{
+			"foo": {{Value: 1}},
+			"bar": {{Value: 2}},
+		},
+		readStatuses: map[string]*deviceReadStatus{
+			"foo": {lastSuccess: time.Now().Add(-2 * time.Minute)},
+			"bar": {lastSuccess: time.Now()},
+		},
+	}
+
+	assert.Nil(t, sm.GetReadingsForDevice("foo"))
+	assert.Len(t, sm.GetReadingsForDevice("bar"), 1)
+
+	readings := sm.GetReadings()
+	assert.Len(t, readings, 1)
+	assert.Contains(t, readings, "bar")
+}
+
+func TestStateManager_GetReadingsForDevice_deviceMaxAge(t *testing.T) {
+	sm := stateManager{
+		config: &config.PluginSettings{
+			Read: &config.ReadSettings{
+				StaleAction: staleActionDrop,
+			},
+		},
+		deviceManager: &deviceManager{
+			devices: map[string]*Device{
+				"foo": {id: "foo", MaxReadingAge: 1 * time.Minute},
+			},
+		},
+		readingsLock: &sync.RWMutex{},
+		readings: map[string][]*output.Reading{
+			"foo": {{Value: 1}},
+		},
+		readStatuses: map[string]*deviceReadStatus{
+			"foo": {lastSuccess: time.Now().Add(-2 * time.Minute)},
+		},
+	}
+
+	assert.Nil(t, sm.GetReadingsForDevice("foo"))
+}
+
+func TestStateManager_recordReadStatus(t *testing.T) {
+	sm := stateManager{}
+
+	_, exists := sm.getReadStatus("foo")
+	assert.False(t, exists)
+
+	sm.recordReadFailure("foo", fmt.Errorf("test error"))
+	sm.recordReadFailure("foo", fmt.Errorf("test error"))
+	status, exists := sm.getReadStatus("foo")
+	assert.True(t, exists)
+	assert.Equal(t, 2, status.consecutiveFailures)
+	assert.True(t, status.lastSuccess.IsZero())
+	assert.EqualError(t, status.lastError, "test error")
+
+	sm.recordReadSuccess("foo")
+	status, exists = sm.getReadStatus("foo")
+	assert.True(t, exists)
+	assert.Equal(t, 0, status.consecutiveFailures)
+	assert.False(t, status.lastSuccess.IsZero())
+	assert.Nil(t, status.lastError)
+}
+
+func TestStateManager_encodeReadStatus(t *testing.T) {
+	ts := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	sm := stateManager{
+		readStatuses: map[string]*deviceReadStatus{
+			"foo": {lastSuccess: ts, consecutiveFailures: 3},
+		},
+	}
+
+	metadata := map[string]string{"a": "b"}
+	md := sm.encodeReadStatus("foo", metadata)
+	assert.Equal(t, map[string]string{
+		"a":                         "b",
+		"last_successful_read":      "2020-01-01T00:00:00Z",
+		"consecutive_read_failures": "3",
+	}, md)
+
+	// The original metadata should not be modified.
+	assert.Equal(t, map[string]string{"a": "b"}, metadata)
+
+	// No status for the device, metadata should be unchanged.
+	assert.Equal(t, metadata, sm.encodeReadStatus("bar", metadata))
+}
+
 func TestStateManager_GetOutputsForDevice_noReadings(t *testing.T) {
 	sm := stateManager{
 		readingsLock: &sync.RWMutex{},
@@ -351,12 +597,12 @@ func TestStateManager_GetCachedReadings_cacheEnabled(t *testing.T) {
 				Enabled: true,
 			},
 		},
-		readingsCache: cache.New(1*time.Minute, 2*time.Minute),
+		readingsCache: newMemoryCache(&config.CacheSettings{TTL: time.Minute}),
 	}
 
-	newCtxs := []*ReadContext{{Device: &Device{id: "123"}, Reading: []*output.Reading{{Value: 3}}}}
-	err := sm.readingsCache.Add("2019-03-22T09:48:00Z", &newCtxs, cache.DefaultExpiration)
+	ts, err := time.Parse(time.RFC3339, "2019-03-22T09:48:00Z")
 	assert.NoError(t, err)
+	sm.readingsCache.(*memoryCache).add(&ReadContext{Device: &Device{id: "123"}, Reading: []*output.Reading{{Value: 3}}}, ts)
 
 	readings := make(chan *ReadContext, 5)
 
@@ -411,7 +657,7 @@ func TestStateManager_dumpCachedReadings_noReadings(t *testing.T) {
 				Enabled: true,
 			},
 		},
-		readingsCache: cache.New(1*time.Minute, 2*time.Minute),
+		readingsCache: newMemoryCache(&config.CacheSettings{TTL: time.Minute}),
 	}
 
 	readings := make(chan *ReadContext, 5)
@@ -434,13 +680,13 @@ func TestStateManager_dumpCachedReadings_cachedReadingBeforeStart(t *testing.T)
 				Enabled: true,
 			},
 		},
-		readingsCache: cache.New(1*time.Minute, 2*time.Minute),
+		readingsCache: newMemoryCache(&config.CacheSettings{TTL: time.Minute}),
 	}
 
 	// Test data setup
-	newCtxs := []*ReadContext{{Device: &Device{id: "123"}, Reading: []*output.Reading{{Value: 3}}}}
-	err := sm.readingsCache.Add("2019-03-22T09:40:00Z", &newCtxs, cache.DefaultExpiration)
+	ts, err := time.Parse(time.RFC3339, "2019-03-22T09:40:00Z")
 	assert.NoError(t, err)
+	sm.readingsCache.(*memoryCache).add(&ReadContext{Device: &Device{id: "123"}, Reading: []*output.Reading{{Value: 3}}}, ts)
 
 	readings := make(chan *ReadContext, 5)
 	defer close(readings)
@@ -462,13 +708,13 @@ func TestStateManager_dumpCachedReadings_cachedReadingAfterEnd(t *testing.T) {
 				Enabled: true,
 			},
 		},
-		readingsCache: cache.New(1*time.Minute, 2*time.Minute),
+		readingsCache: newMemoryCache(&config.CacheSettings{TTL: time.Minute}),
 	}
 
 	// Test data setup
-	newCtxs := []*ReadContext{{Device: &Device{id: "123"}, Reading: []*output.Reading{{Value: 3}}}}
-	err := sm.readingsCache.Add("2019-03-22T09:55:00Z", &newCtxs, cache.DefaultExpiration)
+	ts, err := time.Parse(time.RFC3339, "2019-03-22T09:55:00Z")
 	assert.NoError(t, err)
+	sm.readingsCache.(*memoryCache).add(&ReadContext{Device: &Device{id: "123"}, Reading: []*output.Reading{{Value: 3}}}, ts)
 
 	readings := make(chan *ReadContext, 5)
 	defer close(readings)
@@ -490,13 +736,13 @@ func TestStateManager_dumpCachedReadings_cachedReadingOk(t *testing.T) {
 				Enabled: true,
 			},
 		},
-		readingsCache: cache.New(1*time.Minute, 2*time.Minute),
+		readingsCache: newMemoryCache(&config.CacheSettings{TTL: time.Minute}),
 	}
 
 	// Test data setup
-	newCtxs := []*ReadContext{{Device: &Device{id: "123"}, Reading: []*output.Reading{{Value: 3}}}}
-	err := sm.readingsCache.Add("2019-03-22T09:48:00Z", &newCtxs, cache.DefaultExpiration)
+	ts, err := time.Parse(time.RFC3339, "2019-03-22T09:48:00Z")
 	assert.NoError(t, err)
+	sm.readingsCache.(*memoryCache).add(&ReadContext{Device: &Device{id: "123"}, Reading: []*output.Reading{{Value: 3}}}, ts)
 
 	readings := make(chan *ReadContext, 5)
 	defer close(readings)
@@ -514,20 +760,21 @@ func TestStateManager_dumpCachedReadings_cachedReadingOk(t *testing.T) {
 	assert.Equal(t, "123", rctx.Device.id)
 }
 
-func TestStateManager_dumpCachedReadings_cachedReadingBadTS(t *testing.T) {
+func TestStateManager_dumpCachedReadings_cachedReadingExpired(t *testing.T) {
 	sm := stateManager{
 		config: &config.PluginSettings{
 			Cache: &config.CacheSettings{
 				Enabled: true,
 			},
 		},
-		readingsCache: cache.New(1*time.Minute, 2*time.Minute),
+		readingsCache: newMemoryCache(&config.CacheSettings{TTL: time.Nanosecond}),
 	}
 
 	// Test data setup
-	newCtxs := []*ReadContext{{Device: &Device{id: "123"}, Reading: []*output.Reading{{Value: 3}}}}
-	err := sm.readingsCache.Add("foobar", &newCtxs, cache.DefaultExpiration)
+	ts, err := time.Parse(time.RFC3339, "2019-03-22T09:48:00Z")
 	assert.NoError(t, err)
+	sm.readingsCache.(*memoryCache).add(&ReadContext{Device: &Device{id: "123"}, Reading: []*output.Reading{{Value: 3}}}, ts)
+	time.Sleep(time.Millisecond)
 
 	readings := make(chan *ReadContext, 5)
 	defer close(readings)
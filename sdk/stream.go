@@ -18,12 +18,31 @@ package sdk
 
 import (
 	"sync"
+	"sync/atomic"
 
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/output"
 )
 
+// Stream overflow policies, which determine what happens when a reading is
+// dispatched to a stream whose buffer is full.
+const (
+	streamOverflowDropOldest = "drop-oldest"
+	streamOverflowDropNewest = "drop-newest"
+	streamOverflowDisconnect = "disconnect"
+)
+
+// defaultStreamBufferSize is the stream buffer size used if none is configured.
+const defaultStreamBufferSize = 128
+
 // ReadStream encapsulates a channel which is used to stream data to a client.
+//
+// Readings are dispatched to the stream without blocking, so a client which is
+// not keeping up with readings can not hold up reading updates for the plugin.
+// Once the stream's buffer is full, its overflow policy determines whether
+// readings are dropped or the stream is closed.
 type ReadStream struct {
 	stream   chan *ReadContext
 	readings chan *ReadContext
@@ -31,6 +50,43 @@ type ReadStream struct {
 	filter   []string
 	closed   bool
 	stopLock sync.Mutex
+
+	// policy is the overflow policy for the stream.
+	policy string
+
+	// dropped is the number of readings dropped because the stream's buffer
+	// was full.
+	dropped uint64
+
+	// overflowed is set when the stream is closed by the disconnect overflow
+	// policy.
+	overflowed bool
+
+	// sendLock guards sending readings to, and closing, the stream channel. It is
+	// separate from the stopLock so dispatching readings does not wait on a collect
+	// which is blocked by a slow client.
+	sendLock sync.Mutex
+
+	// done is closed when the stream is closed, releasing any blocked collect.
+	done     chan struct{}
+	doneOnce sync.Once
+
+	// readingFilter filters the readings for the stream's devices. If nil, all
+	// readings are collected.
+	readingFilter *StreamFilter
+
+	// lastValues holds the last value collected for each device output, for
+	// filtering readings by change. It is only used by the listen goroutine.
+	lastValues map[string]interface{}
+
+	// pending are reading sets to collect before any readings dispatched to the
+	// stream, e.g. those replayed for a resumed stream.
+	pending []*ReadContext
+
+	// lastSequence is the sequence number of the last reading set collected.
+	// Reading sets with a sequence at or before it are not collected again. It
+	// is only used by the listen goroutine.
+	lastSequence uint64
 }
 
 // listen collects all new readings and filters them based on the supplied filter.
@@ -47,8 +103,21 @@ func (s *ReadStream) listen() {
 		}).Info("terminating stream listen")
 	}()
 
+	// Collect any pending readings first.
+	for _, r := range s.pending {
+		if s.isClosed() {
+			return
+		}
+		if len(s.filter) == 0 || s.inFilter(r.Device) {
+			if !s.collectFiltered(r) {
+				return
+			}
+		}
+	}
+	s.pending = nil
+
 	for {
-		if s.closed {
+		if s.isClosed() {
 			return
 		}
 		r, open := <-s.stream
@@ -57,37 +126,214 @@ func (s *ReadStream) listen() {
 		}
 
 		if len(s.filter) == 0 {
-			log.WithField("device", r.Device).Debug("collecting reading")
-			s.stopLock.Lock()
-			if s.closed {
+			if !s.collectFiltered(r) {
 				return
 			}
-			s.readings <- r
-			s.stopLock.Unlock()
 		}
 
 		for _, id := range s.filter {
 			if r.Device.id == id {
-				log.WithField("device", r.Device.id).Debug("collecting reading")
-				s.stopLock.Lock()
-				if s.closed {
+				if !s.collectFiltered(r) {
 					return
 				}
-				s.readings <- r
-				s.stopLock.Unlock()
 				break
 			}
 		}
 	}
 }
 
-// close the ReadStream.
+// inFilter checks whether the device is in the stream's device filter.
+func (s *ReadStream) inFilter(device *Device) bool {
+	if device == nil {
+		return false
+	}
+	for _, id := range s.filter {
+		if device.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// collectFiltered applies the stream's reading filter to the readings and collects
+// any which match. Reading sets which were already collected, based on their
+// sequence number, are skipped. If the stream has been closed, false is returned.
+func (s *ReadStream) collectFiltered(r *ReadContext) bool {
+	if r.sequence != 0 {
+		if r.sequence <= s.lastSequence {
+			return true
+		}
+		s.lastSequence = r.sequence
+	}
+
+	r = s.filterReadings(r)
+	if r == nil {
+		return true
+	}
+	log.WithField("device", r.Device.GetID()).Debug("collecting reading")
+	return s.collect(r)
+}
+
+// filterReadings filters the readings for a device using the stream's reading
+// filter. If none of the readings match, nil is returned.
+func (s *ReadStream) filterReadings(r *ReadContext) *ReadContext {
+	filter := s.readingFilter
+	if filter == nil {
+		return r
+	}
+	if !filter.matchesDevice(r.Device) {
+		return nil
+	}
+
+	var readings []*output.Reading
+	for _, reading := range r.Reading {
+		if reading == nil || !filter.matchesType(reading) {
+			continue
+		}
+		if filter.changesOnly() {
+			if s.lastValues == nil {
+				s.lastValues = make(map[string]interface{})
+			}
+			key := streamReadingKey(r.Device, reading)
+			if last, ok := s.lastValues[key]; ok && !filter.changed(last, reading.Value) {
+				continue
+			}
+			s.lastValues[key] = reading.Value
+		}
+		readings = append(readings, reading)
+	}
+
+	if len(readings) == 0 {
+		return nil
+	}
+	if len(readings) == len(r.Reading) {
+		return r
+	}
+	filtered := NewReadContext(r.Device, readings)
+	filtered.sequence = r.sequence
+	return filtered
+}
+
+// collect passes a reading along to the stream's readings channel. If the stream
+// has been closed, the reading is not collected and false is returned.
+func (s *ReadStream) collect(r *ReadContext) bool {
+	s.stopLock.Lock()
+	defer s.stopLock.Unlock()
+
+	if s.closed {
+		return false
+	}
+	select {
+	case s.readings <- r:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// dispatch sends a reading to the stream without blocking. If the stream's buffer
+// is full, the stream's overflow policy is applied. Dispatching to a stream which
+// has been closed has no effect.
+func (s *ReadStream) dispatch(r *ReadContext) {
+	s.sendLock.Lock()
+	if s.closed || s.overflowed || s.stream == nil {
+		s.sendLock.Unlock()
+		return
+	}
+
+	select {
+	case s.stream <- r:
+		s.sendLock.Unlock()
+		return
+	default:
+	}
+
+	switch s.policy {
+	case streamOverflowDropNewest:
+		// Nothing to do; the new reading is dropped.
+	case streamOverflowDisconnect:
+		s.overflowed = true
+	default:
+		// Make room by dropping the oldest buffered reading. Readings are only sent
+		// to the stream while holding the sendLock, so the new reading will fit.
+		select {
+		case <-s.stream:
+		default:
+		}
+		select {
+		case s.stream <- r:
+		default:
+		}
+	}
+	overflowed := s.overflowed
+	s.sendLock.Unlock()
+
+	atomic.AddUint64(&s.dropped, 1)
+	streamReadingsDroppedMetric.WithLabelValues(s.policy).Inc()
+
+	if overflowed {
+		log.WithFields(log.Fields{
+			"id":      s.id,
+			"dropped": s.Dropped(),
+		}).Warn("stream buffer full, disconnecting slow stream client")
+		streamDisconnectsMetric.Inc()
+
+		// Close in the background; closing may need to wait for a blocked collect
+		// to be released, and dispatch should never block.
+		go s.close()
+	} else {
+		log.WithFields(log.Fields{
+			"id":     s.id,
+			"policy": s.policy,
+		}).Debug("stream buffer full, dropped reading")
+	}
+}
+
+// Dropped gets the number of readings which were dropped for the stream because
+// its buffer was full.
+func (s *ReadStream) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// isOverflowed checks whether the ReadStream was closed because its buffer
+// overflowed.
+func (s *ReadStream) isOverflowed() bool {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+
+	return s.overflowed
+}
+
+// isClosed checks whether the ReadStream has been closed.
+func (s *ReadStream) isClosed() bool {
+	s.stopLock.Lock()
+	defer s.stopLock.Unlock()
+
+	return s.closed
+}
+
+// close the ReadStream. Closing a stream which is already closed has no effect.
 func (s *ReadStream) close() {
-	log.WithField("id", s.id).Info("closing read stream")
+	// Release any collect which is blocked on a full readings channel, so the
+	// stopLock it holds is released.
+	s.doneOnce.Do(func() {
+		if s.done != nil {
+			close(s.done)
+		}
+	})
 
 	s.stopLock.Lock()
 	defer s.stopLock.Unlock()
 
+	if s.closed {
+		return
+	}
+	log.WithFields(log.Fields{
+		"id":      s.id,
+		"dropped": s.Dropped(),
+	}).Info("closing read stream")
+
+	s.sendLock.Lock()
 	s.closed = true
 	if s.stream != nil {
 		// Drain the channel.
@@ -97,6 +343,8 @@ func (s *ReadStream) close() {
 		// Close the channel.
 		close(s.stream)
 	}
+	s.sendLock.Unlock()
+
 	if s.readings != nil {
 		// Drain the channel.
 		for len(s.readings) > 0 {
@@ -107,13 +355,34 @@ func (s *ReadStream) close() {
 	}
 }
 
-// newReadStream creates a new ReadStream.
-func newReadStream(filter []string) *ReadStream {
+// newReadStream creates a new ReadStream. If no stream settings are given, the
+// default buffer size and overflow policy are used.
+func newReadStream(filter []string, conf *config.StreamSettings) *ReadStream {
+	size := defaultStreamBufferSize
+	policy := streamOverflowDropOldest
+	if conf != nil {
+		if conf.BufferSize > 0 {
+			size = conf.BufferSize
+		}
+		switch conf.OverflowPolicy {
+		case streamOverflowDropOldest, streamOverflowDropNewest, streamOverflowDisconnect:
+			policy = conf.OverflowPolicy
+		case "":
+		default:
+			log.WithFields(log.Fields{
+				"policy":  conf.OverflowPolicy,
+				"default": policy,
+			}).Warn("unknown stream overflow policy, using default")
+		}
+	}
+
 	return &ReadStream{
-		stream:   make(chan *ReadContext, 128),
-		readings: make(chan *ReadContext, 128),
+		stream:   make(chan *ReadContext, size),
+		readings: make(chan *ReadContext, size),
 		id:       uuid.New(),
 		filter:   filter,
 		stopLock: sync.Mutex{},
+		policy:   policy,
+		done:     make(chan struct{}),
 	}
 }
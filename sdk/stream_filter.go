@@ -0,0 +1,243 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	sdkError "github.com/vapor-ware/synse-sdk/v2/sdk/errors"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/output"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/utils"
+	grpcMetadata "google.golang.org/grpc/metadata"
+)
+
+// Request metadata keys for ReadStream filters. The ReadStream request message only
+// holds device selectors, so additional filters are passed as gRPC request metadata.
+// The type and tag keys may be repeated, and each value may hold a comma-separated
+// list.
+const (
+	streamMetadataType     = "synse-stream-type"
+	streamMetadataTag      = "synse-stream-tag"
+	streamMetadataOnChange = "synse-stream-on-change"
+	streamMetadataDeadband = "synse-stream-deadband"
+)
+
+// StreamTagFilter matches device tags by namespace, annotation, and label. Fields
+// which are empty match any value.
+type StreamTagFilter struct {
+	Namespace  string
+	Annotation string
+	Label      string
+}
+
+// newStreamTagFilter creates a tag filter from a string of the form
+// "namespace[/annotation][:label]", e.g. "vapor", "vapor/rack", or "vapor/rack:r1".
+func newStreamTagFilter(filter string) (*StreamTagFilter, error) {
+	filter = strings.TrimSpace(filter)
+	if filter == "" {
+		return nil, fmt.Errorf("empty tag filter")
+	}
+
+	var tf StreamTagFilter
+	rest := filter
+	if i := strings.Index(rest, ":"); i >= 0 {
+		tf.Label = rest[i+1:]
+		rest = rest[:i]
+	}
+	if i := strings.Index(rest, "/"); i >= 0 {
+		tf.Namespace = rest[:i]
+		tf.Annotation = rest[i+1:]
+	} else {
+		tf.Namespace = rest
+	}
+	if strings.ContainsAny(tf.Annotation, "/") || strings.ContainsAny(tf.Label, ":/") {
+		return nil, fmt.Errorf("invalid tag filter: %s", filter)
+	}
+	return &tf, nil
+}
+
+// matches checks whether the tag matches the filter.
+func (tf *StreamTagFilter) matches(tag *Tag) bool {
+	if tag == nil {
+		return false
+	}
+	return (tf.Namespace == "" || tf.Namespace == tag.Namespace) &&
+		(tf.Annotation == "" || tf.Annotation == tag.Annotation) &&
+		(tf.Label == "" || tf.Label == tag.Label)
+}
+
+// StreamFilter filters the readings sent to a ReadStream client, in addition to
+// the devices matched by the stream's selectors.
+//
+// Clients set the filter with ReadStream request metadata:
+//
+//	synse-stream-type:      reading types or output names, e.g. "temperature"
+//	synse-stream-tag:       tag filters, e.g. "vapor/rack" or "vapor/rack:r1"
+//	synse-stream-on-change: "true" to only stream readings whose value changed
+//	synse-stream-deadband:  the minimum change for numeric values, e.g. "0.5"
+type StreamFilter struct {
+	// Types are the reading types or output names to stream. If empty, readings
+	// of all types are streamed.
+	Types []string
+
+	// Tags are the tag filters for the devices to stream readings for. A device
+	// matches if any of its tags match any of the filters. If empty, readings for
+	// devices with any tags are streamed.
+	Tags []*StreamTagFilter
+
+	// OnChange sets whether readings are only streamed when their value changes
+	// from the last value streamed for the same device output.
+	OnChange bool
+
+	// Deadband is the amount a numeric reading value must move from the last
+	// value streamed for the same device output before it is streamed again.
+	// Setting a deadband implies OnChange.
+	Deadband float64
+}
+
+// newStreamFilterFromMetadata creates a stream filter from gRPC request metadata.
+// If the metadata has no stream filter keys, nil is returned.
+func newStreamFilterFromMetadata(md grpcMetadata.MD) (*StreamFilter, error) {
+	filter := &StreamFilter{
+		Types: splitMetadataValues(md.Get(streamMetadataType)),
+	}
+	for _, t := range splitMetadataValues(md.Get(streamMetadataTag)) {
+		tf, err := newStreamTagFilter(t)
+		if err != nil {
+			return nil, sdkError.InvalidArgumentErr("%s: %v", streamMetadataTag, err)
+		}
+		filter.Tags = append(filter.Tags, tf)
+	}
+	if values := md.Get(streamMetadataOnChange); len(values) > 0 {
+		onChange, err := strconv.ParseBool(values[0])
+		if err != nil {
+			return nil, sdkError.InvalidArgumentErr("%s: %v", streamMetadataOnChange, err)
+		}
+		filter.OnChange = onChange
+	}
+	if values := md.Get(streamMetadataDeadband); len(values) > 0 {
+		deadband, err := strconv.ParseFloat(values[0], 64)
+		if err != nil || deadband < 0 || math.IsNaN(deadband) || math.IsInf(deadband, 0) {
+			return nil, sdkError.InvalidArgumentErr("%s: must be a non-negative number", streamMetadataDeadband)
+		}
+		filter.Deadband = deadband
+	}
+
+	if len(filter.Types) == 0 && len(filter.Tags) == 0 && !filter.changesOnly() {
+		return nil, nil
+	}
+	return filter, nil
+}
+
+// splitMetadataValues splits comma-separated metadata values, dropping any which
+// are empty.
+func splitMetadataValues(values []string) []string {
+	var split []string
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				split = append(split, v)
+			}
+		}
+	}
+	return split
+}
+
+// changesOnly checks whether the filter only streams readings whose value changed.
+func (filter *StreamFilter) changesOnly() bool {
+	return filter.OnChange || filter.Deadband > 0
+}
+
+// matchesDevice checks whether the device matches the filter's tag filters.
+func (filter *StreamFilter) matchesDevice(device *Device) bool {
+	if len(filter.Tags) == 0 {
+		return true
+	}
+	if device == nil {
+		return false
+	}
+	for _, tag := range device.Tags {
+		for _, tf := range filter.Tags {
+			if tf.matches(tag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesType checks whether the reading matches the filter's types, by either its
+// type or the name of its output.
+func (filter *StreamFilter) matchesType(reading *output.Reading) bool {
+	if len(filter.Types) == 0 {
+		return true
+	}
+	var name string
+	if o := reading.GetOutput(); o != nil {
+		name = o.Name
+	}
+	for _, t := range filter.Types {
+		if t == reading.Type || (name != "" && t == name) {
+			return true
+		}
+	}
+	return false
+}
+
+// changed checks whether a reading value should be streamed given the last value
+// streamed for the same device output. Numeric values must move by at least the
+// deadband; other values must differ.
+func (filter *StreamFilter) changed(last, value interface{}) bool {
+	lastNum, lastOk := toFloat64(last)
+	num, ok := toFloat64(value)
+	if lastOk && ok {
+		if filter.Deadband > 0 {
+			return math.Abs(num-lastNum) >= filter.Deadband
+		}
+		return num != lastNum
+	}
+	return !reflect.DeepEqual(last, value)
+}
+
+// toFloat64 converts a numeric reading value to a float64. If the value is not
+// numeric, false is returned. Unlike utils.ConvertToFloat64, strings are not
+// considered numeric, even if they could be parsed as a number.
+func toFloat64(value interface{}) (float64, bool) {
+	if _, ok := value.(string); ok {
+		return 0, false
+	}
+	v, err := utils.ConvertToFloat64(value)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// streamReadingKey identifies a device output reading for on-change filtering.
+// The reading context is included, since a device may provide multiple readings
+// from the same output which are distinguished by their context.
+func streamReadingKey(device *Device, reading *output.Reading) string {
+	var name string
+	if o := reading.GetOutput(); o != nil {
+		name = o.Name
+	}
+	return fmt.Sprintf("%s/%s/%s/%v", device.GetID(), name, reading.Type, reading.Context)
+}
@@ -0,0 +1,245 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/output"
+	grpcMetadata "google.golang.org/grpc/metadata"
+)
+
+func TestNewStreamTagFilter(t *testing.T) {
+	tests := []struct {
+		filter   string
+		expected StreamTagFilter
+	}{
+		{"vapor", StreamTagFilter{Namespace: "vapor"}},
+		{"vapor/rack", StreamTagFilter{Namespace: "vapor", Annotation: "rack"}},
+		{"vapor/rack:r1", StreamTagFilter{Namespace: "vapor", Annotation: "rack", Label: "r1"}},
+		{"vapor/:r1", StreamTagFilter{Namespace: "vapor", Label: "r1"}},
+		{"/rack", StreamTagFilter{Annotation: "rack"}},
+	}
+
+	for _, test := range tests {
+		tf, err := newStreamTagFilter(test.filter)
+		assert.NoError(t, err, test.filter)
+		assert.Equal(t, test.expected, *tf, test.filter)
+	}
+}
+
+func TestNewStreamTagFilter_error(t *testing.T) {
+	for _, filter := range []string{"", " ", "vapor/rack/r1", "vapor/rack:r1:r2"} {
+		tf, err := newStreamTagFilter(filter)
+		assert.Error(t, err, filter)
+		assert.Nil(t, tf, filter)
+	}
+}
+
+func TestStreamTagFilter_matches(t *testing.T) {
+	tag := &Tag{Namespace: "vapor", Annotation: "rack", Label: "r1"}
+
+	assert.True(t, (&StreamTagFilter{}).matches(tag))
+	assert.True(t, (&StreamTagFilter{Namespace: "vapor"}).matches(tag))
+	assert.True(t, (&StreamTagFilter{Namespace: "vapor", Annotation: "rack"}).matches(tag))
+	assert.True(t, (&StreamTagFilter{Annotation: "rack", Label: "r1"}).matches(tag))
+	assert.False(t, (&StreamTagFilter{Namespace: "other"}).matches(tag))
+	assert.False(t, (&StreamTagFilter{Namespace: "vapor", Annotation: "row"}).matches(tag))
+	assert.False(t, (&StreamTagFilter{Namespace: "vapor"}).matches(nil))
+}
+
+func TestNewStreamFilterFromMetadata(t *testing.T) {
+	md := grpcMetadata.Pairs(
+		"synse-stream-type", "temperature, humidity",
+		"synse-stream-type", "power",
+		"synse-stream-tag", "vapor/rack",
+		"synse-stream-on-change", "true",
+		"synse-stream-deadband", "0.5",
+	)
+
+	filter, err := newStreamFilterFromMetadata(md)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"temperature", "humidity", "power"}, filter.Types)
+	assert.Equal(t, []*StreamTagFilter{{Namespace: "vapor", Annotation: "rack"}}, filter.Tags)
+	assert.True(t, filter.OnChange)
+	assert.Equal(t, 0.5, filter.Deadband)
+}
+
+func TestNewStreamFilterFromMetadata_none(t *testing.T) {
+	filter, err := newStreamFilterFromMetadata(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, filter)
+
+	filter, err = newStreamFilterFromMetadata(grpcMetadata.Pairs("synse-stream-on-change", "false"))
+	assert.NoError(t, err)
+	assert.Nil(t, filter)
+}
+
+func TestNewStreamFilterFromMetadata_error(t *testing.T) {
+	tests := []grpcMetadata.MD{
+		grpcMetadata.Pairs("synse-stream-tag", "a/b/c"),
+		grpcMetadata.Pairs("synse-stream-on-change", "maybe"),
+		grpcMetadata.Pairs("synse-stream-deadband", "abc"),
+		grpcMetadata.Pairs("synse-stream-deadband", "-1"),
+	}
+
+	for _, md := range tests {
+		filter, err := newStreamFilterFromMetadata(md)
+		assert.Error(t, err, md)
+		assert.Contains(t, err.Error(), "InvalidArgument", md)
+		assert.Nil(t, filter, md)
+	}
+}
+
+func TestStreamFilter_matchesType(t *testing.T) {
+	o := &output.Output{Name: "fan-speed", Type: "speed"}
+	reading, err := o.MakeReading(100)
+	assert.NoError(t, err)
+
+	assert.True(t, (&StreamFilter{}).matchesType(reading))
+	assert.True(t, (&StreamFilter{Types: []string{"speed"}}).matchesType(reading))
+	assert.True(t, (&StreamFilter{Types: []string{"temperature", "fan-speed"}}).matchesType(reading))
+	assert.False(t, (&StreamFilter{Types: []string{"temperature"}}).matchesType(reading))
+}
+
+func TestStreamFilter_matchesDevice(t *testing.T) {
+	device := &Device{
+		Tags: []*Tag{
+			{Namespace: "system", Annotation: "id", Label: "123"},
+			{Namespace: "vapor", Annotation: "rack", Label: "r1"},
+		},
+	}
+
+	assert.True(t, (&StreamFilter{}).matchesDevice(device))
+	assert.True(t, (&StreamFilter{Tags: []*StreamTagFilter{{Namespace: "vapor"}}}).matchesDevice(device))
+	assert.True(t, (&StreamFilter{Tags: []*StreamTagFilter{
+		{Namespace: "other"},
+		{Annotation: "rack"},
+	}}).matchesDevice(device))
+	assert.False(t, (&StreamFilter{Tags: []*StreamTagFilter{{Namespace: "other"}}}).matchesDevice(device))
+	assert.False(t, (&StreamFilter{Tags: []*StreamTagFilter{{Namespace: "vapor"}}}).matchesDevice(nil))
+}
+
+func TestStreamFilter_changed(t *testing.T) {
+	onChange := &StreamFilter{OnChange: true}
+	assert.False(t, onChange.changed(1, 1))
+	assert.False(t, onChange.changed(1, 1.0))
+	assert.True(t, onChange.changed(1, 2))
+	assert.False(t, onChange.changed("on", "on"))
+	assert.True(t, onChange.changed("on", "off"))
+	assert.True(t, onChange.changed(1, "1"))
+
+	deadband := &StreamFilter{Deadband: 0.5}
+	assert.False(t, deadband.changed(20.0, 20.4))
+	assert.False(t, deadband.changed(20.0, 19.6))
+	assert.True(t, deadband.changed(20.0, 20.5))
+	assert.True(t, deadband.changed(int64(20), uint8(21)))
+	assert.True(t, deadband.changed("on", "off"))
+	assert.True(t, deadband.changed("20.0", "20.1"))
+}
+
+func TestReadStream_filterReadings_noFilter(t *testing.T) {
+	s := newReadStream(nil, nil)
+	r := &ReadContext{Device: &Device{id: "123"}, Reading: []*output.Reading{{Value: 1}}}
+
+	assert.Equal(t, r, s.filterReadings(r))
+}
+
+func TestReadStream_filterReadings_type(t *testing.T) {
+	s := newReadStream(nil, nil)
+	s.readingFilter = &StreamFilter{Types: []string{"temperature"}}
+
+	temp := &output.Reading{Type: "temperature", Value: 20}
+	humidity := &output.Reading{Type: "humidity", Value: 40}
+
+	r := s.filterReadings(&ReadContext{
+		Device:  &Device{id: "123"},
+		Reading: []*output.Reading{temp, humidity},
+	})
+	assert.Equal(t, []*output.Reading{temp}, r.Reading)
+
+	r = s.filterReadings(&ReadContext{
+		Device:  &Device{id: "123"},
+		Reading: []*output.Reading{humidity},
+	})
+	assert.Nil(t, r)
+}
+
+func TestReadStream_filterReadings_tags(t *testing.T) {
+	s := newReadStream(nil, nil)
+	s.readingFilter = &StreamFilter{Tags: []*StreamTagFilter{{Namespace: "vapor", Annotation: "rack"}}}
+
+	r := s.filterReadings(&ReadContext{
+		Device:  &Device{id: "123", Tags: []*Tag{{Namespace: "vapor", Annotation: "rack", Label: "r1"}}},
+		Reading: []*output.Reading{{Value: 1}},
+	})
+	assert.NotNil(t, r)
+
+	r = s.filterReadings(&ReadContext{
+		Device:  &Device{id: "456", Tags: []*Tag{{Namespace: "vapor", Label: "fan"}}},
+		Reading: []*output.Reading{{Value: 1}},
+	})
+	assert.Nil(t, r)
+}
+
+func TestReadStream_filterReadings_deadband(t *testing.T) {
+	s := newReadStream(nil, nil)
+	s.readingFilter = &StreamFilter{Deadband: 1}
+
+	d1 := &Device{id: "1"}
+	d2 := &Device{id: "2"}
+	read := func(device *Device, value float64) bool {
+		return s.filterReadings(&ReadContext{
+			Device:  device,
+			Reading: []*output.Reading{{Type: "temperature", Value: value}},
+		}) != nil
+	}
+
+	assert.True(t, read(d1, 20))
+	assert.False(t, read(d1, 20.5))
+	assert.False(t, read(d1, 20.9))
+	assert.True(t, read(d1, 21))
+	// The deadband is relative to the last value streamed, not the last value read.
+	assert.False(t, read(d1, 21.5))
+	assert.False(t, read(d1, 20.5))
+	assert.True(t, read(d1, 19.5))
+
+	// Each device is tracked separately.
+	assert.True(t, read(d2, 20.5))
+}
+
+func TestReadStream_listen_readingFilter(t *testing.T) {
+	s := newReadStream(nil, nil)
+	s.readingFilter = &StreamFilter{OnChange: true}
+
+	for _, value := range []int{1, 1, 2, 2, 1} {
+		s.stream <- &ReadContext{
+			Device:  &Device{id: "123"},
+			Reading: []*output.Reading{{Type: "state", Value: value}},
+		}
+	}
+	close(s.stream)
+	s.listen()
+
+	var values []interface{}
+	for len(s.readings) > 0 {
+		r := <-s.readings
+		values = append(values, r.Reading[0].Value)
+	}
+	assert.Equal(t, []interface{}{1, 2, 1}, values)
+}
@@ -0,0 +1,262 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	sdkError "github.com/vapor-ware/synse-sdk/v2/sdk/errors"
+	grpcMetadata "google.golang.org/grpc/metadata"
+)
+
+// streamMetadataResumeFrom is the ReadStream request metadata key which holds the
+// position of the last reading the client received, as "<epoch>:<sequence>". If
+// set, the stream resumes after that reading.
+const streamMetadataResumeFrom = "synse-stream-resume-from"
+
+// Reading context keys which hold the sequence number of streamed readings and
+// the epoch in which the sequence number was assigned.
+const (
+	readingContextSequence = "synse-sequence"
+	readingContextEpoch    = "synse-sequence-epoch"
+)
+
+// resumePoint is the position of the last reading set a stream client received:
+// its sequence number and the epoch in which that sequence number was assigned.
+type resumePoint struct {
+	epoch    string
+	sequence uint64
+}
+
+// newEpoch creates an identifier for the current run of the plugin, based on the
+// time at which it was created.
+func newEpoch() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// replayEntry is a reading set held in the replay buffer.
+type replayEntry struct {
+	ctx   *ReadContext
+	added time.Time
+}
+
+// replayBuffer holds the most recent reading sets processed by the state manager,
+// in sequence order, so streams can be resumed from a given sequence number.
+type replayBuffer struct {
+	lock    sync.RWMutex
+	entries []replayEntry
+	head    int
+	count   int
+}
+
+// newReplayBuffer creates a replay buffer which holds up to size reading sets.
+// If the size is not positive, nil is returned.
+func newReplayBuffer(size int) *replayBuffer {
+	if size <= 0 {
+		return nil
+	}
+	return &replayBuffer{
+		entries: make([]replayEntry, size),
+	}
+}
+
+// add adds a reading set to the buffer, replacing the oldest reading set if the
+// buffer is full. Adding to a nil buffer does nothing.
+func (buf *replayBuffer) add(ctx *ReadContext) {
+	if buf == nil {
+		return
+	}
+	buf.lock.Lock()
+	defer buf.lock.Unlock()
+
+	i := (buf.head + buf.count) % len(buf.entries)
+	buf.entries[i] = replayEntry{ctx: ctx, added: time.Now()}
+	if buf.count < len(buf.entries) {
+		buf.count++
+	} else {
+		buf.head = (buf.head + 1) % len(buf.entries)
+	}
+}
+
+// since gets the buffered reading sets with a sequence number after the given
+// sequence, oldest first. It also returns the oldest buffered reading set, or
+// nil if the buffer is empty.
+func (buf *replayBuffer) since(sequence uint64) ([]*ReadContext, *replayEntry) {
+	if buf == nil {
+		return nil, nil
+	}
+	buf.lock.RLock()
+	defer buf.lock.RUnlock()
+
+	if buf.count == 0 {
+		return nil, nil
+	}
+	oldest := buf.entries[buf.head]
+
+	var readings []*ReadContext
+	for n := 0; n < buf.count; n++ {
+		entry := buf.entries[(buf.head+n)%len(buf.entries)]
+		if entry.ctx.sequence > sequence {
+			readings = append(readings, entry.ctx)
+		}
+	}
+	return readings, &oldest
+}
+
+// nextSequence assigns the next sequence number to the reading set.
+func (manager *stateManager) nextSequence(ctx *ReadContext) {
+	ctx.sequence = atomic.AddUint64(&manager.sequence, 1)
+}
+
+// resumeStream adds a stream which resumes after the reading at the given position.
+// The stream is sent the reading sets from the replay buffer which it missed before
+// any new readings.
+//
+// If the stream missed reading sets which are no longer in the replay buffer, the
+// cached readings prior to the replay buffer, within the replay cache window, are
+// sent as well if the plugin has a readings cache. Since cached readings can not
+// be matched to the sequence they were missed from, some readings may be sent
+// more than once.
+//
+// Sequence numbers start again from 0 when the plugin restarts. If the position
+// is from a different epoch, or is ahead of the latest sequence assigned, the
+// plugin has been restarted since the client last received a reading, so all
+// retained readings are sent.
+//
+// This must be called before the stream starts listening.
+func (manager *stateManager) resumeStream(stream *ReadStream, from resumePoint) {
+	sequence := from.sequence
+
+	// The stream is added and the replay buffer read together, while holding the
+	// stream lock, so any reading set which is not in the replay buffer is
+	// dispatched to the stream. Reading sets which are both buffered and dispatched
+	// are de-duplicated by the stream using their sequence number.
+	manager.streamLock.Lock()
+	latest := atomic.LoadUint64(&manager.sequence)
+	if from.epoch == "" || from.epoch != manager.epoch {
+		log.WithFields(log.Fields{
+			"id":       stream.id,
+			"epoch":    from.epoch,
+			"sequence": sequence,
+			"current":  manager.epoch,
+		}).Info("[state manager] stream resume epoch does not match, replaying all readings")
+		sequence = 0
+	} else if sequence > latest {
+		log.WithFields(log.Fields{
+			"id":       stream.id,
+			"sequence": sequence,
+			"latest":   latest,
+		}).Info("[state manager] stream resume sequence is ahead of the latest reading, replaying all readings")
+		sequence = 0
+	}
+	manager.streams[stream.id] = stream
+	replay, oldest := manager.replay.since(sequence)
+	manager.streamLock.Unlock()
+
+	// Check whether reading sets were missed which are no longer buffered.
+	first := latest + 1
+	end := time.Now()
+	if oldest != nil {
+		first = oldest.ctx.sequence
+		end = oldest.added
+	}
+
+	var pending []*ReadContext
+	if sequence+1 < first {
+		window := manager.replayCacheWindow()
+		if manager.cacheEnabled() && window > 0 {
+			log.WithFields(log.Fields{
+				"id":       stream.id,
+				"sequence": sequence,
+				"buffered": first,
+				"window":   window,
+			}).Info("[state manager] stream resume sequence not buffered, falling back to readings cache")
+			page, err := manager.QueryCachedReadings(&CacheQuery{Start: end.Add(-window), End: end})
+			if err != nil {
+				log.WithField("error", err).Error("[state manager] failed to get cached readings for resumed stream")
+			} else {
+				pending = append(pending, page.Readings...)
+			}
+		} else {
+			log.WithFields(log.Fields{
+				"id":       stream.id,
+				"sequence": sequence,
+				"buffered": first,
+			}).Warn("[state manager] stream resume sequence not buffered, some readings were missed")
+		}
+	}
+
+	stream.lastSequence = sequence
+	stream.pending = append(pending, replay...)
+	log.WithFields(log.Fields{
+		"id":       stream.id,
+		"sequence": sequence,
+		"replay":   len(stream.pending),
+	}).Debug("[state manager] resuming stream")
+}
+
+// replayCacheWindow gets how far back the readings cache is searched for readings
+// missed by a resumed stream.
+func (manager *stateManager) replayCacheWindow() time.Duration {
+	if settings := manager.streamSettings(); settings != nil {
+		return settings.ReplayCacheWindow
+	}
+	return 0
+}
+
+// resumePointFromMetadata gets the position to resume a stream after from gRPC
+// request metadata. The position is given as "<epoch>:<sequence>". A position
+// without an epoch is accepted, but can not be matched to the current epoch, so
+// the stream is sent all retained readings. If the metadata does not specify a
+// position, false is returned.
+func resumePointFromMetadata(md grpcMetadata.MD) (resumePoint, bool, error) {
+	values := md.Get(streamMetadataResumeFrom)
+	if len(values) == 0 {
+		return resumePoint{}, false, nil
+	}
+
+	var point resumePoint
+	value := values[0]
+	if i := strings.LastIndex(value, ":"); i >= 0 {
+		point.epoch = value[:i]
+		value = value[i+1:]
+	}
+	sequence, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return resumePoint{}, false, sdkError.InvalidArgumentErr("%s: must be a sequence number, optionally prefixed by its epoch", streamMetadataResumeFrom)
+	}
+	point.sequence = sequence
+	return point, true, nil
+}
+
+// sequenceContext adds the sequence number, and the epoch in which it was assigned,
+// to a reading context, returning a new context map so the context of the reading
+// itself is not modified.
+func sequenceContext(ctx map[string]string, epoch string, sequence uint64) map[string]string {
+	c := make(map[string]string, len(ctx)+2)
+	for k, v := range ctx {
+		c[k] = v
+	}
+	c[readingContextSequence] = strconv.FormatUint(sequence, 10)
+	c[readingContextEpoch] = epoch
+	return c
+}
@@ -0,0 +1,225 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/output"
+	grpcMetadata "google.golang.org/grpc/metadata"
+)
+
+// addReplayTestReadings dispatches the given number of reading sets for the device
+// to the state manager's replay buffer.
+func addReplayTestReadings(sm *stateManager, device *Device, readings int) {
+	for i := 0; i < readings; i++ {
+		ctx := &ReadContext{Device: device, Reading: []*output.Reading{{Value: i}}}
+		sm.nextSequence(ctx)
+		sm.replay.add(ctx)
+	}
+}
+
+// sequences gets the sequence numbers of the reading sets.
+func sequences(readings []*ReadContext) []uint64 {
+	var seqs []uint64
+	for _, r := range readings {
+		seqs = append(seqs, r.sequence)
+	}
+	return seqs
+}
+
+func TestNewReplayBuffer(t *testing.T) {
+	assert.Nil(t, newReplayBuffer(0))
+	assert.Nil(t, newReplayBuffer(-1))
+	assert.Len(t, newReplayBuffer(4).entries, 4)
+}
+
+func TestReplayBuffer_nil(t *testing.T) {
+	var buf *replayBuffer
+
+	assert.NotPanics(t, func() {
+		buf.add(&ReadContext{})
+	})
+	readings, oldest := buf.since(0)
+	assert.Nil(t, readings)
+	assert.Nil(t, oldest)
+}
+
+func TestReplayBuffer_since(t *testing.T) {
+	sm := newTestStateManager(nil)
+	sm.replay = newReplayBuffer(4)
+	addReplayTestReadings(sm, &Device{id: "123"}, 3)
+
+	readings, oldest := sm.replay.since(0)
+	assert.Equal(t, []uint64{1, 2, 3}, sequences(readings))
+	assert.Equal(t, uint64(1), oldest.ctx.sequence)
+
+	readings, _ = sm.replay.since(2)
+	assert.Equal(t, []uint64{3}, sequences(readings))
+
+	readings, _ = sm.replay.since(3)
+	assert.Empty(t, readings)
+}
+
+func TestReplayBuffer_since_wrapped(t *testing.T) {
+	sm := newTestStateManager(nil)
+	sm.replay = newReplayBuffer(4)
+	addReplayTestReadings(sm, &Device{id: "123"}, 10)
+
+	readings, oldest := sm.replay.since(0)
+	assert.Equal(t, []uint64{7, 8, 9, 10}, sequences(readings))
+	assert.Equal(t, uint64(7), oldest.ctx.sequence)
+
+	readings, _ = sm.replay.since(8)
+	assert.Equal(t, []uint64{9, 10}, sequences(readings))
+}
+
+func TestStateManager_resumeStream(t *testing.T) {
+	sm := newTestStateManager(nil)
+	sm.replay = newReplayBuffer(8)
+	addReplayTestReadings(sm, &Device{id: "123"}, 5)
+	s := newReadStream(nil, nil)
+
+	sm.resumeStream(s, resumePoint{epoch: sm.epoch, sequence: 3})
+
+	assert.Contains(t, sm.streams, s.id)
+	assert.Equal(t, uint64(3), s.lastSequence)
+	assert.Equal(t, []uint64{4, 5}, sequences(s.pending))
+}
+
+func TestStateManager_resumeStream_notBuffered(t *testing.T) {
+	sm := newTestStateManager(nil)
+	sm.replay = newReplayBuffer(2)
+	addReplayTestReadings(sm, &Device{id: "123"}, 5)
+	s := newReadStream(nil, nil)
+
+	// Without a readings cache, only the buffered readings are replayed.
+	sm.resumeStream(s, resumePoint{epoch: sm.epoch, sequence: 1})
+	assert.Equal(t, []uint64{4, 5}, sequences(s.pending))
+}
+
+func TestStateManager_resumeStream_cacheFallback(t *testing.T) {
+	sm := newQueryTestStateManager()
+	sm.config.Stream = &config.StreamSettings{ReplayCacheWindow: time.Minute}
+	sm.replay = newReplayBuffer(2)
+	addReplayTestReadings(sm, &Device{id: "1"}, 5)
+	s := newReadStream(nil, nil)
+
+	// The cached readings are sent before the buffered readings.
+	sm.resumeStream(s, resumePoint{epoch: sm.epoch, sequence: 1})
+	assert.Len(t, s.pending, 6)
+	assert.Equal(t, []uint64{0, 0, 0, 0, 4, 5}, sequences(s.pending))
+
+	// Cached readings outside of the replay cache window are not sent.
+	sm.config.Stream.ReplayCacheWindow = 500 * time.Millisecond
+	s = newReadStream(nil, nil)
+	sm.resumeStream(s, resumePoint{epoch: sm.epoch, sequence: 1})
+	assert.Equal(t, []uint64{4, 5}, sequences(s.pending))
+
+	// Without a replay cache window, the cache is not used.
+	sm.config.Stream.ReplayCacheWindow = 0
+	s = newReadStream(nil, nil)
+	sm.resumeStream(s, resumePoint{epoch: sm.epoch, sequence: 1})
+	assert.Equal(t, []uint64{4, 5}, sequences(s.pending))
+}
+
+func TestStateManager_resumeStream_restarted(t *testing.T) {
+	sm := newTestStateManager(nil)
+	sm.replay = newReplayBuffer(8)
+	addReplayTestReadings(sm, &Device{id: "123"}, 3)
+	s := newReadStream(nil, nil)
+
+	// A sequence ahead of the latest sequence means the plugin restarted.
+	sm.resumeStream(s, resumePoint{epoch: sm.epoch, sequence: 100})
+	assert.Equal(t, uint64(0), s.lastSequence)
+	assert.Equal(t, []uint64{1, 2, 3}, sequences(s.pending))
+}
+
+func TestStateManager_resumeStream_otherEpoch(t *testing.T) {
+	sm := newTestStateManager(nil)
+	sm.replay = newReplayBuffer(8)
+	addReplayTestReadings(sm, &Device{id: "123"}, 5)
+
+	// A sequence from another epoch is from before the plugin restarted, even
+	// though it is not ahead of the latest sequence.
+	s := newReadStream(nil, nil)
+	sm.resumeStream(s, resumePoint{epoch: "other", sequence: 3})
+	assert.Equal(t, uint64(0), s.lastSequence)
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5}, sequences(s.pending))
+
+	// A sequence without an epoch can not be matched to the current epoch.
+	s = newReadStream(nil, nil)
+	sm.resumeStream(s, resumePoint{sequence: 3})
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5}, sequences(s.pending))
+}
+
+func TestReadStream_listen_resumed(t *testing.T) {
+	sm := newTestStateManager(nil)
+	sm.replay = newReplayBuffer(8)
+	addReplayTestReadings(sm, &Device{id: "123"}, 5)
+	s := newReadStream(nil, nil)
+	sm.resumeStream(s, resumePoint{epoch: sm.epoch, sequence: 2})
+
+	// Reading sets dispatched after the stream was added may also be in the
+	// replay buffer; they are only collected once.
+	for _, seq := range []uint64{5, 6} {
+		s.stream <- &ReadContext{Device: &Device{id: "123"}, sequence: seq}
+	}
+	close(s.stream)
+	s.listen()
+
+	var collected []*ReadContext
+	for len(s.readings) > 0 {
+		collected = append(collected, <-s.readings)
+	}
+	assert.Equal(t, []uint64{3, 4, 5, 6}, sequences(collected))
+}
+
+func TestResumePointFromMetadata(t *testing.T) {
+	point, ok, err := resumePointFromMetadata(nil)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, resumePoint{}, point)
+
+	point, ok, err = resumePointFromMetadata(grpcMetadata.Pairs("synse-stream-resume-from", "kf3a9:42"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, resumePoint{epoch: "kf3a9", sequence: 42}, point)
+
+	point, ok, err = resumePointFromMetadata(grpcMetadata.Pairs("synse-stream-resume-from", "42"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, resumePoint{sequence: 42}, point)
+
+	_, ok, err = resumePointFromMetadata(grpcMetadata.Pairs("synse-stream-resume-from", "kf3a9:-1"))
+	assert.Error(t, err)
+	assert.False(t, ok)
+}
+
+func TestSequenceContext(t *testing.T) {
+	ctx := map[string]string{"source": "foo"}
+
+	c := sequenceContext(ctx, "kf3a9", 12)
+	assert.Equal(t, map[string]string{"source": "foo", "synse-sequence": "12", "synse-sequence-epoch": "kf3a9"}, c)
+	// The original context is not modified.
+	assert.Equal(t, map[string]string{"source": "foo"}, ctx)
+
+	assert.Equal(t, map[string]string{"synse-sequence": "1", "synse-sequence-epoch": "kf3a9"}, sequenceContext(nil, "kf3a9", 1))
+}
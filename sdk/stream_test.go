@@ -22,17 +22,38 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
 	"github.com/vapor-ware/synse-sdk/v2/sdk/output"
 )
 
 func TestNewReadStream(t *testing.T) {
-	s := newReadStream([]string{"foo", "bar"})
+	s := newReadStream([]string{"foo", "bar"}, nil)
 
 	assert.NotNil(t, s.stream)
 	assert.NotNil(t, s.readings)
 	assert.NotNil(t, s.id)
 	assert.Equal(t, []string{"foo", "bar"}, s.filter)
 	assert.False(t, s.closed)
+	assert.Equal(t, defaultStreamBufferSize, cap(s.stream))
+	assert.Equal(t, streamOverflowDropOldest, s.policy)
+}
+
+func TestNewReadStream_withSettings(t *testing.T) {
+	s := newReadStream(nil, &config.StreamSettings{
+		BufferSize:     4,
+		OverflowPolicy: streamOverflowDisconnect,
+	})
+
+	assert.Equal(t, 4, cap(s.stream))
+	assert.Equal(t, 4, cap(s.readings))
+	assert.Equal(t, streamOverflowDisconnect, s.policy)
+}
+
+func TestNewReadStream_unknownPolicy(t *testing.T) {
+	s := newReadStream(nil, &config.StreamSettings{OverflowPolicy: "unknown"})
+
+	assert.Equal(t, defaultStreamBufferSize, cap(s.stream))
+	assert.Equal(t, streamOverflowDropOldest, s.policy)
 }
 
 func TestReadStream_close_openChannels(t *testing.T) {
@@ -74,6 +95,18 @@ func TestReadStream_close_nilChannels(t *testing.T) {
 	assert.True(t, s.closed)
 }
 
+func TestReadStream_close_alreadyClosed(t *testing.T) {
+	s := newReadStream(nil, nil)
+
+	s.close()
+	assert.True(t, s.closed)
+
+	assert.NotPanics(t, func() {
+		s.close()
+	})
+	assert.True(t, s.closed)
+}
+
 func TestReadStream_listen_withFilter(t *testing.T) {
 	s := ReadStream{
 		stream:   make(chan *ReadContext, 128),
@@ -280,3 +313,118 @@ func TestReadStream_undrainedStreamChannel(t *testing.T) {
 	_, open = <-readingsChan
 	assert.False(t, open)
 }
+
+func TestReadStream_dispatch(t *testing.T) {
+	s := newReadStream(nil, &config.StreamSettings{BufferSize: 2})
+
+	s.dispatch(&ReadContext{Device: &Device{id: "1"}})
+	s.dispatch(&ReadContext{Device: &Device{id: "2"}})
+
+	assert.Len(t, s.stream, 2)
+	assert.Equal(t, uint64(0), s.Dropped())
+}
+
+func TestReadStream_dispatch_dropOldest(t *testing.T) {
+	s := newReadStream(nil, &config.StreamSettings{
+		BufferSize:     2,
+		OverflowPolicy: streamOverflowDropOldest,
+	})
+
+	for _, id := range []string{"1", "2", "3", "4"} {
+		s.dispatch(&ReadContext{Device: &Device{id: id}})
+	}
+
+	assert.Equal(t, uint64(2), s.Dropped())
+	assert.Equal(t, "3", (<-s.stream).Device.id)
+	assert.Equal(t, "4", (<-s.stream).Device.id)
+	assert.False(t, s.isClosed())
+}
+
+func TestReadStream_dispatch_dropNewest(t *testing.T) {
+	s := newReadStream(nil, &config.StreamSettings{
+		BufferSize:     2,
+		OverflowPolicy: streamOverflowDropNewest,
+	})
+
+	for _, id := range []string{"1", "2", "3", "4"} {
+		s.dispatch(&ReadContext{Device: &Device{id: id}})
+	}
+
+	assert.Equal(t, uint64(2), s.Dropped())
+	assert.Equal(t, "1", (<-s.stream).Device.id)
+	assert.Equal(t, "2", (<-s.stream).Device.id)
+	assert.False(t, s.isClosed())
+}
+
+func TestReadStream_dispatch_disconnect(t *testing.T) {
+	s := newReadStream(nil, &config.StreamSettings{
+		BufferSize:     1,
+		OverflowPolicy: streamOverflowDisconnect,
+	})
+
+	s.dispatch(&ReadContext{Device: &Device{id: "1"}})
+	assert.False(t, s.isOverflowed())
+
+	s.dispatch(&ReadContext{Device: &Device{id: "2"}})
+	assert.True(t, s.isOverflowed())
+	assert.Equal(t, uint64(1), s.Dropped())
+
+	// The stream is closed in the background, ending the readings channel.
+	select {
+	case _, open := <-s.readings:
+		assert.False(t, open)
+	case <-time.After(time.Second):
+		t.Fatal("overflowed stream was not closed")
+	}
+	assert.True(t, s.isClosed())
+
+	// Dispatching to a closed stream has no effect.
+	assert.NotPanics(t, func() {
+		s.dispatch(&ReadContext{Device: &Device{id: "3"}})
+	})
+	assert.Equal(t, uint64(1), s.Dropped())
+}
+
+func TestReadStream_dispatch_closed(t *testing.T) {
+	s := newReadStream(nil, nil)
+	s.close()
+
+	assert.NotPanics(t, func() {
+		s.dispatch(&ReadContext{Device: &Device{id: "1"}})
+	})
+	assert.Equal(t, uint64(0), s.Dropped())
+}
+
+// A client which is not consuming readings does not block dispatch, even once
+// the stream's listener is blocked on the full readings channel.
+func TestReadStream_dispatch_slowConsumer(t *testing.T) {
+	s := newReadStream(nil, &config.StreamSettings{BufferSize: 2})
+	go s.listen()
+
+	dispatched := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			s.dispatch(&ReadContext{Device: &Device{id: "1"}})
+		}
+		close(dispatched)
+	}()
+
+	select {
+	case <-dispatched:
+	case <-time.After(time.Second):
+		t.Fatal("dispatch blocked on slow stream consumer")
+	}
+	assert.True(t, s.Dropped() > 0)
+
+	// Closing the stream releases the blocked listener.
+	closed := make(chan struct{})
+	go func() {
+		s.close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("close blocked on slow stream consumer")
+	}
+}
@@ -248,6 +248,8 @@ type TagCache struct {
 	// by decomposing the tag into its searchable components and traversing
 	// the cache.
 	cache map[string]map[string]map[string][]*Device
+
+	lock sync.RWMutex
 }
 
 // NewTagCache creates a new TagCache instance.
@@ -274,6 +276,9 @@ func (cache *TagCache) Add(tag *Tag, device *Device) {
 		"device":     device.id,
 	})
 
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
 	annotations, exists := cache.cache[tag.Namespace]
 	if !exists {
 		// If the namespace doesn't exist, add it with the rest of the tag info.
@@ -334,6 +339,9 @@ func (cache *TagCache) GetDevicesFromStrings(tags ...string) ([]*Device, error)
 
 // GetDevicesFromTags gets the list of Devices which match the given set of tags.
 func (cache *TagCache) GetDevicesFromTags(tags ...*Tag) []*Device {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+
 	var deviceSet filterSet
 
 	for _, tag := range tags {
@@ -364,7 +372,7 @@ func (cache *TagCache) GetDevicesFromTags(tags ...*Tag) []*Device {
 				continue
 			}
 
-			devices = cache.GetDevicesFromNamespace(tag.Namespace)
+			devices = cache.getDevicesFromNamespace(tag.Namespace)
 			deviceSet.Filter(devices)
 			continue
 		}
@@ -391,6 +399,15 @@ func (cache *TagCache) GetDevicesFromTags(tags ...*Tag) []*Device {
 
 // GetDevicesFromNamespace gets the devices for the specified namespaces.
 func (cache *TagCache) GetDevicesFromNamespace(namespaces ...string) []*Device {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+
+	return cache.getDevicesFromNamespace(namespaces...)
+}
+
+// getDevicesFromNamespace gets the devices for the specified namespaces. The cache
+// lock must be held when calling this.
+func (cache *TagCache) getDevicesFromNamespace(namespaces ...string) []*Device {
 	// Initially, store the devices in a map. This will allow us to remove duplicates
 	// which may be present, as a device can have a tag in multiple namespaces.
 	var deviceMap = make(map[string]*Device)
@@ -470,3 +487,45 @@ func newTypeTag(deviceType string) *Tag {
 		string:     fmt.Sprintf("%s/%s:%s", TagNamespaceSystem, TagAnnotationType, deviceType),
 	}
 }
+
+// Remove removes a device from the tag cache for all of its tags. Any labels,
+// annotations, or namespaces which no longer have devices are removed as well.
+func (cache *TagCache) Remove(device *Device) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	for _, tag := range device.Tags {
+		annotations, exists := cache.cache[tag.Namespace]
+		if !exists {
+			continue
+		}
+		labels, exists := annotations[tag.Annotation]
+		if !exists {
+			continue
+		}
+		devices, exists := labels[tag.Label]
+		if !exists {
+			continue
+		}
+
+		var remaining []*Device
+		for _, d := range devices {
+			if d.id != device.id {
+				remaining = append(remaining, d)
+			}
+		}
+
+		if len(remaining) > 0 {
+			labels[tag.Label] = remaining
+			continue
+		}
+		delete(labels, tag.Label)
+		if len(labels) == 0 {
+			delete(annotations, tag.Annotation)
+		}
+		if len(annotations) == 0 {
+			delete(cache.cache, tag.Namespace)
+		}
+	}
+	log.WithField("device", device.id).Debug("[tag] removed device from tag cache")
+}
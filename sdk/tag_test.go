@@ -953,6 +953,45 @@ func TestTagCache_GetDevicesFromNamespace_multipleNamespacesDuplicate(t *testing
 	assert.Len(t, devices, 6)
 }
 
+func TestTagCache_Remove(t *testing.T) {
+	cache := NewTagCache()
+	d1 := &Device{id: "123", Tags: []*Tag{
+		{Namespace: "foo", Annotation: "bar", Label: "baz"},
+		{Namespace: "foo", Annotation: "", Label: "only"},
+	}}
+	d2 := &Device{id: "456", Tags: []*Tag{
+		{Namespace: "foo", Annotation: "bar", Label: "baz"},
+	}}
+	cache.Add(d1.Tags[0], d1)
+	cache.Add(d1.Tags[1], d1)
+	cache.Add(d2.Tags[0], d2)
+
+	cache.Remove(d1)
+
+	devices := cache.GetDevicesFromTags(d1.Tags[0])
+	assert.Len(t, devices, 1)
+	assert.Equal(t, "456", devices[0].id)
+
+	// Tags which no longer have any devices are pruned.
+	assert.Empty(t, cache.GetDevicesFromTags(d1.Tags[1]))
+	assert.NotContains(t, cache.cache["foo"], "")
+
+	cache.Remove(d2)
+	assert.NotContains(t, cache.cache, "foo")
+}
+
+func TestTagCache_Remove_notCached(t *testing.T) {
+	cache := NewTagCache()
+	d := &Device{id: "123", Tags: []*Tag{
+		{Namespace: "foo", Annotation: "bar", Label: "baz"},
+	}}
+
+	assert.NotPanics(t, func() {
+		cache.Remove(d)
+	})
+	assert.Empty(t, cache.cache)
+}
+
 func TestDeviceSelectorToTags_withID(t *testing.T) {
 	tags := DeviceSelectorToTags(&synse.V3DeviceSelector{
 		Id: "1234",
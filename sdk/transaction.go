@@ -17,6 +17,9 @@
 package sdk
 
 import (
+	"context"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -32,6 +35,11 @@ const (
 	statusError   = synse.WriteStatus_ERROR
 )
 
+// statusNameCancelled is the name of the 'cancelled' transaction state. The Synse
+// gRPC API does not define a cancelled write status, so cancelled transactions
+// are reported with an ERROR status and a message noting the cancellation.
+const statusNameCancelled = "cancelled"
+
 // transaction represents an asynchronous write transaction for the Plugin. It
 // tracks the state and status of that transaction over its lifetime.
 type transaction struct {
@@ -43,6 +51,37 @@ type transaction struct {
 	timeout time.Duration
 	context *synse.V3WriteData
 	done    chan struct{}
+
+	// doneOnce ensures the done channel is only closed once, should the
+	// transaction be set to a terminal state more than once.
+	doneOnce sync.Once
+
+	// device is the device being written to.
+	device *Device
+
+	// events is the bus which transaction status changes are published to.
+	events *eventBus
+
+	// journal is the journal which transaction status changes are recorded to.
+	// It is nil if the transaction journal is not enabled.
+	journal *transactionJournal
+
+	// cancelled is set once the transaction has been cancelled. A cancelled
+	// transaction is terminal, with an ERROR status.
+	cancelled bool
+
+	// writeLock guards the state of the transaction's write. cancelRequested is
+	// set when cancellation of the transaction is requested. writeStarted is set
+	// once the write has started, and cancelWrite cancels the context of the
+	// in-progress write, if the write can be interrupted. claimed is set once the
+	// queued write has been taken to be executed or failed, and superseded is set
+	// if the queued write was replaced by a later write before it was claimed.
+	writeLock       sync.Mutex
+	cancelRequested bool
+	writeStarted    bool
+	cancelWrite     context.CancelFunc
+	claimed         bool
+	superseded      bool
 }
 
 // newTransaction creates a new transaction instance.
@@ -85,11 +124,111 @@ func (t *transaction) encode() *synse.V3TransactionStatus {
 	}
 }
 
+// statusName gets the lower-case name of the transaction's current state.
+func (t *transaction) statusName() string {
+	if t.cancelled {
+		return statusNameCancelled
+	}
+	return strings.ToLower(t.status.String())
+}
+
+// isDone checks whether the transaction is in a terminal state.
+func (t *transaction) isDone() bool {
+	select {
+	case <-t.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// requestCancel requests cancellation of the transaction's write. If the write has
+// not started, it will not be started. If the write is in progress, its context is
+// cancelled, provided the write can be interrupted; if it can not be, cancellation
+// is not requested and ErrWriteInProgress is returned, since the write may still
+// complete. The transaction is set to the cancelled state by the write itself.
+func (t *transaction) requestCancel() error {
+	t.writeLock.Lock()
+	defer t.writeLock.Unlock()
+
+	if t.writeStarted && t.cancelWrite == nil {
+		return ErrWriteInProgress
+	}
+	t.cancelRequested = true
+	if t.cancelWrite != nil {
+		t.cancelWrite()
+	}
+	return nil
+}
+
+// startWrite marks the transaction's write as started, registering the function
+// which cancels the write's context. The cancel function is nil if the write can
+// not be interrupted. It returns false if cancellation was already requested, in
+// which case the write should not be started.
+func (t *transaction) startWrite(cancel context.CancelFunc) bool {
+	t.writeLock.Lock()
+	defer t.writeLock.Unlock()
+
+	if t.cancelRequested {
+		return false
+	}
+	t.writeStarted = true
+	t.cancelWrite = cancel
+	return true
+}
+
+// isCancelRequested checks whether cancellation of the transaction was requested.
+func (t *transaction) isCancelRequested() bool {
+	t.writeLock.Lock()
+	defer t.writeLock.Unlock()
+
+	return t.cancelRequested
+}
+
+// claim claims the transaction's queued write, so that it may be executed or
+// failed. It returns false if the write was already claimed or was superseded,
+// in which case the transaction should not be updated.
+func (t *transaction) claim() bool {
+	t.writeLock.Lock()
+	defer t.writeLock.Unlock()
+
+	if t.claimed || t.superseded {
+		return false
+	}
+	t.claimed = true
+	return true
+}
+
+// isSuperseded checks whether the transaction's queued write was superseded by
+// a later write.
+func (t *transaction) isSuperseded() bool {
+	t.writeLock.Lock()
+	defer t.writeLock.Unlock()
+
+	return t.superseded
+}
+
+// supersede marks the transaction's queued write as superseded by a later write,
+// so it will not be executed. It returns false if the write was already claimed,
+// in which case it can no longer be superseded.
+func (t *transaction) supersede() bool {
+	t.writeLock.Lock()
+	defer t.writeLock.Unlock()
+
+	if t.claimed || t.superseded {
+		return false
+	}
+	t.superseded = true
+	return true
+}
+
 // setStatusPending sets the transaction status to 'pending'.
 func (t *transaction) setStatusPending() {
 	log.WithField("id", t.id).Debug("[transaction] transaction status set to PENDING")
 	t.updated = utils.GetCurrentTime()
 	t.status = statusPending
+	t.journal.record(t)
+	t.publishStatus()
 }
 
 // setStatusWriting sets the transaction status to 'writing'.
@@ -97,6 +236,8 @@ func (t *transaction) setStatusWriting() {
 	log.WithField("id", t.id).Debug("[transaction] transaction status set to WRITING")
 	t.updated = utils.GetCurrentTime()
 	t.status = statusWriting
+	t.journal.record(t)
+	t.publishStatus()
 }
 
 // setStatusDone sets the transaction status to 'done'.
@@ -104,10 +245,13 @@ func (t *transaction) setStatusDone() {
 	log.WithField("id", t.id).Debug("[transaction] transaction status set to DONE")
 	t.updated = utils.GetCurrentTime()
 	t.status = statusDone
+	t.journal.record(t)
+	t.publishStatus()
+	transactionsMetric.WithLabelValues(transactionDone).Inc()
 
 	// This is a terminal state, so close the done channel to unblock
 	// anything waiting on the transaction to complete.
-	close(t.done)
+	t.closeDone()
 }
 
 // setStatusError sets the transaction status to 'error'.
@@ -115,8 +259,44 @@ func (t *transaction) setStatusError() {
 	log.WithField("id", t.id).Debug("[transaction] transaction status set to ERROR")
 	t.updated = utils.GetCurrentTime()
 	t.status = statusError
+	t.journal.record(t)
+	t.publishStatus()
+	transactionsMetric.WithLabelValues(transactionError).Inc()
 
 	// This is a terminal state, so close the done channel to unblock
 	// anything waiting on the transaction to complete.
-	close(t.done)
+	t.closeDone()
+}
+
+// setStatusCancelled sets the transaction status to 'cancelled'.
+func (t *transaction) setStatusCancelled() {
+	log.WithField("id", t.id).Debug("[transaction] transaction status set to CANCELLED")
+	t.updated = utils.GetCurrentTime()
+	t.status = statusError
+	t.cancelled = true
+	t.journal.record(t)
+	t.publishStatus()
+	transactionsMetric.WithLabelValues(transactionCancelled).Inc()
+
+	// This is a terminal state, so close the done channel to unblock
+	// anything waiting on the transaction to complete.
+	t.closeDone()
+}
+
+// closeDone closes the transaction's done channel, if it is not already closed.
+func (t *transaction) closeDone() {
+	t.doneOnce.Do(func() {
+		close(t.done)
+	})
+}
+
+// publishStatus publishes an event for the transaction's current status.
+func (t *transaction) publishStatus() {
+	t.events.publish(Event{
+		Type:        EventTransactionStatus,
+		Device:      t.device,
+		Transaction: t.id,
+		Status:      t.statusName(),
+		Message:     t.message,
+	})
 }
@@ -0,0 +1,364 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/utils"
+	synse "github.com/vapor-ware/synse-server-grpc/go"
+)
+
+// interruptedTransactionMessage is the message set on journaled transactions which
+// had not completed when the plugin stopped.
+const interruptedTransactionMessage = "write interrupted: plugin stopped before the transaction completed; the write may or may not have been applied"
+
+// transactionRecord is the journaled state of a write transaction at the time
+// of one of its status changes.
+type transactionRecord struct {
+	ID        string             `json:"id"`
+	Device    string             `json:"device,omitempty"`
+	Status    string             `json:"status"`
+	Created   string             `json:"created"`
+	Updated   string             `json:"updated"`
+	Message   string             `json:"message,omitempty"`
+	Timeout   string             `json:"timeout"`
+	Context   *synse.V3WriteData `json:"context,omitempty"`
+	Cancelled bool               `json:"cancelled,omitempty"`
+	Recorded  time.Time          `json:"recorded"`
+}
+
+// newTransactionRecord creates a journal record for the current state of the
+// given transaction.
+func newTransactionRecord(t *transaction) *transactionRecord {
+	record := &transactionRecord{
+		ID:        t.id,
+		Status:    t.status.String(),
+		Created:   t.created,
+		Updated:   t.updated,
+		Message:   t.message,
+		Timeout:   t.timeout.String(),
+		Context:   t.context,
+		Cancelled: t.cancelled,
+		Recorded:  time.Now().UTC(),
+	}
+	if t.device != nil {
+		record.Device = t.device.id
+	}
+	return record
+}
+
+// terminal checks whether the record holds a terminal transaction status.
+func (record *transactionRecord) terminal() bool {
+	status := synse.WriteStatus(synse.WriteStatus_value[record.Status])
+	return status == statusDone || status == statusError
+}
+
+// transaction creates a completed transaction from the record. Records for
+// transactions in a non-terminal state should be marked as interrupted first.
+func (record *transactionRecord) transaction() *transaction {
+	timeout, err := time.ParseDuration(record.Timeout)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"id":      record.ID,
+			"timeout": record.Timeout,
+		}).Warn("[transaction journal] failed to parse journaled transaction timeout")
+	}
+
+	done := make(chan struct{})
+	close(done)
+
+	return &transaction{
+		id:        record.ID,
+		status:    synse.WriteStatus(synse.WriteStatus_value[record.Status]),
+		created:   record.Created,
+		updated:   record.Updated,
+		message:   record.Message,
+		timeout:   timeout,
+		context:   record.Context,
+		cancelled: record.Cancelled,
+		done:      done,
+	}
+}
+
+// transactionJournal records write transaction status changes to an append-only
+// file on disk, so the outcome of writes is retained across plugin restarts.
+//
+// Each status change is appended to the journal as a line of JSON. When the
+// journal is opened, the latest record for each transaction is loaded. Any
+// transaction which had not reached a terminal state is marked as an error,
+// since the plugin stopped while it was in progress. Records for transactions
+// older than the transaction TTL are compacted out of the journal when it is
+// opened, and periodically while it is open.
+type transactionJournal struct {
+	path string
+	ttl  time.Duration
+
+	lock   sync.Mutex
+	file   *os.File
+	isOpen bool
+	stop   chan struct{}
+}
+
+// newTransactionJournal creates a new transaction journal. The journal must be
+// opened prior to use.
+func newTransactionJournal(conf *config.TransactionSettings) *transactionJournal {
+	return &transactionJournal{
+		path: conf.JournalPath,
+		ttl:  conf.TTL,
+	}
+}
+
+// Open loads the journaled transactions, marking any which did not complete as
+// interrupted, compacts the journal, and opens it for recording. It returns the
+// latest record for each transaction retained in the journal.
+func (j *transactionJournal) Open() ([]*transactionRecord, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.isOpen {
+		return nil, nil
+	}
+
+	log.WithField("path", j.path).Info("[transaction journal] opening transaction journal")
+	if err := os.MkdirAll(filepath.Dir(j.path), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	records, err := j.load(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.terminal() {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"id":     record.ID,
+			"device": record.Device,
+			"status": record.Status,
+		}).Warn("[transaction journal] marking interrupted transaction as failed")
+
+		record.Status = statusError.String()
+		record.Message = interruptedTransactionMessage
+		record.Updated = utils.GetCurrentTime()
+		record.Recorded = time.Now().UTC()
+	}
+
+	if err := j.rewrite(records); err != nil {
+		return nil, err
+	}
+
+	j.stop = make(chan struct{})
+	j.isOpen = true
+	if j.ttl > 0 {
+		go j.runCompaction(j.stop)
+	}
+	return records, nil
+}
+
+// record appends the current state of the transaction to the journal. Failure to
+// record the transaction does not fail the write, so errors are only logged.
+// Recording to a nil or closed journal does nothing.
+func (j *transactionJournal) record(t *transaction) {
+	if j == nil {
+		return
+	}
+
+	line, err := json.Marshal(newTransactionRecord(t))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"id":    t.id,
+			"error": err,
+		}).Error("[transaction journal] failed to encode transaction record")
+		return
+	}
+	line = append(line, '\n')
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if !j.isOpen || j.file == nil {
+		log.WithField("id", t.id).Debug("[transaction journal] journal not open, transaction not recorded")
+		return
+	}
+	if _, err := j.file.Write(line); err != nil {
+		log.WithFields(log.Fields{
+			"id":    t.id,
+			"error": err,
+		}).Error("[transaction journal] failed to record transaction")
+		return
+	}
+	// Sync each record so transaction state is not lost if the plugin crashes.
+	if err := j.file.Sync(); err != nil {
+		log.WithFields(log.Fields{
+			"id":    t.id,
+			"error": err,
+		}).Error("[transaction journal] failed to sync transaction journal")
+	}
+}
+
+// Close stops compaction and closes the journal file.
+func (j *transactionJournal) Close() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if !j.isOpen {
+		return nil
+	}
+	log.Info("[transaction journal] closing transaction journal")
+
+	j.isOpen = false
+	close(j.stop)
+
+	var err error
+	if j.file != nil {
+		err = j.file.Close()
+		j.file = nil
+	}
+	return err
+}
+
+// load reads the latest record for each transaction in the journal, in the order
+// the transactions were first recorded. Records older than the TTL are skipped. A
+// journal which does not exist yet has no records.
+func (j *transactionJournal) load(now time.Time) ([]*transactionRecord, error) {
+	f, err := os.Open(j.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var order []string
+	latest := map[string]*transactionRecord{}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var record transactionRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// A partially written record (e.g. from a crash mid-write) is skipped.
+			log.WithField("error", err).Warn("[transaction journal] skipping unreadable transaction record")
+			continue
+		}
+		if _, exists := latest[record.ID]; !exists {
+			order = append(order, record.ID)
+		}
+		latest[record.ID] = &record
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	records := make([]*transactionRecord, 0, len(order))
+	for _, id := range order {
+		record := latest[id]
+		if j.ttl > 0 && record.Recorded.Before(now.Add(-j.ttl)) {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// rewrite replaces the journal with one holding only the given records, then
+// opens the new journal for appending. The journal lock must be held when
+// calling this.
+func (j *transactionJournal) rewrite(records []*transactionRecord) error {
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(f)
+	encoder := json.NewEncoder(writer)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+	if j.file != nil {
+		if err := j.file.Close(); err != nil {
+			log.WithField("error", err).Warn("[transaction journal] failed to close transaction journal")
+		}
+		j.file = nil
+	}
+
+	j.file, err = os.OpenFile(j.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	return err
+}
+
+// compact removes records for expired transactions, and superseded records for
+// all other transactions, from the journal. The journal lock must be held when
+// calling this.
+func (j *transactionJournal) compact(now time.Time) error {
+	records, err := j.load(now)
+	if err != nil {
+		return err
+	}
+	return j.rewrite(records)
+}
+
+// runCompaction periodically compacts the journal until the stop channel is closed.
+func (j *transactionJournal) runCompaction(stop chan struct{}) {
+	ticker := time.NewTicker(j.ttl)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			j.lock.Lock()
+			var err error
+			if j.isOpen {
+				err = j.compact(now)
+			}
+			j.lock.Unlock()
+			if err != nil {
+				log.WithField("error", err).Error("[transaction journal] failed to compact transaction journal")
+			}
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
+	synse "github.com/vapor-ware/synse-server-grpc/go"
+)
+
+func TestTransactionJournal_recordClosed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.log")
+	j := newTransactionJournal(&config.TransactionSettings{TTL: time.Minute, JournalPath: path})
+
+	assert.NotPanics(t, func() {
+		j.record(newTransaction(time.Second, "abc"))
+	})
+
+	var nilJournal *transactionJournal
+	assert.NotPanics(t, func() {
+		nilJournal.record(newTransaction(time.Second, "abc"))
+	})
+}
+
+func TestTransactionJournal_restart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "txn", "transactions.log")
+
+	// Run some writes before a restart.
+	sm := newTestStateManager(nil)
+	sm.journal = newTransactionJournal(&config.TransactionSettings{TTL: time.Minute, JournalPath: path})
+	assert.NoError(t, sm.openJournal())
+
+	done, err := sm.newTransaction(time.Second, "done")
+	assert.NoError(t, err)
+	done.device = &Device{id: "123"}
+	done.context = &synse.V3WriteData{Action: "cycle"}
+	done.setStatusPending()
+	done.setStatusWriting()
+	done.setStatusDone()
+
+	failed, err := sm.newTransaction(time.Second, "failed")
+	assert.NoError(t, err)
+	failed.setStatusWriting()
+	failed.message = "write error"
+	failed.setStatusError()
+
+	interrupted, err := sm.newTransaction(time.Second, "interrupted")
+	assert.NoError(t, err)
+	interrupted.setStatusPending()
+	interrupted.setStatusWriting()
+
+	// Simulate a crash: the journal is not closed, and the in-memory state is lost.
+	sm = newTestStateManager(nil)
+	sm.journal = newTransactionJournal(&config.TransactionSettings{TTL: time.Minute, JournalPath: path})
+	assert.NoError(t, sm.openJournal())
+	defer sm.closeJournal()
+
+	txn := sm.getTransaction("done")
+	assert.NotNil(t, txn)
+	assert.Equal(t, statusDone, txn.status)
+	assert.Equal(t, time.Second, txn.timeout)
+	assert.Equal(t, "cycle", txn.context.Action)
+
+	txn = sm.getTransaction("failed")
+	assert.NotNil(t, txn)
+	assert.Equal(t, statusError, txn.status)
+	assert.Equal(t, "write error", txn.message)
+
+	txn = sm.getTransaction("interrupted")
+	assert.NotNil(t, txn)
+	assert.Equal(t, statusError, txn.status)
+	assert.Equal(t, interruptedTransactionMessage, txn.message)
+
+	// Restored transactions are complete, so waiting on them does not block.
+	waited := make(chan struct{})
+	go func() {
+		txn.wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("restored transaction is not complete")
+	}
+
+	// The journal is compacted to the latest record for each transaction.
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Len(t, strings.Split(strings.TrimSpace(string(data)), "\n"), 3)
+}
+
+func TestTransactionJournal_expired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.log")
+
+	sm := newTestStateManager(nil)
+	sm.journal = newTransactionJournal(&config.TransactionSettings{TTL: time.Minute, JournalPath: path})
+	assert.NoError(t, sm.openJournal())
+	txn, err := sm.newTransaction(time.Second, "old")
+	assert.NoError(t, err)
+	txn.setStatusDone()
+	assert.NoError(t, sm.journal.Close())
+
+	// Records older than the TTL are dropped when the journal is opened.
+	j := newTransactionJournal(&config.TransactionSettings{TTL: time.Minute, JournalPath: path})
+	records, err := j.load(time.Now().Add(2 * time.Minute))
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+
+	records, err = j.load(time.Now())
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+}
+
+func TestTransactionJournal_load_partialRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.log")
+
+	sm := newTestStateManager(nil)
+	sm.journal = newTransactionJournal(&config.TransactionSettings{TTL: time.Minute, JournalPath: path})
+	assert.NoError(t, sm.openJournal())
+	txn, err := sm.newTransaction(time.Second, "abc")
+	assert.NoError(t, err)
+	txn.setStatusDone()
+	assert.NoError(t, sm.journal.Close())
+
+	// Append a partially written record, as if the plugin crashed mid-write.
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(path, append(data, []byte(`{"id":"def","sta`)...), 0644))
+
+	records, err := sm.journal.load(time.Now())
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, "abc", records[0].ID)
+}
+
+func TestTransactionJournal_Close(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.log")
+	j := newTransactionJournal(&config.TransactionSettings{TTL: time.Minute, JournalPath: path})
+
+	_, err := j.Open()
+	assert.NoError(t, err)
+	assert.NoError(t, j.Close())
+
+	// Closing again is a no-op.
+	assert.NoError(t, j.Close())
+}
+
+func TestStateManager_registerActions_journal(t *testing.T) {
+	plugin := Plugin{}
+	sm := stateManager{
+		journal: newTransactionJournal(&config.TransactionSettings{}),
+	}
+
+	sm.registerActions(&plugin)
+	assert.Len(t, plugin.preRun, 2)
+}
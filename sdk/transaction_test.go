@@ -132,3 +132,10 @@ func TestTransaction_encode(t *testing.T) {
 	assert.Equal(t, tr.updated, encoded.Updated)
 	assert.Equal(t, tr.message, encoded.Message)
 }
+
+func TestTransaction_closeDone(t *testing.T) {
+	txn := newTransaction(time.Second, "")
+	txn.setStatusDone()
+	assert.NotPanics(t, txn.setStatusError)
+	assert.True(t, txn.isDone())
+}
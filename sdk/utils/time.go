@@ -17,24 +17,92 @@
 package utils
 
 import (
+	"fmt"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// Timestamp precisions, which determine the resolution of the timestamps
+// generated by the SDK.
+const (
+	PrecisionSecond      = "second"
+	PrecisionMillisecond = "millisecond"
+	PrecisionMicrosecond = "microsecond"
+	PrecisionNanosecond  = "nanosecond"
+)
+
+// timestampPrecision describes how timestamps are formatted for a precision.
+type timestampPrecision struct {
+	layout     string
+	resolution time.Duration
+}
+
+// precisions are the supported timestamp precisions. Sub-second layouts use a
+// fixed number of fractional digits, so timestamps of the same precision sort
+// lexically in time order. All layouts are valid RFC3339 (and RFC3339Nano).
+var precisions = map[string]timestampPrecision{
+	PrecisionSecond:      {layout: time.RFC3339, resolution: time.Second},
+	PrecisionMillisecond: {layout: "2006-01-02T15:04:05.000Z07:00", resolution: time.Millisecond},
+	PrecisionMicrosecond: {layout: "2006-01-02T15:04:05.000000Z07:00", resolution: time.Microsecond},
+	PrecisionNanosecond:  {layout: "2006-01-02T15:04:05.000000000Z07:00", resolution: time.Nanosecond},
+}
+
+var (
+	precisionLock sync.RWMutex
+	precision     = precisions[PrecisionSecond]
+)
+
+// SetTimestampPrecision sets the precision of the timestamps generated by the
+// SDK. It should be one of "second", "millisecond", "microsecond", or
+// "nanosecond". If empty, second precision is used.
+func SetTimestampPrecision(p string) error {
+	if p == "" {
+		p = PrecisionSecond
+	}
+	tp, ok := precisions[p]
+	if !ok {
+		return fmt.Errorf("unsupported timestamp precision: %s", p)
+	}
+
+	precisionLock.Lock()
+	defer precisionLock.Unlock()
+	precision = tp
+	return nil
+}
+
+// TimestampResolution gets the resolution of the timestamps generated by the
+// SDK, based on the configured timestamp precision.
+func TimestampResolution() time.Duration {
+	precisionLock.RLock()
+	defer precisionLock.RUnlock()
+	return precision.resolution
+}
+
+// FormatTime formats the given time, with location set to UTC, as an RFC3339
+// timestamp with the configured timestamp precision.
+func FormatTime(t time.Time) string {
+	precisionLock.RLock()
+	layout := precision.layout
+	precisionLock.RUnlock()
+	return t.UTC().Format(layout)
+}
+
 // GetCurrentTime return the current time (time.Now()), with location set to UTC,
-// as a string formatted with the RFC3339 layout. This should be the format
-// of all timestamps returned by the SDK.
+// as a string formatted with the RFC3339 layout, at the configured timestamp
+// precision. This should be the format of all timestamps returned by the SDK.
 //
 // The SDK uses this function to generate all of its timestamps. It is highly
 // recommended that plugins use this as well for timestamp generation.
 func GetCurrentTime() string {
-	return time.Now().UTC().Format(time.RFC3339)
+	return FormatTime(time.Now())
 }
 
 // ParseRFC3339 parses a timestamp string in RFC3339 format into a Time struct.
-// If it is given an empty string, it will return the zero-value for a Time
-// instance. You can check if it is a zero time with the Time's `IsZero` method.
+// Timestamps with fractional seconds are supported. If it is given an empty string,
+// it will return the zero-value for a Time instance. You can check if it is a zero
+// time with the Time's `IsZero` method.
 func ParseRFC3339(timestamp string) (t time.Time, err error) {
 	if timestamp == "" {
 		return
@@ -106,3 +106,53 @@ func TestGetCurrentTime(t *testing.T) {
 	out := GetCurrentTime()
 	assert.NotEmpty(t, out)
 }
+
+func TestSetTimestampPrecision(t *testing.T) {
+	defer func() {
+		assert.NoError(t, SetTimestampPrecision(PrecisionSecond))
+	}()
+
+	ts := time.Date(2019, 3, 22, 9, 48, 1, 123456789, time.UTC)
+	tests := []struct {
+		precision  string
+		expected   string
+		resolution time.Duration
+	}{
+		{"", "2019-03-22T09:48:01Z", time.Second},
+		{PrecisionSecond, "2019-03-22T09:48:01Z", time.Second},
+		{PrecisionMillisecond, "2019-03-22T09:48:01.123Z", time.Millisecond},
+		{PrecisionMicrosecond, "2019-03-22T09:48:01.123456Z", time.Microsecond},
+		{PrecisionNanosecond, "2019-03-22T09:48:01.123456789Z", time.Nanosecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.precision, func(t *testing.T) {
+			assert.NoError(t, SetTimestampPrecision(tt.precision))
+			assert.Equal(t, tt.expected, FormatTime(ts))
+			assert.Equal(t, tt.resolution, TimestampResolution())
+
+			// Formatted timestamps parse back to the time at their resolution.
+			parsed, err := ParseRFC3339(FormatTime(ts))
+			assert.NoError(t, err)
+			assert.Equal(t, ts.Truncate(tt.resolution), parsed)
+		})
+	}
+}
+
+func TestSetTimestampPrecision_fixedWidth(t *testing.T) {
+	defer func() {
+		assert.NoError(t, SetTimestampPrecision(PrecisionSecond))
+	}()
+	assert.NoError(t, SetTimestampPrecision(PrecisionMillisecond))
+
+	// Sub-second timestamps have a fixed width, so they sort lexically.
+	ts := time.Date(2019, 3, 22, 9, 48, 1, 0, time.UTC)
+	assert.Equal(t, "2019-03-22T09:48:01.000Z", FormatTime(ts))
+	assert.True(t, FormatTime(ts.Add(100*time.Millisecond)) < FormatTime(ts.Add(120*time.Millisecond)))
+}
+
+func TestSetTimestampPrecision_invalid(t *testing.T) {
+	err := SetTimestampPrecision("minute")
+	assert.Error(t, err)
+	assert.Equal(t, time.Second, TimestampResolution())
+}
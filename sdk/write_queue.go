@@ -0,0 +1,235 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Write serialization modes, which determine which writes are executed one at a
+// time when running in parallel mode.
+const (
+	serializeByDevice     = "device"
+	serializeByHandler    = "handler"
+	serializeByNone       = "none"
+	serializeByDataPrefix = "data:"
+)
+
+// WritePriority is the priority of a device write. Queued writes with a higher
+// priority are processed before those with a lower priority.
+type WritePriority int
+
+// Write priorities. Writes have normal priority unless a different priority is
+// assigned by their device handler.
+const (
+	WritePriorityLow    WritePriority = -1
+	WritePriorityNormal WritePriority = 0
+	WritePriorityHigh   WritePriority = 1
+)
+
+// writePriorities are the write priorities, ordered from highest to lowest.
+var writePriorities = []WritePriority{
+	WritePriorityHigh,
+	WritePriorityNormal,
+	WritePriorityLow,
+}
+
+// String gets the name of the write priority.
+func (p WritePriority) String() string {
+	switch p {
+	case WritePriorityHigh:
+		return "high"
+	case WritePriorityNormal:
+		return "normal"
+	case WritePriorityLow:
+		return "low"
+	default:
+		return fmt.Sprintf("priority(%d)", int(p))
+	}
+}
+
+// newWriteQueues creates the write queues for all write priorities other than
+// normal priority, whose writes are queued to the scheduler's writeChan.
+func newWriteQueues(size int) map[WritePriority]chan *WriteContext {
+	queues := make(map[WritePriority]chan *WriteContext)
+	for _, p := range writePriorities {
+		if p != WritePriorityNormal {
+			queues[p] = make(chan *WriteContext, size)
+		}
+	}
+	return queues
+}
+
+// priorityQueue is the write queue for a write priority.
+type priorityQueue struct {
+	priority WritePriority
+	queue    chan *WriteContext
+}
+
+// writeQueue gets the queue for writes of the given priority. If there is no queue
+// for the priority, the normal priority queue is used.
+func (scheduler *scheduler) writeQueue(p WritePriority) chan *WriteContext {
+	if queue, ok := scheduler.writeQueues[p]; ok {
+		return queue
+	}
+	return scheduler.writeChan
+}
+
+// priorityQueues gets the scheduler's write queues, ordered from highest to lowest
+// priority.
+func (scheduler *scheduler) priorityQueues() []priorityQueue {
+	var queues []priorityQueue
+	for _, p := range writePriorities {
+		if p == WritePriorityNormal {
+			queues = append(queues, priorityQueue{priority: p, queue: scheduler.writeChan})
+		} else if queue, ok := scheduler.writeQueues[p]; ok {
+			queues = append(queues, priorityQueue{priority: p, queue: queue})
+		}
+	}
+	return queues
+}
+
+// queuedWrites gets the total number of writes queued across all priorities.
+func (scheduler *scheduler) queuedWrites() int {
+	var total int
+	for _, q := range scheduler.priorityQueues() {
+		total += len(q.queue)
+	}
+	return total
+}
+
+// nextWrite takes the next write to process from the write queues, or returns nil
+// if no writes are queued. This should only be called from the write loop.
+//
+// Writes are taken from the highest priority queue which has queued writes. Each
+// time a lower priority queue with queued writes is passed over, its skip count
+// is incremented; once it reaches the configured starvation limit, the next write
+// is taken from that queue instead, so lower priority writes are not starved.
+func (scheduler *scheduler) nextWrite() *WriteContext {
+	if scheduler.writeSkips == nil {
+		scheduler.writeSkips = make(map[WritePriority]int)
+	}
+
+	var limit int
+	if scheduler.config != nil && scheduler.config.Write != nil {
+		limit = scheduler.config.Write.StarvationLimit
+	}
+	queues := scheduler.priorityQueues()
+
+	// Service any starved queue first, starting from the lowest priority.
+	if limit > 0 {
+		for i := len(queues) - 1; i >= 0; i-- {
+			q := queues[i]
+			if scheduler.writeSkips[q.priority] < limit {
+				continue
+			}
+			scheduler.writeSkips[q.priority] = 0
+			if w := takeWrite(q.queue); w != nil {
+				return w
+			}
+		}
+	}
+
+	var next *WriteContext
+	for _, q := range queues {
+		if next == nil {
+			next = takeWrite(q.queue)
+			scheduler.writeSkips[q.priority] = 0
+			continue
+		}
+		// A higher priority write was taken, so this queue is passed over.
+		if len(q.queue) > 0 {
+			scheduler.writeSkips[q.priority]++
+		} else {
+			scheduler.writeSkips[q.priority] = 0
+		}
+	}
+	return next
+}
+
+// takeWrite takes a write from the queue without blocking. If the queue has no
+// writes, nil is returned.
+func takeWrite(queue chan *WriteContext) *WriteContext {
+	select {
+	case w := <-queue:
+		return w
+	default:
+		return nil
+	}
+}
+
+// writeQueueDepths describes the number of writes queued at each priority, along
+// with the queue capacity, e.g. "high: 0/128, normal: 5/128, low: 2/128".
+func (scheduler *scheduler) writeQueueDepths() string {
+	var depths []string
+	for _, q := range scheduler.priorityQueues() {
+		depths = append(depths, fmt.Sprintf("%s: %d/%d", q.priority, len(q.queue), cap(q.queue)))
+	}
+	return strings.Join(depths, ", ")
+}
+
+// writeSerializationKey gets the key which determines whether a write is serialized
+// with other writes. Writes with the same key are executed one at a time, in order.
+// If the write is not serialized, an empty string is returned.
+func (scheduler *scheduler) writeSerializationKey(w *WriteContext) string {
+	if w.device == nil {
+		return ""
+	}
+
+	var serializeBy string
+	if scheduler.config != nil && scheduler.config.Write != nil {
+		serializeBy = scheduler.config.Write.SerializeBy
+	}
+
+	switch {
+	case serializeBy == serializeByNone:
+		return ""
+	case serializeBy == serializeByHandler:
+		if w.device.handler != nil {
+			return "handler/" + w.device.handler.Name
+		}
+	case strings.HasPrefix(serializeBy, serializeByDataPrefix):
+		field := strings.TrimPrefix(serializeBy, serializeByDataPrefix)
+		if value, ok := w.device.Data[field]; ok {
+			return fmt.Sprintf("data/%s/%v", field, value)
+		}
+	}
+	return "device/" + w.device.id
+}
+
+// groupWrites groups a batch of writes by their serialization key, keeping the
+// order of the writes within each group. Writes which are not serialized are each
+// put in their own group. Groups are ordered by their first write in the batch.
+func (scheduler *scheduler) groupWrites(batch []*WriteContext) [][]*WriteContext {
+	var groups [][]*WriteContext
+	index := make(map[string]int)
+	for _, w := range batch {
+		key := scheduler.writeSerializationKey(w)
+		if key == "" {
+			groups = append(groups, []*WriteContext{w})
+			continue
+		}
+		if i, ok := index[key]; ok {
+			groups[i] = append(groups[i], w)
+			continue
+		}
+		index[key] = len(groups)
+		groups = append(groups, []*WriteContext{w})
+	}
+	return groups
+}
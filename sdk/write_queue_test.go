@@ -0,0 +1,481 @@
+// Synse SDK
+// Copyright (c) 2017-2022 Vapor IO
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package sdk
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/vapor-ware/synse-sdk/v2/sdk/config"
+	synse "github.com/vapor-ware/synse-server-grpc/go"
+)
+
+// newTestPriorityScheduler creates a scheduler with write queues for all priorities.
+func newTestPriorityScheduler(starvationLimit int) *scheduler {
+	return &scheduler{
+		config: &config.PluginSettings{
+			Write: &config.WriteSettings{StarvationLimit: starvationLimit},
+		},
+		writeChan:   make(chan *WriteContext, 8),
+		writeQueues: newWriteQueues(8),
+	}
+}
+
+// queueTestWrite queues a write with the given priority, using the priority as
+// the write action so the write can be identified.
+func queueTestWrite(t *testing.T, s *scheduler, p WritePriority) {
+	err := s.enqueue(&WriteContext{
+		transaction: newTransaction(time.Second, ""),
+		device:      &Device{id: "123"},
+		data:        &synse.V3WriteData{Action: p.String()},
+		priority:    p,
+	})
+	assert.NoError(t, err)
+}
+
+// nextTestWrites takes the given number of writes from the scheduler's queues,
+// returning their actions.
+func nextTestWrites(s *scheduler, n int) []string {
+	var actions []string
+	for i := 0; i < n; i++ {
+		w := s.nextWrite()
+		if w == nil {
+			actions = append(actions, "")
+			continue
+		}
+		actions = append(actions, w.data.Action)
+	}
+	return actions
+}
+
+func TestWritePriority_String(t *testing.T) {
+	assert.Equal(t, "high", WritePriorityHigh.String())
+	assert.Equal(t, "normal", WritePriorityNormal.String())
+	assert.Equal(t, "low", WritePriorityLow.String())
+	assert.Equal(t, "priority(5)", WritePriority(5).String())
+}
+
+func TestScheduler_enqueue_priority(t *testing.T) {
+	s := newTestPriorityScheduler(0)
+
+	queueTestWrite(t, s, WritePriorityHigh)
+	queueTestWrite(t, s, WritePriorityLow)
+	queueTestWrite(t, s, WritePriorityLow)
+
+	assert.Len(t, s.writeQueues[WritePriorityHigh], 1)
+	assert.Len(t, s.writeChan, 0)
+	assert.Len(t, s.writeQueues[WritePriorityLow], 2)
+	assert.Equal(t, 3, s.queuedWrites())
+	assert.Equal(t, "high: 1/8, normal: 0/8, low: 2/8", s.writeQueueDepths())
+}
+
+func TestScheduler_enqueue_noPriorityQueues(t *testing.T) {
+	s := scheduler{
+		writeChan: make(chan *WriteContext, 2),
+	}
+
+	// Without priority queues, all writes are queued to the normal priority queue.
+	queueTestWrite(t, &s, WritePriorityHigh)
+	assert.Len(t, s.writeChan, 1)
+	assert.Equal(t, "normal: 1/2", s.writeQueueDepths())
+}
+
+func TestScheduler_nextWrite_empty(t *testing.T) {
+	s := newTestPriorityScheduler(4)
+	assert.Nil(t, s.nextWrite())
+}
+
+func TestScheduler_nextWrite_priorityOrder(t *testing.T) {
+	s := newTestPriorityScheduler(0)
+
+	queueTestWrite(t, s, WritePriorityLow)
+	queueTestWrite(t, s, WritePriorityNormal)
+	queueTestWrite(t, s, WritePriorityHigh)
+	queueTestWrite(t, s, WritePriorityNormal)
+
+	assert.Equal(t, []string{"high", "normal", "normal", "low", ""}, nextTestWrites(s, 5))
+}
+
+func TestScheduler_nextWrite_starvation(t *testing.T) {
+	s := newTestPriorityScheduler(2)
+
+	for i := 0; i < 6; i++ {
+		queueTestWrite(t, s, WritePriorityHigh)
+	}
+	queueTestWrite(t, s, WritePriorityLow)
+
+	// The low priority write is processed once it has been passed over twice.
+	assert.Equal(t, []string{"high", "high", "low", "high", "high", "high", "high"}, nextTestWrites(s, 7))
+}
+
+func TestScheduler_nextWrite_noStarvationLimit(t *testing.T) {
+	s := newTestPriorityScheduler(0)
+
+	for i := 0; i < 4; i++ {
+		queueTestWrite(t, s, WritePriorityHigh)
+	}
+	queueTestWrite(t, s, WritePriorityLow)
+
+	assert.Equal(t, []string{"high", "high", "high", "high", "low"}, nextTestWrites(s, 5))
+}
+
+func TestScheduler_removeQueuedWrites_priorities(t *testing.T) {
+	s := newTestPriorityScheduler(0)
+
+	queueTestWrite(t, s, WritePriorityLow)
+	queueTestWrite(t, s, WritePriorityHigh)
+	queueTestWrite(t, s, WritePriorityNormal)
+
+	removed := s.removeQueuedWrites(func(w *WriteContext) bool {
+		return w.priority != WritePriorityNormal
+	})
+	assert.Len(t, removed, 2)
+	assert.Equal(t, WritePriorityHigh, removed[0].priority)
+	assert.Equal(t, WritePriorityLow, removed[1].priority)
+	assert.Equal(t, 1, s.queuedWrites())
+	assert.Len(t, s.writeChan, 1)
+}
+
+func TestScheduler_Write_priority(t *testing.T) {
+	s := newTestPriorityScheduler(0)
+	s.stateManager = &stateManager{
+		transactions: cache.New(1*time.Minute, 2*time.Minute),
+	}
+
+	device := &Device{
+		id: "123",
+		handler: &DeviceHandler{
+			Write:            func(*Device, *WriteData) error { return nil },
+			ActionPriorities: map[string]WritePriority{"power-off": WritePriorityHigh},
+		},
+	}
+
+	_, err := s.Write(device, []*synse.V3WriteData{{Action: "led"}, {Action: "power-off"}})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "power-off", s.nextWrite().data.Action)
+	assert.Equal(t, "led", s.nextWrite().data.Action)
+}
+
+func TestDeviceHandler_writePriority(t *testing.T) {
+	var nilHandler *DeviceHandler
+	assert.Equal(t, WritePriorityNormal, nilHandler.writePriority(nil, &synse.V3WriteData{}))
+
+	handler := &DeviceHandler{
+		ActionPriorities: map[string]WritePriority{
+			"power-off": WritePriorityHigh,
+			"led":       WritePriorityLow,
+		},
+	}
+	assert.Equal(t, WritePriorityHigh, handler.writePriority(nil, &synse.V3WriteData{Action: "power-off"}))
+	assert.Equal(t, WritePriorityLow, handler.writePriority(nil, &synse.V3WriteData{Action: "led"}))
+	assert.Equal(t, WritePriorityNormal, handler.writePriority(nil, &synse.V3WriteData{Action: "other"}))
+
+	// The priority function takes precedence over action priorities.
+	handler.WritePriority = func(_ *Device, data *WriteData) WritePriority {
+		if string(data.Data) == "urgent" {
+			return WritePriorityHigh
+		}
+		return WritePriorityLow
+	}
+	assert.Equal(t, WritePriorityHigh, handler.writePriority(nil, &synse.V3WriteData{Action: "led", Data: []byte("urgent")}))
+	assert.Equal(t, WritePriorityLow, handler.writePriority(nil, &synse.V3WriteData{Action: "power-off"}))
+}
+
+// queueTestCoalesceWrite queues a write for the device with the given action.
+func queueTestCoalesceWrite(t *testing.T, s *scheduler, device *Device, action string) *transaction {
+	txn := newTransaction(time.Second, "")
+	txn.setStatusPending()
+	err := s.enqueue(&WriteContext{
+		transaction: txn,
+		device:      device,
+		data:        &synse.V3WriteData{Action: action},
+	})
+	assert.NoError(t, err)
+	return txn
+}
+
+func TestScheduler_coalesce(t *testing.T) {
+	var written []string
+	handler := &DeviceHandler{
+		Name:           "fan",
+		CoalesceWrites: true,
+		Write: func(device *Device, data *WriteData) error {
+			written = append(written, data.Action)
+			return nil
+		},
+	}
+	device := &Device{id: "123", handler: handler, WriteTimeout: time.Second}
+
+	s := newTestPriorityScheduler(0)
+	s.config.Mode = "serial"
+	s.serialLock = &sync.Mutex{}
+
+	first := queueTestCoalesceWrite(t, s, device, "speed")
+	other := queueTestCoalesceWrite(t, s, device, "mode")
+	second := queueTestCoalesceWrite(t, s, device, "speed")
+	last := queueTestCoalesceWrite(t, s, device, "speed")
+
+	// Earlier writes for the same action are superseded by the latest one.
+	assert.True(t, first.isDone())
+	assert.Equal(t, synse.WriteStatus_DONE, first.status)
+	assert.Equal(t, "write skipped: superseded by transaction "+second.id, first.message)
+	assert.True(t, second.isDone())
+	assert.Equal(t, "write skipped: superseded by transaction "+last.id, second.message)
+	assert.False(t, other.isDone())
+	assert.False(t, last.isDone())
+
+	// Superseded writes are skipped when taken from the queue.
+	for w := s.nextWrite(); w != nil; w = s.nextWrite() {
+		s.write(w)
+	}
+	assert.Equal(t, []string{"mode", "speed"}, written)
+	assert.Equal(t, synse.WriteStatus_DONE, last.status)
+	assert.Empty(t, last.message)
+	assert.Empty(t, s.coalesced)
+}
+
+func TestScheduler_coalesce_disabled(t *testing.T) {
+	device := &Device{id: "123", handler: &DeviceHandler{Name: "fan"}}
+	s := newTestPriorityScheduler(0)
+
+	first := queueTestCoalesceWrite(t, s, device, "speed")
+	queueTestCoalesceWrite(t, s, device, "speed")
+
+	assert.False(t, first.isDone())
+	assert.Equal(t, 2, s.queuedWrites())
+}
+
+func TestScheduler_coalesce_claimed(t *testing.T) {
+	device := &Device{id: "123", handler: &DeviceHandler{Name: "fan", CoalesceWrites: true}}
+	s := newTestPriorityScheduler(0)
+
+	first := queueTestCoalesceWrite(t, s, device, "speed")
+
+	// Once a write has been claimed for execution, it is not superseded.
+	w := s.nextWrite()
+	assert.True(t, w.transaction.claim())
+	queueTestCoalesceWrite(t, s, device, "speed")
+	assert.False(t, first.isDone())
+}
+
+// enqueueTestCoalesceWrite starts queueing a write for the device with the given
+// action in the background, returning its transaction and a channel which receives
+// the result of enqueue.
+func enqueueTestCoalesceWrite(s *scheduler, device *Device, action string) (*transaction, chan error) {
+	txn := newTransaction(time.Second, "")
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.enqueue(&WriteContext{
+			transaction: txn,
+			device:      device,
+			data:        &synse.V3WriteData{Action: action},
+		})
+	}()
+	time.Sleep(3 * enqueueRetryInterval)
+	return txn, errs
+}
+
+func TestScheduler_coalesce_fullQueue(t *testing.T) {
+	device := &Device{id: "123", handler: &DeviceHandler{Name: "fan", CoalesceWrites: true}}
+	s := &scheduler{
+		writeChan: make(chan *WriteContext, 1),
+		stop:      make(chan struct{}),
+	}
+	queueTestWrite(t, s, WritePriorityNormal)
+
+	// Both writes wait for room in the queue. The later write supersedes the
+	// earlier one while it is waiting, so the earlier one is never queued.
+	first, firstErrs := enqueueTestCoalesceWrite(s, device, "speed")
+	second, secondErrs := enqueueTestCoalesceWrite(s, device, "speed")
+	assert.NoError(t, <-firstErrs)
+	assert.True(t, first.isDone())
+	assert.Equal(t, "write skipped: superseded by transaction "+second.id, first.message)
+	assert.False(t, second.isDone())
+
+	// Once there is room, the latest write is queued.
+	<-s.writeChan
+	assert.NoError(t, <-secondErrs)
+	w := <-s.writeChan
+	assert.Equal(t, second, w.transaction)
+	assert.True(t, w.transaction.claim())
+	s.clearCoalesced(w)
+	assert.Empty(t, s.coalesced)
+}
+
+func TestScheduler_coalesce_fullQueueShutdown(t *testing.T) {
+	device := &Device{id: "123", handler: &DeviceHandler{Name: "fan", CoalesceWrites: true}}
+	s := &scheduler{
+		writeChan: make(chan *WriteContext, 1),
+		stop:      make(chan struct{}),
+	}
+	queueTestWrite(t, s, WritePriorityNormal)
+
+	first, firstErrs := enqueueTestCoalesceWrite(s, device, "speed")
+	second, secondErrs := enqueueTestCoalesceWrite(s, device, "speed")
+	assert.NoError(t, <-firstErrs)
+
+	// Rejecting the waiting writes on shutdown does not update the transaction
+	// of the superseded write again.
+	close(s.stop)
+	assert.Equal(t, ErrSchedulerShutdown, <-secondErrs)
+	assert.Equal(t, synse.WriteStatus_DONE, first.status)
+	assert.Equal(t, synse.WriteStatus_ERROR, second.status)
+	assert.Empty(t, s.coalesced)
+	assert.Len(t, s.writeChan, 1)
+}
+
+func TestScheduler_removeQueuedWrites_superseded(t *testing.T) {
+	device := &Device{id: "123", handler: &DeviceHandler{Name: "fan", CoalesceWrites: true}}
+	s := newTestPriorityScheduler(0)
+
+	queueTestCoalesceWrite(t, s, device, "speed")
+	last := queueTestCoalesceWrite(t, s, device, "speed")
+
+	// The superseded write is dropped, but only the pending write is returned.
+	removed := s.removeQueuedWrites(func(*WriteContext) bool { return true })
+	assert.Len(t, removed, 1)
+	assert.Equal(t, last, removed[0].transaction)
+	assert.Equal(t, 0, s.queuedWrites())
+}
+
+// groupTestWriteDevices gets the IDs of the devices for each group of writes.
+func groupTestWriteDevices(groups [][]*WriteContext) [][]string {
+	var ids [][]string
+	for _, group := range groups {
+		var g []string
+		for _, w := range group {
+			g = append(g, w.device.id)
+		}
+		ids = append(ids, g)
+	}
+	return ids
+}
+
+func TestScheduler_groupWrites(t *testing.T) {
+	h1 := &DeviceHandler{Name: "fan"}
+	h2 := &DeviceHandler{Name: "led"}
+	d1 := &Device{id: "1", handler: h1, Data: map[string]interface{}{"bus": 1}}
+	d2 := &Device{id: "2", handler: h1, Data: map[string]interface{}{"bus": 2}}
+	d3 := &Device{id: "3", handler: h2, Data: map[string]interface{}{"bus": 1}}
+	d4 := &Device{id: "4", handler: h2}
+
+	var batch []*WriteContext
+	for _, d := range []*Device{d1, d2, d1, d3, d4, d2} {
+		batch = append(batch, &WriteContext{device: d})
+	}
+
+	cases := []struct {
+		serializeBy string
+		expected    [][]string
+	}{
+		{"", [][]string{{"1", "1"}, {"2", "2"}, {"3"}, {"4"}}},
+		{"device", [][]string{{"1", "1"}, {"2", "2"}, {"3"}, {"4"}}},
+		{"handler", [][]string{{"1", "2", "1", "2"}, {"3", "4"}}},
+		{"data:bus", [][]string{{"1", "1", "3"}, {"2", "2"}, {"4"}}},
+		{"none", [][]string{{"1"}, {"2"}, {"1"}, {"3"}, {"4"}, {"2"}}},
+	}
+	for _, c := range cases {
+		s := scheduler{
+			config: &config.PluginSettings{
+				Write: &config.WriteSettings{SerializeBy: c.serializeBy},
+			},
+		}
+		assert.Equal(t, c.expected, groupTestWriteDevices(s.groupWrites(batch)), c.serializeBy)
+	}
+}
+
+func TestScheduler_scheduleWrites_serializedPerDevice(t *testing.T) {
+	var lock sync.Mutex
+	var active = map[string]int{}
+	var order []string
+	var overlap bool
+
+	handler := &DeviceHandler{
+		Name: "test",
+		Write: func(device *Device, data *WriteData) error {
+			lock.Lock()
+			active[device.id]++
+			if active[device.id] > 1 {
+				overlap = true
+			}
+			order = append(order, device.id+":"+data.Action)
+			lock.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			lock.Lock()
+			active[device.id]--
+			lock.Unlock()
+			return nil
+		},
+	}
+
+	s := scheduler{
+		deviceManager: &deviceManager{
+			handlers: map[string]*DeviceHandler{"test": handler},
+		},
+		config: &config.PluginSettings{
+			Mode: "parallel",
+			Write: &config.WriteSettings{
+				BatchSize:   10,
+				SerializeBy: "device",
+			},
+		},
+		writeChan: make(chan *WriteContext, 10),
+		stop:      make(chan struct{}),
+	}
+
+	d1 := &Device{id: "1", handler: handler, WriteTimeout: time.Second}
+	d2 := &Device{id: "2", handler: handler, WriteTimeout: time.Second}
+	var txns []*transaction
+	for i, d := range []*Device{d1, d2, d1, d1, d2} {
+		txn := newTransaction(time.Second, "")
+		txns = append(txns, txn)
+		assert.NoError(t, s.enqueue(&WriteContext{
+			transaction: txn,
+			device:      d,
+			data:        &synse.V3WriteData{Action: fmt.Sprint(i)},
+		}))
+	}
+
+	go s.scheduleWrites()
+	defer close(s.stop)
+	for _, txn := range txns {
+		txn.wait()
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.False(t, overlap, "concurrent writes to the same device")
+
+	// Writes to each device are executed in the order they were queued.
+	var d1Order, d2Order []string
+	for _, o := range order {
+		if o[0] == '1' {
+			d1Order = append(d1Order, o)
+		} else {
+			d2Order = append(d2Order, o)
+		}
+	}
+	assert.Equal(t, []string{"1:0", "1:2", "1:3"}, d1Order)
+	assert.Equal(t, []string{"2:1", "2:4"}, d2Order)
+}